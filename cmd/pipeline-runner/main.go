@@ -0,0 +1,172 @@
+// Package main implements the pipeline runner.
+// It runs the ingress, transform and egress plugins of a declarative
+// pipeline spec against a shared store.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/relais/pkg/config"
+	"github.com/relais/pkg/logging"
+	"github.com/relais/pkg/pipeline"
+	"github.com/relais/pkg/plugins"
+	"github.com/relais/pkg/plugins/external"
+	"github.com/relais/pkg/plugins/wasm"
+	"github.com/relais/plugins/egress/webrtc_egress"
+	"github.com/relais/plugins/ingress/camera"
+	"github.com/relais/plugins/transforms/watermark"
+)
+
+// newRegistry returns a registry of the built-in plugins, under the names
+// the other runners select them by.
+func newRegistry() (*plugins.Registry, error) {
+	registry := plugins.NewRegistry()
+	builtins := []struct {
+		pType   plugins.PluginType
+		name    string
+		factory plugins.PluginFactory
+	}{
+		{plugins.PluginTypeIngress, "camera", func() plugins.Plugin { return camera.NewCameraPlugin() }},
+		{plugins.PluginTypeTransform, "watermark", func() plugins.Plugin { return watermark.NewWatermarkPlugin() }},
+		{plugins.PluginTypeEgress, "webrtc", func() plugins.Plugin { return webrtc_egress.NewWebRTCEgressPlugin() }},
+	}
+	for _, b := range builtins {
+		if err := registry.Register(b.pType, b.name, b.factory); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// printPlugins writes the plugins of a registry and their config options.
+func printPlugins(w io.Writer, registry *plugins.Registry) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, pType := range []plugins.PluginType{plugins.PluginTypeIngress, plugins.PluginTypeTransform, plugins.PluginTypeEgress} {
+		for _, name := range registry.Names(pType) {
+			fmt.Fprintf(tw, "%s %s\n", pType, name)
+			schema, err := registry.Schema(pType, name)
+			if err != nil {
+				return err
+			}
+			if schema == nil {
+				fmt.Fprintf(tw, "  (no schema)\n")
+				continue
+			}
+			for _, f := range schema.Fields {
+				var notes []string
+				if f.Required {
+					notes = append(notes, "required")
+				}
+				if f.Default != nil {
+					notes = append(notes, fmt.Sprintf("default %v", f.Default))
+				}
+				if f.Range != nil {
+					notes = append(notes, fmt.Sprintf("range %v to %v", f.Range.Min, f.Range.Max))
+				}
+				if len(f.Values) > 0 {
+					notes = append(notes, "one of "+strings.Join(f.Values, ", "))
+				}
+				fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", f.Name, f.Type, f.Description, strings.Join(notes, ", "))
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+func main() {
+	specPath := flag.String("spec", "pipeline.yaml", "Path of the pipeline spec, in YAML or JSON")
+	var externals []string
+	flag.Func("external", "Path of an external plugin executable, may be repeated", func(path string) error {
+		externals = append(externals, path)
+		return nil
+	})
+	var modules []string
+	flag.Func("wasm", "Path of a WebAssembly transform module, may be repeated", func(path string) error {
+		modules = append(modules, path)
+		return nil
+	})
+	listPlugins := flag.Bool("plugins", false, "List the registered plugins and their config options, then exit")
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Initialize logger
+	logger := logging.NewLogger(cfg.Logging.Level)
+
+	// Compile the pipeline before touching storage, so spec errors are
+	// reported first
+	registry, err := newRegistry()
+	if err != nil {
+		logger.Fatalf("Failed to register plugins: %v", err)
+	}
+	for _, path := range externals {
+		if err := external.Register(ctx, registry, path); err != nil {
+			logger.Fatalf("Failed to register external plugin %s: %v", path, err)
+		}
+	}
+	for _, path := range modules {
+		if err := wasm.Register(registry, "", path); err != nil {
+			logger.Fatalf("Failed to register WebAssembly plugin %s: %v", path, err)
+		}
+	}
+	if *listPlugins {
+		if err := printPlugins(os.Stdout, registry); err != nil {
+			logger.Fatalf("Failed to list plugins: %v", err)
+		}
+		return
+	}
+	spec, err := pipeline.LoadFile(*specPath)
+	if err != nil {
+		logger.Fatalf("Failed to load pipeline: %v", err)
+	}
+	p, err := pipeline.Compile(spec, registry)
+	if err != nil {
+		logger.Fatalf("Failed to compile pipeline: %v", err)
+	}
+
+	// Initialize storage
+	store, err := config.NewStorage(cfg.Storage)
+	if err != nil {
+		logger.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer store.Close()
+
+	// Apply the default retention policy and the per-session ones
+	if err := config.ApplyRetention(ctx, store, cfg.Storage.Retention); err != nil {
+		logger.Fatalf("Failed to configure retention: %v", err)
+	}
+
+	// Run the pipeline
+	manager := plugins.NewPluginManager(registry, store)
+	if err := p.Start(ctx, manager); err != nil {
+		logger.Fatalf("Failed to start pipeline: %v", err)
+	}
+	for _, node := range p.Nodes() {
+		logger.Infof("Started %s %s (%s): %s -> %s", node.Type, node.ID, node.Plugin, node.InputSession, node.OutputSession)
+	}
+
+	// Handle shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	if err := p.Stop(); err != nil {
+		logger.Errorf("Failed to stop pipeline: %v", err)
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"github.com/relais/pkg/config"
 	"github.com/relais/pkg/logging"
 	"github.com/relais/pkg/plugins"
-	"github.com/relais/pkg/storage"
 	"github.com/relais/plugins/transforms/watermark"
 )
 
@@ -32,17 +31,17 @@ func main() {
 	logger := logging.NewLogger(cfg.Logging.Level)
 
 	// Initialize storage
-	var store storage.Storage
-	if cfg.Storage.Type == "redis" {
-		store, err = storage.NewRedisStorage(cfg.Storage.RedisURL)
-	} else {
-		store = storage.NewMemoryStorage()
-	}
+	store, err := config.NewStorage(cfg.Storage)
 	if err != nil {
 		logger.Fatalf("Failed to initialize storage: %v", err)
 	}
 	defer store.Close()
 
+	// Apply the default retention policy and the per-session ones
+	if err := config.ApplyRetention(ctx, store, cfg.Storage.Retention); err != nil {
+		logger.Fatalf("Failed to configure retention: %v", err)
+	}
+
 	// Initialize plugin
 	var plugin plugins.TransformPlugin
 	switch *pluginType {
@@ -52,6 +51,16 @@ func main() {
 		logger.Fatalf("Unknown plugin type: %s", *pluginType)
 	}
 
+	// Validate the plugin config against its schema and initialize it
+	pluginConfig, err := plugins.ValidateConfig(*pluginType, plugin, cfg.Plugin)
+	if err != nil {
+		logger.Fatalf("Invalid plugin config: %v", err)
+	}
+	if err := plugin.Initialize(ctx, pluginConfig); err != nil {
+		logger.Fatalf("Failed to initialize plugin: %v", err)
+	}
+	defer plugin.Stop()
+
 	// Handle shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
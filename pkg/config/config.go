@@ -1,6 +1,10 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
+	"time"
+
 	"github.com/pion/webrtc/v3"
 	"github.com/spf13/viper"
 )
@@ -11,6 +15,11 @@ type Config struct {
 	Storage StorageConfig
 	Logging LoggingConfig
 	WebRTC  WebRTCConfig
+
+	// Plugin holds the config of the plugin run by the ingress, transform
+	// and egress runners. It is read from plugin_config, a JSON object of
+	// the plugin's options such as {"device_id": "cam1", "fps": 25}.
+	Plugin map[string]interface{} `mapstructure:"-"`
 }
 
 type ServerConfig struct {
@@ -19,8 +28,89 @@ type ServerConfig struct {
 }
 
 type StorageConfig struct {
-	Type     string // "redis" or "memory"
-	RedisURL string
+	Type      string // "redis", "redis_streams", "file", "s3", "tiered" or "memory"
+	RedisURL  string
+	File      FileStorageConfig
+	S3        S3StorageConfig
+	Tiered    TieredStorageConfig
+	Retention RetentionConfig
+}
+
+// FileStorageConfig holds the settings of the filesystem storage backend.
+type FileStorageConfig struct {
+	Dir             string        `mapstructure:"dir"`               // Directory holding the recorded sessions
+	MaxSegmentBytes int64         `mapstructure:"max_segment_bytes"` // Segment size before rotation
+	MaxSegmentAge   time.Duration `mapstructure:"max_segment_age"`   // Segment age before rotation, e.g. "10m"
+	Sync            bool          `mapstructure:"sync"`              // Flush every write to disk
+}
+
+// S3StorageConfig holds the settings of the S3-compatible object storage
+// backend.
+type S3StorageConfig struct {
+	Endpoint    string        `mapstructure:"endpoint"`     // Service URL, e.g. "http://localhost:9000"
+	Region      string        `mapstructure:"region"`       // Region used for request signing
+	Bucket      string        `mapstructure:"bucket"`       // Bucket holding the sessions
+	AccessKey   string        `mapstructure:"access_key"`   // Access key ID
+	SecretKey   string        `mapstructure:"secret_key"`   // Secret access key
+	Prefix      string        `mapstructure:"prefix"`       // Key prefix for all objects
+	BatchFrames int           `mapstructure:"batch_frames"` // Frames per batch object
+	BatchBytes  int64         `mapstructure:"batch_bytes"`  // Frame data bytes per batch object
+	BatchAge    time.Duration `mapstructure:"batch_age"`    // Longest time frames wait for their batch
+}
+
+// TieredStorageConfig holds the settings of the tiered storage backend,
+// which keeps recent frames in memory and spills older ones to a cold backend.
+type TieredStorageConfig struct {
+	Cold       string `mapstructure:"cold"`        // Cold backend type, "file" or "s3"
+	HotFrames  int    `mapstructure:"hot_frames"`  // Frames kept in memory per session
+	SpillBatch int    `mapstructure:"spill_batch"` // Frames moved to the cold backend at once
+}
+
+// RetentionConfig holds the retention policies of the sessions. The
+// embedded limits are the default policy, applied to every session without
+// a policy of its own in Sessions.
+type RetentionConfig struct {
+	RetentionLimits `mapstructure:",squash"`
+
+	// Sessions holds the policies of individual sessions by session ID,
+	// e.g. "lobby-cam/source". It is read from storage.retention.sessions,
+	// a JSON object such as {"lobby-cam/source": {"max_age": "5m"}}.
+	Sessions map[string]RetentionLimits `mapstructure:"-"`
+}
+
+// RetentionLimits holds the limits of a retention policy. A zero value
+// disables the corresponding limit.
+type RetentionLimits struct {
+	MaxFrames int           `mapstructure:"max_frames"` // Frames kept per session
+	MaxAge    time.Duration `mapstructure:"max_age"`    // Age of the oldest frame kept, e.g. "30s"
+	MaxBytes  int64         `mapstructure:"max_bytes"`  // Frame data bytes kept per session
+}
+
+// parseRetentionSessions parses the JSON object of per-session retention
+// policies, whose max_age values are duration strings.
+func parseRetentionSessions(data string) (map[string]RetentionLimits, error) {
+	var raw map[string]struct {
+		MaxFrames int    `json:"max_frames"`
+		MaxAge    string `json:"max_age"`
+		MaxBytes  int64  `json:"max_bytes"`
+	}
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse session retention policies: %v", err)
+	}
+
+	sessions := make(map[string]RetentionLimits, len(raw))
+	for sessionID, r := range raw {
+		limits := RetentionLimits{MaxFrames: r.MaxFrames, MaxBytes: r.MaxBytes}
+		if r.MaxAge != "" {
+			maxAge, err := time.ParseDuration(r.MaxAge)
+			if err != nil {
+				return nil, fmt.Errorf("invalid max_age of session %s: %v", sessionID, err)
+			}
+			limits.MaxAge = maxAge
+		}
+		sessions[sessionID] = limits
+	}
+	return sessions, nil
 }
 
 type LoggingConfig struct {
@@ -38,17 +128,49 @@ func LoadConfig() (*Config, error) {
 	viper.SetDefault("server.port", 8080)
 	viper.SetDefault("storage.type", "memory")
 	viper.SetDefault("storage.redis_url", "localhost:6379")
+	viper.SetDefault("storage.file.dir", "data")
+	viper.SetDefault("storage.file.max_segment_bytes", 64<<20)
+	viper.SetDefault("storage.file.max_segment_age", "0s")
+	viper.SetDefault("storage.file.sync", false)
+	viper.SetDefault("storage.s3.region", "us-east-1")
+	viper.SetDefault("storage.s3.batch_frames", 300)
+	viper.SetDefault("storage.s3.batch_bytes", 8<<20)
+	viper.SetDefault("storage.s3.batch_age", "10s")
+	viper.SetDefault("storage.tiered.cold", "file")
+	viper.SetDefault("storage.tiered.hot_frames", 300)
+	viper.SetDefault("storage.tiered.spill_batch", 30)
+	viper.SetDefault("storage.retention.max_frames", 0)
+	viper.SetDefault("storage.retention.max_age", "0s")
+	viper.SetDefault("storage.retention.max_bytes", 0)
+	viper.SetDefault("storage.retention.sessions", "")
 	viper.SetDefault("logging.level", "info")
+	viper.SetDefault("plugin_config", "")
 	viper.SetDefault("webrtc.ice_servers", []string{"stun:stun.l.google.com:19302"})
 
 	viper.AutomaticEnv()
 	viper.SetEnvPrefix("RELAIS")
 
 	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
+	err := viper.Unmarshal(&config)
+	if err != nil {
 		return nil, err
 	}
 
+	// Parse the per-session retention policies
+	if sessions := viper.GetString("storage.retention.sessions"); sessions != "" {
+		config.Storage.Retention.Sessions, err = parseRetentionSessions(sessions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Parse the config of the plugin to run
+	if pluginConfig := viper.GetString("plugin_config"); pluginConfig != "" {
+		if err := json.Unmarshal([]byte(pluginConfig), &config.Plugin); err != nil {
+			return nil, fmt.Errorf("failed to parse plugin config: %v", err)
+		}
+	}
+
 	// Convert string ICE servers to proper ICEServer objects
 	iceURLs := viper.GetStringSlice("webrtc.ice_servers")
 	config.WebRTC.ICEServers = make([]webrtc.ICEServer, len(iceURLs))
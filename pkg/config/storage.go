@@ -0,0 +1,109 @@
+package config
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/relais/pkg/storage"
+)
+
+// NewStorage creates the storage backend selected by the configuration.
+// Unknown types fall back to in-memory storage.
+//
+// Returns an error if the backend cannot be initialized.
+func NewStorage(cfg StorageConfig) (storage.Storage, error) {
+	switch cfg.Type {
+	case "redis":
+		return storage.NewRedisStorage(cfg.RedisURL)
+	case "redis_streams":
+		return storage.NewRedisStreamsStorage(cfg.RedisURL)
+	case "file":
+		return newFileStorage(cfg.File)
+	case "s3":
+		return newObjectStorage(cfg.S3)
+	case "tiered":
+		var cold storage.Storage
+		var err error
+		switch cfg.Tiered.Cold {
+		case "file":
+			cold, err = newFileStorage(cfg.File)
+		case "s3":
+			cold, err = newObjectStorage(cfg.S3)
+		default:
+			return nil, fmt.Errorf("unknown cold storage type: %s", cfg.Tiered.Cold)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		store, err := storage.NewTieredStorage(cold, storage.TieredConfig{
+			HotFrames:  cfg.Tiered.HotFrames,
+			SpillBatch: cfg.Tiered.SpillBatch,
+		})
+		if err != nil {
+			cold.Close()
+			return nil, err
+		}
+		return store, nil
+	default:
+		return storage.NewMemoryStorage(), nil
+	}
+}
+
+// ApplyRetention sets the default retention policy of a storage, then the
+// policies of the sessions configured with their own.
+//
+// Returns an error if a policy cannot be stored.
+func ApplyRetention(ctx context.Context, store storage.Storage, cfg RetentionConfig) error {
+	if err := store.SetRetention(ctx, "", retentionPolicy(cfg.RetentionLimits)); err != nil {
+		return fmt.Errorf("failed to set default retention policy: %w", err)
+	}
+	for sessionID, limits := range cfg.Sessions {
+		if err := store.SetRetention(ctx, sessionID, retentionPolicy(limits)); err != nil {
+			return fmt.Errorf("failed to set retention policy of session %s: %w", sessionID, err)
+		}
+	}
+	return nil
+}
+
+// retentionPolicy converts configured limits to a storage retention policy.
+func retentionPolicy(limits RetentionLimits) storage.RetentionPolicy {
+	return storage.RetentionPolicy{
+		MaxFrames: limits.MaxFrames,
+		MaxAge:    limits.MaxAge,
+		MaxBytes:  limits.MaxBytes,
+	}
+}
+
+// newFileStorage creates a filesystem storage backend.
+func newFileStorage(cfg FileStorageConfig) (*storage.FileStorage, error) {
+	return storage.NewFileStorage(storage.FileConfig{
+		Dir:             cfg.Dir,
+		MaxSegmentBytes: cfg.MaxSegmentBytes,
+		MaxSegmentAge:   cfg.MaxSegmentAge,
+		Sync:            cfg.Sync,
+	})
+}
+
+// newObjectStorage creates an object storage backend on an S3-compatible
+// service.
+func newObjectStorage(cfg S3StorageConfig) (*storage.ObjectStorage, error) {
+	client, err := storage.NewS3Client(storage.S3Config{
+		Endpoint:  cfg.Endpoint,
+		Region:    cfg.Region,
+		Bucket:    cfg.Bucket,
+		AccessKey: cfg.AccessKey,
+		SecretKey: cfg.SecretKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.NewObjectStorage(storage.ObjectConfig{
+		Client:      client,
+		Prefix:      cfg.Prefix,
+		BatchFrames: cfg.BatchFrames,
+		BatchBytes:  cfg.BatchBytes,
+		BatchAge:    cfg.BatchAge,
+	})
+}
@@ -0,0 +1,176 @@
+package frames
+
+import (
+	"fmt"
+)
+
+// aacSampleRates maps the sampling frequency index of ADTS headers and
+// AudioSpecificConfig to a sample rate in Hz.
+var aacSampleRates = [...]int{
+	96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+// aacProfile returns the name of an MPEG-4 audio object type.
+func aacProfile(objectType uint8) string {
+	switch objectType {
+	case 1:
+		return "main"
+	case 2:
+		return "lc"
+	case 3:
+		return "ssr"
+	case 4:
+		return "ltp"
+	case 5:
+		return "he"
+	case 29:
+		return "he-v2"
+	default:
+		return fmt.Sprint(objectType)
+	}
+}
+
+// ADTSHeader is the header of an AAC frame in an ADTS stream, as written by
+// hardware encoders and carried in MPEG-TS.
+type ADTSHeader struct {
+	ObjectType      uint8 // MPEG-4 audio object type, 2 for AAC-LC
+	SampleRateIndex uint8 // Sampling frequency index
+	ChannelConfig   uint8 // Channel configuration, 0 if given in the payload
+	HeaderSize      int   // Header size in bytes, 7 or 9 with a CRC
+	FrameLength     int   // Frame length in bytes, including the header
+}
+
+// SampleRate returns the sample rate in Hz.
+func (h ADTSHeader) SampleRate() int {
+	return aacSampleRates[h.SampleRateIndex]
+}
+
+// AudioSpecificConfig returns the 2-byte AudioSpecificConfig describing the
+// stream, to be carried as SideDataAudioSpecificConfig once the ADTS
+// headers are stripped.
+func (h ADTSHeader) AudioSpecificConfig() []byte {
+	return []byte{
+		h.ObjectType<<3 | h.SampleRateIndex>>1,
+		h.SampleRateIndex<<7 | h.ChannelConfig<<3,
+	}
+}
+
+// ParseADTSHeader decodes the ADTS header at the start of data.
+//
+// Returns an error wrapping ErrInvalidBitstream if data does not start with
+// a valid ADTS header.
+func ParseADTSHeader(data []byte) (ADTSHeader, error) {
+	if len(data) < 7 {
+		return ADTSHeader{}, errBitstream("truncated ADTS header")
+	}
+	if data[0] != 0xff || data[1]&0xf6 != 0xf0 {
+		return ADTSHeader{}, errBitstream("missing ADTS sync word")
+	}
+
+	header := ADTSHeader{
+		ObjectType:      data[2]>>6 + 1,
+		SampleRateIndex: data[2] >> 2 & 0x0f,
+		ChannelConfig:   data[2]&0x01<<2 | data[3]>>6,
+		HeaderSize:      7,
+		FrameLength:     int(data[3]&0x03)<<11 | int(data[4])<<3 | int(data[5])>>5,
+	}
+	if data[1]&0x01 == 0 { // protection_absent
+		header.HeaderSize = 9
+	}
+	if int(header.SampleRateIndex) >= len(aacSampleRates) {
+		return ADTSHeader{}, errBitstream("invalid AAC sampling frequency index %d", header.SampleRateIndex)
+	}
+	if header.FrameLength < header.HeaderSize {
+		return ADTSHeader{}, errBitstream("invalid ADTS frame length %d", header.FrameLength)
+	}
+	return header, nil
+}
+
+// IsADTS reports whether data starts with a valid ADTS header.
+func IsADTS(data []byte) bool {
+	_, err := ParseADTSHeader(data)
+	return err == nil
+}
+
+// StripADTS removes the ADTS headers from a payload of one or more ADTS
+// frames, returning the raw AAC frames concatenated and the header of the
+// first frame.
+//
+// Returns an error wrapping ErrInvalidBitstream if a header is invalid or a
+// frame is truncated.
+func StripADTS(data []byte) ([]byte, ADTSHeader, error) {
+	first, err := ParseADTSHeader(data)
+	if err != nil {
+		return nil, ADTSHeader{}, err
+	}
+
+	raw := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		header, err := ParseADTSHeader(data)
+		if err != nil {
+			return nil, ADTSHeader{}, err
+		}
+		if header.FrameLength > len(data) {
+			return nil, ADTSHeader{}, errBitstream("ADTS frame length %d exceeds payload", header.FrameLength)
+		}
+		raw = append(raw, data[header.HeaderSize:header.FrameLength]...)
+		data = data[header.FrameLength:]
+	}
+	return raw, first, nil
+}
+
+// ParseAudioSpecificConfig decodes the profile, sample rate and channel
+// count of an MPEG-4 AudioSpecificConfig, e.g. from
+// SideDataAudioSpecificConfig.
+//
+// Returns an error wrapping ErrInvalidBitstream if config is truncated or
+// uses an unsupported sample rate.
+func ParseAudioSpecificConfig(config []byte) (CodecParams, error) {
+	r := &bitReader{data: config}
+
+	objectType, err := r.readBits(5)
+	if err != nil {
+		return CodecParams{}, err
+	}
+	if objectType == 31 { // Escape to an extended object type
+		ext, err := r.readBits(6)
+		if err != nil {
+			return CodecParams{}, err
+		}
+		objectType = 32 + ext
+	}
+
+	index, err := r.readBits(4)
+	if err != nil {
+		return CodecParams{}, err
+	}
+	var sampleRate int
+	switch {
+	case index == 15: // Explicit 24-bit sample rate
+		rate, err := r.readBits(24)
+		if err != nil {
+			return CodecParams{}, err
+		}
+		sampleRate = int(rate)
+	case int(index) < len(aacSampleRates):
+		sampleRate = aacSampleRates[index]
+	default:
+		return CodecParams{}, errBitstream("invalid AAC sampling frequency index %d", index)
+	}
+
+	channels, err := r.readBits(4)
+	if err != nil {
+		return CodecParams{}, err
+	}
+	// Configuration 7 is 7.1, every other one up to 6 is its channel count
+	if channels == 7 {
+		channels = 8
+	}
+
+	return CodecParams{
+		Type:       CodecAAC,
+		Profile:    aacProfile(uint8(objectType)),
+		SampleRate: sampleRate,
+		Channels:   int(channels),
+	}, nil
+}
@@ -0,0 +1,344 @@
+package frames
+
+import (
+	"fmt"
+)
+
+// AV1 payloads are temporal units in the low overhead bitstream format: a
+// sequence of OBUs (open bitstream units), each starting with a 1 or 2-byte
+// header and, in this format, its size as a LEB128 integer. The last OBU of
+// a payload may omit its size and extends to the end.
+
+// AV1OBUType is the type of an AV1 OBU, from bits 1 to 4 of its header.
+type AV1OBUType uint8
+
+const (
+	AV1OBUSequenceHeader    AV1OBUType = 1  // Sequence header, the codec configuration
+	AV1OBUTemporalDelimiter AV1OBUType = 2  // Start of a temporal unit
+	AV1OBUFrameHeader       AV1OBUType = 3  // Frame header without tile data
+	AV1OBUTileGroup         AV1OBUType = 4  // Tile data of a frame
+	AV1OBUMetadata          AV1OBUType = 5  // Metadata such as HDR information
+	AV1OBUFrame             AV1OBUType = 6  // Frame header followed by its tile data
+	AV1OBUPadding           AV1OBUType = 15 // Padding
+)
+
+// AV1OBUTypeOf returns the type of an AV1 OBU, or 0 if obu is empty.
+func AV1OBUTypeOf(obu []byte) AV1OBUType {
+	if len(obu) == 0 {
+		return 0
+	}
+	return AV1OBUType(obu[0] >> 3 & 0x0f)
+}
+
+// readLEB128 reads a LEB128 integer, returning its value and size in
+// bytes, or a size of 0 if data is truncated.
+func readLEB128(data []byte) (uint64, int) {
+	var value uint64
+	for i := 0; i < 8 && i < len(data); i++ {
+		value |= uint64(data[i]&0x7f) << (7 * i)
+		if data[i]&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// parseAV1OBU parses the OBU at the start of data, returning its payload
+// and its size including header and size field.
+func parseAV1OBU(data []byte) ([]byte, int, error) {
+	if len(data) == 0 || data[0]&0x80 != 0 {
+		return nil, 0, errBitstream("invalid AV1 OBU header")
+	}
+	headerSize := 1
+	if data[0]&0x04 != 0 { // obu_extension_flag
+		headerSize++
+	}
+	if len(data) < headerSize {
+		return nil, 0, errBitstream("truncated AV1 OBU header")
+	}
+	if data[0]&0x02 == 0 { // obu_has_size_field
+		return data[headerSize:], len(data), nil
+	}
+
+	size, n := readLEB128(data[headerSize:])
+	if n == 0 {
+		return nil, 0, errBitstream("truncated AV1 OBU size")
+	}
+	headerSize += n
+	if size > uint64(len(data)-headerSize) {
+		return nil, 0, errBitstream("AV1 OBU size %d exceeds payload", size)
+	}
+	return data[headerSize : headerSize+int(size)], headerSize + int(size), nil
+}
+
+// SplitAV1OBUs splits an AV1 temporal unit into its OBUs. OBUs are returned
+// with their header and size field.
+//
+// Returns an error if an OBU header is invalid or an OBU is truncated.
+func SplitAV1OBUs(data []byte) ([][]byte, error) {
+	obus := make([][]byte, 0)
+	for len(data) > 0 {
+		_, size, err := parseAV1OBU(data)
+		if err != nil {
+			return nil, err
+		}
+		obus = append(obus, data[:size])
+		data = data[size:]
+	}
+	return obus, nil
+}
+
+// AV1IsKeyFrame reports whether an AV1 temporal unit contains a key frame,
+// from the frame type in its frame header.
+func AV1IsKeyFrame(data []byte) bool {
+	obus, err := SplitAV1OBUs(data)
+	if err != nil {
+		return false
+	}
+
+	for _, obu := range obus {
+		switch AV1OBUTypeOf(obu) {
+		case AV1OBUSequenceHeader:
+			// Reduced still picture streams consist of key frames only
+			if header, err := parseAV1SequenceHeader(obu); err == nil && header.reducedStillPicture {
+				return true
+			}
+		case AV1OBUFrame, AV1OBUFrameHeader:
+			payload, _, err := parseAV1OBU(obu)
+			if err != nil || len(payload) == 0 {
+				return false
+			}
+			// show_existing_frame unset and frame_type KEY_FRAME
+			return payload[0]&0xe0 == 0
+		}
+	}
+	return false
+}
+
+// AV1SequenceHeader returns the sequence header OBU of an AV1 temporal unit
+// as side data for Frame.SideData. Returns nil if the temporal unit carries
+// no sequence header.
+func AV1SequenceHeader(data []byte) []SideData {
+	obus, err := SplitAV1OBUs(data)
+	if err != nil {
+		return nil
+	}
+
+	for _, obu := range obus {
+		if AV1OBUTypeOf(obu) == AV1OBUSequenceHeader {
+			return []SideData{{Type: SideDataAV1SequenceHeader, Data: obu}}
+		}
+	}
+	return nil
+}
+
+// av1SequenceHeader holds the fields of an AV1 sequence header that are
+// needed to describe the stream.
+type av1SequenceHeader struct {
+	profile             uint32
+	reducedStillPicture bool
+	levelIdx            uint32 // Level of the first operating point
+	tier                uint32
+	width               int
+	height              int
+}
+
+// parseAV1SequenceHeader parses a sequence header OBU, including its
+// header.
+func parseAV1SequenceHeader(obu []byte) (av1SequenceHeader, error) {
+	if AV1OBUTypeOf(obu) != AV1OBUSequenceHeader {
+		return av1SequenceHeader{}, errBitstream("not an AV1 sequence header")
+	}
+	payload, _, err := parseAV1OBU(obu)
+	if err != nil {
+		return av1SequenceHeader{}, err
+	}
+
+	var header av1SequenceHeader
+	if err := header.parse(&bitReader{data: payload}); err != nil {
+		return av1SequenceHeader{}, fmt.Errorf("failed to parse AV1 sequence header: %w", err)
+	}
+	return header, nil
+}
+
+// parse reads the sequence header fields up to the maximum frame size.
+func (h *av1SequenceHeader) parse(r *bitReader) error {
+	var err error
+	if h.profile, err = r.readBits(3); err != nil {
+		return err
+	}
+	if err := r.skipBits(1); err != nil { // still_picture
+		return err
+	}
+	if h.reducedStillPicture, err = r.readFlag(); err != nil {
+		return err
+	}
+
+	if h.reducedStillPicture {
+		if h.levelIdx, err = r.readBits(5); err != nil {
+			return err
+		}
+	} else if err := h.parseOperatingPoints(r); err != nil {
+		return err
+	}
+
+	widthBits, err := r.readBits(4)
+	if err != nil {
+		return err
+	}
+	heightBits, err := r.readBits(4)
+	if err != nil {
+		return err
+	}
+	width, err := r.readBits(int(widthBits) + 1)
+	if err != nil {
+		return err
+	}
+	height, err := r.readBits(int(heightBits) + 1)
+	if err != nil {
+		return err
+	}
+	h.width, h.height = int(width)+1, int(height)+1
+	return nil
+}
+
+// parseOperatingPoints reads the timing and decoder model information and
+// the operating points, keeping the level and tier of the first one.
+func (h *av1SequenceHeader) parseOperatingPoints(r *bitReader) error {
+	timingInfo, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+	decoderModelInfo := false
+	bufferDelayLength := 0
+	if timingInfo {
+		// num_units_in_display_tick, time_scale
+		if err := r.skipBits(64); err != nil {
+			return err
+		}
+		equalPictureInterval, err := r.readFlag()
+		if err != nil {
+			return err
+		}
+		if equalPictureInterval {
+			if _, err := r.readUE(); err != nil { // num_ticks_per_picture_minus_1
+				return err
+			}
+		}
+
+		if decoderModelInfo, err = r.readFlag(); err != nil {
+			return err
+		}
+		if decoderModelInfo {
+			length, err := r.readBits(5)
+			if err != nil {
+				return err
+			}
+			bufferDelayLength = int(length) + 1
+			// num_units_in_decoding_tick, buffer_removal_time_length_minus_1,
+			// frame_presentation_time_length_minus_1
+			if err := r.skipBits(32 + 5 + 5); err != nil {
+				return err
+			}
+		}
+	}
+
+	initialDisplayDelay, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+	operatingPoints, err := r.readBits(5)
+	if err != nil {
+		return err
+	}
+	for i := 0; i <= int(operatingPoints); i++ {
+		if err := r.skipBits(12); err != nil { // operating_point_idc
+			return err
+		}
+		levelIdx, err := r.readBits(5)
+		if err != nil {
+			return err
+		}
+		var tier uint32
+		if levelIdx > 7 {
+			if tier, err = r.readBits(1); err != nil {
+				return err
+			}
+		}
+		if i == 0 {
+			h.levelIdx, h.tier = levelIdx, tier
+		}
+
+		if decoderModelInfo {
+			present, err := r.readFlag()
+			if err != nil {
+				return err
+			}
+			if present {
+				// decoder_buffer_delay, encoder_buffer_delay, low_delay_mode_flag
+				if err := r.skipBits(2*bufferDelayLength + 1); err != nil {
+					return err
+				}
+			}
+		}
+		if initialDisplayDelay {
+			present, err := r.readFlag()
+			if err != nil {
+				return err
+			}
+			if present {
+				if err := r.skipBits(4); err != nil { // initial_display_delay_minus_1
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// profileName returns the name of the sequence profile.
+func (h av1SequenceHeader) profileName() string {
+	switch h.profile {
+	case 0:
+		return "main"
+	case 1:
+		return "high"
+	case 2:
+		return "professional"
+	default:
+		return fmt.Sprintf("profile %d", h.profile)
+	}
+}
+
+// level returns the level of the first operating point as in "4.0",
+// followed by " high" for the high tier.
+func (h av1SequenceHeader) level() string {
+	if h.levelIdx == 31 {
+		return "max"
+	}
+	level := fmt.Sprintf("%d.%d", 2+h.levelIdx>>2, h.levelIdx&3)
+	if h.tier == 1 {
+		level += " high"
+	}
+	return level
+}
+
+// ParseAV1SequenceHeader decodes the profile, level and maximum resolution
+// of an AV1 sequence header OBU, e.g. from SideDataAV1SequenceHeader.
+//
+// Returns an error wrapping ErrInvalidBitstream if obu is not a valid
+// sequence header.
+func ParseAV1SequenceHeader(obu []byte) (CodecParams, error) {
+	header, err := parseAV1SequenceHeader(obu)
+	if err != nil {
+		return CodecParams{}, err
+	}
+
+	return CodecParams{
+		Type:    CodecAV1,
+		Profile: header.profileName(),
+		Level:   header.level(),
+		Width:   header.width,
+		Height:  header.height,
+	}, nil
+}
@@ -0,0 +1,118 @@
+package frames
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidBitstream is returned when a codec payload or header cannot be
+// parsed. Errors carrying it describe what was wrong and can be compared
+// with errors.Is.
+var ErrInvalidBitstream = errors.New("invalid bitstream")
+
+// errBitstream returns an ErrInvalidBitstream error with details.
+func errBitstream(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidBitstream, fmt.Sprintf(format, args...))
+}
+
+// bitReader reads a bitstream MSB first, as codec headers are laid out.
+type bitReader struct {
+	data []byte // Bytes being read
+	pos  int    // Position of the next bit
+}
+
+// readBit reads a single bit.
+func (r *bitReader) readBit() (uint32, error) {
+	if r.pos >= len(r.data)*8 {
+		return 0, errBitstream("unexpected end of data")
+	}
+	bit := uint32(r.data[r.pos/8]>>(7-r.pos%8)) & 1
+	r.pos++
+	return bit, nil
+}
+
+// readBits reads n bits, at most 32, as an unsigned integer.
+func (r *bitReader) readBits(n int) (uint32, error) {
+	var value uint32
+	for i := 0; i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		value = value<<1 | bit
+	}
+	return value, nil
+}
+
+// readFlag reads a single bit as a boolean.
+func (r *bitReader) readFlag() (bool, error) {
+	bit, err := r.readBit()
+	return bit == 1, err
+}
+
+// skipBits skips n bits.
+func (r *bitReader) skipBits(n int) error {
+	if r.pos+n > len(r.data)*8 {
+		return errBitstream("unexpected end of data")
+	}
+	r.pos += n
+	return nil
+}
+
+// readUE reads an unsigned Exp-Golomb code, as used by H.264 and H.265
+// parameter sets.
+func (r *bitReader) readUE() (uint32, error) {
+	zeros := 0
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			break
+		}
+		zeros++
+		if zeros > 31 {
+			return 0, errBitstream("Exp-Golomb code too long")
+		}
+	}
+
+	suffix, err := r.readBits(zeros)
+	if err != nil {
+		return 0, err
+	}
+	return uint32((uint64(1)<<zeros)-1) + suffix, nil
+}
+
+// readSE reads a signed Exp-Golomb code.
+func (r *bitReader) readSE() (int32, error) {
+	code, err := r.readUE()
+	if err != nil {
+		return 0, err
+	}
+	if code%2 == 1 {
+		return int32((code + 1) / 2), nil
+	}
+	return -int32(code / 2), nil
+}
+
+// unescapeRBSP removes the emulation prevention bytes from a NAL unit
+// payload: every 0x03 following two zero bytes was inserted by the encoder
+// to keep start codes out of the payload.
+func unescapeRBSP(data []byte) []byte {
+	rbsp := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return rbsp
+}
@@ -7,28 +7,57 @@ type CodecType string
 
 const (
 	CodecH264 CodecType = "h264" // H.264/AVC video codec
+	CodecHEVC CodecType = "hevc" // H.265/HEVC video codec
 	CodecVP8  CodecType = "vp8"  // VP8 video codec
 	CodecVP9  CodecType = "vp9"  // VP9 video codec
+	CodecAV1  CodecType = "av1"  // AV1 video codec
 	CodecOpus CodecType = "opus" // Opus audio codec
 	CodecAAC  CodecType = "aac"  // AAC audio codec
+	CodecJPEG CodecType = "jpeg" // JPEG still images, e.g. MJPEG cameras
+	CodecPNG  CodecType = "png"  // PNG still images
 )
 
 // CodecParams contains codec-specific configuration.
 // Used to configure encoders and decoders.
 type CodecParams struct {
-	Type      CodecType // Codec identifier
-	Profile   string    // Codec profile (e.g., "baseline", "main", "high")
-	Level     string    // Codec level (e.g., "3.1", "4.0")
-	BitRate   int       // Target bitrate in bits per second
-	FrameRate int       // Target frame rate for video
+	Type       CodecType // Codec identifier
+	Profile    string    // Codec profile (e.g., "baseline", "main", "high")
+	Level      string    // Codec level (e.g., "3.1", "4.0")
+	BitRate    int       // Target bitrate in bits per second
+	FrameRate  int       // Target frame rate for video
+	Width      int       // Picture width in pixels for video
+	Height     int       // Picture height in pixels for video
+	SampleRate int       // Sample rate in Hz for audio
+	Channels   int       // Channel count for audio
 }
 
 // IsVideo returns true if the codec is a video codec.
 func (c CodecType) IsVideo() bool {
-	return c == CodecH264 || c == CodecVP8 || c == CodecVP9
+	return c == CodecH264 || c == CodecHEVC || c == CodecVP8 || c == CodecVP9 || c == CodecAV1 ||
+		c == CodecJPEG || c == CodecPNG
 }
 
 // IsAudio returns true if the codec is an audio codec.
 func (c CodecType) IsAudio() bool {
 	return c == CodecOpus || c == CodecAAC
 }
+
+// IsKeyFrame reports whether a payload of this codec can be decoded on its
+// own. Audio frames and still images always can; video payloads are
+// inspected with the codec's key frame helper.
+func (c CodecType) IsKeyFrame(data []byte) bool {
+	switch c {
+	case CodecH264:
+		return H264IsKeyFrame(data)
+	case CodecHEVC:
+		return H265IsKeyFrame(data)
+	case CodecVP8:
+		return VP8IsKeyFrame(data)
+	case CodecVP9:
+		return VP9IsKeyFrame(data)
+	case CodecAV1:
+		return AV1IsKeyFrame(data)
+	default:
+		return c.IsAudio() || c == CodecJPEG || c == CodecPNG
+	}
+}
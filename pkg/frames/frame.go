@@ -4,15 +4,37 @@ import (
 	"time"
 )
 
-// Frame represents a media frame with metadata
+// Media types of a frame.
+const (
+	MediaTypeVideo = "video" // Video frame
+	MediaTypeAudio = "audio" // Audio frame
+)
+
+// Frame represents a media frame with metadata.
+// It contains both the raw frame data and the information needed for proper
+// playback, A/V synchronization and packaging. Frames are the fundamental
+// unit of media in the Relais system and are stored as is by the storage
+// package.
+//
+// Timestamp is the wall-clock time the frame was captured at, while PTS, DTS
+// and Duration are media timestamps counted in Timebase units. Media
+// timestamps are only meaningful within a track and are left zero by sources
+// that don't provide them.
 type Frame struct {
-	SessionID string
-	Index     int64
-	Data      []byte
-	Timestamp time.Time
-	MediaType string // "video" or "audio"
-	Codec     string
-	KeyFrame  bool
+	SessionID string     // Unique identifier for the media session this frame belongs to
+	Index     int64      // Sequential frame number within the session, used for ordering
+	Data      []byte     // Raw frame data (encoded video/audio) in the specified codec format
+	Timestamp time.Time  // When the frame was captured/created, used for synchronization
+	MediaType string     // Type of media (MediaTypeVideo or MediaTypeAudio)
+	Codec     CodecType  // Codec used for encoding
+	KeyFrame  bool       // Whether this is a key frame (for video), important for seeking
+	TrackID   uint32     // Track within the session, e.g. to tell audio from video
+	Sequence  uint64     // Sequence number of the frame within its track
+	PTS       int64      // Presentation timestamp in Timebase units
+	DTS       int64      // Decode timestamp in Timebase units, equal to PTS without B-frames
+	Duration  int64      // Frame duration in Timebase units, 0 if unknown
+	Timebase  Timebase   // Unit of PTS, DTS and Duration
+	SideData  []SideData // Codec configuration needed to decode the frame, e.g. SPS/PPS
 }
 
 // FrameMetadata contains frame information without the actual data
@@ -21,13 +43,19 @@ type FrameMetadata struct {
 	Index     int64
 	Timestamp time.Time
 	MediaType string
-	Codec     string
+	Codec     CodecType
 	KeyFrame  bool
-	Size      int
+	TrackID   uint32
+	Sequence  uint64
+	PTS       int64
+	DTS       int64
+	Duration  int64
+	Timebase  Timebase
+	Size      int // Size of the frame data in bytes
 }
 
 // NewFrame creates a new frame with the given parameters
-func NewFrame(sessionID string, index int64, data []byte, mediaType, codec string) Frame {
+func NewFrame(sessionID string, index int64, data []byte, mediaType string, codec CodecType) Frame {
 	return Frame{
 		SessionID: sessionID,
 		Index:     index,
@@ -38,3 +66,113 @@ func NewFrame(sessionID string, index int64, data []byte, mediaType, codec strin
 		KeyFrame:  false,
 	}
 }
+
+// Metadata returns the metadata of the frame.
+func (f Frame) Metadata() FrameMetadata {
+	return FrameMetadata{
+		SessionID: f.SessionID,
+		Index:     f.Index,
+		Timestamp: f.Timestamp,
+		MediaType: f.MediaType,
+		Codec:     f.Codec,
+		KeyFrame:  f.KeyFrame,
+		TrackID:   f.TrackID,
+		Sequence:  f.Sequence,
+		PTS:       f.PTS,
+		DTS:       f.DTS,
+		Duration:  f.Duration,
+		Timebase:  f.Timebase,
+		Size:      len(f.Data),
+	}
+}
+
+// PresentationTime returns the PTS of the frame as a duration.
+// Returns 0 if the frame has no timebase.
+func (f Frame) PresentationTime() time.Duration {
+	return f.Timebase.Duration(f.PTS)
+}
+
+// DecodeTime returns the DTS of the frame as a duration.
+// Returns 0 if the frame has no timebase.
+func (f Frame) DecodeTime() time.Duration {
+	return f.Timebase.Duration(f.DTS)
+}
+
+// FindSideData returns the data of the first side data entry of the given
+// type, or nil if the frame carries none.
+func (f Frame) FindSideData(t SideDataType) []byte {
+	for _, sd := range f.SideData {
+		if sd.Type == t {
+			return sd.Data
+		}
+	}
+	return nil
+}
+
+// Timebase is the unit of media timestamps as a fraction of a second,
+// e.g. 1/90000 for the RTP video clock.
+type Timebase struct {
+	Num uint32 // Numerator
+	Den uint32 // Denominator
+}
+
+// Common timebases.
+var (
+	Timebase90kHz = Timebase{Num: 1, Den: 90000}   // RTP video clock
+	Timebase48kHz = Timebase{Num: 1, Den: 48000}   // Opus and common audio sample rate
+	TimebaseMicro = Timebase{Num: 1, Den: 1000000} // Microseconds
+)
+
+// IsZero reports whether the timebase is unset.
+func (tb Timebase) IsZero() bool {
+	return tb.Num == 0 || tb.Den == 0
+}
+
+// Duration converts a timestamp in this timebase to a duration.
+// Returns 0 if the timebase is unset.
+func (tb Timebase) Duration(ts int64) time.Duration {
+	if tb.IsZero() {
+		return 0
+	}
+	// Split into whole and fractional units to avoid overflowing int64
+	units := ts * int64(tb.Num)
+	whole, frac := units/int64(tb.Den), units%int64(tb.Den)
+	return time.Duration(whole)*time.Second + time.Duration(frac*int64(time.Second)/int64(tb.Den))
+}
+
+// Timestamp converts a duration to a timestamp in this timebase, rounding
+// to the nearest unit. Returns 0 if the timebase is unset.
+func (tb Timebase) Timestamp(d time.Duration) int64 {
+	if tb.IsZero() {
+		return 0
+	}
+	if d < 0 {
+		return -tb.Timestamp(-d)
+	}
+
+	// Compute d * Den / Num in whole seconds and nanoseconds separately,
+	// unsigned to leave room for the rounding term
+	num, den, second := uint64(tb.Num), uint64(tb.Den), uint64(time.Second)
+	whole := uint64(d/time.Second) * den
+	q, r := whole/num, whole%num
+	return int64(q + (r*second+uint64(d%time.Second)*den+num*second/2)/(num*second))
+}
+
+// SideDataType identifies the kind of codec side data carried by a frame.
+type SideDataType uint8
+
+const (
+	SideDataSPS                 SideDataType = 1 // H.264/H.265 sequence parameter set
+	SideDataPPS                 SideDataType = 2 // H.264/H.265 picture parameter set
+	SideDataVPS                 SideDataType = 3 // H.265 video parameter set
+	SideDataOpusHeader          SideDataType = 4 // Opus identification header ("OpusHead")
+	SideDataAudioSpecificConfig SideDataType = 5 // AAC AudioSpecificConfig
+	SideDataAV1SequenceHeader   SideDataType = 6 // AV1 sequence header OBU
+)
+
+// SideData is codec configuration carried alongside frame data, such as the
+// parameter sets a decoder needs before it can decode a key frame.
+type SideData struct {
+	Type SideDataType // Kind of side data
+	Data []byte       // Raw side data, e.g. a NAL unit without start code
+}
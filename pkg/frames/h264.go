@@ -0,0 +1,458 @@
+package frames
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// H264NALType is the type of an H.264 NAL unit, from the low 5 bits of its
+// header byte.
+type H264NALType uint8
+
+const (
+	H264NALSlice H264NALType = 1 // Coded slice of a non-IDR picture
+	H264NALIDR   H264NALType = 5 // Coded slice of an IDR picture, i.e. a key frame
+	H264NALSEI   H264NALType = 6 // Supplemental enhancement information
+	H264NALSPS   H264NALType = 7 // Sequence parameter set
+	H264NALPPS   H264NALType = 8 // Picture parameter set
+	H264NALAUD   H264NALType = 9 // Access unit delimiter
+)
+
+// H264NALUnitType returns the type of an H.264 NAL unit, or 0 if nal is
+// empty.
+func H264NALUnitType(nal []byte) H264NALType {
+	if len(nal) == 0 {
+		return 0
+	}
+	return H264NALType(nal[0] & 0x1f)
+}
+
+// H264IsKeyFrame reports whether an H.264 payload, in Annex-B or 4-byte
+// AVCC form, contains an IDR slice. Ingress plugins use it to set
+// Frame.KeyFrame.
+func H264IsKeyFrame(data []byte) bool {
+	nals, err := SplitNALUnits(data)
+	if err != nil {
+		return false
+	}
+	for _, nal := range nals {
+		if H264NALUnitType(nal) == H264NALIDR {
+			return true
+		}
+	}
+	return false
+}
+
+// H264ParameterSets returns the SPS and PPS NAL units of an H.264 payload,
+// in Annex-B or 4-byte AVCC form, as side data for Frame.SideData.
+// Returns nil if the payload carries no parameter sets.
+func H264ParameterSets(data []byte) []SideData {
+	nals, err := SplitNALUnits(data)
+	if err != nil {
+		return nil
+	}
+
+	var sideData []SideData
+	for _, nal := range nals {
+		switch H264NALUnitType(nal) {
+		case H264NALSPS:
+			sideData = append(sideData, SideData{Type: SideDataSPS, Data: nal})
+		case H264NALPPS:
+			sideData = append(sideData, SideData{Type: SideDataPPS, Data: nal})
+		}
+	}
+	return sideData
+}
+
+// h264SPS holds the fields of an H.264 sequence parameter set that are
+// needed to describe the stream.
+type h264SPS struct {
+	profileIDC      uint8
+	constraintFlags uint8
+	levelIDC        uint8
+	chromaFormatIDC uint32
+	bitDepthLuma    uint32
+	bitDepthChroma  uint32
+	width           int
+	height          int
+}
+
+// h264HighProfiles are the profile_idc values whose SPS carries chroma
+// format and bit depth fields.
+var h264HighProfiles = map[uint8]bool{
+	100: true, 110: true, 122: true, 244: true, 44: true, 83: true, 86: true,
+	118: true, 128: true, 138: true, 139: true, 134: true, 135: true,
+}
+
+// parseH264SPS parses an SPS NAL unit, including its header byte.
+func parseH264SPS(nal []byte) (h264SPS, error) {
+	if H264NALUnitType(nal) != H264NALSPS {
+		return h264SPS{}, errBitstream("not an H.264 SPS")
+	}
+	rbsp := unescapeRBSP(nal[1:])
+	if len(rbsp) < 3 {
+		return h264SPS{}, errBitstream("truncated H.264 SPS")
+	}
+
+	sps := h264SPS{
+		profileIDC:      rbsp[0],
+		constraintFlags: rbsp[1],
+		levelIDC:        rbsp[2],
+		chromaFormatIDC: 1,
+		bitDepthLuma:    8,
+		bitDepthChroma:  8,
+	}
+	r := &bitReader{data: rbsp, pos: 24}
+	if err := sps.parse(r); err != nil {
+		return h264SPS{}, fmt.Errorf("failed to parse H.264 SPS: %w", err)
+	}
+	return sps, nil
+}
+
+// parse reads the SPS fields following profile, constraints and level.
+func (sps *h264SPS) parse(r *bitReader) error {
+	if _, err := r.readUE(); err != nil { // seq_parameter_set_id
+		return err
+	}
+
+	separateColourPlane := false
+	if h264HighProfiles[sps.profileIDC] {
+		var err error
+		if sps.chromaFormatIDC, err = r.readUE(); err != nil {
+			return err
+		}
+		if sps.chromaFormatIDC == 3 {
+			if separateColourPlane, err = r.readFlag(); err != nil {
+				return err
+			}
+		}
+		lumaMinus8, err := r.readUE()
+		if err != nil {
+			return err
+		}
+		chromaMinus8, err := r.readUE()
+		if err != nil {
+			return err
+		}
+		sps.bitDepthLuma, sps.bitDepthChroma = lumaMinus8+8, chromaMinus8+8
+		if err := r.skipBits(1); err != nil { // qpprime_y_zero_transform_bypass_flag
+			return err
+		}
+
+		scalingMatrix, err := r.readFlag()
+		if err != nil {
+			return err
+		}
+		if scalingMatrix {
+			lists := 8
+			if sps.chromaFormatIDC == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				present, err := r.readFlag()
+				if err != nil {
+					return err
+				}
+				if !present {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				if err := skipScalingList(r, size); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if _, err := r.readUE(); err != nil { // log2_max_frame_num_minus4
+		return err
+	}
+	pocType, err := r.readUE()
+	if err != nil {
+		return err
+	}
+	switch pocType {
+	case 0:
+		if _, err := r.readUE(); err != nil { // log2_max_pic_order_cnt_lsb_minus4
+			return err
+		}
+	case 1:
+		if err := r.skipBits(1); err != nil { // delta_pic_order_always_zero_flag
+			return err
+		}
+		if _, err := r.readSE(); err != nil { // offset_for_non_ref_pic
+			return err
+		}
+		if _, err := r.readSE(); err != nil { // offset_for_top_to_bottom_field
+			return err
+		}
+		cycle, err := r.readUE()
+		if err != nil {
+			return err
+		}
+		for i := uint32(0); i < cycle; i++ {
+			if _, err := r.readSE(); err != nil { // offset_for_ref_frame
+				return err
+			}
+		}
+	}
+
+	if _, err := r.readUE(); err != nil { // max_num_ref_frames
+		return err
+	}
+	if err := r.skipBits(1); err != nil { // gaps_in_frame_num_value_allowed_flag
+		return err
+	}
+	widthMbs, err := r.readUE()
+	if err != nil {
+		return err
+	}
+	heightMapUnits, err := r.readUE()
+	if err != nil {
+		return err
+	}
+	frameMbsOnly, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+	if !frameMbsOnly {
+		if err := r.skipBits(1); err != nil { // mb_adaptive_frame_field_flag
+			return err
+		}
+	}
+	if err := r.skipBits(1); err != nil { // direct_8x8_inference_flag
+		return err
+	}
+
+	fieldFactor := 2
+	if frameMbsOnly {
+		fieldFactor = 1
+	}
+	sps.width = int(widthMbs+1) * 16
+	sps.height = fieldFactor * int(heightMapUnits+1) * 16
+
+	cropping, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+	if cropping {
+		var crop [4]uint32 // left, right, top, bottom
+		for i := range crop {
+			if crop[i], err = r.readUE(); err != nil {
+				return err
+			}
+		}
+
+		// Cropping is counted in chroma samples
+		cropX, cropY := 1, fieldFactor
+		if !separateColourPlane && sps.chromaFormatIDC != 0 {
+			subWidth, subHeight := 2, 1
+			if sps.chromaFormatIDC == 1 {
+				subHeight = 2
+			} else if sps.chromaFormatIDC == 3 {
+				subWidth = 1
+			}
+			cropX, cropY = subWidth, subHeight*fieldFactor
+		}
+		sps.width -= cropX * int(crop[0]+crop[1])
+		sps.height -= cropY * int(crop[2]+crop[3])
+		if sps.width <= 0 || sps.height <= 0 {
+			return errBitstream("cropping exceeds picture size")
+		}
+	}
+
+	return nil
+}
+
+// skipScalingList skips a scaling list of an SPS or PPS.
+func skipScalingList(r *bitReader, size int) error {
+	last, next := int32(8), int32(8)
+	for i := 0; i < size; i++ {
+		if next != 0 {
+			delta, err := r.readSE()
+			if err != nil {
+				return err
+			}
+			next = (last + delta + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+	return nil
+}
+
+// profile returns the name of the SPS profile.
+func (sps h264SPS) profile() string {
+	switch sps.profileIDC {
+	case 66:
+		if sps.constraintFlags&0x40 != 0 {
+			return "constrained baseline"
+		}
+		return "baseline"
+	case 77:
+		return "main"
+	case 88:
+		return "extended"
+	case 100:
+		return "high"
+	case 110:
+		return "high 10"
+	case 122:
+		return "high 4:2:2"
+	case 244:
+		return "high 4:4:4"
+	case 44:
+		return "cavlc 4:4:4"
+	default:
+		return fmt.Sprintf("profile %d", sps.profileIDC)
+	}
+}
+
+// level returns the SPS level as in "3.1".
+func (sps h264SPS) level() string {
+	// Level 1b is signalled by constraint_set3_flag in baseline and main
+	if sps.levelIDC == 9 || (sps.levelIDC == 11 && sps.constraintFlags&0x10 != 0 &&
+		(sps.profileIDC == 66 || sps.profileIDC == 77)) {
+		return "1b"
+	}
+	return fmt.Sprintf("%d.%d", sps.levelIDC/10, sps.levelIDC%10)
+}
+
+// ParseH264SPS decodes the profile, level and resolution of an H.264
+// sequence parameter set NAL unit, e.g. from SideDataSPS.
+//
+// Returns an error wrapping ErrInvalidBitstream if nal is not a valid SPS.
+func ParseH264SPS(nal []byte) (CodecParams, error) {
+	sps, err := parseH264SPS(nal)
+	if err != nil {
+		return CodecParams{}, err
+	}
+
+	return CodecParams{
+		Type:    CodecH264,
+		Profile: sps.profile(),
+		Level:   sps.level(),
+		Width:   sps.width,
+		Height:  sps.height,
+	}, nil
+}
+
+// H264DecoderConfig is an AVCDecoderConfigurationRecord ("avcC" box), which
+// carries the parameter sets of an H.264 stream in MP4 and tells the size of
+// the NAL unit length prefix of its samples.
+type H264DecoderConfig struct {
+	LengthSize int      // Size of the NAL unit length prefix in bytes: 1, 2 or 4
+	SPS        [][]byte // Sequence parameter set NAL units
+	PPS        [][]byte // Picture parameter set NAL units
+}
+
+// Marshal serializes the configuration record. Profile and level are taken
+// from the first SPS.
+//
+// Returns an error if there is no valid SPS or a parameter set is too large.
+func (c H264DecoderConfig) Marshal() ([]byte, error) {
+	if len(c.SPS) == 0 || len(c.SPS) > 31 || len(c.PPS) > 255 {
+		return nil, errBitstream("invalid number of H.264 parameter sets")
+	}
+	lengthSize := c.LengthSize
+	if lengthSize == 0 {
+		lengthSize = 4
+	}
+	if lengthSize != 1 && lengthSize != 2 && lengthSize != 4 {
+		return nil, errBitstream("invalid NAL unit length size %d", lengthSize)
+	}
+	sps, err := parseH264SPS(c.SPS[0])
+	if err != nil {
+		return nil, err
+	}
+
+	record := []byte{
+		1, // configurationVersion
+		sps.profileIDC,
+		sps.constraintFlags,
+		sps.levelIDC,
+		0xfc | byte(lengthSize-1),
+		0xe0 | byte(len(c.SPS)),
+	}
+	if record, err = appendParameterSets(record, c.SPS); err != nil {
+		return nil, err
+	}
+	record = append(record, byte(len(c.PPS)))
+	if record, err = appendParameterSets(record, c.PPS); err != nil {
+		return nil, err
+	}
+
+	// High profiles carry chroma format and bit depths
+	if h264HighProfiles[sps.profileIDC] {
+		record = append(record,
+			0xfc|byte(sps.chromaFormatIDC),
+			0xf8|byte(sps.bitDepthLuma-8),
+			0xf8|byte(sps.bitDepthChroma-8),
+			0, // numOfSequenceParameterSetExt
+		)
+	}
+	return record, nil
+}
+
+// ParseH264DecoderConfig parses an AVCDecoderConfigurationRecord.
+//
+// Returns an error wrapping ErrInvalidBitstream if the record is truncated
+// or has an unknown version.
+func ParseH264DecoderConfig(record []byte) (H264DecoderConfig, error) {
+	if len(record) < 6 {
+		return H264DecoderConfig{}, errBitstream("truncated H.264 decoder configuration")
+	}
+	if record[0] != 1 {
+		return H264DecoderConfig{}, errBitstream("unknown H.264 decoder configuration version %d", record[0])
+	}
+
+	var err error
+	config := H264DecoderConfig{LengthSize: int(record[4]&0x03) + 1}
+	if config.LengthSize == 3 {
+		return H264DecoderConfig{}, errBitstream("invalid NAL unit length size 3")
+	}
+
+	data := record[5:]
+	if config.SPS, data, err = readParameterSets(data[1:], int(data[0]&0x1f)); err != nil {
+		return H264DecoderConfig{}, err
+	}
+	if len(data) < 1 {
+		return H264DecoderConfig{}, errBitstream("truncated H.264 decoder configuration")
+	}
+	if config.PPS, _, err = readParameterSets(data[1:], int(data[0])); err != nil {
+		return H264DecoderConfig{}, err
+	}
+	return config, nil
+}
+
+// appendParameterSets appends parameter sets prefixed with their 16-bit
+// length, as in decoder configuration records.
+func appendParameterSets(record []byte, sets [][]byte) ([]byte, error) {
+	for _, nal := range sets {
+		if len(nal) > 0xffff {
+			return nil, errBitstream("parameter set too large")
+		}
+		record = binary.BigEndian.AppendUint16(record, uint16(len(nal)))
+		record = append(record, nal...)
+	}
+	return record, nil
+}
+
+// readParameterSets reads count parameter sets prefixed with their 16-bit
+// length and returns them with the remaining data.
+func readParameterSets(data []byte, count int) ([][]byte, []byte, error) {
+	sets := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data)) {
+			return nil, nil, errBitstream("truncated decoder configuration")
+		}
+		length := int(binary.BigEndian.Uint16(data))
+		sets = append(sets, data[2:2+length])
+		data = data[2+length:]
+	}
+	return sets, data, nil
+}
@@ -0,0 +1,266 @@
+package frames
+
+import (
+	"fmt"
+)
+
+// H265NALType is the type of an H.265 NAL unit, from bits 1 to 6 of its
+// 2-byte header.
+type H265NALType uint8
+
+const (
+	H265NALTrailR   H265NALType = 1  // Coded slice of a trailing picture
+	H265NALBLAWLP   H265NALType = 16 // First of the intra random access point types
+	H265NALIDRWRADL H265NALType = 19 // Coded slice of an IDR picture with leading pictures
+	H265NALIDRNLP   H265NALType = 20 // Coded slice of an IDR picture without leading pictures
+	H265NALCRA      H265NALType = 21 // Coded slice of a clean random access picture
+	H265NALIRAPMax  H265NALType = 23 // Last of the intra random access point types
+	H265NALVPS      H265NALType = 32 // Video parameter set
+	H265NALSPS      H265NALType = 33 // Sequence parameter set
+	H265NALPPS      H265NALType = 34 // Picture parameter set
+	H265NALAUD      H265NALType = 35 // Access unit delimiter
+	H265NALSEI      H265NALType = 39 // Prefix supplemental enhancement information
+)
+
+// H265NALUnitType returns the type of an H.265 NAL unit, or 0 if nal is
+// empty.
+func H265NALUnitType(nal []byte) H265NALType {
+	if len(nal) == 0 {
+		return 0
+	}
+	return H265NALType(nal[0] >> 1 & 0x3f)
+}
+
+// H265IsKeyFrame reports whether an H.265 payload, in Annex-B or 4-byte
+// AVCC form, contains an intra random access point (IDR, CRA or BLA)
+// slice, from which decoding can start.
+func H265IsKeyFrame(data []byte) bool {
+	nals, err := SplitNALUnits(data)
+	if err != nil {
+		return false
+	}
+	for _, nal := range nals {
+		if t := H265NALUnitType(nal); t >= H265NALBLAWLP && t <= H265NALIRAPMax {
+			return true
+		}
+	}
+	return false
+}
+
+// H265ParameterSets returns the VPS, SPS and PPS NAL units of an H.265
+// payload, in Annex-B or 4-byte AVCC form, as side data for
+// Frame.SideData. Returns nil if the payload carries no parameter sets.
+func H265ParameterSets(data []byte) []SideData {
+	nals, err := SplitNALUnits(data)
+	if err != nil {
+		return nil
+	}
+
+	var sideData []SideData
+	for _, nal := range nals {
+		switch H265NALUnitType(nal) {
+		case H265NALVPS:
+			sideData = append(sideData, SideData{Type: SideDataVPS, Data: nal})
+		case H265NALSPS:
+			sideData = append(sideData, SideData{Type: SideDataSPS, Data: nal})
+		case H265NALPPS:
+			sideData = append(sideData, SideData{Type: SideDataPPS, Data: nal})
+		}
+	}
+	return sideData
+}
+
+// h265SPS holds the fields of an H.265 sequence parameter set that are
+// needed to describe the stream.
+type h265SPS struct {
+	profileIDC uint32
+	tier       uint32
+	levelIDC   uint32
+	width      int
+	height     int
+}
+
+// parseH265SPS parses an SPS NAL unit, including its 2-byte header.
+func parseH265SPS(nal []byte) (h265SPS, error) {
+	if H265NALUnitType(nal) != H265NALSPS || len(nal) < 2 {
+		return h265SPS{}, errBitstream("not an H.265 SPS")
+	}
+
+	var sps h265SPS
+	r := &bitReader{data: unescapeRBSP(nal[2:])}
+	if err := sps.parse(r); err != nil {
+		return h265SPS{}, fmt.Errorf("failed to parse H.265 SPS: %w", err)
+	}
+	return sps, nil
+}
+
+// parse reads the SPS fields up to the conformance window.
+func (sps *h265SPS) parse(r *bitReader) error {
+	if err := r.skipBits(4); err != nil { // sps_video_parameter_set_id
+		return err
+	}
+	maxSubLayers, err := r.readBits(3) // sps_max_sub_layers_minus1
+	if err != nil {
+		return err
+	}
+	if err := r.skipBits(1); err != nil { // sps_temporal_id_nesting_flag
+		return err
+	}
+	if err := sps.parseProfileTierLevel(r, int(maxSubLayers)); err != nil {
+		return err
+	}
+
+	if _, err := r.readUE(); err != nil { // sps_seq_parameter_set_id
+		return err
+	}
+	chromaFormatIDC, err := r.readUE()
+	if err != nil {
+		return err
+	}
+	separateColourPlane := false
+	if chromaFormatIDC == 3 {
+		if separateColourPlane, err = r.readFlag(); err != nil {
+			return err
+		}
+	}
+	width, err := r.readUE()
+	if err != nil {
+		return err
+	}
+	height, err := r.readUE()
+	if err != nil {
+		return err
+	}
+	sps.width, sps.height = int(width), int(height)
+
+	conformanceWindow, err := r.readFlag()
+	if err != nil {
+		return err
+	}
+	if conformanceWindow {
+		var window [4]uint32 // left, right, top, bottom
+		for i := range window {
+			if window[i], err = r.readUE(); err != nil {
+				return err
+			}
+		}
+
+		// The window is counted in chroma samples
+		subWidth, subHeight := 1, 1
+		if !separateColourPlane {
+			if chromaFormatIDC == 1 || chromaFormatIDC == 2 {
+				subWidth = 2
+			}
+			if chromaFormatIDC == 1 {
+				subHeight = 2
+			}
+		}
+		sps.width -= subWidth * int(window[0]+window[1])
+		sps.height -= subHeight * int(window[2]+window[3])
+	}
+	if sps.width <= 0 || sps.height <= 0 {
+		return errBitstream("invalid picture size")
+	}
+
+	return nil
+}
+
+// parseProfileTierLevel reads the general profile, tier and level of a
+// profile_tier_level structure and skips the sub-layer ones.
+func (sps *h265SPS) parseProfileTierLevel(r *bitReader, maxSubLayers int) error {
+	if err := r.skipBits(2); err != nil { // general_profile_space
+		return err
+	}
+	var err error
+	if sps.tier, err = r.readBits(1); err != nil {
+		return err
+	}
+	if sps.profileIDC, err = r.readBits(5); err != nil {
+		return err
+	}
+	// Compatibility flags, source flags and constraint flags
+	if err := r.skipBits(32 + 48); err != nil {
+		return err
+	}
+	if sps.levelIDC, err = r.readBits(8); err != nil {
+		return err
+	}
+
+	profilePresent := make([]bool, maxSubLayers)
+	levelPresent := make([]bool, maxSubLayers)
+	for i := 0; i < maxSubLayers; i++ {
+		if profilePresent[i], err = r.readFlag(); err != nil {
+			return err
+		}
+		if levelPresent[i], err = r.readFlag(); err != nil {
+			return err
+		}
+	}
+	if maxSubLayers > 0 {
+		if err := r.skipBits(2 * (8 - maxSubLayers)); err != nil { // reserved_zero_2bits
+			return err
+		}
+	}
+	for i := 0; i < maxSubLayers; i++ {
+		if profilePresent[i] {
+			if err := r.skipBits(88); err != nil {
+				return err
+			}
+		}
+		if levelPresent[i] {
+			if err := r.skipBits(8); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// profile returns the name of the SPS profile.
+func (sps h265SPS) profile() string {
+	switch sps.profileIDC {
+	case 1:
+		return "main"
+	case 2:
+		return "main 10"
+	case 3:
+		return "main still picture"
+	case 4:
+		return "range extensions"
+	case 5:
+		return "high throughput"
+	case 9:
+		return "screen content"
+	default:
+		return fmt.Sprintf("profile %d", sps.profileIDC)
+	}
+}
+
+// level returns the SPS level as in "4.1", followed by " high" for the
+// high tier.
+func (sps h265SPS) level() string {
+	level := fmt.Sprintf("%d.%d", sps.levelIDC/30, sps.levelIDC%30/3)
+	if sps.tier == 1 {
+		level += " high"
+	}
+	return level
+}
+
+// ParseH265SPS decodes the profile, level and resolution of an H.265
+// sequence parameter set NAL unit, e.g. from SideDataSPS.
+//
+// Returns an error wrapping ErrInvalidBitstream if nal is not a valid SPS.
+func ParseH265SPS(nal []byte) (CodecParams, error) {
+	sps, err := parseH265SPS(nal)
+	if err != nil {
+		return CodecParams{}, err
+	}
+
+	return CodecParams{
+		Type:    CodecHEVC,
+		Profile: sps.profile(),
+		Level:   sps.level(),
+		Width:   sps.width,
+		Height:  sps.height,
+	}, nil
+}
@@ -0,0 +1,155 @@
+package frames
+
+import (
+	"encoding/binary"
+)
+
+// H.264 and H.265 payloads are sequences of NAL units framed in one of two
+// ways:
+//
+//   - Annex-B, used by raw elementary streams, RTSP cameras and WebRTC
+//     stacks: every NAL unit is preceded by a 00 00 01 or 00 00 00 01 start
+//     code.
+//   - AVCC (also length-prefixed), used by MP4 and Matroska: every NAL unit
+//     is preceded by its length as a big-endian integer of 1, 2 or 4 bytes,
+//     4 unless a decoder configuration record says otherwise.
+//
+// The helpers below split and build both forms. NAL units are returned
+// without start code or length prefix.
+
+// annexBStartCode is the start code written in front of every NAL unit.
+var annexBStartCode = []byte{0, 0, 0, 1}
+
+// IsAnnexB reports whether data starts with an Annex-B start code.
+// A length-prefixed payload whose first NAL unit is 1 byte long looks the
+// same, but such NAL units carry no data and don't occur in practice.
+func IsAnnexB(data []byte) bool {
+	return len(data) >= 3 && data[0] == 0 && data[1] == 0 &&
+		(data[2] == 1 || (len(data) >= 4 && data[2] == 0 && data[3] == 1))
+}
+
+// SplitAnnexB splits an Annex-B payload into its NAL units. Zero bytes
+// trailing a NAL unit belong to the next start code and are dropped, and so
+// are empty NAL units. Data before the first start code is ignored.
+func SplitAnnexB(data []byte) [][]byte {
+	nals := make([][]byte, 0)
+	start := -1
+	for i := 0; i+2 < len(data); {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			i++
+			continue
+		}
+		if start >= 0 {
+			nals = appendNAL(nals, data[start:i])
+		}
+		i += 3
+		start = i
+	}
+	if start >= 0 {
+		nals = appendNAL(nals, data[start:])
+	}
+	return nals
+}
+
+// appendNAL appends a NAL unit without its trailing zero bytes, unless it
+// is empty.
+func appendNAL(nals [][]byte, nal []byte) [][]byte {
+	end := len(nal)
+	for end > 0 && nal[end-1] == 0 {
+		end--
+	}
+	if end == 0 {
+		return nals
+	}
+	return append(nals, nal[:end])
+}
+
+// SplitAVCC splits a length-prefixed payload into its NAL units.
+// lengthSize is the size of the length prefix in bytes: 1, 2 or 4.
+//
+// Returns an error if lengthSize is invalid or a NAL unit is truncated.
+func SplitAVCC(data []byte, lengthSize int) ([][]byte, error) {
+	if lengthSize != 1 && lengthSize != 2 && lengthSize != 4 {
+		return nil, errBitstream("invalid NAL unit length size %d", lengthSize)
+	}
+
+	nals := make([][]byte, 0)
+	for len(data) > 0 {
+		if len(data) < lengthSize {
+			return nil, errBitstream("truncated NAL unit length")
+		}
+		var length uint64
+		for _, b := range data[:lengthSize] {
+			length = length<<8 | uint64(b)
+		}
+		data = data[lengthSize:]
+		if length > uint64(len(data)) {
+			return nil, errBitstream("NAL unit length %d exceeds payload", length)
+		}
+		if length > 0 {
+			nals = append(nals, data[:length])
+		}
+		data = data[length:]
+	}
+	return nals, nil
+}
+
+// SplitNALUnits splits an H.264 or H.265 payload into its NAL units,
+// telling Annex-B from AVCC with IsAnnexB. AVCC payloads are expected to
+// use 4-byte lengths.
+//
+// Returns an error if a length-prefixed payload is truncated.
+func SplitNALUnits(data []byte) ([][]byte, error) {
+	if IsAnnexB(data) {
+		return SplitAnnexB(data), nil
+	}
+	return SplitAVCC(data, 4)
+}
+
+// JoinAnnexB builds an Annex-B payload from NAL units, using 4-byte start
+// codes.
+func JoinAnnexB(nals [][]byte) []byte {
+	size := 0
+	for _, nal := range nals {
+		size += len(annexBStartCode) + len(nal)
+	}
+
+	data := make([]byte, 0, size)
+	for _, nal := range nals {
+		data = append(data, annexBStartCode...)
+		data = append(data, nal...)
+	}
+	return data
+}
+
+// JoinAVCC builds a payload of NAL units prefixed with their 4-byte length.
+func JoinAVCC(nals [][]byte) []byte {
+	size := 0
+	for _, nal := range nals {
+		size += 4 + len(nal)
+	}
+
+	data := make([]byte, 0, size)
+	for _, nal := range nals {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(nal)))
+		data = append(data, nal...)
+	}
+	return data
+}
+
+// AnnexBToAVCC converts an Annex-B payload to 4-byte length-prefixed form.
+func AnnexBToAVCC(data []byte) []byte {
+	return JoinAVCC(SplitAnnexB(data))
+}
+
+// AVCCToAnnexB converts a length-prefixed payload to Annex-B.
+// lengthSize is the size of the length prefix in bytes: 1, 2 or 4.
+//
+// Returns an error if lengthSize is invalid or a NAL unit is truncated.
+func AVCCToAnnexB(data []byte, lengthSize int) ([]byte, error) {
+	nals, err := SplitAVCC(data, lengthSize)
+	if err != nil {
+		return nil, err
+	}
+	return JoinAnnexB(nals), nil
+}
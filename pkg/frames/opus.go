@@ -0,0 +1,102 @@
+package frames
+
+import (
+	"time"
+)
+
+// OpusSampleRate is the rate Opus always decodes at, whatever the input
+// sample rate of the encoder was.
+const OpusSampleRate = 48000
+
+// OpusTOC is the table of contents byte that starts every Opus packet
+// (RFC 6716, section 3.1).
+type OpusTOC struct {
+	Config        uint8         // Configuration number, 0 to 31
+	Mode          string        // "silk", "hybrid" or "celt"
+	Bandwidth     string        // "narrowband" up to "fullband"
+	FrameDuration time.Duration // Duration of each frame in the packet
+	Stereo        bool          // Whether the frames are coded in stereo
+	Frames        int           // Number of frames in the packet
+}
+
+// Channels returns the number of coded channels, 1 or 2.
+func (t OpusTOC) Channels() int {
+	if t.Stereo {
+		return 2
+	}
+	return 1
+}
+
+// Duration returns the duration of the whole packet.
+func (t OpusTOC) Duration() time.Duration {
+	return t.FrameDuration * time.Duration(t.Frames)
+}
+
+// ParseOpusTOC decodes the TOC byte of an Opus packet, and the frame count
+// byte of packets carrying an arbitrary number of frames.
+//
+// Returns an error wrapping ErrInvalidBitstream if packet is empty or
+// truncated.
+func ParseOpusTOC(packet []byte) (OpusTOC, error) {
+	if len(packet) == 0 {
+		return OpusTOC{}, errBitstream("empty Opus packet")
+	}
+
+	toc := OpusTOC{
+		Config: packet[0] >> 3,
+		Stereo: packet[0]&0x04 != 0,
+	}
+	switch config := toc.Config; {
+	case config < 12:
+		toc.Mode = "silk"
+		toc.Bandwidth = [...]string{"narrowband", "mediumband", "wideband"}[config/4]
+		toc.FrameDuration = [...]time.Duration{10, 20, 40, 60}[config%4] * time.Millisecond
+	case config < 16:
+		toc.Mode = "hybrid"
+		toc.Bandwidth = [...]string{"superwideband", "fullband"}[(config-12)/2]
+		toc.FrameDuration = [...]time.Duration{10, 20}[config%2] * time.Millisecond
+	default:
+		toc.Mode = "celt"
+		toc.Bandwidth = [...]string{"narrowband", "wideband", "superwideband", "fullband"}[(config-16)/4]
+		toc.FrameDuration = [...]time.Duration{2500, 5000, 10000, 20000}[config%4] * time.Microsecond
+	}
+
+	switch packet[0] & 0x03 {
+	case 0:
+		toc.Frames = 1
+	case 1, 2:
+		toc.Frames = 2
+	default:
+		if len(packet) < 2 {
+			return OpusTOC{}, errBitstream("missing Opus frame count")
+		}
+		toc.Frames = int(packet[1] & 0x3f)
+		if toc.Frames == 0 {
+			return OpusTOC{}, errBitstream("invalid Opus frame count 0")
+		}
+	}
+	return toc, nil
+}
+
+// ParseOpusHead decodes the channel count of an Opus identification header
+// ("OpusHead", RFC 7845 section 5.1), e.g. from SideDataOpusHeader.
+//
+// Returns an error wrapping ErrInvalidBitstream if data is not an
+// identification header.
+func ParseOpusHead(data []byte) (CodecParams, error) {
+	if len(data) < 19 || string(data[:8]) != "OpusHead" {
+		return CodecParams{}, errBitstream("not an Opus identification header")
+	}
+	if data[8]&0xf0 != 0 {
+		return CodecParams{}, errBitstream("unsupported Opus header version %d", data[8])
+	}
+	if data[9] == 0 {
+		return CodecParams{}, errBitstream("invalid Opus channel count 0")
+	}
+
+	return CodecParams{
+		Type:       CodecOpus,
+		SampleRate: OpusSampleRate,
+		Channels:   int(data[9]),
+	}, nil
+}
@@ -0,0 +1,161 @@
+package frames
+
+import (
+	"fmt"
+)
+
+// VP8IsKeyFrame reports whether a VP8 frame is a key frame, from the frame
+// type bit of its frame tag.
+func VP8IsKeyFrame(data []byte) bool {
+	return len(data) >= 3 && data[0]&0x01 == 0
+}
+
+// ParseVP8KeyFrame decodes the dimensions of a VP8 key frame from its frame
+// header (RFC 6386, section 9.1). Inter frames carry no dimensions.
+//
+// Returns an error wrapping ErrInvalidBitstream if data is not a VP8 key
+// frame.
+func ParseVP8KeyFrame(data []byte) (CodecParams, error) {
+	if !VP8IsKeyFrame(data) {
+		return CodecParams{}, errBitstream("not a VP8 key frame")
+	}
+	if len(data) < 10 {
+		return CodecParams{}, errBitstream("truncated VP8 key frame header")
+	}
+	if data[3] != 0x9d || data[4] != 0x01 || data[5] != 0x2a {
+		return CodecParams{}, errBitstream("missing VP8 start code")
+	}
+
+	// The upper two bits of each dimension are the scaling mode
+	return CodecParams{
+		Type:    CodecVP8,
+		Profile: fmt.Sprint(data[0] >> 1 & 0x07),
+		Width:   int(data[6]) | int(data[7]&0x3f)<<8,
+		Height:  int(data[8]) | int(data[9]&0x3f)<<8,
+	}, nil
+}
+
+// vp9Header holds the fields of a VP9 uncompressed frame header up to the
+// frame size.
+type vp9Header struct {
+	profile  uint32
+	keyFrame bool
+	width    int
+	height   int
+}
+
+// parseVP9Header parses a VP9 uncompressed frame header. The frame size is
+// only decoded for key frames.
+func parseVP9Header(data []byte) (vp9Header, error) {
+	r := &bitReader{data: data}
+
+	marker, err := r.readBits(2)
+	if err != nil {
+		return vp9Header{}, err
+	}
+	if marker != 2 {
+		return vp9Header{}, errBitstream("invalid VP9 frame marker")
+	}
+	low, err := r.readBit()
+	if err != nil {
+		return vp9Header{}, err
+	}
+	high, err := r.readBit()
+	if err != nil {
+		return vp9Header{}, err
+	}
+	header := vp9Header{profile: high<<1 | low}
+	if header.profile == 3 {
+		if err := r.skipBits(1); err != nil { // reserved_zero
+			return vp9Header{}, err
+		}
+	}
+
+	// A shown existing frame repeats an earlier frame without coding one
+	showExisting, err := r.readFlag()
+	if err != nil || showExisting {
+		return header, err
+	}
+	frameType, err := r.readBit()
+	if err != nil {
+		return vp9Header{}, err
+	}
+	header.keyFrame = frameType == 0
+	if !header.keyFrame {
+		return header, nil
+	}
+
+	if err := r.skipBits(2); err != nil { // show_frame, error_resilient_mode
+		return vp9Header{}, err
+	}
+	sync, err := r.readBits(24)
+	if err != nil {
+		return vp9Header{}, err
+	}
+	if sync != 0x498342 {
+		return vp9Header{}, errBitstream("missing VP9 sync code")
+	}
+
+	// color_config
+	if header.profile >= 2 {
+		if err := r.skipBits(1); err != nil { // ten_or_twelve_bit
+			return vp9Header{}, err
+		}
+	}
+	colorSpace, err := r.readBits(3)
+	if err != nil {
+		return vp9Header{}, err
+	}
+	colorBits := 0
+	if colorSpace != 7 { // CS_RGB
+		colorBits++ // color_range
+		if header.profile == 1 || header.profile == 3 {
+			colorBits += 3 // subsampling_x, subsampling_y, reserved_zero
+		}
+	} else if header.profile == 1 || header.profile == 3 {
+		colorBits++ // reserved_zero
+	}
+	if err := r.skipBits(colorBits); err != nil {
+		return vp9Header{}, err
+	}
+
+	width, err := r.readBits(16)
+	if err != nil {
+		return vp9Header{}, err
+	}
+	height, err := r.readBits(16)
+	if err != nil {
+		return vp9Header{}, err
+	}
+	header.width, header.height = int(width)+1, int(height)+1
+	return header, nil
+}
+
+// VP9IsKeyFrame reports whether a VP9 frame is a key frame, from its
+// uncompressed header.
+func VP9IsKeyFrame(data []byte) bool {
+	header, err := parseVP9Header(data)
+	return err == nil && header.keyFrame
+}
+
+// ParseVP9KeyFrame decodes the profile and dimensions of a VP9 key frame
+// from its uncompressed header. Inter frames carry no dimensions.
+//
+// Returns an error wrapping ErrInvalidBitstream if data is not a VP9 key
+// frame.
+func ParseVP9KeyFrame(data []byte) (CodecParams, error) {
+	header, err := parseVP9Header(data)
+	if err != nil {
+		return CodecParams{}, err
+	}
+	if !header.keyFrame {
+		return CodecParams{}, errBitstream("not a VP9 key frame")
+	}
+
+	return CodecParams{
+		Type:    CodecVP9,
+		Profile: fmt.Sprint(header.profile),
+		Width:   header.width,
+		Height:  header.height,
+	}, nil
+}
@@ -0,0 +1,350 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/relais/pkg/plugins"
+	"github.com/relais/pkg/storage"
+	"github.com/relais/pkg/util"
+)
+
+// Pipeline errors.
+var (
+	ErrAlreadyStarted = errors.New("pipeline already started") // Start was called on a running pipeline
+	ErrNotStarted     = errors.New("pipeline not started")     // Stop was called on a pipeline that isn't running
+)
+
+// Node is a stage of a compiled pipeline, with its sessions resolved.
+//
+// The session mapping is added to the stage config unless the stage sets
+// the keys itself:
+//   - sources get session_id, the session to write
+//   - transforms get stream, input_rendition and output_rendition, and
+//     instance_id, the pipeline-wide name of their checkpoints
+//   - sinks get session_id, the session to read
+type Node struct {
+	ID            string                 // Stage ID
+	Type          plugins.PluginType     // Plugin type
+	Plugin        string                 // Plugin name in the registry
+	InstanceID    string                 // Plugin instance ID, "<pipeline>/<stage>"
+	Input         string                 // ID of the stage read, empty for sources
+	Stream        string                 // Stream worked on
+	InputSession  string                 // Session read, empty for sources
+	OutputSession string                 // Session written, empty for sinks
+	Config        map[string]interface{} // Plugin config
+}
+
+// Pipeline is a compiled pipeline spec, run by a plugin manager.
+type Pipeline struct {
+	name  string
+	nodes []Node // Sources, transforms, then sinks
+
+	mu      sync.Mutex
+	manager *plugins.PluginManager // Manager running the pipeline, nil if stopped
+	started []string               // Instance IDs started, in start order
+}
+
+// Compile validates a pipeline spec against the plugins of a registry and
+// resolves the sessions of its stages.
+//
+// Stage configs are validated against the schemas of plugins implementing
+// plugins.Configurable, and converted to the types of their fields.
+//
+// Returns an error wrapping ErrInvalidSpec listing every problem found,
+// such as duplicate stage IDs, unknown plugins, invalid stage configs, or
+// inputs that don't name a source or an earlier transform.
+func Compile(spec *Spec, registry *plugins.Registry) (*Pipeline, error) {
+	c := compiler{
+		spec:     spec,
+		registry: registry,
+		stages:   make(map[string]*Node),
+		sessions: make(map[string]string),
+	}
+	nodes := c.compile()
+	if len(c.problems) > 0 {
+		return nil, util.NewError(util.ErrorTypeValidation, "pipeline "+spec.Name,
+			fmt.Errorf("%w: %s", ErrInvalidSpec, strings.Join(c.problems, "; ")))
+	}
+	return &Pipeline{name: spec.Name, nodes: nodes}, nil
+}
+
+// compiler holds the state of a spec being compiled.
+type compiler struct {
+	spec     *Spec
+	registry *plugins.Registry
+	stages   map[string]*Node  // Stages seen so far by ID
+	sessions map[string]string // IDs of the stages writing each session
+	problems []string          // Validation problems found
+}
+
+// problem records a validation problem.
+func (c *compiler) problem(format string, args ...interface{}) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+// compile returns the nodes of the spec, recording the problems found.
+func (c *compiler) compile() []Node {
+	spec := c.spec
+	if spec.Name == "" {
+		c.problem("missing pipeline name")
+	}
+	if len(spec.Sources) == 0 {
+		c.problem("no sources")
+	}
+	if len(spec.Sinks) == 0 {
+		c.problem("no sinks")
+	}
+
+	var nodes []*Node
+	for _, stage := range spec.Sources {
+		node := c.node(stage, plugins.PluginTypeIngress)
+		if stage.Input != "" {
+			c.problem("ingress %s: ingress stages have no input", stage.ID)
+		}
+		node.Stream = stage.Stream
+		if node.Stream == "" {
+			node.Stream = stage.ID
+		}
+		rendition := stage.Rendition
+		if rendition == "" {
+			rendition = storage.RenditionSource
+		}
+		node.OutputSession = c.output(node, rendition)
+		setDefault(node.Config, "session_id", node.OutputSession)
+		nodes = append(nodes, node)
+	}
+
+	previous := ""
+	if len(spec.Sources) == 1 {
+		previous = spec.Sources[0].ID
+	}
+	for _, stage := range spec.Transforms {
+		node := c.node(stage, plugins.PluginTypeTransform)
+		input := c.input(node, stage, previous)
+		if stage.Stream != "" {
+			c.problem("transform %s: stream is set by the input", stage.ID)
+		}
+		rendition := stage.Rendition
+		if rendition == "" {
+			rendition = stage.ID
+		}
+		if input != nil {
+			_, inputRendition := storage.SplitRenditionSessionID(input.OutputSession)
+			if rendition == inputRendition {
+				c.problem("transform %s: rendition %s is the rendition of its input", stage.ID, rendition)
+			}
+			node.OutputSession = c.output(node, rendition)
+			setDefault(node.Config, "stream", node.Stream)
+			setDefault(node.Config, "input_rendition", inputRendition)
+			setDefault(node.Config, "output_rendition", rendition)
+		}
+		setDefault(node.Config, "instance_id", node.InstanceID)
+		nodes = append(nodes, node)
+		previous = stage.ID
+	}
+
+	for _, stage := range spec.Sinks {
+		node := c.node(stage, plugins.PluginTypeEgress)
+		c.input(node, stage, previous)
+		if stage.Stream != "" || stage.Rendition != "" {
+			c.problem("egress %s: stream and rendition are set by the input", stage.ID)
+		}
+		if node.InputSession != "" {
+			setDefault(node.Config, "session_id", node.InputSession)
+		}
+		nodes = append(nodes, node)
+	}
+
+	compiled := make([]Node, len(nodes))
+	for i, node := range nodes {
+		c.validate(node)
+		compiled[i] = *node
+	}
+	return compiled
+}
+
+// node returns the node of a stage, checking its ID and plugin.
+func (c *compiler) node(stage Stage, pType plugins.PluginType) *Node {
+	node := &Node{
+		ID:         stage.ID,
+		Type:       pType,
+		Plugin:     stage.Plugin,
+		InstanceID: c.spec.Name + "/" + stage.ID,
+		Config:     make(map[string]interface{}, len(stage.Config)+4),
+	}
+	for k, v := range stage.Config {
+		node.Config[k] = v
+	}
+
+	switch {
+	case stage.ID == "":
+		c.problem("%s stage without id", pType)
+	case strings.Contains(stage.ID, "/"):
+		c.problem("%s %s: id must not contain '/'", pType, stage.ID)
+	case c.stages[stage.ID] != nil:
+		c.problem("%s %s: duplicate id", pType, stage.ID)
+	default:
+		c.stages[stage.ID] = node
+	}
+
+	if stage.Plugin == "" {
+		c.problem("%s %s: missing plugin", pType, stage.ID)
+	} else if !c.registry.Has(pType, stage.Plugin) {
+		c.problem("%s %s: unknown %s plugin %s", pType, stage.ID, pType, stage.Plugin)
+	}
+	return node
+}
+
+// validate checks the config of a node against the schema of its plugin,
+// if it declares one, and replaces it with the converted values.
+func (c *compiler) validate(node *Node) {
+	schema, err := c.registry.Schema(node.Type, node.Plugin)
+	if err != nil {
+		if !errors.Is(err, plugins.ErrPluginNotFound) {
+			c.problem("%s %s: %v", node.Type, node.ID, err)
+		}
+		return
+	}
+	if schema == nil {
+		return
+	}
+	config, err := schema.Validate(node.Config)
+	if err != nil {
+		c.problem("%s %s: %v", node.Type, node.ID, err)
+		return
+	}
+	node.Config = config
+}
+
+// input resolves the input of a transform or sink, defaulting to previous.
+// Returns the input node, or nil if there is no valid one.
+func (c *compiler) input(node *Node, stage Stage, previous string) *Node {
+	id := stage.Input
+	if id == "" {
+		id = previous
+	}
+	if id == "" {
+		c.problem("%s %s: missing input, the pipeline has several sources", node.Type, stage.ID)
+		return nil
+	}
+
+	input := c.stages[id]
+	if input == nil || input.Type == plugins.PluginTypeEgress || input == node {
+		c.problem("%s %s: input %s is not a source or an earlier transform", node.Type, stage.ID, id)
+		return nil
+	}
+	node.Input = id
+	node.Stream = input.Stream
+	node.InputSession = input.OutputSession
+	return input
+}
+
+// output returns the session a node writes a rendition to, checking that
+// no other node writes it.
+func (c *compiler) output(node *Node, rendition string) string {
+	session := storage.RenditionSessionID(node.Stream, rendition)
+	if other, ok := c.sessions[session]; ok {
+		c.problem("%s %s: session %s is also written by %s", node.Type, node.ID, session, other)
+	}
+	c.sessions[session] = node.ID
+	return session
+}
+
+// setDefault sets a config key unless it is set already.
+func setDefault(config map[string]interface{}, key string, value interface{}) {
+	if _, ok := config[key]; !ok {
+		config[key] = value
+	}
+}
+
+// Name returns the name of the pipeline.
+func (p *Pipeline) Name() string {
+	return p.name
+}
+
+// Nodes returns the stages of the pipeline: sources, transforms in chain
+// order, then sinks.
+func (p *Pipeline) Nodes() []Node {
+	nodes := make([]Node, len(p.nodes))
+	copy(nodes, p.nodes)
+	return nodes
+}
+
+// Start runs every stage of the pipeline as a plugin instance of manager,
+// sinks first and sources last so that no frame is written before its
+// readers run. The instances run until ctx is cancelled or Stop is called.
+// Stage configs were validated by Compile and aren't validated again.
+//
+// If a stage fails to start, the stages already started are stopped and the
+// error is returned.
+func (p *Pipeline) Start(ctx context.Context, manager *plugins.PluginManager) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.manager != nil {
+		return util.NewError(util.ErrorTypePlugin, "pipeline "+p.name, ErrAlreadyStarted)
+	}
+
+	p.manager = manager
+	p.started = p.started[:0]
+	for i := len(p.nodes) - 1; i >= 0; i-- {
+		node := p.nodes[i]
+		if _, err := manager.StartValidatedInstance(ctx, node.InstanceID, node.Type, node.Plugin, node.Config); err != nil {
+			p.stop()
+			return fmt.Errorf("failed to start stage %s: %w", node.ID, err)
+		}
+		p.started = append(p.started, node.InstanceID)
+	}
+	return nil
+}
+
+// Stop stops the stages of the pipeline, sources first, and waits for them
+// to return. Stages that stopped on their own are skipped.
+//
+// Returns the errors of the stages that failed to stop.
+func (p *Pipeline) Stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.manager == nil {
+		return util.NewError(util.ErrorTypePlugin, "pipeline "+p.name, ErrNotStarted)
+	}
+	return p.stop()
+}
+
+// stop stops the started stages in reverse start order. The caller must
+// hold the lock.
+func (p *Pipeline) stop() error {
+	var errs []error
+	for i := len(p.started) - 1; i >= 0; i-- {
+		if err := p.manager.StopPlugin(p.started[i]); err != nil && !errors.Is(err, plugins.ErrPluginNotRunning) {
+			errs = append(errs, err)
+		}
+	}
+	p.manager = nil
+	p.started = nil
+	return errors.Join(errs...)
+}
+
+// Status returns the status of the plugin instances of the pipeline, in
+// the order of Nodes. Returns nil if the pipeline isn't started.
+func (p *Pipeline) Status() []plugins.PluginStatus {
+	p.mu.Lock()
+	manager := p.manager
+	p.mu.Unlock()
+	if manager == nil {
+		return nil
+	}
+
+	var statuses []plugins.PluginStatus
+	for _, node := range p.nodes {
+		if status, err := manager.GetPluginStatus(node.InstanceID); err == nil {
+			statuses = append(statuses, *status)
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,98 @@
+// Package pipeline runs media pipelines declared in YAML or JSON. A
+// pipeline spec lists the sources (ingress plugins), an ordered chain of
+// transforms and the sinks (egress plugins) of a pipeline. The pipeline
+// package works out which storage session each stage writes and reads, and
+// runs every stage as a plugin instance of a plugins.PluginManager.
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidSpec is returned, wrapped, for pipeline specs that fail
+// validation.
+var ErrInvalidSpec = errors.New("invalid pipeline spec")
+
+// Spec is the declarative definition of a pipeline.
+//
+// Every source writes the frames of a stream to a session. A transform
+// reads the session of its input stage and writes a new rendition of the
+// same stream, and a sink reads the session of its input stage. Several
+// transforms and sinks may read the same stage, so the stages form a tree
+// rooted at each source.
+//
+// Example:
+//
+//	name: lobby
+//	sources:
+//	  - id: cam
+//	    plugin: camera
+//	    stream: lobby-cam
+//	    config: {device_id: /dev/video0, fps: 30}
+//	transforms:
+//	  - id: watermarked
+//	    plugin: watermark
+//	sinks:
+//	  - id: viewer
+//	    plugin: webrtc
+//	  - id: raw-viewer
+//	    plugin: webrtc
+//	    input: cam
+type Spec struct {
+	Name       string  `yaml:"name" json:"name"`             // Pipeline name, prefixing its plugin instance IDs
+	Sources    []Stage `yaml:"sources" json:"sources"`       // Ingress stages
+	Transforms []Stage `yaml:"transforms" json:"transforms"` // Transform stages, in chain order
+	Sinks      []Stage `yaml:"sinks" json:"sinks"`           // Egress stages
+}
+
+// Stage is a plugin instance of a pipeline.
+type Stage struct {
+	ID     string `yaml:"id" json:"id"`         // Stage ID, unique in the pipeline
+	Plugin string `yaml:"plugin" json:"plugin"` // Plugin name in the registry
+
+	// Input is the ID of the stage a transform or sink reads. It defaults
+	// to the previous transform in the chain, or for the first transform
+	// the only source. Sinks default to the last transform, or the only
+	// source if there are no transforms.
+	Input string `yaml:"input,omitempty" json:"input,omitempty"`
+
+	// Stream is the stream a source writes, defaulting to the stage ID.
+	// Transforms and sinks work on the stream of their input.
+	Stream string `yaml:"stream,omitempty" json:"stream,omitempty"`
+
+	// Rendition is the rendition a source or transform writes, defaulting
+	// to storage.RenditionSource for sources and the stage ID for
+	// transforms.
+	Rendition string `yaml:"rendition,omitempty" json:"rendition,omitempty"`
+
+	// Config is passed to the plugin's Initialize, with the session
+	// mapping added. See Node.
+	Config map[string]interface{} `yaml:"config,omitempty" json:"config,omitempty"`
+}
+
+// Parse decodes a pipeline spec from YAML or JSON. Unknown fields are
+// rejected. The spec is not validated; see Compile.
+func Parse(data []byte) (*Spec, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var spec Spec
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline spec: %v", err)
+	}
+	return &spec, nil
+}
+
+// LoadFile reads and decodes a pipeline spec file. See Parse.
+func LoadFile(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipeline spec: %v", err)
+	}
+	return Parse(data)
+}
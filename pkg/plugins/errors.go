@@ -0,0 +1,26 @@
+package plugins
+
+import (
+	"errors"
+
+	"github.com/relais/pkg/util"
+)
+
+// Sentinel errors returned by the plugin registry and manager. They are
+// wrapped in a util.Error of type util.ErrorTypePlugin naming the plugin, so
+// they must be compared with errors.Is. ErrInvalidConfig is wrapped in a
+// util.Error of type util.ErrorTypeValidation instead.
+var (
+	ErrPluginNotFound          = errors.New("plugin not found")          // No plugin with that name exists
+	ErrPluginAlreadyRegistered = errors.New("plugin already registered") // A plugin with that name exists
+	ErrPluginNotRunning        = errors.New("plugin not running")        // The plugin isn't running
+	ErrPluginAlreadyRunning    = errors.New("plugin already running")    // The plugin is running
+	ErrPluginInvalidType       = errors.New("plugin has invalid type")   // The plugin doesn't implement its type's interface
+	ErrInvalidConfig           = errors.New("invalid plugin config")     // The config doesn't match the plugin's schema
+	ErrInvalidSchema           = errors.New("invalid plugin schema")     // The plugin's schema has invalid defaults
+)
+
+// pluginError wraps a sentinel error for the named plugin.
+func pluginError(name string, err error) error {
+	return util.NewError(util.ErrorTypePlugin, name, err)
+}
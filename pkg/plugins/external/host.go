@@ -0,0 +1,559 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/relais/pkg/plugins"
+	"github.com/relais/pkg/storage"
+)
+
+// Timeouts of plugin processes.
+const (
+	handshakeTimeout = 10 * time.Second // Time a process gets to send its handshake
+	stopTimeout      = 5 * time.Second  // Time a process gets to exit after msgStop
+)
+
+// message is a protocol message received from a plugin process.
+type message struct {
+	t       messageType
+	payload []byte
+}
+
+// process is a running plugin executable.
+type process struct {
+	cmd      *exec.Cmd
+	conn     *conn
+	stdin    io.Closer
+	messages chan message  // Messages received, closed once the process exited
+	exited   chan struct{} // Closed once the process exited
+	exitErr  error         // Why the process exited, set before exited is closed
+
+	closeOnce sync.Once
+	closing   chan struct{} // Closed once messages are no longer read
+}
+
+// start launches a plugin executable and reads its handshake.
+func start(ctx context.Context, path string, args []string) (*process, handshake, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, handshake{}, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, handshake{}, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, handshake{}, fmt.Errorf("failed to start plugin %s: %v", path, err)
+	}
+
+	p := &process{
+		cmd:      cmd,
+		conn:     newConn(stdout, stdin),
+		stdin:    stdin,
+		messages: make(chan message, 16),
+		exited:   make(chan struct{}),
+		closing:  make(chan struct{}),
+	}
+	go p.readLoop()
+
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+	var h handshake
+	if err := p.receiveJSON(ctx, msgHandshake, &h); err != nil {
+		p.kill()
+		return nil, handshake{}, fmt.Errorf("failed to read plugin handshake: %w", err)
+	}
+	if h.Version != ProtocolVersion {
+		p.kill()
+		return nil, handshake{}, fmt.Errorf("plugin %s speaks protocol version %d, want %d", path, h.Version, ProtocolVersion)
+	}
+	return p, h, nil
+}
+
+// readLoop passes received messages on until the process output ends, then
+// reaps the process.
+func (p *process) readLoop() {
+	var readErr error
+	for {
+		t, payload, err := p.conn.read()
+		if err != nil {
+			readErr = err
+			break
+		}
+		select {
+		case p.messages <- message{t, payload}:
+		case <-p.closing:
+			// Nobody reads messages anymore, drop them until the process
+			// exits
+		}
+	}
+	close(p.messages)
+
+	p.exitErr = p.cmd.Wait()
+	if p.exitErr == nil && !errors.Is(readErr, io.EOF) {
+		p.exitErr = readErr
+	}
+	close(p.exited)
+}
+
+// exitError returns the error of an exited process.
+func (p *process) exitError() error {
+	<-p.exited
+	if p.exitErr != nil {
+		return fmt.Errorf("plugin process exited: %v", p.exitErr)
+	}
+	return errors.New("plugin process exited")
+}
+
+// receive waits for the next message, which must be of type t.
+func (p *process) receive(ctx context.Context, t messageType) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case m, ok := <-p.messages:
+		if !ok {
+			return nil, p.exitError()
+		}
+		if m.t != t {
+			return nil, fmt.Errorf("unexpected plugin message type %d, want %d", m.t, t)
+		}
+		return m.payload, nil
+	}
+}
+
+// receiveJSON waits for the next message, which must be of type t, and
+// decodes its JSON payload into v.
+func (p *process) receiveJSON(ctx context.Context, t messageType, v interface{}) error {
+	payload, err := p.receive(ctx, t)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("failed to decode plugin message: %v", err)
+	}
+	return nil
+}
+
+// abandon stops passing messages on.
+func (p *process) abandon() {
+	p.closeOnce.Do(func() { close(p.closing) })
+}
+
+// kill ends the process without asking it and waits for it to exit.
+func (p *process) kill() {
+	p.abandon()
+	p.cmd.Process.Kill()
+	<-p.exited
+}
+
+// stop asks the process to stop, killing it if it doesn't exit in time.
+// Returns the error reported by the plugin, or why the process exited if it
+// exited abnormally without answering, e.g. because it had crashed.
+func (p *process) stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+
+	var res result
+	err := p.conn.writeJSON(msgStop, nil)
+	if err == nil {
+		// Frames still in flight may arrive before the result
+		err = p.drainUntil(ctx, msgResult, &res)
+	}
+	p.abandon()
+	p.stdin.Close()
+
+	select {
+	case <-p.exited:
+	case <-ctx.Done():
+		p.kill()
+		return fmt.Errorf("plugin process didn't stop within %v", stopTimeout)
+	}
+	if err != nil {
+		// The process exited without answering
+		if p.exitErr != nil {
+			return fmt.Errorf("plugin process exited without stopping: %v", p.exitErr)
+		}
+		return nil
+	}
+	return res.err()
+}
+
+// drainUntil discards messages until one of type t arrives, and decodes its
+// JSON payload into v.
+func (p *process) drainUntil(ctx context.Context, t messageType, v interface{}) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-p.messages:
+			if !ok {
+				return p.exitError()
+			}
+			if m.t == t {
+				return json.Unmarshal(m.payload, v)
+			}
+		}
+	}
+}
+
+// Plugin runs a plugin executable as an ingress, egress or transform
+// plugin. The executable is launched by Initialize and relaunched by Run
+// if it exited, so a plugin manager restarting a crashed plugin starts a
+// new process.
+//
+// Frames reach the executable according to its type:
+//   - sources send frames, which are written to storage as they are
+//   - transformers get the frames of a rendition, as in
+//     plugins.RenditionTransform, and answer with the transformed frames
+//   - sinks get the frames of the session_id config option, starting with
+//     its latest key frame
+type Plugin struct {
+	path     string
+	args     []string
+	expected plugins.PluginType // Type the executable must have, or empty for any
+
+	mu        sync.Mutex
+	config    map[string]interface{}
+	process   *process  // Running process, or nil
+	handshake handshake // Handshake of the last process started
+	transform plugins.RenditionTransform
+	sessionID string     // Session sent to sinks
+	callMu    sync.Mutex // Serializes transform calls
+}
+
+// NewPlugin creates a plugin running the executable at path with args.
+func NewPlugin(path string, args ...string) *Plugin {
+	return &Plugin{path: path, args: args}
+}
+
+// Factory returns a plugin factory for an executable of type pType,
+// suitable for plugins.Registry.Register.
+func Factory(pType plugins.PluginType, path string, args ...string) plugins.PluginFactory {
+	return func() plugins.Plugin {
+		p := NewPlugin(path, args...)
+		p.expected = pType
+		return p
+	}
+}
+
+// Discover launches an executable to read its handshake and stops it.
+//
+// Returns the type and name the plugin announces.
+func Discover(ctx context.Context, path string, args ...string) (plugins.PluginType, string, error) {
+	p, h, err := start(ctx, path, args)
+	if err != nil {
+		return "", "", err
+	}
+	p.abandon()
+	p.stdin.Close()
+	select {
+	case <-p.exited:
+	case <-time.After(stopTimeout):
+		p.kill()
+	}
+	return h.Type, h.Name, nil
+}
+
+// Register discovers the type and name of an executable and registers it
+// under them.
+func Register(ctx context.Context, registry *plugins.Registry, path string, args ...string) error {
+	pType, name, err := Discover(ctx, path, args...)
+	if err != nil {
+		return err
+	}
+	return registry.Register(pType, name, Factory(pType, path, args...))
+}
+
+// Initialize launches the executable and initializes it with config.
+// Transformers take the options of plugins.RenditionTransform, reading the
+// source rendition and writing a rendition named after the plugin by
+// default. Sinks take session_id, the session to send.
+func (p *Plugin) Initialize(ctx context.Context, config map[string]interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.config = config
+	if p.process != nil {
+		p.process.kill()
+		p.process = nil
+	}
+	if _, err := p.ensureProcess(ctx); err != nil {
+		return err
+	}
+	if err := p.configure(config); err != nil {
+		p.process.kill()
+		p.process = nil
+		return err
+	}
+	return nil
+}
+
+// configure sets up the host side of the plugin from its config.
+func (p *Plugin) configure(config map[string]interface{}) error {
+	switch p.handshake.Type {
+	case plugins.PluginTypeTransform:
+		p.transform = plugins.RenditionTransform{
+			Input:      storage.RenditionSource,
+			Output:     p.handshake.Name,
+			InstanceID: p.handshake.Name,
+		}
+		return p.transform.Configure(config)
+	case plugins.PluginTypeEgress:
+		p.sessionID, _ = config["session_id"].(string)
+		if p.sessionID == "" {
+			return errors.New("missing session_id")
+		}
+	}
+	return nil
+}
+
+// ensureProcess returns the running process, launching and initializing a
+// new one if there is none or it exited. The caller must hold the lock.
+func (p *Plugin) ensureProcess(ctx context.Context) (*process, error) {
+	if p.process != nil {
+		select {
+		case <-p.process.exited:
+			p.process = nil
+		default:
+			return p.process, nil
+		}
+	}
+
+	proc, h, err := start(ctx, p.path, p.args)
+	if err != nil {
+		return nil, err
+	}
+	if p.expected != "" && h.Type != p.expected {
+		proc.kill()
+		return nil, fmt.Errorf("plugin %s is a %s plugin, want %s", h.Name, h.Type, p.expected)
+	}
+
+	var res result
+	err = proc.conn.writeJSON(msgInitialize, p.config)
+	if err == nil {
+		err = proc.receiveJSON(ctx, msgResult, &res)
+	}
+	if err == nil {
+		err = res.err()
+	}
+	if err != nil {
+		proc.kill()
+		return nil, fmt.Errorf("failed to initialize plugin %s: %w", h.Name, err)
+	}
+
+	p.process = proc
+	p.handshake = h
+	return proc, nil
+}
+
+// Run runs the executable, relaunching it first if it exited, until ctx is
+// cancelled, the plugin reports it is done, or the process exits.
+func (p *Plugin) Run(ctx context.Context, store storage.Storage) error {
+	p.mu.Lock()
+	proc, err := p.ensureProcess(ctx)
+	pType := p.handshake.Type
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := proc.conn.write(msgRun, nil); err != nil {
+		return proc.exitError()
+	}
+
+	switch pType {
+	case plugins.PluginTypeIngress:
+		return p.runSource(ctx, proc, store)
+	case plugins.PluginTypeTransform:
+		return p.transform.Run(ctx, store, func(ctx context.Context, frame storage.Frame) (storage.Frame, bool, error) {
+			return p.transformFrame(ctx, proc, frame)
+		})
+	case plugins.PluginTypeEgress:
+		return p.runSink(ctx, proc, store)
+	default:
+		return fmt.Errorf("unknown plugin type %s", pType)
+	}
+}
+
+// runSource writes the frames sent by a source to storage.
+func (p *Plugin) runSource(ctx context.Context, proc *process, store storage.Storage) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-proc.messages:
+			if !ok {
+				return proc.exitError()
+			}
+			if done, err := doneResult(m); done {
+				return err
+			}
+			if m.t != msgFrame {
+				continue
+			}
+			frame, err := storage.DecodeFrame(m.payload)
+			if err != nil {
+				return fmt.Errorf("failed to decode plugin frame: %w", err)
+			}
+			if err := store.PutFrame(ctx, frame); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// transformFrame sends a frame to a transformer and returns its answer.
+//
+// Answers carry no request ID, so a call abandoned when ctx is cancelled
+// kills the process: its late answer would otherwise be taken as the answer
+// to the next frame. Run starts a new process.
+func (p *Plugin) transformFrame(ctx context.Context, proc *process, frame storage.Frame) (storage.Frame, bool, error) {
+	p.callMu.Lock()
+	defer p.callMu.Unlock()
+
+	data, err := storage.EncodeFrame(frame)
+	if err != nil {
+		return frame, false, fmt.Errorf("failed to encode frame %d for plugin: %w", frame.Index, err)
+	}
+	if err := p.write(ctx, proc, msgFrame, data); err != nil {
+		return frame, false, err
+	}
+
+	select {
+	case <-ctx.Done():
+		p.discard(proc)
+		return frame, false, ctx.Err()
+	case m, ok := <-proc.messages:
+		if !ok {
+			return frame, false, proc.exitError()
+		}
+		if done, err := doneResult(m); done {
+			if err == nil {
+				err = errors.New("plugin stopped running")
+			}
+			return frame, false, err
+		}
+		switch m.t {
+		case msgSkip:
+			return frame, false, nil
+		case msgFrame:
+			out, err := storage.DecodeFrame(m.payload)
+			if err != nil {
+				return frame, false, fmt.Errorf("failed to decode plugin frame: %w", err)
+			}
+			return out, true, nil
+		default:
+			return frame, false, fmt.Errorf("unexpected plugin message type %d", m.t)
+		}
+	}
+}
+
+// runSink sends the frames of the session to a sink.
+func (p *Plugin) runSink(ctx context.Context, proc *process, store storage.Storage) error {
+	stream, err := storage.SubscribeFromKeyFrame(ctx, store, p.sessionID)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-proc.messages:
+			if !ok {
+				return proc.exitError()
+			}
+			if done, err := doneResult(m); done {
+				return err
+			}
+		case frame, ok := <-stream:
+			if !ok {
+				// Storage was closed or the subscription ended
+				return ctx.Err()
+			}
+			data, err := storage.EncodeFrame(frame)
+			if err != nil {
+				return fmt.Errorf("failed to encode frame %d for plugin: %w", frame.Index, err)
+			}
+			if err := p.write(ctx, proc, msgFrame, data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// write sends a message to a process. A process that stops reading its
+// input blocks the write once the pipe is full, so the process is killed if
+// ctx is cancelled before the write completes.
+func (p *Plugin) write(ctx context.Context, proc *process, t messageType, payload []byte) error {
+	written := make(chan struct{})
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		select {
+		case <-ctx.Done():
+			p.discard(proc)
+		case <-written:
+		}
+	}()
+
+	err := proc.conn.write(t, payload)
+	close(written)
+	<-watched
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return proc.exitError()
+	}
+	return nil
+}
+
+// doneResult reports whether a message is msgDone, and returns the error
+// it carries.
+func doneResult(m message) (bool, error) {
+	if m.t != msgDone {
+		return false, nil
+	}
+	var res result
+	if err := json.Unmarshal(m.payload, &res); err != nil {
+		return true, fmt.Errorf("failed to decode plugin message: %v", err)
+	}
+	return true, res.err()
+}
+
+// discard kills a process that can't be used anymore, so that Run starts a
+// new one.
+func (p *Plugin) discard(proc *process) {
+	proc.kill()
+
+	p.mu.Lock()
+	if p.process == proc {
+		p.process = nil
+	}
+	p.mu.Unlock()
+}
+
+// Stop asks the executable to stop and waits for it to exit, killing it if
+// it takes too long.
+func (p *Plugin) Stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.process == nil {
+		return nil
+	}
+	err := p.process.stop()
+	p.process = nil
+	return err
+}
@@ -0,0 +1,148 @@
+// Package external runs plugins as separate executables. The host launches
+// the executable and talks to it over its standard input and output, so
+// plugins can be shipped without being compiled into the runners, and a
+// crashing plugin only takes down its own process.
+//
+// The host side is Plugin, which adapts an executable to the
+// IngressPlugin, EgressPlugin and TransformPlugin interfaces. The plugin
+// side is Serve, which runs a Source, Transformer or Sink.
+//
+// # Protocol
+//
+// Messages are framed as a big-endian uint32 length, covering the rest of
+// the message, a uint8 message type and the payload. Control payloads are
+// JSON; frames are in the binary envelope of storage.EncodeFrame.
+//
+//  1. The plugin sends msgHandshake with the protocol version, its type and
+//     its name.
+//  2. The host sends msgInitialize with the config, and the plugin answers
+//     msgResult.
+//  3. The host sends msgRun. Sources then send msgFrame for every captured
+//     frame. Transformers get msgFrame for every input frame and answer
+//     msgFrame with the output frame or msgSkip, one frame at a time; the
+//     host kills a transformer whose answer it stops waiting for. Sinks get
+//     msgFrame for every frame to deliver. A plugin that stops running on
+//     its own sends msgDone with the error, if any.
+//  4. The host sends msgStop, and the plugin answers msgResult and exits.
+//
+// A plugin also exits when its standard input is closed. Anything written
+// to standard error is passed to the host's standard error.
+package external
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/relais/pkg/plugins"
+)
+
+// ProtocolVersion is the version of the protocol spoken by this package.
+// Plugins announcing another version are rejected.
+const ProtocolVersion = 1
+
+// maxMessageSize is the size of the largest message accepted.
+const maxMessageSize = 64 << 20
+
+// messageType is the type of a protocol message.
+type messageType uint8
+
+// Protocol message types.
+const (
+	msgHandshake  messageType = iota + 1 // Plugin to host: handshake
+	msgInitialize                        // Host to plugin: config
+	msgResult                            // Plugin to host: result of msgInitialize or msgStop
+	msgRun                               // Host to plugin: start running
+	msgStop                              // Host to plugin: stop and exit
+	msgFrame                             // Either way: a frame
+	msgSkip                              // Plugin to host: the transformer skipped the frame
+	msgDone                              // Plugin to host: the plugin stopped running, with its result
+)
+
+// handshake is the payload of msgHandshake.
+type handshake struct {
+	Version int                `json:"version"` // Protocol version
+	Type    plugins.PluginType `json:"type"`    // Plugin type
+	Name    string             `json:"name"`    // Plugin name
+}
+
+// result is the payload of msgResult and msgDone.
+type result struct {
+	Error string `json:"error,omitempty"` // Error message, empty on success
+}
+
+// newResult returns the result of an operation that returned err.
+func newResult(err error) result {
+	if err == nil {
+		return result{}
+	}
+	return result{Error: err.Error()}
+}
+
+// err returns the error of a result, or nil on success.
+func (r result) err() error {
+	if r.Error == "" {
+		return nil
+	}
+	return errors.New(r.Error)
+}
+
+// conn reads and writes protocol messages. Writes may be concurrent; reads
+// must not.
+type conn struct {
+	r   *bufio.Reader
+	wmu sync.Mutex
+	w   *bufio.Writer
+}
+
+// newConn returns a conn reading from r and writing to w.
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: bufio.NewReader(r), w: bufio.NewWriter(w)}
+}
+
+// write sends a message.
+func (c *conn) write(t messageType, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(1+len(payload)))
+	header[4] = byte(t)
+	if _, err := c.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(payload); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// writeJSON sends a message with a JSON payload.
+func (c *conn) writeJSON(t messageType, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %v", err)
+	}
+	return c.write(t, payload)
+}
+
+// read receives a message.
+func (c *conn) read() (messageType, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size == 0 || size > maxMessageSize {
+		return 0, nil, fmt.Errorf("invalid message size %d", size)
+	}
+	payload := make([]byte, size-1)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	return messageType(header[4]), payload, nil
+}
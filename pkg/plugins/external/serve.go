@@ -0,0 +1,208 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/relais/pkg/plugins"
+	"github.com/relais/pkg/storage"
+)
+
+// Handler is the plugin side of an external plugin. Handlers implement one
+// of Source, Transformer or Sink, which sets the type of the plugin.
+type Handler interface {
+	// Initialize sets up the plugin with the config given to the host
+	// plugin. Values went through JSON, so numbers are float64 and byte
+	// slices base64 strings.
+	Initialize(config map[string]interface{}) error
+
+	// Close releases the resources of the plugin before the process exits.
+	Close() error
+}
+
+// Source is the handler of an ingress plugin.
+type Source interface {
+	Handler
+
+	// Ingest captures frames and passes them to emit until ctx is
+	// cancelled. The frames are written to storage as they are, so they
+	// must carry their session ID and index.
+	Ingest(ctx context.Context, emit func(storage.Frame) error) error
+}
+
+// Transformer is the handler of a transform plugin.
+type Transformer interface {
+	Handler
+
+	// Transform returns the transformed frame, or false to skip the frame.
+	// The host sets the session ID and index of transformed frames. An
+	// error stops the plugin until the host restarts it.
+	Transform(frame storage.Frame) (storage.Frame, bool, error)
+}
+
+// Sink is the handler of an egress plugin.
+type Sink interface {
+	Handler
+
+	// Send delivers a frame. An error stops the plugin until the host
+	// restarts it.
+	Send(frame storage.Frame) error
+}
+
+// handlerType returns the plugin type of a handler.
+func handlerType(h Handler) (plugins.PluginType, error) {
+	switch h.(type) {
+	case Source:
+		return plugins.PluginTypeIngress, nil
+	case Transformer:
+		return plugins.PluginTypeTransform, nil
+	case Sink:
+		return plugins.PluginTypeEgress, nil
+	default:
+		return "", errors.New("handler is not a Source, Transformer or Sink")
+	}
+}
+
+// Serve runs a handler as the plugin named name, talking to the host over
+// standard input and output. Standard output is redirected to standard
+// error, so that output printed by the plugin doesn't corrupt the protocol.
+//
+// Serve returns once the host stops the plugin or closes standard input.
+func Serve(name string, h Handler) error {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	return ServeIO(os.Stdin, out, name, h)
+}
+
+// ServeIO runs a handler as the plugin named name, talking to the host
+// over r and w. See Serve.
+func ServeIO(r io.Reader, w io.Writer, name string, h Handler) error {
+	pType, err := handlerType(h)
+	if err != nil {
+		return err
+	}
+
+	c := newConn(r, w)
+	if err := c.writeJSON(msgHandshake, handshake{Version: ProtocolVersion, Type: pType, Name: name}); err != nil {
+		return err
+	}
+
+	s := server{conn: c, handler: h}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	defer s.shutdown()
+
+	for {
+		t, payload, err := c.read()
+		if errors.Is(err, io.EOF) {
+			s.shutdown()
+			return h.Close()
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t {
+		case msgInitialize:
+			var config map[string]interface{}
+			if err := json.Unmarshal(payload, &config); err != nil {
+				return fmt.Errorf("failed to decode config: %v", err)
+			}
+			err = c.writeJSON(msgResult, newResult(h.Initialize(config)))
+		case msgRun:
+			s.run()
+		case msgFrame:
+			err = s.frame(payload)
+		case msgStop:
+			s.shutdown()
+			return c.writeJSON(msgResult, newResult(h.Close()))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// server is the state of a plugin served by ServeIO.
+type server struct {
+	conn    *conn
+	handler Handler
+
+	ctx       context.Context // Cancelled on stop
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	ingesting chan struct{} // Closed once Ingest returned, nil if never called
+}
+
+// run starts ingesting frames if the handler is a source that isn't
+// ingesting already.
+func (s *server) run() {
+	source, ok := s.handler.(Source)
+	if !ok {
+		return
+	}
+	if s.ingesting != nil {
+		select {
+		case <-s.ingesting:
+		default:
+			return
+		}
+	}
+
+	done := make(chan struct{})
+	s.ingesting = done
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(done)
+		err := source.Ingest(s.ctx, func(frame storage.Frame) error {
+			data, err := storage.EncodeFrame(frame)
+			if err != nil {
+				return err
+			}
+			return s.conn.write(msgFrame, data)
+		})
+		if s.ctx.Err() == nil {
+			s.conn.writeJSON(msgDone, newResult(err))
+		}
+	}()
+}
+
+// frame handles a frame sent by the host.
+func (s *server) frame(payload []byte) error {
+	frame, err := storage.DecodeFrame(payload)
+	if err != nil {
+		return s.conn.writeJSON(msgDone, newResult(fmt.Errorf("failed to decode frame: %w", err)))
+	}
+
+	switch h := s.handler.(type) {
+	case Transformer:
+		out, ok, err := h.Transform(frame)
+		if err != nil {
+			return s.conn.writeJSON(msgDone, newResult(err))
+		}
+		if !ok {
+			return s.conn.write(msgSkip, nil)
+		}
+		data, err := storage.EncodeFrame(out)
+		if err != nil {
+			return s.conn.writeJSON(msgDone, newResult(err))
+		}
+		return s.conn.write(msgFrame, data)
+	case Sink:
+		if err := h.Send(frame); err != nil {
+			return s.conn.writeJSON(msgDone, newResult(err))
+		}
+	}
+	return nil
+}
+
+// shutdown stops ingesting and waits for Ingest to return.
+func (s *server) shutdown() {
+	s.cancel()
+	s.wg.Wait()
+}
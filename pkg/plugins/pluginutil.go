@@ -2,78 +2,399 @@ package plugins
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/relais/pkg/storage"
 )
 
-// PluginStatus represents the current state of a plugin
+// PluginStatus represents the current state of a plugin instance
 type PluginStatus struct {
+	ID        string     // Instance ID
+	Type      PluginType // Plugin type
+	Name      string     // Plugin name in the registry
 	Running   bool
 	StartTime time.Time
-	Error     error
+	Error     error // Last error returned by Run, or nil
+	Restarts  int   // Times Run was restarted after failing
+}
+
+// RestartPolicy controls how the plugin manager restarts plugins whose Run
+// fails. The delay before a restart starts at InitialBackoff and doubles
+// with each restart up to MaxBackoff. A run lasting at least StableAfter
+// starts the count over, so a plugin failing now and then isn't given up
+// on.
+type RestartPolicy struct {
+	MaxRestarts    int           // Consecutive restarts before giving up, or negative for no limit
+	InitialBackoff time.Duration // Delay before the first restart
+	MaxBackoff     time.Duration // Upper bound of the delay
+	StableAfter    time.Duration // Run time resetting the restart count, or zero to never reset it
+}
+
+// DefaultRestartPolicy is the restart policy of new plugin managers.
+var DefaultRestartPolicy = RestartPolicy{
+	MaxRestarts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	StableAfter:    time.Minute,
+}
+
+// backoff returns the delay before the given restart, counted from 1.
+func (p RestartPolicy) backoff(restart int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < restart && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// runnable is implemented by the ingress, egress and transform plugins.
+type runnable interface {
+	Plugin
+	Run(ctx context.Context, store storage.Storage) error
+}
+
+// managedPlugin is a plugin instance started by the manager.
+type managedPlugin struct {
+	plugin  runnable               // Running plugin, replaced on restart; only used by supervise
+	config  map[string]interface{} // Validated config the instance was initialized with
+	status  PluginStatus
+	cancel  context.CancelFunc // Cancels Run
+	done    chan struct{}      // Closed once the plugin is stopped
+	stopErr error              // Error returned by Stop
 }
 
-// PluginManager handles plugin lifecycle
+// PluginManager handles plugin lifecycle. A plugin can run as several
+// instances, each with its own ID and config, e.g. one camera ingress per
+// device. Started instances run in their own goroutine and are restarted
+// according to the restart policy when Run fails.
 type PluginManager struct {
 	mu       sync.RWMutex
 	registry *Registry
-	status   map[string]*PluginStatus
+	store    storage.Storage
+	policy   RestartPolicy
+	plugins  map[string]*managedPlugin // Instances by ID
 }
 
-// NewPluginManager creates a new plugin manager
-func NewPluginManager(registry *Registry) *PluginManager {
+// NewPluginManager creates a new plugin manager running plugins against
+// store
+func NewPluginManager(registry *Registry, store storage.Storage) *PluginManager {
 	return &PluginManager{
 		registry: registry,
-		status:   make(map[string]*PluginStatus),
+		store:    store,
+		policy:   DefaultRestartPolicy,
+		plugins:  make(map[string]*managedPlugin),
 	}
 }
 
-// StartPlugin initializes and starts a plugin
+// SetRestartPolicy sets the restart policy of plugins started afterwards
+func (pm *PluginManager) SetRestartPolicy(policy RestartPolicy) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.policy = policy
+}
+
+// StartPlugin starts an instance of a plugin with the plugin name as
+// instance ID. See StartInstance.
 func (pm *PluginManager) StartPlugin(ctx context.Context, pType PluginType, name string, config map[string]interface{}) error {
+	_, err := pm.StartInstance(ctx, name, pType, name, config)
+	return err
+}
+
+// StartInstance initializes an instance of a plugin and runs it in a new
+// goroutine until ctx is cancelled or the instance is stopped. id names the
+// instance; if empty, an ID of the form "<name>-<n>" is generated. A
+// stopped instance with the same ID is replaced.
+//
+// If Run returns an error, the error is recorded in the instance status, the
+// plugin is stopped, and a new plugin initialized with the same config is
+// run after a backoff delay, until the restart policy gives up. If Run
+// returns nil, the instance is done and isn't restarted. Either way the
+// plugin is stopped once it no longer runs.
+//
+// If the plugin implements Configurable, config is validated against its
+// schema first, and Initialize gets the converted values. Restarts reuse
+// the converted values without validating them again.
+//
+// Returns the instance ID, or an error if the instance is already running
+// or the plugin doesn't exist, isn't of type pType, has an invalid config
+// or fails to initialize.
+func (pm *PluginManager) StartInstance(ctx context.Context, id string, pType PluginType, name string, config map[string]interface{}) (string, error) {
+	return pm.startInstance(ctx, id, pType, name, config, true)
+}
+
+// StartValidatedInstance is StartInstance for a config validated against
+// the plugin's schema already, such as the stage configs of a compiled
+// pipeline. Initialize gets config as it is.
+func (pm *PluginManager) StartValidatedInstance(ctx context.Context, id string, pType PluginType, name string, config map[string]interface{}) (string, error) {
+	return pm.startInstance(ctx, id, pType, name, config, false)
+}
+
+// startInstance starts an instance, validating its config first if
+// validate is set.
+func (pm *PluginManager) startInstance(ctx context.Context, id string, pType PluginType, name string, config map[string]interface{}, validate bool) (string, error) {
+	if id != "" {
+		pm.mu.RLock()
+		mp, exists := pm.plugins[id]
+		running := exists && mp.status.Running
+		pm.mu.RUnlock()
+		if running {
+			return "", pluginError(id, ErrPluginAlreadyRunning)
+		}
+	}
+
 	plugin, err := pm.registry.Create(pType, name)
 	if err != nil {
-		return fmt.Errorf("failed to create plugin: %w", err)
+		return "", fmt.Errorf("failed to create plugin: %w", err)
+	}
+	r, err := asRunnable(pType, plugin)
+	if err != nil {
+		return "", pluginError(name, err)
 	}
 
-	if err := plugin.Initialize(ctx, config); err != nil {
-		return fmt.Errorf("failed to initialize plugin: %w", err)
+	config = copyConfig(config)
+	if validate {
+		if config, err = ValidateConfig(string(pType)+"/"+name, plugin, config); err != nil {
+			return "", err
+		}
+	}
+	if err := plugin.Initialize(ctx, copyConfig(config)); err != nil {
+		return "", fmt.Errorf("failed to initialize plugin: %w", err)
 	}
 
 	pm.mu.Lock()
-	pm.status[name] = &PluginStatus{
-		Running:   true,
-		StartTime: time.Now(),
+	if id == "" {
+		id = pm.generateID(name)
+	} else if mp, exists := pm.plugins[id]; exists && mp.status.Running {
+		pm.mu.Unlock()
+		plugin.Stop()
+		return "", pluginError(id, ErrPluginAlreadyRunning)
 	}
+	runCtx, cancel := context.WithCancel(ctx)
+	mp := &managedPlugin{
+		plugin: r,
+		config: config,
+		status: PluginStatus{
+			ID:        id,
+			Type:      pType,
+			Name:      name,
+			Running:   true,
+			StartTime: time.Now(),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	pm.plugins[id] = mp
+	policy := pm.policy
 	pm.mu.Unlock()
 
-	return nil
+	go pm.supervise(runCtx, mp, policy)
+	return id, nil
 }
 
-// StopPlugin stops a running plugin
-func (pm *PluginManager) StopPlugin(name string) error {
+// generateID returns an unused instance ID for a plugin. The caller must
+// hold the lock.
+func (pm *PluginManager) generateID(name string) string {
+	for n := 1; ; n++ {
+		id := fmt.Sprintf("%s-%d", name, n)
+		if _, exists := pm.plugins[id]; !exists {
+			return id
+		}
+	}
+}
+
+// copyConfig returns a shallow copy of a plugin config, so that instances
+// don't share the caller's map.
+func copyConfig(config map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		c[k] = v
+	}
+	return c
+}
+
+// asRunnable returns a plugin as the interface of its type.
+func asRunnable(pType PluginType, plugin Plugin) (runnable, error) {
+	var (
+		r  runnable
+		ok bool
+	)
+	switch pType {
+	case PluginTypeIngress:
+		r, ok = plugin.(IngressPlugin)
+	case PluginTypeEgress:
+		r, ok = plugin.(EgressPlugin)
+	case PluginTypeTransform:
+		r, ok = plugin.(TransformPlugin)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: not a %s plugin", ErrPluginInvalidType, pType)
+	}
+	return r, nil
+}
+
+// supervise runs a plugin, restarting it according to policy, then stops
+// it. A plugin whose Run failed is stopped, and the restart runs a new
+// instance initialized from the instance config, as the state the failed
+// one kept from Initialize may be what failed.
+func (pm *PluginManager) supervise(ctx context.Context, mp *managedPlugin, policy RestartPolicy) {
+	defer close(mp.done)
+	defer mp.cancel()
+
+	for restarts, streak := 0, 0; ; {
+		started := time.Now()
+		var err error
+		if mp.plugin == nil {
+			mp.plugin, err = pm.reinitialize(ctx, mp)
+		}
+		if err == nil {
+			err = pm.run(ctx, mp.plugin)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if policy.StableAfter > 0 && time.Since(started) >= policy.StableAfter {
+			streak = 0
+		}
+
+		pm.mu.Lock()
+		mp.status.Error = err
+		pm.mu.Unlock()
+		if err == nil || (policy.MaxRestarts >= 0 && streak >= policy.MaxRestarts) {
+			break
+		}
+		if mp.plugin != nil {
+			mp.plugin.Stop()
+			mp.plugin = nil
+		}
+
+		restarts++
+		streak++
+		timer := time.NewTimer(policy.backoff(streak))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		pm.mu.Lock()
+		mp.status.Restarts = restarts
+		pm.mu.Unlock()
+	}
+
+	var stopErr error
+	if mp.plugin != nil {
+		stopErr = mp.plugin.Stop()
+	}
 	pm.mu.Lock()
-	defer pm.mu.Unlock()
+	mp.status.Running = false
+	mp.stopErr = stopErr
+	pm.mu.Unlock()
+}
+
+// reinitialize creates a new plugin for an instance being restarted and
+// initializes it with the instance config.
+func (pm *PluginManager) reinitialize(ctx context.Context, mp *managedPlugin) (runnable, error) {
+	plugin, err := pm.registry.Create(mp.status.Type, mp.status.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plugin: %w", err)
+	}
+	r, err := asRunnable(mp.status.Type, plugin)
+	if err != nil {
+		return nil, pluginError(mp.status.Name, err)
+	}
+	if err := plugin.Initialize(ctx, copyConfig(mp.config)); err != nil {
+		return nil, fmt.Errorf("failed to initialize plugin: %w", err)
+	}
+	return r, nil
+}
+
+// run calls Run on a plugin, turning a panic into an error.
+func (pm *PluginManager) run(ctx context.Context, plugin runnable) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("plugin panicked: %v", r)
+		}
+	}()
+	return plugin.Run(ctx, pm.store)
+}
 
-	status, exists := pm.status[name]
-	if !exists || !status.Running {
-		return fmt.Errorf("plugin not running: %s", name)
+// StopPlugin stops a running plugin instance and waits for it to return
+func (pm *PluginManager) StopPlugin(id string) error {
+	pm.mu.RLock()
+	mp, exists := pm.plugins[id]
+	running := exists && mp.status.Running
+	pm.mu.RUnlock()
+	if !running {
+		return pluginError(id, ErrPluginNotRunning)
 	}
 
-	status.Running = false
-	status.Error = nil
+	mp.cancel()
+	<-mp.done
+
+	pm.mu.RLock()
+	err := mp.stopErr
+	pm.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to stop plugin: %w", err)
+	}
 	return nil
 }
 
-// GetPluginStatus returns the current status of a plugin
-func (pm *PluginManager) GetPluginStatus(name string) (*PluginStatus, error) {
+// RestartPlugin stops a plugin instance if it is running, then starts it
+// again under the same ID with a new plugin initialized from its config.
+// The restart count starts over.
+func (pm *PluginManager) RestartPlugin(ctx context.Context, id string) error {
+	pm.mu.RLock()
+	mp, exists := pm.plugins[id]
+	pm.mu.RUnlock()
+	if !exists {
+		return pluginError(id, ErrPluginNotFound)
+	}
+
+	if err := pm.StopPlugin(id); err != nil && !errors.Is(err, ErrPluginNotRunning) {
+		return err
+	}
+	_, err := pm.startInstance(ctx, id, mp.status.Type, mp.status.Name, mp.config, false)
+	return err
+}
+
+// GetPluginStatus returns a snapshot of the current status of a plugin
+// instance
+func (pm *PluginManager) GetPluginStatus(id string) (*PluginStatus, error) {
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
 
-	status, exists := pm.status[name]
+	mp, exists := pm.plugins[id]
 	if !exists {
-		return nil, fmt.Errorf("plugin not found: %s", name)
+		return nil, pluginError(id, ErrPluginNotFound)
 	}
 
-	return status, nil
+	status := mp.status
+	return &status, nil
+}
+
+// ListPlugins returns a snapshot of the status of all plugin instances,
+// running or not, ordered by ID
+func (pm *PluginManager) ListPlugins() []PluginStatus {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	statuses := make([]PluginStatus, 0, len(pm.plugins))
+	for _, mp := range pm.plugins {
+		statuses = append(statuses, mp.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses
 }
@@ -1,7 +1,7 @@
 package plugins
 
 import (
-	"fmt"
+	"sort"
 	"sync"
 )
 
@@ -40,23 +40,70 @@ func (r *Registry) Register(pType PluginType, name string, factory PluginFactory
 	}
 
 	if _, exists := r.plugins[pType][name]; exists {
-		return fmt.Errorf("plugin already registered: %s", name)
+		return pluginError(name, ErrPluginAlreadyRegistered)
 	}
 
 	r.plugins[pType][name] = factory
 	return nil
 }
 
-// Create instantiates a new plugin by type and name
-func (r *Registry) Create(pType PluginType, name string) (Plugin, error) {
+// Has reports whether a plugin is registered under a type and name
+func (r *Registry) Has(pType PluginType, name string) bool {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	if factories, ok := r.plugins[pType]; ok {
-		if factory, ok := factories[name]; ok {
-			return factory(), nil
+	_, ok := r.plugins[pType][name]
+	return ok
+}
+
+// Create instantiates a new plugin by type and name. If the plugin
+// implements Configurable, its schema is checked before the plugin is
+// returned, so that invalid defaults are reported before any config is
+// validated against it.
+//
+// Returns an error wrapping ErrPluginNotFound or ErrInvalidSchema.
+func (r *Registry) Create(pType PluginType, name string) (Plugin, error) {
+	r.mu.RLock()
+	factory, ok := r.plugins[pType][name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, pluginError(string(pType)+"/"+name, ErrPluginNotFound)
+	}
+
+	plugin := factory()
+	if c, ok := plugin.(Configurable); ok {
+		if err := c.ConfigSchema().Check(); err != nil {
+			return nil, pluginError(string(pType)+"/"+name, err)
 		}
 	}
+	return plugin, nil
+}
+
+// Names returns the names of the plugins registered under a type, sorted
+func (r *Registry) Names(pType PluginType) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.plugins[pType]))
+	for name := range r.plugins[pType] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
-	return nil, fmt.Errorf("plugin not found: %s/%s", pType, name)
+// Schema returns the config schema of a plugin, or nil if the plugin
+// doesn't declare one. The schema is taken from a new, uninitialized
+// instance of the plugin, and checked by Create.
+func (r *Registry) Schema(pType PluginType, name string) (*Schema, error) {
+	plugin, err := r.Create(pType, name)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := plugin.(Configurable)
+	if !ok {
+		return nil, nil
+	}
+	schema := c.ConfigSchema()
+	return &schema, nil
 }
@@ -0,0 +1,344 @@
+package plugins
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/relais/pkg/util"
+)
+
+// FieldType is the type of a config option.
+type FieldType string
+
+const (
+	FieldString   FieldType = "string"   // string
+	FieldInt      FieldType = "int"      // int
+	FieldFloat    FieldType = "float"    // float64
+	FieldBool     FieldType = "bool"     // bool
+	FieldDuration FieldType = "duration" // time.Duration, written as e.g. "1.5s"
+	FieldBytes    FieldType = "bytes"    // []byte, written as a base64 string
+)
+
+// Range bounds the value of a numeric option, inclusively.
+type Range struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// Field describes a config option of a plugin.
+type Field struct {
+	Name        string      `json:"name"`
+	Type        FieldType   `json:"type"`
+	Description string      `json:"description,omitempty"`
+	Default     interface{} `json:"default,omitempty"`  // Value used when the option is missing, nil for none
+	Required    bool        `json:"required,omitempty"` // The option must be set
+	Range       *Range      `json:"range,omitempty"`    // Bounds of int and float options, nil for none
+	Values      []string    `json:"values,omitempty"`   // Allowed values of string options, empty for any
+}
+
+// Schema describes the config options of a plugin.
+type Schema struct {
+	Fields []Field `json:"fields"`
+}
+
+// Configurable is implemented by plugins declaring the config options they
+// support. Hosts validate their config against the schema once, before
+// Initialize is called, and Initialize gets the options as the types of the
+// schema. The registry checks the schema whenever it creates the plugin.
+type Configurable interface {
+	ConfigSchema() Schema
+}
+
+// hostKeys are the options set by hosts to wire plugins together, such as
+// the session mapping added by pipelines. They are passed through as they
+// are when a schema doesn't declare them.
+var hostKeys = map[string]bool{
+	"session_id":       true,
+	"stream":           true,
+	"input_rendition":  true,
+	"output_rendition": true,
+	"instance_id":      true,
+}
+
+// Field returns the field of an option, or nil if the schema doesn't
+// declare it.
+func (s Schema) Field(name string) *Field {
+	for i := range s.Fields {
+		if s.Fields[i].Name == name {
+			return &s.Fields[i]
+		}
+	}
+	return nil
+}
+
+// Check checks the schema itself: the default of every field must be a
+// value of the field's type, within its range and allowed values, so that
+// Validate can set it as it is.
+//
+// Returns an error wrapping ErrInvalidSchema listing every problem found.
+func (s Schema) Check() error {
+	var problems []string
+	for _, f := range s.Fields {
+		if f.Default == nil {
+			continue
+		}
+		value, err := f.convert(f.Default)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("default of %s: %v", f.Name, err))
+		} else if reflect.TypeOf(value) != reflect.TypeOf(f.Default) {
+			problems = append(problems, fmt.Sprintf("default of %s: expected %s, got %T", f.Name, f.Type, f.Default))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidSchema, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Validate checks a config against the schema, and returns a copy of it
+// with the values converted to the types of their fields and the defaults
+// of missing options set.
+//
+// Values are converted from the forms JSON, YAML and environment variables
+// produce: numbers of any type, and strings for every type. A nil value is
+// the same as a missing option.
+//
+// Returns an error wrapping ErrInvalidConfig listing every problem found,
+// such as missing required options, values of the wrong type or out of
+// range, and unknown options.
+func (s Schema) Validate(config map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(config)+len(s.Fields))
+	var problems []string
+
+	for _, f := range s.Fields {
+		v := config[f.Name]
+		if v == nil {
+			if f.Required {
+				problems = append(problems, "missing required option "+f.Name)
+			} else if f.Default != nil {
+				out[f.Name] = f.Default
+			}
+			continue
+		}
+		value, err := f.convert(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("option %s: %v", f.Name, err))
+			continue
+		}
+		out[f.Name] = value
+	}
+
+	var unknown []string
+	for k, v := range config {
+		if s.Field(k) != nil {
+			continue
+		}
+		if hostKeys[k] {
+			out[k] = v
+		} else {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		problems = append(problems, "unknown option "+k)
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidConfig, strings.Join(problems, "; "))
+	}
+	return out, nil
+}
+
+// convert returns a value as the type of the field, checking its range and
+// allowed values.
+func (f Field) convert(v interface{}) (interface{}, error) {
+	switch f.Type {
+	case FieldString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string, got %T", v)
+		}
+		if len(f.Values) > 0 && !contains(f.Values, s) {
+			return nil, fmt.Errorf("%q is not one of %s", s, strings.Join(f.Values, ", "))
+		}
+		return s, nil
+	case FieldInt:
+		n, err := toInt(v)
+		if err != nil {
+			return nil, err
+		}
+		return n, f.checkRange(float64(n))
+	case FieldFloat:
+		x, err := toFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		return x, f.checkRange(x)
+	case FieldBool:
+		switch b := v.(type) {
+		case bool:
+			return b, nil
+		case string:
+			parsed, err := strconv.ParseBool(strings.TrimSpace(b))
+			if err != nil {
+				return nil, fmt.Errorf("invalid bool %q", b)
+			}
+			return parsed, nil
+		}
+		return nil, fmt.Errorf("expected bool, got %T", v)
+	case FieldDuration:
+		switch d := v.(type) {
+		case time.Duration:
+			return d, nil
+		case string:
+			parsed, err := time.ParseDuration(strings.TrimSpace(d))
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration %q", d)
+			}
+			return parsed, nil
+		}
+		return nil, fmt.Errorf("expected duration such as \"1s\", got %T", v)
+	case FieldBytes:
+		switch b := v.(type) {
+		case []byte:
+			return b, nil
+		case string:
+			decoded, err := base64.StdEncoding.DecodeString(b)
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64: %v", err)
+			}
+			return decoded, nil
+		}
+		return nil, fmt.Errorf("expected bytes or base64 string, got %T", v)
+	default:
+		return nil, fmt.Errorf("unknown field type %s", f.Type)
+	}
+}
+
+// checkRange checks that a numeric value is within the range of the field.
+func (f Field) checkRange(x float64) error {
+	if f.Range != nil && (x < f.Range.Min || x > f.Range.Max) {
+		return fmt.Errorf("%v is out of range [%v, %v]", x, f.Range.Min, f.Range.Max)
+	}
+	return nil
+}
+
+// toInt converts an integer of any type, an integral float or a decimal
+// string to an int.
+func toInt(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int8:
+		return int(n), nil
+	case int16:
+		return int(n), nil
+	case int32:
+		return int(n), nil
+	case int64:
+		if n < math.MinInt || n > math.MaxInt {
+			return 0, fmt.Errorf("%d overflows int", n)
+		}
+		return int(n), nil
+	case uint:
+		if n > math.MaxInt {
+			return 0, fmt.Errorf("%d overflows int", n)
+		}
+		return int(n), nil
+	case uint8:
+		return int(n), nil
+	case uint16:
+		return int(n), nil
+	case uint32:
+		return int(n), nil
+	case uint64:
+		if n > math.MaxInt {
+			return 0, fmt.Errorf("%d overflows int", n)
+		}
+		return int(n), nil
+	case float32:
+		return floatToInt(float64(n))
+	case float64:
+		return floatToInt(n)
+	case string:
+		parsed, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil {
+			return 0, fmt.Errorf("invalid integer %q", n)
+		}
+		return parsed, nil
+	}
+	return 0, fmt.Errorf("expected integer, got %T", v)
+}
+
+// floatToInt converts an integral float to an int.
+func floatToInt(x float64) (int, error) {
+	if x != math.Trunc(x) || x < math.MinInt || x >= math.MaxInt {
+		return 0, fmt.Errorf("expected integer, got %v", x)
+	}
+	return int(x), nil
+}
+
+// toFloat converts a number of any type or a decimal string to a finite
+// float64.
+func toFloat(v interface{}) (float64, error) {
+	switch x := v.(type) {
+	case float64:
+		return finite(x)
+	case float32:
+		return finite(float64(x))
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", x)
+		}
+		return finite(parsed)
+	}
+	n, err := toInt(v)
+	if err != nil {
+		return 0, fmt.Errorf("expected number, got %T", v)
+	}
+	return float64(n), nil
+}
+
+// finite returns x, or an error if it is NaN or infinite, which no range
+// check would reject.
+func finite(x float64) (float64, error) {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return 0, fmt.Errorf("%v is not a finite number", x)
+	}
+	return x, nil
+}
+
+// contains reports whether values contains s.
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateConfig validates the config of a plugin against its schema. See
+// Schema.Validate. The config is returned as it is if the plugin doesn't
+// implement Configurable.
+//
+// Errors are validation errors naming the plugin.
+func ValidateConfig(name string, plugin Plugin, config map[string]interface{}) (map[string]interface{}, error) {
+	c, ok := plugin.(Configurable)
+	if !ok {
+		return config, nil
+	}
+	config, err := c.ConfigSchema().Validate(config)
+	if err != nil {
+		return nil, util.NewError(util.ErrorTypeValidation, name, err)
+	}
+	return config, nil
+}
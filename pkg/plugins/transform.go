@@ -0,0 +1,291 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/relais/pkg/storage"
+)
+
+// FrameFunc transforms a frame read from the input rendition of a stream.
+// It returns the frame to write to the output rendition, or false to skip
+// the frame. An error stops the transform.
+type FrameFunc func(ctx context.Context, frame storage.Frame) (storage.Frame, bool, error)
+
+// RenditionTransform runs a FrameFunc over the input rendition of every
+// stream, or of a single stream, writing the results to the output
+// rendition. It is the frame loop shared by transform plugins that work
+// one frame at a time.
+//
+// Progress is recorded with a storage checkpoint per input session, so a
+// restarted transform resumes after the last frame it processed. With a
+// Group, the input is instead read through a consumer group of a storage
+// implementing storage.GroupReader, so that instances of the group share the
+// frames of the input sessions.
+type RenditionTransform struct {
+	Input      string // Rendition read from
+	Output     string // Rendition written to
+	Stream     string // Only stream processed, or empty for all streams
+	InstanceID string // Consumer name of the checkpoints, unique per plugin instance
+	Group      string // Consumer group sharing the input, or empty to read it all
+}
+
+// Consumer group reads, see runGroup.
+const (
+	groupBatch     = 16                     // Maximum messages read per session and call
+	groupBlock     = 500 * time.Millisecond // How long a read waits for new frames
+	groupClaimIdle = 30 * time.Second       // Idle time after which pending frames are claimed
+)
+
+// Configure overrides the fields of the transform with the config options
+// shared by rendition transforms, and checks the result.
+// Supported config options:
+// - input_rendition: string - Rendition to read frames from
+// - output_rendition: string - Rendition to write transformed frames to
+// - stream: string - Only stream to process, all streams by default
+// - instance_id: string - Name under which progress is checkpointed, unique per plugin instance
+// - group: string - Consumer group sharing the input between instances, which need distinct instance IDs
+func (t *RenditionTransform) Configure(config map[string]interface{}) error {
+	if input, ok := config["input_rendition"].(string); ok {
+		t.Input = input
+	}
+	if output, ok := config["output_rendition"].(string); ok {
+		t.Output = output
+	}
+	if stream, ok := config["stream"].(string); ok {
+		t.Stream = stream
+	}
+	if instanceID, ok := config["instance_id"].(string); ok {
+		t.InstanceID = instanceID
+	}
+	if group, ok := config["group"].(string); ok {
+		t.Group = group
+	}
+	if t.Input == t.Output {
+		return fmt.Errorf("input and output rendition must differ: %s", t.Input)
+	}
+	return nil
+}
+
+// RenditionTransformFields returns the schema fields of the config options
+// read by Configure, for the schemas of plugins using a RenditionTransform.
+func RenditionTransformFields() []Field {
+	return []Field{
+		{Name: "input_rendition", Type: FieldString, Description: "Rendition to read frames from"},
+		{Name: "output_rendition", Type: FieldString, Description: "Rendition to write transformed frames to"},
+		{Name: "stream", Type: FieldString, Description: "Only stream to process, all streams by default"},
+		{Name: "instance_id", Type: FieldString, Description: "Name under which progress is checkpointed, unique per plugin instance"},
+		{Name: "group", Type: FieldString, Description: "Consumer group sharing the input between instances, which need distinct instance IDs"},
+	}
+}
+
+// Run transforms frames until ctx is cancelled or fn returns an error.
+// Sessions of the input rendition are looked for periodically, and each is
+// processed in its own goroutine, so fn must be safe for concurrent use.
+//
+// Transformed frames keep their index and are written to the output
+// session. Frames are checkpointed once written or skipped. A frame that
+// fails to be written or checkpointed stops the transform with the error, and
+// is processed again after a restart.
+//
+// With a Group, frames are read through the consumer group instead, see
+// runGroup, and the storage must implement storage.GroupReader.
+//
+// Returns the error of fn or of the storage, or the context error once
+// cancelled.
+func (t RenditionTransform) Run(ctx context.Context, store storage.Storage, fn FrameFunc) error {
+	if t.Group != "" {
+		reader, ok := store.(storage.GroupReader)
+		if !ok {
+			return fmt.Errorf("consumer group %s requires a storage supporting consumer groups, got %T", t.Group, store)
+		}
+		return t.runGroup(ctx, store, reader, fn)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Check for new sessions periodically
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// Sessions being processed. A session whose subscription ends, e.g.
+	// because it fell behind, is removed and subscribed to again from its
+	// checkpoint.
+	var mu sync.Mutex
+	subscribed := make(map[string]bool)
+	for {
+		// Subscribe to sessions that appeared since the last check
+		sessions, err := t.inputSessions(ctx, store)
+		if err == nil {
+			for _, sessionID := range sessions {
+				mu.Lock()
+				active := subscribed[sessionID]
+				mu.Unlock()
+				if active {
+					continue
+				}
+
+				// Resume after the last frame processed by this instance
+				fromIndex := int64(0)
+				index, ok, err := store.GetCheckpoint(ctx, t.InstanceID, sessionID)
+				if err != nil {
+					continue
+				}
+				if ok {
+					fromIndex = index + 1
+				}
+
+				frames, err := store.Subscribe(ctx, sessionID, fromIndex)
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				subscribed[sessionID] = true
+				mu.Unlock()
+
+				wg.Add(1)
+				go func(sessionID string) {
+					defer wg.Done()
+					if err := t.processFrames(ctx, store, frames, sessionID, fn); err != nil {
+						cancel(err)
+						return
+					}
+					mu.Lock()
+					delete(subscribed, sessionID)
+					mu.Unlock()
+				}(sessionID)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-ticker.C:
+		}
+	}
+}
+
+// inputSessions returns the sessions of the input rendition.
+func (t RenditionTransform) inputSessions(ctx context.Context, store storage.Storage) ([]string, error) {
+	sessions, err := store.ListSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var inputs []string
+	for _, sessionID := range sessions {
+		stream, rendition := storage.SplitRenditionSessionID(sessionID)
+		if rendition == t.Input && (t.Stream == "" || stream == t.Stream) {
+			inputs = append(inputs, sessionID)
+		}
+	}
+	return inputs, nil
+}
+
+// processFrames transforms frames from a session subscription until the
+// subscription ends or fn fails.
+//
+// Processing stops at the first frame that can't be written or checkpointed,
+// so the checkpoint never moves past a frame missing from the output.
+func (t RenditionTransform) processFrames(ctx context.Context, store storage.Storage, frames <-chan storage.Frame, sessionID string, fn FrameFunc) error {
+	for frame := range frames {
+		if err := t.transformFrame(ctx, store, frame, fn); err != nil {
+			return err
+		}
+		if err := store.SetCheckpoint(ctx, t.InstanceID, sessionID, frame.Index); err != nil {
+			return fmt.Errorf("failed to checkpoint frame %d of session %s: %w", frame.Index, sessionID, err)
+		}
+	}
+	return nil
+}
+
+// runGroup transforms the frames of the input sessions read through the
+// consumer group, with InstanceID as consumer name, until ctx is cancelled
+// or a frame fails.
+//
+// Each frame is acknowledged once written or skipped. A frame that fails
+// stops the transform without being acknowledged, so it stays pending and is
+// claimed once idle for groupClaimIdle, by another instance or by this one
+// after a restart. Frames of a session are processed in order by each
+// instance, but not across instances.
+func (t RenditionTransform) runGroup(ctx context.Context, store storage.Storage, reader storage.GroupReader, fn FrameFunc) error {
+	// Look for new sessions while no frame is read
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	var lastClaim time.Time
+	for {
+		sessions, err := t.inputSessions(ctx, store)
+		if err != nil || len(sessions) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+			continue
+		}
+
+		var messages []storage.StreamMessage
+		if time.Since(lastClaim) >= groupClaimIdle {
+			for _, sessionID := range sessions {
+				claimed, err := reader.ClaimStale(ctx, t.Group, t.InstanceID, sessionID, groupClaimIdle, groupBatch)
+				if err != nil {
+					return groupError(ctx, err)
+				}
+				messages = append(messages, claimed...)
+			}
+			lastClaim = time.Now()
+		}
+
+		read, err := reader.ReadGroup(ctx, t.Group, t.InstanceID, sessions, groupBatch, groupBlock)
+		if err != nil {
+			return groupError(ctx, err)
+		}
+		messages = append(messages, read...)
+
+		for _, m := range messages {
+			if err := t.transformFrame(ctx, store, m.Frame, fn); err != nil {
+				return err
+			}
+			if err := reader.Ack(ctx, t.Group, m.Frame.SessionID, m.ID); err != nil {
+				return fmt.Errorf("failed to acknowledge frame %d of session %s: %w", m.Frame.Index, m.Frame.SessionID, err)
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// groupError returns the context error if ctx was cancelled during a
+// consumer group read that failed with err, and err otherwise.
+func groupError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// transformFrame runs fn on a frame of an input session and writes the
+// result, if any, to the matching session of the output rendition.
+func (t RenditionTransform) transformFrame(ctx context.Context, store storage.Storage, frame storage.Frame, fn FrameFunc) error {
+	out, ok, err := fn(ctx, frame)
+	if err != nil || !ok {
+		return err
+	}
+
+	stream, _ := storage.SplitRenditionSessionID(frame.SessionID)
+	out.SessionID = storage.RenditionSessionID(stream, t.Output)
+	out.Index = frame.Index
+	if err := store.PutFrame(ctx, out); err != nil {
+		return fmt.Errorf("failed to write frame %d of session %s: %w", frame.Index, out.SessionID, err)
+	}
+	return nil
+}
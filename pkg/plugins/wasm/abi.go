@@ -0,0 +1,140 @@
+// Package wasm runs transform plugins written as WebAssembly modules, with
+// the pure-Go wazero runtime. Modules are sandboxed: they only see the
+// frames they are given, their memory is capped and every call has a
+// deadline. A module file is reloaded when it changes, so transforms can be
+// updated without restarting the host.
+//
+// # ABI
+//
+// A module exports its memory and:
+//
+//	relais_alloc(size i32) -> ptr i32
+//	relais_transform(meta_ptr, meta_len, data_ptr, data_len i32) -> status i32
+//	relais_init(config_ptr, config_len i32) -> status i32    (optional)
+//
+// The host allocates the buffers it passes with relais_alloc; they belong
+// to the module afterwards. relais_init gets the plugin config as JSON.
+// relais_transform gets the frame metadata as JSON, as in Metadata, and the
+// frame payload. It returns StatusOK to write the frame, StatusSkip to skip
+// it, or any other status for an error.
+//
+// The host module "relais" provides:
+//
+//	output(ptr, len i32)          sets the payload of the transformed frame,
+//	                              the input payload if never called
+//	set_metadata(ptr, len i32)    overrides metadata fields with a JSON object
+//	error(ptr, len i32)           sets the message of the error returned next
+//	log(ptr, len i32)             writes a message to the host's standard error
+//
+// Modules built for WASI, e.g. by TinyGo or Rust, get the WASI preview 1
+// imports, and their _initialize function is called if exported.
+package wasm
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/relais/pkg/frames"
+	"github.com/relais/pkg/storage"
+)
+
+// Names of the ABI.
+const (
+	hostModule      = "relais"           // Module of the host functions
+	exportAlloc     = "relais_alloc"     // Allocates guest memory
+	exportInit      = "relais_init"      // Initializes the module with its config
+	exportTransform = "relais_transform" // Transforms a frame
+)
+
+// Statuses returned by relais_transform and relais_init.
+const (
+	StatusOK   = 0 // The frame was transformed, or the module initialized
+	StatusSkip = 1 // The frame is skipped
+)
+
+// Metadata is the JSON form of the metadata of a frame passed to modules.
+type Metadata struct {
+	SessionID   string `json:"session_id"`
+	Index       int64  `json:"index"`
+	Timestamp   int64  `json:"timestamp"` // Unix nanoseconds, 0 if unknown
+	MediaType   string `json:"media_type"`
+	Codec       string `json:"codec,omitempty"`
+	KeyFrame    bool   `json:"key_frame"`
+	TrackID     uint32 `json:"track_id"`
+	Sequence    uint64 `json:"sequence"`
+	PTS         int64  `json:"pts"`
+	DTS         int64  `json:"dts"`
+	Duration    int64  `json:"duration"`
+	TimebaseNum uint32 `json:"timebase_num"`
+	TimebaseDen uint32 `json:"timebase_den"`
+}
+
+// MetadataOf returns the metadata of a frame.
+func MetadataOf(frame storage.Frame) Metadata {
+	m := Metadata{
+		SessionID:   frame.SessionID,
+		Index:       frame.Index,
+		MediaType:   frame.MediaType,
+		Codec:       string(frame.Codec),
+		KeyFrame:    frame.KeyFrame,
+		TrackID:     frame.TrackID,
+		Sequence:    frame.Sequence,
+		PTS:         frame.PTS,
+		DTS:         frame.DTS,
+		Duration:    frame.Duration,
+		TimebaseNum: frame.Timebase.Num,
+		TimebaseDen: frame.Timebase.Den,
+	}
+	if !frame.Timestamp.IsZero() {
+		m.Timestamp = frame.Timestamp.UnixNano()
+	}
+	return m
+}
+
+// Apply sets the metadata fields of a frame. Side data is dropped if the
+// codec changes, as it describes the old codec.
+func (m Metadata) Apply(frame storage.Frame) storage.Frame {
+	if frames.CodecType(m.Codec) != frame.Codec {
+		frame.SideData = nil
+	}
+	frame.SessionID = m.SessionID
+	frame.Index = m.Index
+	frame.Timestamp = time.Time{}
+	if m.Timestamp != 0 {
+		frame.Timestamp = time.Unix(0, m.Timestamp)
+	}
+	frame.MediaType = m.MediaType
+	frame.Codec = frames.CodecType(m.Codec)
+	frame.KeyFrame = m.KeyFrame
+	frame.TrackID = m.TrackID
+	frame.Sequence = m.Sequence
+	frame.PTS = m.PTS
+	frame.DTS = m.DTS
+	frame.Duration = m.Duration
+	frame.Timebase = frames.Timebase{Num: m.TimebaseNum, Den: m.TimebaseDen}
+	return frame
+}
+
+// result is what a module returned for a frame.
+type result struct {
+	status   int32  // Status returned by relais_transform
+	output   []byte // Payload set with output, nil if not called
+	metadata []byte // JSON object set with set_metadata, nil if not called
+	message  string // Message set with error
+}
+
+// frame returns the transformed frame of a result for the input frame.
+func (r result) frame(frame storage.Frame) (storage.Frame, error) {
+	if r.metadata != nil {
+		m := MetadataOf(frame)
+		if err := json.Unmarshal(r.metadata, &m); err != nil {
+			return frame, fmt.Errorf("module set invalid metadata: %v", err)
+		}
+		frame = m.Apply(frame)
+	}
+	if r.output != nil {
+		frame.Data = r.output
+	}
+	return frame, nil
+}
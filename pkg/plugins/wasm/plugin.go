@@ -0,0 +1,196 @@
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/relais/pkg/plugins"
+	"github.com/relais/pkg/storage"
+)
+
+// reloadInterval is how often the module file is checked for changes.
+const reloadInterval = time.Second
+
+// Plugin is a transform plugin running a WebAssembly module. It works like
+// plugins.RenditionTransform, passing every frame to the module.
+//
+// The module is instantiated by Initialize. It is instantiated again after
+// a failed call, since a trap may leave its state inconsistent, and when
+// the module file changes.
+type Plugin struct {
+	path string
+
+	mu        sync.Mutex
+	config    []byte // JSON config passed to relais_init
+	transform plugins.RenditionTransform
+	instance  *instance // Instantiated module, nil after a failed call
+	modTime   time.Time // Modification time of the module file loaded
+	checked   time.Time // Last time the module file was checked for changes
+}
+
+// NewPlugin creates a plugin running the module at path.
+func NewPlugin(path string) *Plugin {
+	return &Plugin{path: path}
+}
+
+// Factory returns a plugin factory for the module at path, suitable for
+// plugins.Registry.Register.
+func Factory(path string) plugins.PluginFactory {
+	return func() plugins.Plugin {
+		return NewPlugin(path)
+	}
+}
+
+// Register registers the module at path as a transform plugin. The name
+// defaults to the file name without extension.
+func Register(registry *plugins.Registry, name, path string) error {
+	if name == "" {
+		name = moduleName(path)
+	}
+	return registry.Register(plugins.PluginTypeTransform, name, Factory(path))
+}
+
+// moduleName returns the file name of a module without extension.
+func moduleName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// Initialize loads the module and initializes it with config.
+// Supported config options:
+// - the options of plugins.RenditionTransform, reading the source rendition
+// and writing a rendition named after the module file by default
+// - any other option, passed to relais_init
+func (p *Plugin) Initialize(ctx context.Context, config map[string]interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	name := moduleName(p.path)
+	p.transform = plugins.RenditionTransform{
+		Input:      storage.RenditionSource,
+		Output:     name,
+		InstanceID: name,
+	}
+	if err := p.transform.Configure(config); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+	p.config = data
+
+	p.close(ctx)
+	return p.load(ctx)
+}
+
+// load reads, compiles and instantiates the module file. The caller must
+// hold the lock.
+func (p *Plugin) load(ctx context.Context) error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to load module: %v", err)
+	}
+	wasm, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to load module: %v", err)
+	}
+
+	instance, err := newInstance(ctx, wasm, p.config)
+	if err != nil {
+		return err
+	}
+	p.instance = instance
+	p.modTime = info.ModTime()
+	p.checked = time.Now()
+	return nil
+}
+
+// reload loads the module file again if it changed. The running instance
+// is kept if the new module fails to load. The caller must hold the lock.
+func (p *Plugin) reload(ctx context.Context) {
+	if time.Since(p.checked) < reloadInterval {
+		return
+	}
+	p.checked = time.Now()
+
+	info, err := os.Stat(p.path)
+	if err != nil || info.ModTime().Equal(p.modTime) {
+		return
+	}
+	old := p.instance
+	if err := p.load(ctx); err != nil {
+		// Don't try the broken file again until it changes
+		p.modTime = info.ModTime()
+		return
+	}
+	if old != nil {
+		old.close(ctx)
+	}
+}
+
+// Run transforms frames with the module until ctx is cancelled or a call
+// to the module fails.
+func (p *Plugin) Run(ctx context.Context, store storage.Storage) error {
+	return p.transform.Run(ctx, store, p.transformFrame)
+}
+
+// transformFrame passes a frame to the module.
+func (p *Plugin) transformFrame(ctx context.Context, frame storage.Frame) (storage.Frame, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.instance == nil {
+		if err := p.load(ctx); err != nil {
+			return frame, false, err
+		}
+	} else {
+		p.reload(ctx)
+	}
+
+	metadata, err := json.Marshal(MetadataOf(frame))
+	if err != nil {
+		return frame, false, err
+	}
+	res, err := p.instance.transform(ctx, metadata, frame.Data)
+	if err != nil {
+		p.close(ctx)
+		return frame, false, err
+	}
+
+	switch res.status {
+	case StatusOK:
+		out, err := res.frame(frame)
+		return out, err == nil, err
+	case StatusSkip:
+		return frame, false, nil
+	default:
+		if res.message != "" {
+			return frame, false, fmt.Errorf("module failed with status %d: %s", res.status, res.message)
+		}
+		return frame, false, fmt.Errorf("module failed with status %d", res.status)
+	}
+}
+
+// close releases the module instance. The caller must hold the lock.
+func (p *Plugin) close(ctx context.Context) {
+	if p.instance != nil {
+		p.instance.close(ctx)
+		p.instance = nil
+	}
+}
+
+// Stop releases the module.
+func (p *Plugin) Stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.close(context.Background())
+	return nil
+}
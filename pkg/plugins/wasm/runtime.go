@@ -0,0 +1,172 @@
+package wasm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Sandbox limits of modules.
+const (
+	memoryLimitPages = 1024            // Memory of a module, in 64 KiB pages
+	callTimeout      = 5 * time.Second // Longest call into a module
+)
+
+// instance is an instantiated module, in its own runtime.
+type instance struct {
+	runtime     wazero.Runtime
+	module      api.Module
+	allocFn     api.Function // relais_alloc
+	transformFn api.Function // relais_transform
+	res         result       // Result of the call in progress, set by the host functions
+}
+
+// newInstance compiles and instantiates a module, then passes it its JSON
+// config if it exports relais_init.
+func newInstance(ctx context.Context, wasm, config []byte) (*instance, error) {
+	i := &instance{}
+	i.runtime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(memoryLimitPages).
+		WithCloseOnContextDone(true))
+
+	if err := i.instantiate(ctx, wasm); err != nil {
+		i.runtime.Close(ctx)
+		return nil, err
+	}
+
+	if init := i.module.ExportedFunction(exportInit); init != nil {
+		res, err := i.call(ctx, init, config)
+		if err == nil && res.status != StatusOK {
+			err = fmt.Errorf("status %d", res.status)
+			if res.message != "" {
+				err = fmt.Errorf("status %d: %s", res.status, res.message)
+			}
+		}
+		if err != nil {
+			i.runtime.Close(ctx)
+			return nil, fmt.Errorf("failed to initialize module: %w", err)
+		}
+	}
+	return i, nil
+}
+
+// instantiate sets up the host functions and instantiates the module.
+func (i *instance) instantiate(ctx context.Context, wasm []byte) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, i.runtime); err != nil {
+		return fmt.Errorf("failed to instantiate WASI: %v", err)
+	}
+	_, err := i.runtime.NewHostModuleBuilder(hostModule).
+		NewFunctionBuilder().WithFunc(i.hostOutput).Export("output").
+		NewFunctionBuilder().WithFunc(i.hostSetMetadata).Export("set_metadata").
+		NewFunctionBuilder().WithFunc(i.hostError).Export("error").
+		NewFunctionBuilder().WithFunc(i.hostLog).Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to instantiate host module: %v", err)
+	}
+
+	compiled, err := i.runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		return fmt.Errorf("failed to compile module: %v", err)
+	}
+	i.module, err = i.runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStdout(os.Stderr).
+		WithStderr(os.Stderr))
+	if err != nil {
+		return fmt.Errorf("failed to instantiate module: %v", err)
+	}
+
+	i.allocFn = i.module.ExportedFunction(exportAlloc)
+	i.transformFn = i.module.ExportedFunction(exportTransform)
+	if i.allocFn == nil || i.transformFn == nil || i.module.Memory() == nil {
+		return fmt.Errorf("module must export memory, %s and %s", exportAlloc, exportTransform)
+	}
+	return nil
+}
+
+// write copies data into a buffer allocated in the module.
+func (i *instance) write(ctx context.Context, data []byte) (uint32, error) {
+	results, err := i.allocFn.Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, err
+	}
+	ptr := uint32(results[0])
+	if !i.module.Memory().Write(ptr, data) {
+		return 0, fmt.Errorf("%s returned invalid buffer %#x for %d bytes", exportAlloc, ptr, len(data))
+	}
+	return ptr, nil
+}
+
+// call calls fn with the buffers holding args, within callTimeout.
+func (i *instance) call(ctx context.Context, fn api.Function, args ...[]byte) (result, error) {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+
+	params := make([]uint64, 0, 2*len(args))
+	for _, arg := range args {
+		ptr, err := i.write(ctx, arg)
+		if err != nil {
+			return result{}, err
+		}
+		params = append(params, uint64(ptr), uint64(len(arg)))
+	}
+
+	i.res = result{}
+	results, err := fn.Call(ctx, params...)
+	if err != nil {
+		return result{}, fmt.Errorf("module call failed: %v", err)
+	}
+	if len(results) != 1 {
+		return result{}, errors.New("module function must return a status")
+	}
+	res := i.res
+	res.status = int32(uint32(results[0]))
+	return res, nil
+}
+
+// transform passes the metadata and payload of a frame to the module.
+func (i *instance) transform(ctx context.Context, metadata, data []byte) (result, error) {
+	return i.call(ctx, i.transformFn, metadata, data)
+}
+
+// close releases the runtime of the instance.
+func (i *instance) close(ctx context.Context) {
+	i.runtime.Close(ctx)
+}
+
+// read returns a copy of a buffer of the module.
+func read(m api.Module, ptr, size uint32) []byte {
+	data, ok := m.Memory().Read(ptr, size)
+	if !ok {
+		panic(fmt.Sprintf("buffer %#x of %d bytes out of memory", ptr, size))
+	}
+	return append([]byte{}, data...)
+}
+
+// hostOutput implements output.
+func (i *instance) hostOutput(ctx context.Context, m api.Module, ptr, size uint32) {
+	i.res.output = read(m, ptr, size)
+}
+
+// hostSetMetadata implements set_metadata.
+func (i *instance) hostSetMetadata(ctx context.Context, m api.Module, ptr, size uint32) {
+	i.res.metadata = read(m, ptr, size)
+}
+
+// hostError implements error.
+func (i *instance) hostError(ctx context.Context, m api.Module, ptr, size uint32) {
+	i.res.message = string(read(m, ptr, size))
+}
+
+// hostLog implements log.
+func (i *instance) hostLog(ctx context.Context, m api.Module, ptr, size uint32) {
+	fmt.Fprintf(os.Stderr, "%s\n", read(m, ptr, size))
+}
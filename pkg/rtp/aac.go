@@ -0,0 +1,134 @@
+package rtp
+
+import (
+	"encoding/binary"
+
+	"github.com/relais/pkg/frames"
+)
+
+// AAC payload format (RFC 3640) in AAC-hbr mode: an AU-headers-length
+// field, then a 2-byte header per access unit holding its 13-bit size and
+// a 3-bit index, then the access units. Access units that don't fit in a
+// packet are fragmented, with the full size in the header of every
+// fragment.
+const aacAUHeaderSize = 2
+
+// aacMaxAUSize is the largest size an AU header can describe.
+const aacMaxAUSize = 1<<13 - 1
+
+// aacAccessUnits splits an AAC payload into raw access units, removing ADTS
+// headers if there are any.
+func aacAccessUnits(data []byte) [][]byte {
+	if !frames.IsADTS(data) {
+		return [][]byte{data}
+	}
+
+	var units [][]byte
+	for len(data) > 0 {
+		header, err := frames.ParseADTSHeader(data)
+		if err != nil || header.FrameLength > len(data) {
+			break
+		}
+		units = append(units, data[header.HeaderSize:header.FrameLength])
+		data = data[header.FrameLength:]
+	}
+	return units
+}
+
+// aacPayloader packetizes AAC access units, raw or in ADTS form.
+type aacPayloader struct{}
+
+// Payload implements rtp.Payloader.
+func (aacPayloader) Payload(mtu uint16, payload []byte) [][]byte {
+	units := aacAccessUnits(payload)
+
+	// Aggregate the access units if they all fit in one packet
+	size := 2
+	for _, unit := range units {
+		size += aacAUHeaderSize + len(unit)
+	}
+	if size <= int(mtu) {
+		packet := make([]byte, 2, size)
+		binary.BigEndian.PutUint16(packet, uint16(len(units)*aacAUHeaderSize*8))
+		for _, unit := range units {
+			packet = binary.BigEndian.AppendUint16(packet, uint16(len(unit))<<3)
+		}
+		for _, unit := range units {
+			packet = append(packet, unit...)
+		}
+		return [][]byte{packet}
+	}
+
+	// Otherwise send them one by one, in fragments if needed
+	var payloads [][]byte
+	room := int(mtu) - 2 - aacAUHeaderSize
+	for _, unit := range units {
+		if len(unit) > aacMaxAUSize || room <= 0 {
+			continue
+		}
+		header := uint16(len(unit)) << 3
+		for data := unit; len(data) > 0; {
+			n := room
+			if n > len(data) {
+				n = len(data)
+			}
+			packet := make([]byte, 0, 2+aacAUHeaderSize+n)
+			packet = binary.BigEndian.AppendUint16(packet, aacAUHeaderSize*8)
+			packet = binary.BigEndian.AppendUint16(packet, header)
+			packet = append(packet, data[:n]...)
+			payloads = append(payloads, packet)
+			data = data[n:]
+		}
+	}
+	return payloads
+}
+
+// aacDepacketizer reassembles AAC access units.
+type aacDepacketizer struct {
+	fragment []byte // Access unit being reassembled from fragments
+	size     int    // Size of the access unit being reassembled
+}
+
+// Unmarshal implements depacketizer. Access units are returned raw,
+// without ADTS headers.
+func (d *aacDepacketizer) Unmarshal(payload []byte) ([]byte, error) {
+	if len(payload) < 2 {
+		return nil, errPayload("short AAC packet")
+	}
+	headersSize := int(binary.BigEndian.Uint16(payload)+7) / 8
+	if headersSize%aacAUHeaderSize != 0 || headersSize == 0 || 2+headersSize > len(payload) {
+		return nil, errPayload("invalid AAC AU headers length")
+	}
+	headers := payload[2 : 2+headersSize]
+	data := payload[2+headersSize:]
+
+	// A single access unit larger than the data is a fragment
+	if len(headers) == aacAUHeaderSize {
+		size := int(binary.BigEndian.Uint16(headers) >> 3)
+		if size > len(data) || d.fragment != nil {
+			if d.fragment != nil && size != d.size {
+				d.fragment = nil
+				return nil, errPayload("AAC fragment of another access unit")
+			}
+			d.fragment = append(d.fragment, data...)
+			d.size = size
+			if len(d.fragment) < size {
+				return nil, nil
+			}
+			unit := d.fragment[:size]
+			d.fragment = nil
+			return unit, nil
+		}
+	}
+
+	units := make([]byte, 0, len(data))
+	for ; len(headers) > 0; headers = headers[aacAUHeaderSize:] {
+		size := int(binary.BigEndian.Uint16(headers) >> 3)
+		if size > len(data) {
+			return nil, errPayload("AAC access unit size %d exceeds payload", size)
+		}
+		units = append(units, data[:size]...)
+		data = data[size:]
+	}
+	return units, nil
+}
@@ -0,0 +1,63 @@
+package rtp
+
+import (
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/rtp/codecs/av1/frame"
+)
+
+// av1Depacketizer reassembles AV1 OBUs into a temporal unit in the low
+// overhead bitstream format.
+type av1Depacketizer struct {
+	assembler frame.AV1 // Reassembles OBUs fragmented across packets
+}
+
+// Unmarshal implements depacketizer.
+func (d *av1Depacketizer) Unmarshal(payload []byte) ([]byte, error) {
+	var packet codecs.AV1Packet
+	if _, err := packet.Unmarshal(payload); err != nil {
+		return nil, errPayload("%v", err)
+	}
+	obus, err := d.assembler.ReadFrames(&packet)
+	if err != nil {
+		return nil, errPayload("%v", err)
+	}
+
+	var data []byte
+	for _, o := range obus {
+		data = appendSizedOBU(data, o)
+	}
+	return data, nil
+}
+
+// IsPartitionHead reports whether a payload starts with a whole OBU rather
+// than the continuation of a fragmented one.
+func (d *av1Depacketizer) IsPartitionHead(payload []byte) bool {
+	return len(payload) > 0 && payload[0]&0x80 == 0 // Z bit
+}
+
+// appendSizedOBU appends an OBU to data with its size field set, as OBUs
+// sent over RTP usually omit it but the low overhead format requires it.
+func appendSizedOBU(data, o []byte) []byte {
+	if len(o) == 0 || o[0]&0x02 != 0 { // obu_has_size_field
+		return append(data, o...)
+	}
+
+	headerSize := 1
+	if o[0]&0x04 != 0 && len(o) > 1 { // obu_extension_flag
+		headerSize++
+	}
+
+	data = append(data, o[0]|0x02)
+	data = append(data, o[1:headerSize]...)
+	// The size is written as LEB128, 7 bits per byte, least significant first
+	for size := len(o) - headerSize; ; {
+		b := byte(size & 0x7f)
+		size >>= 7
+		if size == 0 {
+			data = append(data, b)
+			break
+		}
+		data = append(data, b|0x80)
+	}
+	return append(data, o[headerSize:]...)
+}
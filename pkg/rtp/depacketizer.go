@@ -0,0 +1,216 @@
+package rtp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/relais/pkg/frames"
+)
+
+// depacketizer extracts codec data from packet payloads. Data split across
+// packets is returned once complete, with nil returned for the packets
+// before.
+type depacketizer interface {
+	Unmarshal(payload []byte) ([]byte, error)
+}
+
+// newDepacketizer returns the depacketizer of a codec, or nil if RTP can't
+// carry the codec. H.264 and H.265 data is returned in Annex-B form, AV1 in
+// the low overhead bitstream format and AAC as raw access units.
+func newDepacketizer(codec frames.CodecType) depacketizer {
+	switch codec {
+	case frames.CodecH264:
+		return &codecs.H264Packet{}
+	case frames.CodecHEVC:
+		return &h265Depacketizer{}
+	case frames.CodecVP8:
+		return &codecs.VP8Packet{}
+	case frames.CodecVP9:
+		return &codecs.VP9Packet{}
+	case frames.CodecAV1:
+		return &av1Depacketizer{}
+	case frames.CodecOpus:
+		return &codecs.OpusPacket{}
+	case frames.CodecAAC:
+		return &aacDepacketizer{}
+	default:
+		return nil
+	}
+}
+
+// Depacketizer reassembles the frames of a track from the received RTP
+// packets of one stream. Packets are reordered in a JitterBuffer first, and
+// frames missing a packet are dropped.
+//
+// Video frames end with the packet carrying the marker bit, or when a
+// packet with another timestamp arrives. Audio frames end with every
+// complete payload.
+//
+// A Depacketizer is not safe for concurrent use.
+type Depacketizer struct {
+	codec        frames.CodecType // Codec of the frames
+	clockRate    uint32           // Clock rate of packet timestamps
+	jitter       *JitterBuffer    // Reorders packets
+	depacketizer depacketizer     // Extracts codec data from payloads
+	sequence     uint64           // Sequence number of the next frame
+
+	started   bool   // Whether a frame was started
+	timestamp uint32 // RTP timestamp of the last frame started
+	pts       int64  // Timestamp of the last frame started, extended to 64 bits
+
+	assembling bool   // Whether a frame is being assembled
+	broken     bool   // Whether the frame being assembled misses data
+	data       []byte // Data of the frame being assembled
+}
+
+// NewDepacketizer creates a depacketizer for frames of the given codec.
+// clockRate is the RTP clock rate, or 0 for DefaultClockRate, which AAC
+// doesn't have. bufferSize is the size of the jitter buffer, or 0 for
+// DefaultJitterBufferSize.
+//
+// Returns an error wrapping ErrUnsupportedCodec if RTP can't carry codec.
+func NewDepacketizer(codec frames.CodecType, clockRate uint32, bufferSize int) (*Depacketizer, error) {
+	clockRate, err := resolveClockRate(codec, clockRate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Depacketizer{
+		codec:        codec,
+		clockRate:    clockRate,
+		jitter:       NewJitterBuffer(bufferSize),
+		depacketizer: newDepacketizer(codec),
+	}, nil
+}
+
+// Push adds a received packet and returns the frames it completes, in
+// order. Frames have their media fields set, with PTS counted from the
+// first frame in units of the clock rate; SessionID and Index are left to
+// the caller.
+func (d *Depacketizer) Push(packet *rtp.Packet) []frames.Frame {
+	d.jitter.Push(packet)
+
+	var out []frames.Frame
+	for {
+		next, lost := d.jitter.Pop()
+		if next == nil {
+			return out
+		}
+		out = d.process(next, lost, out)
+	}
+}
+
+// Flush releases the buffered packets, skipping missing ones, and returns
+// the frames they complete, e.g. when the stream ends. A frame still
+// awaiting its last packet is dropped.
+func (d *Depacketizer) Flush() []frames.Frame {
+	var out []frames.Frame
+	for d.jitter.Len() > 0 {
+		packet, lost := d.jitter.pop(true)
+		out = d.process(packet, lost, out)
+	}
+	d.assembling, d.broken, d.data = false, false, nil
+	return out
+}
+
+// process assembles a packet released by the jitter buffer after lost
+// missing ones, appending the frames it completes to out.
+func (d *Depacketizer) process(packet *rtp.Packet, lost int, out []frames.Frame) []frames.Frame {
+	if lost > 0 {
+		// Reassembly state is stale, and the frame being assembled misses
+		// its end
+		d.depacketizer = newDepacketizer(d.codec)
+		d.broken = true
+	}
+	if d.assembling && packet.Timestamp != d.timestamp {
+		out = d.finish(out)
+	}
+	if !d.assembling {
+		d.start(packet.Timestamp)
+		// A frame not starting with its first packet misses its start
+		d.broken = !d.isFrameStart(packet.Payload, lost)
+	}
+
+	data, err := d.unmarshal(packet.Payload)
+	if err != nil {
+		d.broken = true
+	}
+	d.data = append(d.data, data...)
+
+	if (d.codec.IsAudio() && len(data) > 0) || (d.codec.IsVideo() && packet.Marker) {
+		out = d.finish(out)
+	}
+	return out
+}
+
+// unmarshal extracts the codec data of a payload. Payload parsers index
+// into payloads as their headers declare, and some panic on truncated
+// payloads rather than failing, so a panic is returned as an error and the
+// parser, left in an unknown state, is replaced.
+func (d *Depacketizer) unmarshal(payload []byte) (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.depacketizer = newDepacketizer(d.codec)
+			data, err = nil, fmt.Errorf("malformed %s payload: %v", d.codec, r)
+		}
+	}()
+	return d.depacketizer.Unmarshal(payload)
+}
+
+// isFrameStart reports whether a payload starts a frame. Without a way to
+// tell from the payload format, a payload is taken as a frame start unless
+// packets were lost before it. A payload too malformed to tell doesn't
+// start a frame.
+func (d *Depacketizer) isFrameStart(payload []byte, lost int) (start bool) {
+	defer func() {
+		if recover() != nil {
+			start = false
+		}
+	}()
+	if checker, ok := d.depacketizer.(interface{ IsPartitionHead([]byte) bool }); ok {
+		return checker.IsPartitionHead(payload)
+	}
+	return lost == 0
+}
+
+// start starts assembling a frame.
+func (d *Depacketizer) start(timestamp uint32) {
+	if d.started {
+		d.pts += int64(int32(timestamp - d.timestamp))
+	}
+	d.started = true
+	d.timestamp = timestamp
+	d.assembling = true
+	d.data = nil
+}
+
+// finish ends the frame being assembled and appends it to out unless it is
+// broken or empty.
+func (d *Depacketizer) finish(out []frames.Frame) []frames.Frame {
+	data, broken := d.data, d.broken
+	d.assembling, d.broken, d.data = false, false, nil
+	if broken || len(data) == 0 {
+		return out
+	}
+
+	mediaType := frames.MediaTypeVideo
+	if d.codec.IsAudio() {
+		mediaType = frames.MediaTypeAudio
+	}
+	frame := frames.Frame{
+		Data:      data,
+		Timestamp: time.Now(),
+		MediaType: mediaType,
+		Codec:     d.codec,
+		KeyFrame:  d.codec.IsKeyFrame(data),
+		Sequence:  d.sequence,
+		PTS:       d.pts,
+		DTS:       d.pts,
+		Timebase:  frames.Timebase{Num: 1, Den: d.clockRate},
+		SideData:  inlineSideData(d.codec, data),
+	}
+	d.sequence++
+	return append(out, frame)
+}
@@ -0,0 +1,118 @@
+package rtp
+
+import (
+	"encoding/binary"
+
+	"github.com/relais/pkg/frames"
+)
+
+// H.265 payload format (RFC 7798). NAL units that fit in a packet are sent
+// as they are, others are split into fragmentation units. Aggregation
+// packets are understood on receive but not sent.
+const (
+	h265NALAggregation   = 48 // Aggregation packet
+	h265NALFragmentation = 49 // Fragmentation unit
+)
+
+// h265Payloader packetizes Annex-B H.265 access units.
+type h265Payloader struct{}
+
+// Payload implements rtp.Payloader.
+func (h265Payloader) Payload(mtu uint16, payload []byte) [][]byte {
+	var payloads [][]byte
+	for _, nal := range frames.SplitAnnexB(payload) {
+		if len(nal) <= int(mtu) {
+			payloads = append(payloads, append([]byte{}, nal...))
+			continue
+		}
+		if len(nal) < 3 || mtu <= 3 {
+			continue
+		}
+
+		// The payload header is the NAL unit header with the FU type,
+		// followed by the FU header carrying start and end bits and the
+		// NAL unit type
+		header := [2]byte{nal[0]&0x81 | h265NALFragmentation<<1, nal[1]}
+		nalType := nal[0] >> 1 & 0x3f
+		data := nal[2:]
+		for start := true; len(data) > 0; start = false {
+			size := int(mtu) - 3
+			if size > len(data) {
+				size = len(data)
+			}
+			fu := nalType
+			if start {
+				fu |= 0x80
+			}
+			if size == len(data) {
+				fu |= 0x40
+			}
+
+			packet := make([]byte, 0, 3+size)
+			packet = append(packet, header[0], header[1], fu)
+			packet = append(packet, data[:size]...)
+			payloads = append(payloads, packet)
+			data = data[size:]
+		}
+	}
+	return payloads
+}
+
+// h265Depacketizer reassembles H.265 NAL units into Annex-B form.
+type h265Depacketizer struct {
+	fragment []byte // NAL unit being reassembled from fragmentation units
+}
+
+// Unmarshal implements depacketizer.
+func (d *h265Depacketizer) Unmarshal(payload []byte) ([]byte, error) {
+	if len(payload) < 2 {
+		return nil, errPayload("short H.265 packet")
+	}
+
+	switch frames.H265NALType(payload[0] >> 1 & 0x3f) {
+	case h265NALAggregation:
+		var nals [][]byte
+		for data := payload[2:]; len(data) > 0; {
+			if len(data) < 2 {
+				return nil, errPayload("truncated H.265 aggregation unit")
+			}
+			size := int(binary.BigEndian.Uint16(data))
+			if size > len(data)-2 {
+				return nil, errPayload("H.265 aggregation unit size %d exceeds payload", size)
+			}
+			nals = append(nals, data[2:2+size])
+			data = data[2+size:]
+		}
+		return frames.JoinAnnexB(nals), nil
+
+	case h265NALFragmentation:
+		if len(payload) < 3 {
+			return nil, errPayload("short H.265 fragmentation unit")
+		}
+		fu := payload[2]
+		if fu&0x80 != 0 {
+			// Rebuild the NAL unit header from the payload and FU headers
+			d.fragment = append(d.fragment[:0], payload[0]&0x81|(fu&0x3f)<<1, payload[1])
+		} else if d.fragment == nil {
+			return nil, errPayload("H.265 fragmentation unit without start")
+		}
+		d.fragment = append(d.fragment, payload[3:]...)
+		if fu&0x40 == 0 {
+			return nil, nil
+		}
+		nal := d.fragment
+		d.fragment = nil
+		return frames.JoinAnnexB([][]byte{nal}), nil
+
+	default:
+		return frames.JoinAnnexB([][]byte{payload}), nil
+	}
+}
+
+// IsPartitionHead reports whether a payload starts a NAL unit.
+func (d *h265Depacketizer) IsPartitionHead(payload []byte) bool {
+	if len(payload) < 3 || payload[0]>>1&0x3f != h265NALFragmentation {
+		return len(payload) >= 2
+	}
+	return payload[2]&0x80 != 0
+}
@@ -0,0 +1,108 @@
+package rtp
+
+import (
+	"github.com/pion/rtp"
+)
+
+// DefaultJitterBufferSize is the default number of packets a JitterBuffer
+// holds while waiting for a missing one.
+const DefaultJitterBufferSize = 128
+
+// JitterBuffer reorders received packets by sequence number. Packets are
+// released in order as soon as they are next in sequence. A missing packet
+// is waited for until the buffer holds size packets, then it is counted as
+// lost and skipped. Packets arriving after their turn and duplicates are
+// dropped, and a packet more than size packets late restarts the buffer.
+//
+// A JitterBuffer is not safe for concurrent use.
+type JitterBuffer struct {
+	size    int                    // Packets held before skipping a missing one
+	packets map[uint16]*rtp.Packet // Buffered packets by sequence number
+	next    uint16                 // Sequence number of the next packet to release
+	started bool                   // Whether a packet was pushed
+	popped  bool                   // Whether a packet was released
+}
+
+// NewJitterBuffer creates a jitter buffer holding up to size packets, or
+// DefaultJitterBufferSize if size is not positive.
+func NewJitterBuffer(size int) *JitterBuffer {
+	if size <= 0 {
+		size = DefaultJitterBufferSize
+	}
+	return &JitterBuffer{
+		size:    size,
+		packets: make(map[uint16]*rtp.Packet),
+	}
+}
+
+// Push adds a received packet to the buffer.
+func (b *JitterBuffer) Push(packet *rtp.Packet) {
+	seq := packet.SequenceNumber
+	switch {
+	case !b.started:
+		b.next, b.started = seq, true
+	case int16(seq-b.next) < 0:
+		// Until a packet is released, an earlier one starts the stream.
+		// Afterwards it is late, unless it is so far behind that the
+		// sender must have restarted the sequence.
+		if b.popped && -int(int16(seq-b.next)) <= b.size {
+			return
+		}
+		if b.popped {
+			b.packets = make(map[uint16]*rtp.Packet)
+		}
+		b.next = seq
+	}
+	if _, ok := b.packets[seq]; !ok {
+		b.packets[seq] = packet
+	}
+}
+
+// Pop releases the next packet in sequence order, or returns nil if it is
+// still awaited. lost is the number of missing packets skipped before it.
+func (b *JitterBuffer) Pop() (packet *rtp.Packet, lost int) {
+	return b.pop(len(b.packets) >= b.size)
+}
+
+// pop releases the next packet, skipping missing ones if skip is set.
+func (b *JitterBuffer) pop(skip bool) (*rtp.Packet, int) {
+	if packet, ok := b.packets[b.next]; ok {
+		return b.release(packet), 0
+	}
+	if !skip || len(b.packets) == 0 {
+		return nil, 0
+	}
+
+	// Give up on the missing packets
+	first := -1
+	for seq := range b.packets {
+		if d := int(uint16(seq - b.next)); first < 0 || d < first {
+			first = d
+		}
+	}
+	return b.release(b.packets[b.next+uint16(first)]), first
+}
+
+// release removes a packet from the buffer and moves past it.
+func (b *JitterBuffer) release(packet *rtp.Packet) *rtp.Packet {
+	delete(b.packets, packet.SequenceNumber)
+	b.next = packet.SequenceNumber + 1
+	b.popped = true
+	return packet
+}
+
+// Flush releases all buffered packets in sequence order, skipping the
+// missing ones, e.g. when the stream ends.
+func (b *JitterBuffer) Flush() []*rtp.Packet {
+	packets := make([]*rtp.Packet, 0, len(b.packets))
+	for len(b.packets) > 0 {
+		packet, _ := b.pop(true)
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+// Len returns the number of buffered packets.
+func (b *JitterBuffer) Len() int {
+	return len(b.packets)
+}
@@ -0,0 +1,218 @@
+package rtp
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/relais/pkg/frames"
+)
+
+// newPayloader returns the payloader of a codec, or nil if RTP can't carry
+// the codec.
+func newPayloader(codec frames.CodecType) rtp.Payloader {
+	switch codec {
+	case frames.CodecH264:
+		return &codecs.H264Payloader{}
+	case frames.CodecHEVC:
+		return h265Payloader{}
+	case frames.CodecVP8:
+		return &codecs.VP8Payloader{EnablePictureID: true}
+	case frames.CodecVP9:
+		return &codecs.VP9Payloader{}
+	case frames.CodecAV1:
+		return &codecs.AV1Payloader{}
+	case frames.CodecOpus:
+		return &codecs.OpusPayloader{}
+	case frames.CodecAAC:
+		return aacPayloader{}
+	default:
+		return nil
+	}
+}
+
+// Packetizer converts the frames of a track into the RTP packets of one
+// stream, with its own SSRC, sequence numbers and timestamps.
+//
+// A Packetizer is not safe for concurrent use.
+type Packetizer struct {
+	codec       frames.CodecType // Codec of the frames
+	payloader   rtp.Payloader    // Splits frames into packet payloads
+	sequencer   rtp.Sequencer    // Numbers packets
+	payloadType uint8            // Payload type of packets
+	ssrc        uint32           // SSRC of packets
+	clockRate   uint32           // Clock rate of packet timestamps
+	offset      uint32           // Random timestamp of the first frame
+	epoch       time.Time        // Capture time of the first frame without media timestamps
+}
+
+// NewPacketizer creates a packetizer for frames of the given codec.
+// clockRate is the RTP clock rate, or 0 for DefaultClockRate, which AAC
+// doesn't have. The SSRC, first sequence number and first timestamp are
+// random.
+//
+// Returns an error wrapping ErrUnsupportedCodec if RTP can't carry codec.
+func NewPacketizer(codec frames.CodecType, payloadType uint8, clockRate uint32) (*Packetizer, error) {
+	clockRate, err := resolveClockRate(codec, clockRate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Packetizer{
+		codec:       codec,
+		payloader:   newPayloader(codec),
+		sequencer:   rtp.NewRandomSequencer(),
+		payloadType: payloadType,
+		ssrc:        rand.Uint32(),
+		clockRate:   clockRate,
+		offset:      rand.Uint32(),
+	}, nil
+}
+
+// SSRC returns the SSRC of the packets.
+func (p *Packetizer) SSRC() uint32 {
+	return p.ssrc
+}
+
+// ClockRate returns the clock rate of the packet timestamps.
+func (p *Packetizer) ClockRate() uint32 {
+	return p.clockRate
+}
+
+// Packetize converts a frame into packets of at most DefaultMTU bytes. The
+// packets share the timestamp of the frame's presentation time and the last
+// one has the marker bit set.
+//
+// H.264 and H.265 key frames get the parameter sets from their side data if
+// they don't carry them inline, and AV1 key frames the sequence header.
+//
+// Frames without a codec are taken to be of the packetizer's codec.
+//
+// Returns an error if the frame is of another codec or can't be parsed.
+func (p *Packetizer) Packetize(frame frames.Frame) ([]*rtp.Packet, error) {
+	if frame.Codec == "" {
+		frame.Codec = p.codec
+	}
+	if frame.Codec != p.codec {
+		return nil, fmt.Errorf("frame codec %s doesn't match packetizer codec %s", frame.Codec, p.codec)
+	}
+	units, err := payloadUnits(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	var payloads [][]byte
+	for _, unit := range units {
+		payloads = append(payloads, p.payloader.Payload(DefaultMTU-headerSize, unit)...)
+	}
+
+	timestamp := p.timestamp(frame)
+	packets := make([]*rtp.Packet, len(payloads))
+	for i, payload := range payloads {
+		packets[i] = &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         i == len(payloads)-1,
+				PayloadType:    p.payloadType,
+				SequenceNumber: p.sequencer.NextSequenceNumber(),
+				Timestamp:      timestamp,
+				SSRC:           p.ssrc,
+			},
+			Payload: payload,
+		}
+	}
+	return packets, nil
+}
+
+// timestamp returns the RTP timestamp of a frame, from its presentation
+// time or, without media timestamps, its capture time.
+func (p *Packetizer) timestamp(frame frames.Frame) uint32 {
+	clock := frames.Timebase{Num: 1, Den: p.clockRate}
+	if frame.Timebase == clock {
+		return p.offset + uint32(frame.PTS)
+	}
+
+	var elapsed time.Duration
+	if !frame.Timebase.IsZero() {
+		elapsed = frame.PresentationTime()
+	} else {
+		if p.epoch.IsZero() {
+			p.epoch = frame.Timestamp
+		}
+		elapsed = frame.Timestamp.Sub(p.epoch)
+	}
+	return p.offset + uint32(clock.Timestamp(elapsed))
+}
+
+// parameterSetTypes are the side data types of H.264 and H.265 parameter
+// sets, in the order decoders expect them.
+var parameterSetTypes = []frames.SideDataType{frames.SideDataVPS, frames.SideDataSPS, frames.SideDataPPS}
+
+// payloadUnits returns the data of a frame as the units its payloader
+// expects.
+//
+// H.264 and H.265 are packetized from Annex-B, so length-prefixed payloads
+// are converted, and key frames get the parameter sets from their side data
+// if they don't carry them inline. AV1 is packetized one OBU at a time,
+// without temporal delimiters, and key frames get the sequence header from
+// their side data in the same way.
+func payloadUnits(frame frames.Frame) ([][]byte, error) {
+	switch frame.Codec {
+	case frames.CodecH264, frames.CodecHEVC:
+		nals, err := frames.SplitNALUnits(frame.Data)
+		if err != nil {
+			return nil, err
+		}
+		if frame.KeyFrame && inlineSideData(frame.Codec, frame.Data) == nil {
+			parameterSets := make([][]byte, 0, len(parameterSetTypes))
+			for _, t := range parameterSetTypes {
+				for _, sd := range frame.SideData {
+					if sd.Type == t {
+						parameterSets = append(parameterSets, sd.Data)
+					}
+				}
+			}
+			nals = append(parameterSets, nals...)
+		}
+		return [][]byte{frames.JoinAnnexB(nals)}, nil
+
+	case frames.CodecAV1:
+		obus, err := frames.SplitAV1OBUs(frame.Data)
+		if err != nil {
+			return nil, err
+		}
+		units := make([][]byte, 0, len(obus)+1)
+		if frame.KeyFrame && inlineSideData(frame.Codec, frame.Data) == nil {
+			if sequenceHeader := frame.FindSideData(frames.SideDataAV1SequenceHeader); sequenceHeader != nil {
+				units = append(units, sequenceHeader)
+			}
+		}
+		for _, obu := range obus {
+			if frames.AV1OBUTypeOf(obu) != frames.AV1OBUTemporalDelimiter {
+				units = append(units, obu)
+			}
+		}
+		return units, nil
+
+	default:
+		return [][]byte{frame.Data}, nil
+	}
+}
+
+// inlineSideData returns the codec configuration carried in the data of a
+// frame, as side data. Returns nil if there is none or the codec never
+// carries it inline.
+func inlineSideData(codec frames.CodecType, data []byte) []frames.SideData {
+	switch codec {
+	case frames.CodecH264:
+		return frames.H264ParameterSets(data)
+	case frames.CodecHEVC:
+		return frames.H265ParameterSets(data)
+	case frames.CodecAV1:
+		return frames.AV1SequenceHeader(data)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,66 @@
+// Package rtp converts media frames to RTP packets and back. It is the
+// shared transport layer of the RTP, RTSP and WebRTC plugins: a Packetizer
+// turns frames into packets following the payload format of their codec,
+// and a Depacketizer reorders received packets in a JitterBuffer and
+// reassembles them into frames.
+//
+// Packets are github.com/pion/rtp packets, and the payload formats pion
+// implements are reused. H.265 (RFC 7798) and AAC (RFC 3640) are
+// implemented here.
+package rtp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/relais/pkg/frames"
+)
+
+// Errors returned by packetizers and depacketizers. Errors carrying them
+// describe what was wrong and can be compared with errors.Is.
+var (
+	ErrUnsupportedCodec = errors.New("codec not supported over RTP") // The codec has no RTP payload format
+	ErrInvalidPayload   = errors.New("invalid RTP payload")          // A packet payload couldn't be parsed
+)
+
+// errPayload returns an ErrInvalidPayload error with details.
+func errPayload(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPayload, fmt.Sprintf(format, args...))
+}
+
+// DefaultMTU is the default maximum size of packets, headers included. It
+// leaves room for SRTP and tunnel overhead on common networks.
+const DefaultMTU = 1200
+
+// headerSize is the size of an RTP header without CSRCs or extensions.
+const headerSize = 12
+
+// DefaultClockRate returns the RTP clock rate of a codec: 90kHz for video
+// and 48kHz for Opus. AAC is clocked at its sample rate, so it has no
+// default and 0 is returned, as for codecs RTP can't carry.
+func DefaultClockRate(codec frames.CodecType) uint32 {
+	switch codec {
+	case frames.CodecH264, frames.CodecHEVC, frames.CodecVP8, frames.CodecVP9, frames.CodecAV1:
+		return 90000
+	case frames.CodecOpus:
+		return 48000
+	default:
+		return 0
+	}
+}
+
+// resolveClockRate returns clockRate, or the default of the codec if it is 0.
+//
+// Returns an error if the codec is not supported or has no default.
+func resolveClockRate(codec frames.CodecType, clockRate uint32) (uint32, error) {
+	if codec != frames.CodecAAC && DefaultClockRate(codec) == 0 {
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedCodec, codec)
+	}
+	if clockRate == 0 {
+		clockRate = DefaultClockRate(codec)
+	}
+	if clockRate == 0 {
+		return 0, fmt.Errorf("clock rate required for %s", codec)
+	}
+	return clockRate, nil
+}
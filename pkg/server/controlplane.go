@@ -2,7 +2,9 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/relais/pkg/storage"
 )
@@ -50,7 +52,7 @@ func (cp *ControlPlane) createSession(w http.ResponseWriter, r *http.Request) {
 
 	session, err := cp.sessionMgr.CreateSession(r.Context(), req.Type, req.Metadata)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -59,7 +61,32 @@ func (cp *ControlPlane) createSession(w http.ResponseWriter, r *http.Request) {
 
 func (cp *ControlPlane) handleSession(w http.ResponseWriter, r *http.Request) {
 	// Extract session ID from URL path
-	// Implementation details...
+	sessionID := strings.TrimPrefix(r.URL.Path, "/api/v1/sessions/")
+	if sessionID == "" {
+		http.Error(w, "Session ID required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		session, exists := cp.sessionMgr.GetSession(sessionID)
+		if !exists {
+			writeError(w, fmt.Errorf("session %s: %w", sessionID, storage.ErrSessionNotFound))
+			return
+		}
+		json.NewEncoder(w).Encode(session)
+	case http.MethodDelete:
+		// Forget the session only once its frames are gone, so that a
+		// failed delete can be retried
+		if err := cp.storage.DeleteSession(r.Context(), sessionID); err != nil {
+			writeError(w, err)
+			return
+		}
+		cp.sessionMgr.CleanupSession(r.Context(), sessionID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
 }
 
 func (cp *ControlPlane) handlePlugins(w http.ResponseWriter, r *http.Request) {
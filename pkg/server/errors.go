@@ -0,0 +1,39 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/relais/pkg/plugins"
+	"github.com/relais/pkg/storage"
+	"github.com/relais/pkg/util"
+)
+
+// statusCode maps an error to the HTTP status code returned by the API.
+// Sentinel errors of the storage and plugin packages get a specific code;
+// validation errors are the client's fault and anything else is reported
+// as an internal error.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrSessionNotFound),
+		errors.Is(err, storage.ErrFrameNotFound),
+		errors.Is(err, plugins.ErrPluginNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, plugins.ErrPluginAlreadyRegistered),
+		errors.Is(err, plugins.ErrPluginAlreadyRunning),
+		errors.Is(err, plugins.ErrPluginNotRunning):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrBackendUnavailable),
+		errors.Is(err, storage.ErrClosed):
+		return http.StatusServiceUnavailable
+	case util.IsErrorType(err, util.ErrorTypeValidation):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError writes err as a plain text response with its mapped status code.
+func writeError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), statusCode(err))
+}
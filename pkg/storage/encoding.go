@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/relais/pkg/frames"
+)
+
+// Binary frame envelope layout (all integers big-endian):
+//
+//	magic      [2]byte  "RF"
+//	version    uint8    frameEncodingVersion
+//	flags      uint8    flagKeyFrame | flagZeroTime
+//	index      int64
+//	timestamp  int64    Unix nanoseconds
+//	track      uint32
+//	sequence   uint64
+//	pts        int64
+//	dts        int64
+//	duration   int64
+//	timebase   uint32 numerator, uint32 denominator
+//	session    uint16 length + bytes
+//	media type uint8 length + bytes
+//	codec      uint8 length + bytes
+//	side data  uint8 count, then per entry uint8 type, uint16 length + bytes
+//	data       remaining bytes
+//
+// The payload is stored raw at the end of the envelope, avoiding the base64
+// expansion and encoding cost of JSON for large video frames.
+//
+// Version 1 envelopes lack the fields from track to timebase and the side
+// data; they are still decoded, with those fields left zero.
+const (
+	frameEncodingVersion = 2
+
+	flagKeyFrame = 1 << 0 // Frame.KeyFrame is set
+	flagZeroTime = 1 << 1 // Frame.Timestamp is the zero time
+)
+
+var frameMagic = [2]byte{'R', 'F'}
+
+// frameHeaderSize is the size of the fixed part of a version 1 envelope.
+const frameHeaderSize = 2 + 1 + 1 + 8 + 8
+
+// frameTimingSize is the size of the fixed fields added by version 2.
+const frameTimingSize = 4 + 8 + 8 + 8 + 8 + 4 + 4
+
+// EncodeFrame serializes a frame into the compact binary envelope used by
+// the Redis backends.
+//
+// Returns an error if a variable-length field is too long for the envelope.
+func EncodeFrame(frame Frame) ([]byte, error) {
+	if err := checkEncodable(frame); err != nil {
+		return nil, err
+	}
+
+	size := frameHeaderSize + frameTimingSize + 2 + len(frame.SessionID) + 1 + len(frame.MediaType) + 1 + len(frame.Codec) + 1 + len(frame.Data)
+	for _, sd := range frame.SideData {
+		size += 1 + 2 + len(sd.Data)
+	}
+	buf := make([]byte, 0, size)
+
+	var flags byte
+	if frame.KeyFrame {
+		flags |= flagKeyFrame
+	}
+	var timestamp int64
+	if frame.Timestamp.IsZero() {
+		flags |= flagZeroTime
+	} else {
+		timestamp = frame.Timestamp.UnixNano()
+	}
+
+	buf = append(buf, frameMagic[0], frameMagic[1], frameEncodingVersion, flags)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(frame.Index))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(timestamp))
+	buf = binary.BigEndian.AppendUint32(buf, frame.TrackID)
+	buf = binary.BigEndian.AppendUint64(buf, frame.Sequence)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(frame.PTS))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(frame.DTS))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(frame.Duration))
+	buf = binary.BigEndian.AppendUint32(buf, frame.Timebase.Num)
+	buf = binary.BigEndian.AppendUint32(buf, frame.Timebase.Den)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(frame.SessionID)))
+	buf = append(buf, frame.SessionID...)
+	buf = append(buf, byte(len(frame.MediaType)))
+	buf = append(buf, frame.MediaType...)
+	buf = append(buf, byte(len(frame.Codec)))
+	buf = append(buf, frame.Codec...)
+	buf = append(buf, byte(len(frame.SideData)))
+	for _, sd := range frame.SideData {
+		buf = append(buf, byte(sd.Type))
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(sd.Data)))
+		buf = append(buf, sd.Data...)
+	}
+	buf = append(buf, frame.Data...)
+
+	return buf, nil
+}
+
+// checkEncodable checks that the variable-length fields of a frame fit the
+// envelope.
+func checkEncodable(frame Frame) error {
+	if len(frame.SessionID) > 0xFFFF {
+		return fmt.Errorf("session ID too long: %d bytes", len(frame.SessionID))
+	}
+	if len(frame.MediaType) > 0xFF || len(frame.Codec) > 0xFF {
+		return fmt.Errorf("media type or codec too long")
+	}
+	if len(frame.SideData) > 0xFF {
+		return fmt.Errorf("too many side data entries: %d", len(frame.SideData))
+	}
+	for _, sd := range frame.SideData {
+		if len(sd.Data) > 0xFFFF {
+			return fmt.Errorf("side data too long: %d bytes", len(sd.Data))
+		}
+	}
+	return nil
+}
+
+// errTruncated is returned when an envelope ends before its last field.
+var errTruncated = errors.New("failed to decode frame: truncated")
+
+// DecodeFrame deserializes a frame produced by EncodeFrame. Frames stored as
+// JSON by earlier versions are recognized by their leading '{' and decoded as
+// JSON, so existing data stays readable.
+//
+// The Data and SideData of a frame decoded from a binary envelope alias data
+// instead of copying it, which keeps decoding large frames cheap. Callers
+// must not modify or reuse data while the frame is in use; the backends pass
+// a buffer read for the frame alone.
+//
+// Returns an error if the data is truncated, uses an unknown envelope version
+// or is neither a binary envelope nor JSON.
+func DecodeFrame(data []byte) (Frame, error) {
+	if len(data) > 0 && data[0] == '{' {
+		var frame Frame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			return Frame{}, fmt.Errorf("failed to unmarshal frame: %v", err)
+		}
+		return frame, nil
+	}
+
+	if len(data) < frameHeaderSize || data[0] != frameMagic[0] || data[1] != frameMagic[1] {
+		return Frame{}, errors.New("failed to decode frame: unknown format")
+	}
+	version := data[2]
+	if version != 1 && version != frameEncodingVersion {
+		return Frame{}, fmt.Errorf("failed to decode frame: unsupported version %d", version)
+	}
+
+	flags := data[3]
+	frame := Frame{
+		Index:    int64(binary.BigEndian.Uint64(data[4:12])),
+		KeyFrame: flags&flagKeyFrame != 0,
+	}
+	if flags&flagZeroTime == 0 {
+		frame.Timestamp = time.Unix(0, int64(binary.BigEndian.Uint64(data[12:20])))
+	}
+
+	rest := data[frameHeaderSize:]
+	if version >= 2 {
+		if len(rest) < frameTimingSize {
+			return Frame{}, errTruncated
+		}
+		frame.TrackID = binary.BigEndian.Uint32(rest[0:4])
+		frame.Sequence = binary.BigEndian.Uint64(rest[4:12])
+		frame.PTS = int64(binary.BigEndian.Uint64(rest[12:20]))
+		frame.DTS = int64(binary.BigEndian.Uint64(rest[20:28]))
+		frame.Duration = int64(binary.BigEndian.Uint64(rest[28:36]))
+		frame.Timebase.Num = binary.BigEndian.Uint32(rest[36:40])
+		frame.Timebase.Den = binary.BigEndian.Uint32(rest[40:44])
+		rest = rest[frameTimingSize:]
+	}
+
+	if len(rest) < 2 {
+		return Frame{}, errTruncated
+	}
+	n := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+
+	var ok bool
+	if frame.SessionID, rest, ok = readString(rest, n); !ok {
+		return Frame{}, errTruncated
+	}
+	if len(rest) < 1 {
+		return Frame{}, errTruncated
+	}
+	if frame.MediaType, rest, ok = readString(rest[1:], int(rest[0])); !ok {
+		return Frame{}, errTruncated
+	}
+	if len(rest) < 1 {
+		return Frame{}, errTruncated
+	}
+	var codec string
+	if codec, rest, ok = readString(rest[1:], int(rest[0])); !ok {
+		return Frame{}, errTruncated
+	}
+	frame.Codec = frames.CodecType(codec)
+
+	if version >= 2 {
+		if len(rest) < 1 {
+			return Frame{}, errTruncated
+		}
+		count := int(rest[0])
+		rest = rest[1:]
+		for i := 0; i < count; i++ {
+			if len(rest) < 3 {
+				return Frame{}, errTruncated
+			}
+			sd := frames.SideData{Type: frames.SideDataType(rest[0])}
+			n := int(binary.BigEndian.Uint16(rest[1:3]))
+			if len(rest) < 3+n {
+				return Frame{}, errTruncated
+			}
+			sd.Data = rest[3 : 3+n]
+			frame.SideData = append(frame.SideData, sd)
+			rest = rest[3+n:]
+		}
+	}
+
+	if len(rest) > 0 {
+		frame.Data = rest
+	}
+	return frame, nil
+}
+
+// readString reads an n byte string from the front of buf.
+func readString(buf []byte, n int) (string, []byte, bool) {
+	if len(buf) < n {
+		return "", nil, false
+	}
+	return string(buf[:n]), buf[n:], true
+}
+
+// metadataPrefixSize is the number of envelope bytes read to decode the
+// metadata of a frame without its data. Envelopes whose fields before the
+// data are longer, e.g. because of large side data, are read in full.
+const metadataPrefixSize = 1024
+
+// encodeMetadata serializes the metadata of a frame for the Redis backends,
+// which store it next to the frame: the data size as uint32 followed by the
+// envelope of the frame without data and side data.
+//
+// Returns an error if a variable-length field is too long for the envelope.
+func encodeMetadata(frame Frame) ([]byte, error) {
+	size := uint32(len(frame.Data))
+	frame.Data = nil
+	frame.SideData = nil
+	envelope, err := EncodeFrame(frame)
+	if err != nil {
+		return nil, err
+	}
+	return append(binary.BigEndian.AppendUint32(nil, size), envelope...), nil
+}
+
+// decodeMetadata deserializes metadata produced by encodeMetadata.
+func decodeMetadata(data []byte) (FrameMetadata, error) {
+	if len(data) < 4 {
+		return FrameMetadata{}, errTruncated
+	}
+	return decodeMetadataPrefix(data[4:], int64(binary.BigEndian.Uint32(data)))
+}
+
+// decodeMetadataPrefix decodes the metadata of a frame from the start of its
+// envelope. The prefix must extend at least to the frame data; the size of
+// the data is passed separately since it is not part of the prefix.
+//
+// Returns errTruncated if the prefix ends before the frame data.
+func decodeMetadataPrefix(prefix []byte, size int64) (FrameMetadata, error) {
+	frame, err := DecodeFrame(prefix)
+	if err != nil {
+		return FrameMetadata{}, err
+	}
+	metadata := frame.Metadata()
+	metadata.Size = int(size)
+	return metadata, nil
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/relais/pkg/util"
+)
+
+// Sentinel errors returned by storage backends. Backends wrap them in a
+// util.Error of type util.ErrorTypeStorage that carries the details, so they
+// must be compared with errors.Is:
+//
+//	if errors.Is(err, storage.ErrSessionNotFound) {
+//		// the session was never written or has been deleted
+//	}
+var (
+	ErrSessionNotFound    = errors.New("session not found")           // The session doesn't exist
+	ErrFrameNotFound      = errors.New("frame not found")             // The session exists but not the frame
+	ErrBackendUnavailable = errors.New("storage backend unavailable") // The backend couldn't be reached
+	ErrClosed             = errors.New("storage closed")              // The storage was closed
+)
+
+// errSessionNotFound returns an ErrSessionNotFound error for a session.
+func errSessionNotFound(sessionID string) error {
+	return util.NewError(util.ErrorTypeStorage, fmt.Sprintf("session %s", sessionID), ErrSessionNotFound)
+}
+
+// errFrameNotFound returns an ErrFrameNotFound error for a frame.
+func errFrameNotFound(sessionID string, frameIndex int64) error {
+	return util.NewError(util.ErrorTypeStorage, fmt.Sprintf("session %s, index %d", sessionID, frameIndex), ErrFrameNotFound)
+}
+
+// errNoKeyFrame returns an ErrFrameNotFound error for a session without key
+// frames.
+func errNoKeyFrame(sessionID string) error {
+	return util.NewError(util.ErrorTypeStorage, fmt.Sprintf("session %s, no key frame", sessionID), ErrFrameNotFound)
+}
+
+// errUnavailable returns an ErrBackendUnavailable error for a failed backend
+// call. The backend's error stays available to errors.Is and errors.As.
+func errUnavailable(message string, err error) error {
+	return util.NewError(util.ErrorTypeStorage, message, fmt.Errorf("%w: %w", ErrBackendUnavailable, err))
+}
+
+// errClosed returns an ErrClosed error for an operation on a closed storage.
+func errClosed(operation string) error {
+	return util.NewError(util.ErrorTypeStorage, operation, ErrClosed)
+}
+
+// isClosed reports whether done has been closed.
+func isClosed(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,1131 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// On-disk layout of FileStorage:
+//
+//	<dir>/retention.json                       per-session retention policies
+//	<dir>/sessions/<session>/<seq>.seg         append-only segment of records
+//	<dir>/sessions/<session>/<seq>.idx         index of the records in the segment
+//	<dir>/sessions/<session>/checkpoints.json  consumer checkpoints
+//
+// A segment record is a header followed by its payload (integers big-endian):
+//
+//	length  uint32  payload length
+//	crc     uint32  CRC-32 (Castagnoli) of the payload
+//	type    uint8   recordFrame or recordDelete
+//	payload         EncodeFrame envelope, or the evicted frame index as int64
+//
+// An index entry is fixed-size and points at one record:
+//
+//	type       uint8  record type, with indexKeyFrame set for key frames
+//	index      int64
+//	timestamp  int64  Unix nanoseconds, math.MinInt64 for the zero time
+//	offset     int64  offset of the record in the segment
+//	length     uint32 payload length
+//	size       uint32 size of the frame Data
+//
+// The segment is the source of truth: records are written before their index
+// entry, and an index that lags behind its segment is completed on startup by
+// scanning the segment from the last indexed record.
+const (
+	recordFrame  = 1 // Record holds a frame
+	recordDelete = 2 // Record marks a frame as evicted
+
+	indexKeyFrame = 0x80 // Index entry type flag marking a key frame
+
+	recordHeaderSize = 4 + 4 + 1
+	indexEntrySize   = 1 + 8 + 8 + 8 + 4 + 4
+
+	segmentExt = ".seg"
+	indexExt   = ".idx"
+
+	sessionsDir    = "sessions"
+	retentionFile  = "retention.json"
+	checkpointFile = "checkpoints.json"
+
+	// filePageSize is the number of frames a subscription reads at once.
+	filePageSize = 64
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// FileConfig holds the configuration of a FileStorage.
+type FileConfig struct {
+	Dir             string          // Directory holding all session data, created if missing
+	MaxSegmentBytes int64           // Size after which a new segment is started, default 64 MiB
+	MaxSegmentAge   time.Duration   // Age after which a new segment is started, 0 disables
+	Sync            bool            // Whether every write is flushed to disk with fsync
+	Retention       RetentionPolicy // Default retention policy for all sessions
+}
+
+// defaultMaxSegmentBytes is the segment size used when none is configured.
+const defaultMaxSegmentBytes = 64 << 20
+
+// FileStorage implements the Storage interface on the local filesystem.
+// It is meant for single-node deployments and edge devices where Redis isn't
+// available but recordings must survive a restart.
+//
+// Frames are appended to per-session segment files. Each segment has an index
+// file next to it, and the indexes of all sessions are loaded into memory on
+// startup, so reads go straight to the right offset on disk. A segment is
+// rotated once it reaches MaxSegmentBytes or MaxSegmentAge, and removed once
+// retention has evicted every frame it holds. Overwriting a frame appends a new
+// record; the space of the old one is reclaimed with its segment.
+//
+// Crash Recovery:
+// On startup, index entries pointing past the end of their segment are
+// dropped, records missing from the index are re-indexed, and a torn record at
+// the tail of a segment is truncated. Without Sync, frames written just before
+// a crash may be lost, but the storage always reopens in a consistent state.
+//
+// A directory must not be opened by more than one FileStorage at a time.
+type FileStorage struct {
+	mu        sync.RWMutex                          // Protects all fields below
+	config    FileConfig                            // Storage configuration
+	sessions  map[string]*fileSession               // Maps session ID to its on-disk state
+	retention map[string]RetentionPolicy            // Maps session ID to its own retention policy
+	defaults  RetentionPolicy                       // Retention policy for sessions without their own
+	watchers  map[string]map[chan struct{}]struct{} // Maps session ID to subscriptions waiting for frames
+	done      chan struct{}                         // Closed by Close to end all subscriptions
+	closeOnce sync.Once                             // Guards closing of done
+}
+
+// fileSession is the in-memory state of a session stored on disk.
+type fileSession struct {
+	dir       string                 // Directory holding the session's segments
+	segments  []*fileSegment         // Segments in ascending sequence order, the last one is active
+	frames    map[int64]fileLocation // Maps frame index to the record holding the frame
+	indexes   []int64                // Frame indexes in ascending order
+	keyFrames []int64                // Key frame indexes in ascending order
+	size      int64                  // Total size of frame data
+	newest    time.Time              // Newest frame timestamp seen
+
+	checkpoints map[string]int64 // Maps consumer to its checkpoint
+}
+
+// fileSegment is an open segment file and its index.
+type fileSegment struct {
+	seq     int64     // Sequence number, also the file name
+	data    *os.File  // Segment records
+	index   *os.File  // Index entries for the records
+	size    int64     // Size of the segment file
+	entries int64     // Number of index entries
+	live    int       // Number of frames in the segment not overwritten or evicted
+	created time.Time // When the segment was opened for writing
+}
+
+// fileLocation locates a frame record on disk.
+type fileLocation struct {
+	segment   *fileSegment
+	offset    int64
+	length    uint32
+	timestamp time.Time
+	size      int64
+}
+
+// indexEntry is the decoded form of an index entry.
+type indexEntry struct {
+	typ       byte
+	index     int64
+	timestamp time.Time
+	offset    int64
+	length    uint32
+	size      uint32
+	keyFrame  bool
+}
+
+// NewFileStorage opens a FileStorage in the configured directory, recovering
+// all sessions stored there.
+//
+// Returns an error if the directory cannot be created or a segment cannot be
+// opened or repaired.
+func NewFileStorage(config FileConfig) (*FileStorage, error) {
+	if config.Dir == "" {
+		return nil, errors.New("storage directory not set")
+	}
+	if config.MaxSegmentBytes <= 0 {
+		config.MaxSegmentBytes = defaultMaxSegmentBytes
+	}
+
+	if err := os.MkdirAll(filepath.Join(config.Dir, sessionsDir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %v", err)
+	}
+
+	s := &FileStorage{
+		config:    config,
+		sessions:  make(map[string]*fileSession),
+		retention: make(map[string]RetentionPolicy),
+		defaults:  config.Retention,
+		watchers:  make(map[string]map[chan struct{}]struct{}),
+		done:      make(chan struct{}),
+	}
+
+	if err := s.loadRetention(); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(config.Dir, sessionsDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage directory: %v", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		sessionID, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			continue
+		}
+		session, err := s.loadSession(filepath.Join(config.Dir, sessionsDir, entry.Name()))
+		if err != nil {
+			s.closeFiles()
+			return nil, fmt.Errorf("failed to recover session %s: %v", sessionID, err)
+		}
+		s.sessions[sessionID] = session
+	}
+
+	return s, nil
+}
+
+// PutFrame appends a frame to the active segment of its session, rotating the
+// segment first if it is full or too old. The session's retention policy is
+// then enforced and subscribers are woken up.
+//
+// Returns an error if the session ID is empty or the frame cannot be written.
+func (s *FileStorage) PutFrame(_ context.Context, frame Frame) error {
+	if isClosed(s.done) {
+		return errClosed("put frame")
+	}
+
+	if frame.SessionID == "" {
+		return errors.New("session ID must not be empty")
+	}
+
+	payload, err := EncodeFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[frame.SessionID]
+	if !exists {
+		dir := filepath.Join(s.config.Dir, sessionsDir, escapeSessionID(frame.SessionID))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create session directory: %v", err)
+		}
+		session = &fileSession{dir: dir, frames: make(map[int64]fileLocation)}
+		s.sessions[frame.SessionID] = session
+	}
+
+	entry := indexEntry{
+		typ:       recordFrame,
+		index:     frame.Index,
+		timestamp: frame.Timestamp,
+		size:      uint32(len(frame.Data)),
+		keyFrame:  frame.KeyFrame,
+	}
+	segment, err := s.appendLocked(session, &entry, payload)
+	if err != nil {
+		return err
+	}
+	session.apply(segment, entry)
+
+	if err := s.enforceRetentionLocked(frame.SessionID, session); err != nil {
+		return err
+	}
+
+	// Wake up subscribers
+	for notify := range s.watchers[frame.SessionID] {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// GetFrame reads a single frame from disk.
+// Returns an error if the session or frame doesn't exist, or the record is
+// damaged.
+func (s *FileStorage) GetFrame(_ context.Context, sessionID string, frameIndex int64) (Frame, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return Frame{}, errSessionNotFound(sessionID)
+	}
+
+	location, exists := session.frames[frameIndex]
+	if !exists {
+		return Frame{}, errFrameNotFound(sessionID, frameIndex)
+	}
+
+	return readFrame(location)
+}
+
+// ListFrames returns all frames of a session, sorted by frame index.
+// Returns an error if the session doesn't exist.
+func (s *FileStorage) ListFrames(ctx context.Context, sessionID string) ([]Frame, error) {
+	return s.ListFramesRange(ctx, sessionID, math.MinInt64, math.MaxInt64)
+}
+
+// ListFramesRange returns the frames of a session with an index between
+// fromIndex and toIndex (both inclusive), sorted by frame index. The range is
+// located in the in-memory index, so only the frames in range are read.
+//
+// Returns an error if the session doesn't exist.
+func (s *FileStorage) ListFramesRange(_ context.Context, sessionID string, fromIndex, toIndex int64) ([]Frame, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return nil, errSessionNotFound(sessionID)
+	}
+
+	indexes := session.indexes
+	start := sort.Search(len(indexes), func(i int) bool { return indexes[i] >= fromIndex })
+	end := sort.Search(len(indexes), func(i int) bool { return indexes[i] > toIndex })
+	if end < start {
+		end = start
+	}
+
+	return session.read(indexes[start:end])
+}
+
+// ListFrameMetadata returns the metadata of the frames of a session with an
+// index between fromIndex and toIndex (both inclusive), sorted by frame index.
+// Only the start of each frame record is read from disk, not the frame data.
+//
+// Returns an error if the session doesn't exist or a record cannot be read.
+func (s *FileStorage) ListFrameMetadata(_ context.Context, sessionID string, fromIndex, toIndex int64) ([]FrameMetadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return nil, errSessionNotFound(sessionID)
+	}
+
+	indexes := session.indexes
+	start := sort.Search(len(indexes), func(i int) bool { return indexes[i] >= fromIndex })
+	end := sort.Search(len(indexes), func(i int) bool { return indexes[i] > toIndex })
+	if end < start {
+		end = start
+	}
+
+	metadata := make([]FrameMetadata, 0, end-start)
+	for _, index := range indexes[start:end] {
+		m, err := readMetadata(session.frames[index])
+		if err != nil {
+			return nil, err
+		}
+		metadata = append(metadata, m)
+	}
+	return metadata, nil
+}
+
+// LatestKeyFrame reads the key frame of a session with the highest index from
+// disk. Key frames are flagged in the segment indexes and tracked in memory,
+// so only the frame itself is read.
+//
+// Returns an error if the session doesn't exist, holds no key frame, or the
+// record is damaged.
+func (s *FileStorage) LatestKeyFrame(_ context.Context, sessionID string) (Frame, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return Frame{}, errSessionNotFound(sessionID)
+	}
+
+	if len(session.keyFrames) == 0 {
+		return Frame{}, errNoKeyFrame(sessionID)
+	}
+	return readFrame(session.frames[session.keyFrames[len(session.keyFrames)-1]])
+}
+
+// ListFramesPage returns up to limit frames of a session starting at the
+// frame index given by cursor.
+//
+// Returns an error if the session doesn't exist or limit is not positive.
+func (s *FileStorage) ListFramesPage(_ context.Context, sessionID string, cursor int64, limit int) (FramePage, error) {
+	if limit <= 0 {
+		return FramePage{}, fmt.Errorf("invalid page limit: %d", limit)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return FramePage{}, errSessionNotFound(sessionID)
+	}
+
+	indexes := session.indexes
+	start := sort.Search(len(indexes), func(i int) bool { return indexes[i] >= cursor })
+	end := start + limit
+	if end > len(indexes) {
+		end = len(indexes)
+	}
+
+	frames, err := session.read(indexes[start:end])
+	if err != nil {
+		return FramePage{}, err
+	}
+
+	page := FramePage{
+		Frames:     frames,
+		NextCursor: cursor,
+		HasMore:    end < len(indexes),
+	}
+	if len(frames) > 0 {
+		page.NextCursor = frames[len(frames)-1].Index + 1
+	}
+	return page, nil
+}
+
+// ListFramesByTime returns the frames of a session whose Timestamp lies
+// between start and end (both inclusive), sorted by frame index. Timestamps
+// are kept in the in-memory index, so only matching frames are read.
+//
+// Returns an error if the session doesn't exist.
+func (s *FileStorage) ListFramesByTime(_ context.Context, sessionID string, start, end time.Time) ([]Frame, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return nil, errSessionNotFound(sessionID)
+	}
+
+	matches := make([]int64, 0)
+	for _, index := range session.indexes {
+		timestamp := session.frames[index].timestamp
+		if timestamp.Before(start) || timestamp.After(end) {
+			continue
+		}
+		matches = append(matches, index)
+	}
+
+	return session.read(matches)
+}
+
+// Subscribe delivers frames of a session as they are stored. The backlog is
+// read from disk page by page, so subscribing to a long recording doesn't
+// load it into memory at once. Frames are delivered in index order; a frame
+// written below the index already delivered is skipped.
+//
+// The returned channel is closed when ctx is cancelled or Close is called.
+func (s *FileStorage) Subscribe(ctx context.Context, sessionID string, fromIndex int64) (<-chan Frame, error) {
+	if isClosed(s.done) {
+		return nil, errClosed("subscribe")
+	}
+
+	notify := make(chan struct{}, 1)
+
+	s.mu.Lock()
+	if s.watchers[sessionID] == nil {
+		s.watchers[sessionID] = make(map[chan struct{}]struct{})
+	}
+	s.watchers[sessionID][notify] = struct{}{}
+	s.mu.Unlock()
+
+	page := func(ctx context.Context, cursor int64) (FramePage, error) {
+		return s.ListFramesPage(ctx, sessionID, cursor, filePageSize)
+	}
+	unwatch := func() {
+		s.mu.Lock()
+		delete(s.watchers[sessionID], notify)
+		if len(s.watchers[sessionID]) == 0 {
+			delete(s.watchers, sessionID)
+		}
+		s.mu.Unlock()
+	}
+
+	return follow(ctx, s.done, notify, fromIndex, page, unwatch), nil
+}
+
+// ListSessions returns the IDs of all stored sessions, sorted alphabetically.
+func (s *FileStorage) ListSessions(_ context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := make([]string, 0, len(s.sessions))
+	for sessionID := range s.sessions {
+		sessions = append(sessions, sessionID)
+	}
+	sort.Strings(sessions)
+
+	return sessions, nil
+}
+
+// DeleteSession closes the segments of a session and removes its directory.
+// Returns an error if the session doesn't exist or its files cannot be removed.
+func (s *FileStorage) DeleteSession(_ context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return errSessionNotFound(sessionID)
+	}
+
+	for _, segment := range session.segments {
+		segment.close()
+	}
+	delete(s.sessions, sessionID)
+
+	if err := os.RemoveAll(session.dir); err != nil {
+		return fmt.Errorf("failed to remove session %s: %v", sessionID, err)
+	}
+
+	if _, exists := s.retention[sessionID]; exists {
+		delete(s.retention, sessionID)
+		return s.saveRetentionLocked()
+	}
+	return nil
+}
+
+// SetRetention sets the retention policy of a session, or the default policy
+// if sessionID is empty. Session policies are saved to disk; the default
+// policy comes from the configuration and is not persisted.
+//
+// Returns an error if the policies cannot be saved.
+func (s *FileStorage) SetRetention(_ context.Context, sessionID string, policy RetentionPolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sessionID == "" {
+		s.defaults = policy
+		return nil
+	}
+
+	s.retention[sessionID] = policy
+	return s.saveRetentionLocked()
+}
+
+// SetCheckpoint records the index of the last frame of a session that a
+// consumer has processed. The checkpoints of a session are saved to disk
+// next to its segments and removed together with the session.
+//
+// Returns an error if the session doesn't exist or the checkpoints cannot
+// be saved.
+func (s *FileStorage) SetCheckpoint(_ context.Context, consumer, sessionID string, index int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return errSessionNotFound(sessionID)
+	}
+
+	if session.checkpoints == nil {
+		session.checkpoints = make(map[string]int64)
+	}
+	session.checkpoints[consumer] = index
+	return session.saveCheckpoints()
+}
+
+// GetCheckpoint returns the checkpoint a consumer recorded for a session.
+// Returns an error if the session doesn't exist.
+func (s *FileStorage) GetCheckpoint(_ context.Context, consumer, sessionID string) (int64, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return 0, false, errSessionNotFound(sessionID)
+	}
+
+	index, exists := session.checkpoints[consumer]
+	return index, exists, nil
+}
+
+// Close ends all active subscriptions and closes every open segment.
+// Stored frames are left on disk.
+//
+// Returns an error if a segment cannot be closed cleanly.
+func (s *FileStorage) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		err = s.closeFiles()
+	})
+	return err
+}
+
+// closeFiles closes all open segments.
+func (s *FileStorage) closeFiles() error {
+	var firstErr error
+	for _, session := range s.sessions {
+		for _, segment := range session.segments {
+			if err := segment.close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// appendLocked writes a record and its index entry to the active segment of a
+// session. The entry's offset and length are filled in. The caller must hold
+// s.mu for writing.
+func (s *FileStorage) appendLocked(session *fileSession, entry *indexEntry, payload []byte) (*fileSegment, error) {
+	segment, err := s.activeSegmentLocked(session)
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	record[8] = entry.typ
+	record = append(record, payload...)
+
+	if _, err := segment.data.WriteAt(record, segment.size); err != nil {
+		return nil, fmt.Errorf("failed to write segment: %v", err)
+	}
+
+	// The index entry always follows the record, so a crash can only leave
+	// records without an index entry, never the reverse
+	entry.offset = segment.size
+	entry.length = uint32(len(payload))
+	if _, err := segment.index.WriteAt(encodeIndexEntry(*entry), segment.entries*indexEntrySize); err != nil {
+		return nil, fmt.Errorf("failed to write segment index: %v", err)
+	}
+
+	if s.config.Sync {
+		if err := segment.data.Sync(); err != nil {
+			return nil, fmt.Errorf("failed to sync segment: %v", err)
+		}
+		if err := segment.index.Sync(); err != nil {
+			return nil, fmt.Errorf("failed to sync segment index: %v", err)
+		}
+	}
+
+	segment.size += int64(len(record))
+	segment.entries++
+	return segment, nil
+}
+
+// activeSegmentLocked returns the segment new records of a session go to,
+// starting a new one if the current segment is full or too old.
+// The caller must hold s.mu for writing.
+func (s *FileStorage) activeSegmentLocked(session *fileSession) (*fileSegment, error) {
+	if n := len(session.segments); n > 0 {
+		active := session.segments[n-1]
+		full := active.size >= s.config.MaxSegmentBytes
+		expired := s.config.MaxSegmentAge > 0 && time.Since(active.created) >= s.config.MaxSegmentAge
+		if active.size == 0 || (!full && !expired) {
+			return active, nil
+		}
+	}
+
+	var seq int64 = 1
+	if n := len(session.segments); n > 0 {
+		seq = session.segments[n-1].seq + 1
+	}
+
+	segment, _, err := openSegment(session.dir, seq)
+	if err != nil {
+		return nil, err
+	}
+	session.segments = append(session.segments, segment)
+	return segment, nil
+}
+
+// enforceRetentionLocked evicts the frames of a session that its retention
+// policy doesn't keep, recording every eviction in the active segment so
+// that it survives a restart. Segments left without live frames are removed. The caller must hold s.mu for writing.
+func (s *FileStorage) enforceRetentionLocked(sessionID string, session *fileSession) error {
+	policy, exists := s.retention[sessionID]
+	if !exists {
+		policy = s.defaults
+	}
+	if policy.IsZero() {
+		return nil
+	}
+
+	evicted := policy.evictions(session.indexes, session.newest, session.size, func(index int64) (time.Time, int64) {
+		location := session.frames[index]
+		return location.timestamp, location.size
+	})
+	for _, index := range evicted {
+		// Record the eviction, then drop the frame
+		entry := indexEntry{typ: recordDelete, index: index}
+		payload := binary.BigEndian.AppendUint64(nil, uint64(index))
+		segment, err := s.appendLocked(session, &entry, payload)
+		if err != nil {
+			return err
+		}
+		session.apply(segment, entry)
+	}
+
+	return session.compact()
+}
+
+// apply updates the in-memory state of a session with an index entry.
+func (session *fileSession) apply(segment *fileSegment, entry indexEntry) {
+	old, exists := session.frames[entry.index]
+	if exists {
+		old.segment.live--
+		session.size -= old.size
+	}
+
+	switch entry.typ {
+	case recordFrame:
+		if !exists {
+			session.indexes = insertIndex(session.indexes, entry.index)
+		}
+		session.frames[entry.index] = fileLocation{
+			segment:   segment,
+			offset:    entry.offset,
+			length:    entry.length,
+			timestamp: entry.timestamp,
+			size:      int64(entry.size),
+		}
+		if entry.keyFrame {
+			session.keyFrames = insertIndex(session.keyFrames, entry.index)
+		} else {
+			session.keyFrames = removeIndex(session.keyFrames, entry.index)
+		}
+		segment.live++
+		session.size += int64(entry.size)
+		if entry.timestamp.After(session.newest) {
+			session.newest = entry.timestamp
+		}
+
+	case recordDelete:
+		if exists {
+			delete(session.frames, entry.index)
+			session.indexes = removeIndex(session.indexes, entry.index)
+			session.keyFrames = removeIndex(session.keyFrames, entry.index)
+		}
+	}
+}
+
+// compact removes the oldest segments of a session for as long as they hold
+// no live frames. Segments are only removed oldest first, so an eviction
+// record is never removed before the frame it evicts. The active segment is
+// always kept.
+func (session *fileSession) compact() error {
+	for len(session.segments) > 1 && session.segments[0].live == 0 {
+		segment := session.segments[0]
+		segment.close()
+		if err := segment.remove(session.dir); err != nil {
+			return err
+		}
+		session.segments = session.segments[1:]
+	}
+	return nil
+}
+
+// read reads the frames with the given indexes from disk.
+func (session *fileSession) read(indexes []int64) ([]Frame, error) {
+	frames := make([]Frame, 0, len(indexes))
+	for _, index := range indexes {
+		frame, err := readFrame(session.frames[index])
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// readFrame reads and verifies the frame record at a location.
+func readFrame(location fileLocation) (Frame, error) {
+	_, payload, err := readRecord(location.segment.data, location.offset)
+	if err != nil {
+		return Frame{}, fmt.Errorf("failed to read frame from segment %d at offset %d: %v", location.segment.seq, location.offset, err)
+	}
+	return DecodeFrame(payload)
+}
+
+// readMetadata reads the metadata of the frame record at a location from the
+// start of the record. Records whose fields before the frame data don't fit
+// in metadataPrefixSize are read and verified in full.
+func readMetadata(location fileLocation) (FrameMetadata, error) {
+	length := int64(location.length)
+	if length > metadataPrefixSize {
+		prefix := make([]byte, metadataPrefixSize)
+		if _, err := location.segment.data.ReadAt(prefix, location.offset+recordHeaderSize); err != nil {
+			return FrameMetadata{}, fmt.Errorf("failed to read frame from segment %d at offset %d: %v", location.segment.seq, location.offset, err)
+		}
+		if metadata, err := decodeMetadataPrefix(prefix, location.size); err == nil {
+			return metadata, nil
+		}
+	}
+
+	frame, err := readFrame(location)
+	if err != nil {
+		return FrameMetadata{}, err
+	}
+	return frame.Metadata(), nil
+}
+
+// loadSession opens all segments of a session directory and replays their
+// indexes.
+func (s *FileStorage) loadSession(dir string) (*fileSession, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	seqs := make([]int64, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	session := &fileSession{dir: dir, frames: make(map[int64]fileLocation)}
+	for _, seq := range seqs {
+		segment, index, err := openSegment(dir, seq)
+		if err != nil {
+			for _, segment := range session.segments {
+				segment.close()
+			}
+			return nil, err
+		}
+		session.segments = append(session.segments, segment)
+
+		for _, entry := range index {
+			session.apply(segment, entry)
+		}
+	}
+
+	if err := session.loadCheckpoints(); err != nil {
+		for _, segment := range session.segments {
+			segment.close()
+		}
+		return nil, err
+	}
+
+	return session, session.compact()
+}
+
+// loadCheckpoints reads the saved consumer checkpoints of a session.
+func (session *fileSession) loadCheckpoints() error {
+	data, err := os.ReadFile(filepath.Join(session.dir, checkpointFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoints: %v", err)
+	}
+	if err := json.Unmarshal(data, &session.checkpoints); err != nil {
+		return fmt.Errorf("failed to unmarshal checkpoints: %v", err)
+	}
+	return nil
+}
+
+// saveCheckpoints writes the consumer checkpoints of a session, replacing
+// the file atomically.
+func (session *fileSession) saveCheckpoints() error {
+	data, err := json.Marshal(session.checkpoints)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoints: %v", err)
+	}
+
+	path := filepath.Join(session.dir, checkpointFile)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("failed to write checkpoints: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write checkpoints: %v", err)
+	}
+	return nil
+}
+
+// openSegment opens or creates a segment and its index, repairing both after
+// a crash, and returns the segment with its index entries.
+func openSegment(dir string, seq int64) (*fileSegment, []indexEntry, error) {
+	name := filepath.Join(dir, fmt.Sprintf("%016d", seq))
+
+	data, err := os.OpenFile(name+segmentExt, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open segment: %v", err)
+	}
+	index, err := os.OpenFile(name+indexExt, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		data.Close()
+		return nil, nil, fmt.Errorf("failed to open segment index: %v", err)
+	}
+
+	segment := &fileSegment{seq: seq, data: data, index: index, created: time.Now()}
+	entries, err := segment.recover()
+	if err != nil {
+		segment.close()
+		return nil, nil, err
+	}
+	return segment, entries, nil
+}
+
+// recover loads the index of a segment and brings it in line with the
+// segment: entries pointing past the end of the segment are dropped, records
+// after the last indexed one are indexed, and a torn record at the tail is
+// truncated.
+func (segment *fileSegment) recover() ([]indexEntry, error) {
+	info, err := segment.data.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat segment: %v", err)
+	}
+	dataSize := info.Size()
+
+	raw, err := io.ReadAll(io.NewSectionReader(segment.index, 0, math.MaxInt64))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read segment index: %v", err)
+	}
+
+	// Keep index entries up to the first one that doesn't fit the segment
+	entries := make([]indexEntry, 0, len(raw)/indexEntrySize)
+	var end int64
+	for len(raw) >= indexEntrySize {
+		entry := decodeIndexEntry(raw[:indexEntrySize])
+		recordEnd := entry.offset + recordHeaderSize + int64(entry.length)
+		if entry.offset != end || recordEnd > dataSize {
+			break
+		}
+		entries = append(entries, entry)
+		end = recordEnd
+		raw = raw[indexEntrySize:]
+	}
+
+	// The last indexed record may not have reached the disk intact; if it
+	// doesn't verify, rebuild the whole index from the segment
+	if n := len(entries); n > 0 {
+		if _, _, err := readRecord(segment.data, entries[n-1].offset); err != nil {
+			entries = entries[:0]
+			end = 0
+		}
+	}
+	indexed := len(entries)
+
+	// Index the records that follow the last indexed one
+	reader := bufio.NewReader(io.NewSectionReader(segment.data, end, dataSize-end))
+	for end < dataSize {
+		entry, recordSize, ok := scanRecord(reader)
+		if !ok {
+			break
+		}
+		entry.offset = end
+		entries = append(entries, entry)
+		end += recordSize
+	}
+
+	// Cut off a torn record and rewrite the index beyond the verified entries
+	if end < dataSize {
+		if err := segment.data.Truncate(end); err != nil {
+			return nil, fmt.Errorf("failed to truncate segment: %v", err)
+		}
+	}
+	if err := segment.index.Truncate(int64(indexed) * indexEntrySize); err != nil {
+		return nil, fmt.Errorf("failed to truncate segment index: %v", err)
+	}
+	for i := indexed; i < len(entries); i++ {
+		if _, err := segment.index.WriteAt(encodeIndexEntry(entries[i]), int64(i)*indexEntrySize); err != nil {
+			return nil, fmt.Errorf("failed to write segment index: %v", err)
+		}
+	}
+
+	segment.size = end
+	segment.entries = int64(len(entries))
+	return entries, nil
+}
+
+// readRecord reads and verifies the record at offset.
+func readRecord(file *os.File, offset int64) (byte, []byte, error) {
+	var header [recordHeaderSize]byte
+	if _, err := file.ReadAt(header[:], offset); err != nil {
+		return 0, nil, err
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := file.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		return 0, nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return 0, nil, errors.New("checksum mismatch")
+	}
+	return header[8], payload, nil
+}
+
+// scanRecord reads the next record from a segment and returns its index
+// entry, without offset, and its size on disk. It reports false if the
+// record is incomplete or damaged.
+func scanRecord(reader *bufio.Reader) (indexEntry, int64, bool) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return indexEntry{}, 0, false
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return indexEntry{}, 0, false
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return indexEntry{}, 0, false
+	}
+
+	entry := indexEntry{typ: header[8], length: length}
+	switch entry.typ {
+	case recordFrame:
+		frame, err := DecodeFrame(payload)
+		if err != nil {
+			return indexEntry{}, 0, false
+		}
+		entry.index = frame.Index
+		entry.timestamp = frame.Timestamp
+		entry.size = uint32(len(frame.Data))
+		entry.keyFrame = frame.KeyFrame
+	case recordDelete:
+		if length != 8 {
+			return indexEntry{}, 0, false
+		}
+		entry.index = int64(binary.BigEndian.Uint64(payload))
+	default:
+		return indexEntry{}, 0, false
+	}
+
+	return entry, recordHeaderSize + int64(length), true
+}
+
+// close closes the files of a segment.
+func (segment *fileSegment) close() error {
+	dataErr := segment.data.Close()
+	indexErr := segment.index.Close()
+	if dataErr != nil {
+		return dataErr
+	}
+	return indexErr
+}
+
+// remove deletes the files of a closed segment.
+func (segment *fileSegment) remove(dir string) error {
+	name := filepath.Join(dir, fmt.Sprintf("%016d", segment.seq))
+	if err := os.Remove(name + segmentExt); err != nil {
+		return fmt.Errorf("failed to remove segment: %v", err)
+	}
+	if err := os.Remove(name + indexExt); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove segment index: %v", err)
+	}
+	return nil
+}
+
+// encodeIndexEntry serializes an index entry.
+func encodeIndexEntry(entry indexEntry) []byte {
+	timestamp := int64(math.MinInt64)
+	if !entry.timestamp.IsZero() {
+		timestamp = entry.timestamp.UnixNano()
+	}
+
+	typ := entry.typ
+	if entry.keyFrame {
+		typ |= indexKeyFrame
+	}
+
+	buf := make([]byte, 0, indexEntrySize)
+	buf = append(buf, typ)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(entry.index))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(timestamp))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(entry.offset))
+	buf = binary.BigEndian.AppendUint32(buf, entry.length)
+	buf = binary.BigEndian.AppendUint32(buf, entry.size)
+	return buf
+}
+
+// decodeIndexEntry deserializes an index entry.
+func decodeIndexEntry(buf []byte) indexEntry {
+	entry := indexEntry{
+		typ:      buf[0] &^ indexKeyFrame,
+		keyFrame: buf[0]&indexKeyFrame != 0,
+		index:    int64(binary.BigEndian.Uint64(buf[1:9])),
+		offset:   int64(binary.BigEndian.Uint64(buf[17:25])),
+		length:   binary.BigEndian.Uint32(buf[25:29]),
+		size:     binary.BigEndian.Uint32(buf[29:33]),
+	}
+	if timestamp := int64(binary.BigEndian.Uint64(buf[9:17])); timestamp != math.MinInt64 {
+		entry.timestamp = time.Unix(0, timestamp)
+	}
+	return entry
+}
+
+// loadRetention reads the saved per-session retention policies.
+func (s *FileStorage) loadRetention() error {
+	data, err := os.ReadFile(filepath.Join(s.config.Dir, retentionFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read retention policies: %v", err)
+	}
+	if err := json.Unmarshal(data, &s.retention); err != nil {
+		return fmt.Errorf("failed to unmarshal retention policies: %v", err)
+	}
+	return nil
+}
+
+// saveRetentionLocked writes the per-session retention policies, replacing
+// the file atomically. The caller must hold s.mu for writing.
+func (s *FileStorage) saveRetentionLocked() error {
+	data, err := json.Marshal(s.retention)
+	if err != nil {
+		return fmt.Errorf("failed to marshal retention policies: %v", err)
+	}
+
+	path := filepath.Join(s.config.Dir, retentionFile)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("failed to write retention policies: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write retention policies: %v", err)
+	}
+	return nil
+}
+
+// escapeSessionID turns a session ID into a directory name. Path separators
+// are escaped, and so is a leading dot so that "." and ".." stay ordinary
+// names.
+func escapeSessionID(sessionID string) string {
+	name := url.PathEscape(sessionID)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
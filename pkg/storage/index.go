@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"sort"
+)
+
+// insertIndex adds a frame index to a sorted index list unless it is
+// already present, and returns the updated list. Frames usually arrive in
+// order, so appending is the common case.
+func insertIndex(indexes []int64, index int64) []int64 {
+	if n := len(indexes); n == 0 || indexes[n-1] < index {
+		return append(indexes, index)
+	}
+
+	pos := sort.Search(len(indexes), func(i int) bool { return indexes[i] >= index })
+	if indexes[pos] == index {
+		return indexes
+	}
+	indexes = append(indexes, 0)
+	copy(indexes[pos+1:], indexes[pos:])
+	indexes[pos] = index
+	return indexes
+}
+
+// removeIndex removes a frame index from a sorted index list if present,
+// and returns the updated list.
+func removeIndex(indexes []int64, index int64) []int64 {
+	pos := sort.Search(len(indexes), func(i int) bool { return indexes[i] >= index })
+	if pos == len(indexes) || indexes[pos] != index {
+		return indexes
+	}
+	return append(indexes[:pos], indexes[pos+1:]...)
+}
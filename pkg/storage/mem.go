@@ -4,8 +4,10 @@ package storage
 import (
 	"context"
 	"fmt"
+	"math"
 	"sort"
 	"sync"
+	"time"
 )
 
 // MemoryStorage implements the Storage interface using in-memory maps.
@@ -24,27 +26,52 @@ import (
 // - The number of sessions and frames being stored
 // - The size of frame data (especially for high-resolution video)
 // - Cleaning up sessions that are no longer needed via DeleteSession
+// - Bounding sessions with a RetentionPolicy via SetRetention
 type MemoryStorage struct {
-	mu       sync.RWMutex                    // Protects access to the frames map
-	frames   map[string]map[int64]Frame      // Maps session ID to a map of frame index to Frame
-	sessions map[string]struct{}             // Tracks active sessions for efficient listing
+	mu          sync.RWMutex                       // Protects access to the frames map
+	frames      map[string]map[int64]Frame         // Maps session ID to a map of frame index to Frame
+	indexes     map[string][]int64                 // Maps session ID to its frame indexes in ascending order
+	keyFrames   map[string][]int64                 // Maps session ID to its key frame indexes in ascending order
+	sessions    map[string]struct{}                // Tracks active sessions for efficient listing
+	sizes       map[string]int64                   // Maps session ID to the total size of its frame data
+	newest      map[string]time.Time               // Maps session ID to the newest frame timestamp seen
+	retention   map[string]RetentionPolicy         // Maps session ID to its own retention policy
+	defaults    RetentionPolicy                    // Retention policy for sessions without their own
+	subscribers map[string]map[*subscription]int64 // Maps session ID to subscriptions and the next index they deliver
+	checkpoints map[string]map[string]int64        // Maps session ID to the checkpoint of each consumer
+	done        chan struct{}                      // Closed by Close to end all subscriptions
+	closeOnce   sync.Once                          // Guards closing of done
 }
 
 // NewMemoryStorage creates a new MemoryStorage instance.
 // It initializes the internal maps used for storing frames and tracking sessions.
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		frames:   make(map[string]map[int64]Frame),
-		sessions: make(map[string]struct{}),
+		frames:      make(map[string]map[int64]Frame),
+		indexes:     make(map[string][]int64),
+		keyFrames:   make(map[string][]int64),
+		sessions:    make(map[string]struct{}),
+		sizes:       make(map[string]int64),
+		newest:      make(map[string]time.Time),
+		retention:   make(map[string]RetentionPolicy),
+		subscribers: make(map[string]map[*subscription]int64),
+		checkpoints: make(map[string]map[string]int64),
+		done:        make(chan struct{}),
 	}
 }
 
 // PutFrame stores a frame in memory, creating the session map if it doesn't exist.
 // If a frame with the same session ID and index already exists, it will be overwritten.
+// The session's retention policy is then enforced, and the frame is handed to
+// every subscriber of the session.
 //
 // The context parameter is included for interface compatibility but is not used
 // since memory operations are immediate.
 func (s *MemoryStorage) PutFrame(_ context.Context, frame Frame) error {
+	if isClosed(s.done) {
+		return errClosed("put frame")
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -54,8 +81,32 @@ func (s *MemoryStorage) PutFrame(_ context.Context, frame Frame) error {
 		s.sessions[frame.SessionID] = struct{}{}
 	}
 
-	// Store the frame
+	// Store the frame, keeping the index list sorted
+	if old, exists := s.frames[frame.SessionID][frame.Index]; exists {
+		s.sizes[frame.SessionID] -= int64(len(old.Data))
+	} else {
+		s.indexes[frame.SessionID] = insertIndex(s.indexes[frame.SessionID], frame.Index)
+	}
+	if frame.KeyFrame {
+		s.keyFrames[frame.SessionID] = insertIndex(s.keyFrames[frame.SessionID], frame.Index)
+	} else {
+		s.keyFrames[frame.SessionID] = removeIndex(s.keyFrames[frame.SessionID], frame.Index)
+	}
 	s.frames[frame.SessionID][frame.Index] = frame
+	s.sizes[frame.SessionID] += int64(len(frame.Data))
+	if frame.Timestamp.After(s.newest[frame.SessionID]) {
+		s.newest[frame.SessionID] = frame.Timestamp
+	}
+
+	s.enforceRetentionLocked(frame.SessionID)
+
+	// Fan out to subscribers, which deliver frames in index order
+	for sub, next := range s.subscribers[frame.SessionID] {
+		if frame.Index >= next {
+			sub.push(frame)
+			s.subscribers[frame.SessionID][sub] = frame.Index + 1
+		}
+	}
 	return nil
 }
 
@@ -71,13 +122,13 @@ func (s *MemoryStorage) GetFrame(_ context.Context, sessionID string, frameIndex
 	// Check if session exists
 	sessionFrames, exists := s.frames[sessionID]
 	if !exists {
-		return Frame{}, fmt.Errorf("session not found: %s", sessionID)
+		return Frame{}, errSessionNotFound(sessionID)
 	}
 
 	// Check if frame exists
 	frame, exists := sessionFrames[frameIndex]
 	if !exists {
-		return Frame{}, fmt.Errorf("frame not found: session %s, index %d", sessionID, frameIndex)
+		return Frame{}, errFrameNotFound(sessionID, frameIndex)
 	}
 
 	return frame, nil
@@ -93,25 +144,245 @@ func (s *MemoryStorage) ListFrames(_ context.Context, sessionID string) ([]Frame
 	defer s.mu.RUnlock()
 
 	// Check if session exists
+	if _, exists := s.frames[sessionID]; !exists {
+		return nil, errSessionNotFound(sessionID)
+	}
+
+	return s.rangeLocked(sessionID, math.MinInt64, math.MaxInt64), nil
+}
+
+// ListFramesRange returns the frames of a session with an index between
+// fromIndex and toIndex (both inclusive), sorted by frame index.
+// The range is located with a binary search over the session's sorted
+// index list, so the cost depends on the size of the range rather than
+// the size of the session.
+//
+// Returns an error if the session doesn't exist.
+func (s *MemoryStorage) ListFramesRange(_ context.Context, sessionID string, fromIndex, toIndex int64) ([]Frame, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.frames[sessionID]; !exists {
+		return nil, errSessionNotFound(sessionID)
+	}
+
+	return s.rangeLocked(sessionID, fromIndex, toIndex), nil
+}
+
+// ListFrameMetadata returns the metadata of the frames of a session with an
+// index between fromIndex and toIndex (both inclusive), sorted by frame index.
+//
+// Returns an error if the session doesn't exist.
+func (s *MemoryStorage) ListFrameMetadata(_ context.Context, sessionID string, fromIndex, toIndex int64) ([]FrameMetadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.frames[sessionID]; !exists {
+		return nil, errSessionNotFound(sessionID)
+	}
+
+	frames := s.rangeLocked(sessionID, fromIndex, toIndex)
+	metadata := make([]FrameMetadata, 0, len(frames))
+	for _, frame := range frames {
+		metadata = append(metadata, frame.Metadata())
+	}
+	return metadata, nil
+}
+
+// LatestKeyFrame returns the key frame of a session with the highest index,
+// looked up in the session's sorted key frame index.
+//
+// Returns an error if the session doesn't exist or holds no key frame.
+func (s *MemoryStorage) LatestKeyFrame(_ context.Context, sessionID string) (Frame, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	sessionFrames, exists := s.frames[sessionID]
 	if !exists {
-		return nil, fmt.Errorf("session not found: %s", sessionID)
+		return Frame{}, errSessionNotFound(sessionID)
 	}
 
-	// Convert map to sorted slice
-	frames := make([]Frame, 0, len(sessionFrames))
-	for _, frame := range sessionFrames {
-		frames = append(frames, frame)
+	keyFrames := s.keyFrames[sessionID]
+	if len(keyFrames) == 0 {
+		return Frame{}, errNoKeyFrame(sessionID)
 	}
+	return sessionFrames[keyFrames[len(keyFrames)-1]], nil
+}
 
-	// Sort frames by index
-	sort.Slice(frames, func(i, j int) bool {
-		return frames[i].Index < frames[j].Index
-	})
+// ListFramesPage returns up to limit frames of a session starting at the
+// frame index given by cursor.
+//
+// Returns an error if the session doesn't exist or limit is not positive.
+func (s *MemoryStorage) ListFramesPage(_ context.Context, sessionID string, cursor int64, limit int) (FramePage, error) {
+	if limit <= 0 {
+		return FramePage{}, fmt.Errorf("invalid page limit: %d", limit)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessionFrames, exists := s.frames[sessionID]
+	if !exists {
+		return FramePage{}, errSessionNotFound(sessionID)
+	}
+
+	indexes := s.indexes[sessionID]
+	start := sort.Search(len(indexes), func(i int) bool { return indexes[i] >= cursor })
+	end := start + limit
+	if end > len(indexes) {
+		end = len(indexes)
+	}
+
+	page := FramePage{
+		Frames:     make([]Frame, 0, end-start),
+		NextCursor: cursor,
+		HasMore:    end < len(indexes),
+	}
+	for _, index := range indexes[start:end] {
+		page.Frames = append(page.Frames, sessionFrames[index])
+	}
+	if len(page.Frames) > 0 {
+		page.NextCursor = page.Frames[len(page.Frames)-1].Index + 1
+	}
+
+	return page, nil
+}
+
+// ListFramesByTime returns the frames of a session whose Timestamp lies
+// between start and end (both inclusive), sorted by frame index.
+// Timestamps are not guaranteed to follow frame indexes, so this scans
+// all frames of the session.
+//
+// Returns an error if the session doesn't exist.
+func (s *MemoryStorage) ListFramesByTime(_ context.Context, sessionID string, start, end time.Time) ([]Frame, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessionFrames, exists := s.frames[sessionID]
+	if !exists {
+		return nil, errSessionNotFound(sessionID)
+	}
+
+	frames := make([]Frame, 0)
+	for _, index := range s.indexes[sessionID] {
+		frame := sessionFrames[index]
+		if frame.Timestamp.Before(start) || frame.Timestamp.After(end) {
+			continue
+		}
+		frames = append(frames, frame)
+	}
 
 	return frames, nil
 }
 
+// rangeLocked returns the frames of a session with an index between
+// fromIndex and toIndex (both inclusive). The caller must hold s.mu.
+func (s *MemoryStorage) rangeLocked(sessionID string, fromIndex, toIndex int64) []Frame {
+	indexes := s.indexes[sessionID]
+	start := sort.Search(len(indexes), func(i int) bool { return indexes[i] >= fromIndex })
+	end := sort.Search(len(indexes), func(i int) bool { return indexes[i] > toIndex })
+	if end < start {
+		end = start
+	}
+
+	frames := make([]Frame, 0, end-start)
+	for _, index := range indexes[start:end] {
+		frames = append(frames, s.frames[sessionID][index])
+	}
+	return frames
+}
+
+// oldestFrames returns the oldest frames of a session beyond the newest keep
+// frames, in index order.
+func (s *MemoryStorage) oldestFrames(sessionID string, keep int) []Frame {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	indexes := s.indexes[sessionID]
+	if len(indexes) <= keep {
+		return nil
+	}
+
+	frames := make([]Frame, 0, len(indexes)-keep)
+	for _, index := range indexes[:len(indexes)-keep] {
+		frames = append(frames, s.frames[sessionID][index])
+	}
+	return frames
+}
+
+// removeFrames removes frames of a session by index without notifying
+// subscribers. Indexes that aren't stored are ignored.
+func (s *MemoryStorage) removeFrames(sessionID string, indexes []int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := make(map[int64]struct{}, len(indexes))
+	for _, index := range indexes {
+		frame, exists := s.frames[sessionID][index]
+		if !exists {
+			continue
+		}
+		delete(s.frames[sessionID], index)
+		s.sizes[sessionID] -= int64(len(frame.Data))
+		removed[index] = struct{}{}
+	}
+
+	kept := s.indexes[sessionID][:0]
+	for _, index := range s.indexes[sessionID] {
+		if _, ok := removed[index]; !ok {
+			kept = append(kept, index)
+		}
+	}
+	s.indexes[sessionID] = kept
+
+	for index := range removed {
+		s.keyFrames[sessionID] = removeIndex(s.keyFrames[sessionID], index)
+	}
+}
+
+// Subscribe delivers frames of a session as they are stored.
+// Existing frames with an index >= fromIndex are queued first; the backlog
+// snapshot and the subscriber registration happen under the same lock, so
+// no frame is missed or delivered twice between the two.
+//
+// The returned channel is closed when ctx is cancelled, Close is called, or
+// the subscriber falls more than MaxPendingFrames frames behind.
+func (s *MemoryStorage) Subscribe(ctx context.Context, sessionID string, fromIndex int64) (<-chan Frame, error) {
+	if isClosed(s.done) {
+		return nil, errClosed("subscribe")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Collect the backlog of already stored frames
+	backlog := s.rangeLocked(sessionID, fromIndex, math.MaxInt64)
+
+	next := fromIndex
+	if len(backlog) > 0 {
+		next = backlog[len(backlog)-1].Index + 1
+	}
+
+	sub := newSubscription(backlog)
+	if s.subscribers[sessionID] == nil {
+		s.subscribers[sessionID] = make(map[*subscription]int64)
+	}
+	s.subscribers[sessionID][sub] = next
+
+	go func() {
+		sub.run(ctx, s.done)
+
+		s.mu.Lock()
+		delete(s.subscribers[sessionID], sub)
+		if len(s.subscribers[sessionID]) == 0 {
+			delete(s.subscribers, sessionID)
+		}
+		s.mu.Unlock()
+	}()
+
+	return sub.out, nil
+}
+
 // ListSessions returns a list of all active session IDs.
 // The returned list is sorted alphabetically for consistent ordering.
 //
@@ -142,19 +413,105 @@ func (s *MemoryStorage) DeleteSession(_ context.Context, sessionID string) error
 
 	// Check if session exists
 	if _, exists := s.frames[sessionID]; !exists {
-		return fmt.Errorf("session not found: %s", sessionID)
+		return errSessionNotFound(sessionID)
 	}
 
 	// Remove session data
 	delete(s.frames, sessionID)
+	delete(s.indexes, sessionID)
+	delete(s.keyFrames, sessionID)
 	delete(s.sessions, sessionID)
+	delete(s.sizes, sessionID)
+	delete(s.newest, sessionID)
+	delete(s.retention, sessionID)
+	delete(s.checkpoints, sessionID)
 	return nil
 }
 
-// Close implements the Storage interface but does nothing for MemoryStorage
-// since there are no resources to clean up.
+// SetRetention sets the retention policy of a session, or the default policy
+// if sessionID is empty. The policy is enforced on the next write to a session.
 //
-// This method is included for interface compatibility and always returns nil.
+// The context parameter is included for interface compatibility but is not used
+// since memory operations are immediate.
+func (s *MemoryStorage) SetRetention(_ context.Context, sessionID string, policy RetentionPolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sessionID == "" {
+		s.defaults = policy
+		return nil
+	}
+
+	s.retention[sessionID] = policy
+	return nil
+}
+
+// SetCheckpoint records the index of the last frame of a session that a
+// consumer has processed. Returns an error if the session doesn't exist.
+//
+// The context parameter is included for interface compatibility but is not used
+// since memory operations are immediate.
+func (s *MemoryStorage) SetCheckpoint(_ context.Context, consumer, sessionID string, index int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.sessions[sessionID]; !exists {
+		return errSessionNotFound(sessionID)
+	}
+
+	if s.checkpoints[sessionID] == nil {
+		s.checkpoints[sessionID] = make(map[string]int64)
+	}
+	s.checkpoints[sessionID][consumer] = index
+	return nil
+}
+
+// GetCheckpoint returns the checkpoint a consumer recorded for a session.
+// Returns an error if the session doesn't exist.
+//
+// The context parameter is included for interface compatibility but is not used
+// since memory operations are immediate.
+func (s *MemoryStorage) GetCheckpoint(_ context.Context, consumer, sessionID string) (int64, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.sessions[sessionID]; !exists {
+		return 0, false, errSessionNotFound(sessionID)
+	}
+
+	index, exists := s.checkpoints[sessionID][consumer]
+	return index, exists, nil
+}
+
+// enforceRetentionLocked evicts the frames of a session that its retention
+// policy doesn't keep. The caller must hold s.mu for writing.
+func (s *MemoryStorage) enforceRetentionLocked(sessionID string) {
+	policy, exists := s.retention[sessionID]
+	if !exists {
+		policy = s.defaults
+	}
+	if policy.IsZero() {
+		return
+	}
+
+	frames := s.frames[sessionID]
+	evicted := policy.evictions(s.indexes[sessionID], s.newest[sessionID], s.sizes[sessionID], func(index int64) (time.Time, int64) {
+		return frames[index].Timestamp, int64(len(frames[index].Data))
+	})
+	for _, index := range evicted {
+		s.sizes[sessionID] -= int64(len(frames[index].Data))
+		delete(frames, index)
+		s.indexes[sessionID] = removeIndex(s.indexes[sessionID], index)
+		s.keyFrames[sessionID] = removeIndex(s.keyFrames[sessionID], index)
+	}
+}
+
+// Close ends all active subscriptions. Stored frames are left in place.
+//
+// This method always returns nil.
 func (s *MemoryStorage) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
 	return nil
 }
@@ -0,0 +1,1036 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrObjectNotFound is returned by an ObjectClient for a missing object.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectClient is the subset of an object store API used by ObjectStorage.
+// S3Client implements it for S3-compatible services, and MemoryObjectClient
+// implements it in memory for tests and development.
+type ObjectClient interface {
+	// PutObject stores data under key, replacing any existing object.
+	PutObject(ctx context.Context, key string, data []byte) error
+
+	// GetObject returns the content of an object.
+	// Returns ErrObjectNotFound if the object doesn't exist.
+	GetObject(ctx context.Context, key string) ([]byte, error)
+
+	// GetObjectRange returns length bytes of an object starting at offset.
+	// Returns ErrObjectNotFound if the object doesn't exist.
+	GetObjectRange(ctx context.Context, key string, offset, length int64) ([]byte, error)
+
+	// ListObjects returns the keys of all objects starting with prefix,
+	// sorted lexicographically.
+	ListObjects(ctx context.Context, prefix string) ([]string, error)
+
+	// DeleteObject removes an object. Deleting a missing object is not an error.
+	DeleteObject(ctx context.Context, key string) error
+}
+
+// MemoryObjectClient implements ObjectClient in memory.
+// It stands in for an object store in tests and local development.
+type MemoryObjectClient struct {
+	mu      sync.RWMutex      // Protects objects
+	objects map[string][]byte // Maps key to object content
+}
+
+// NewMemoryObjectClient creates an empty MemoryObjectClient.
+func NewMemoryObjectClient() *MemoryObjectClient {
+	return &MemoryObjectClient{objects: make(map[string][]byte)}
+}
+
+// PutObject stores a copy of data under key.
+func (c *MemoryObjectClient) PutObject(_ context.Context, key string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.objects[key] = append([]byte(nil), data...)
+	return nil
+}
+
+// GetObject returns the content of an object.
+func (c *MemoryObjectClient) GetObject(_ context.Context, key string) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	data, exists := c.objects[key]
+	if !exists {
+		return nil, ErrObjectNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// GetObjectRange returns a byte range of an object.
+func (c *MemoryObjectClient) GetObjectRange(_ context.Context, key string, offset, length int64) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	data, exists := c.objects[key]
+	if !exists {
+		return nil, ErrObjectNotFound
+	}
+	if offset < 0 || length < 0 || offset+length > int64(len(data)) {
+		return nil, fmt.Errorf("invalid range %d-%d of object %s", offset, offset+length, key)
+	}
+	return append([]byte(nil), data[offset:offset+length]...), nil
+}
+
+// ListObjects returns the keys starting with prefix in lexicographic order.
+func (c *MemoryObjectClient) ListObjects(_ context.Context, prefix string) ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := make([]string, 0)
+	for key := range c.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// DeleteObject removes an object.
+func (c *MemoryObjectClient) DeleteObject(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.objects, key)
+	return nil
+}
+
+// ObjectConfig holds the configuration of an ObjectStorage.
+type ObjectConfig struct {
+	Client      ObjectClient    // Object store to write to
+	Prefix      string          // Key prefix for all objects, e.g. "relais/"
+	BatchFrames int             // Frames per batch object, default 300
+	BatchBytes  int64           // Frame data bytes per batch object, default 8 MiB
+	BatchAge    time.Duration   // Longest time frames wait for their batch, default 10s
+	Retention   RetentionPolicy // Default retention policy for all sessions
+}
+
+// Default batch limits for ObjectStorage.
+const (
+	defaultBatchFrames = 300
+	defaultBatchBytes  = 8 << 20
+	defaultBatchAge    = 10 * time.Second
+)
+
+// ObjectStorage implements the Storage interface on an S3-compatible object
+// store, for archiving sessions to cheap and durable storage.
+//
+// Object Layout:
+// Frames are buffered in memory and written in batches, one object per batch
+// holding the length-prefixed EncodeFrame envelopes of its frames. Each
+// session has a manifest object listing its batches and the offset of every
+// frame in them:
+//
+//	<prefix>sessions/<session>/manifest.json
+//	<prefix>sessions/<session>/batches/<seq>.bin
+//
+// A batch is written before the manifest that references it, so the manifest
+// is the commit point: a crash can leave unreferenced batch objects behind but
+// never a manifest pointing at a missing batch. Batches are written once a
+// session has BatchFrames frames or BatchBytes of data buffered, or its oldest
+// buffered frame is BatchAge old, and on Close.
+//
+// Reads:
+// Manifests are loaded on startup and kept in memory. Buffered frames are
+// read from memory and frames of written batches are fetched with ranged
+// GETs, so reading one frame doesn't download its whole batch.
+//
+// Retention and overwrites drop frames from the manifest; a batch object is
+// deleted once none of its frames are left. Each session must only be written
+// by one ObjectStorage at a time.
+type ObjectStorage struct {
+	mu        sync.RWMutex                          // Protects sessions, defaults and watchers
+	client    ObjectClient                          // Object store
+	config    ObjectConfig                          // Storage configuration
+	sessions  map[string]*objectSession             // Maps session ID to its state
+	defaults  RetentionPolicy                       // Retention policy for sessions without their own
+	watchers  map[string]map[chan struct{}]struct{} // Maps session ID to subscriptions waiting for frames
+	done      chan struct{}                         // Closed by Close to stop flushing and subscriptions
+	flushed   chan struct{}                         // Closed when the background flusher has exited
+	closeOnce sync.Once                             // Guards closing of done
+}
+
+// objectSession is the in-memory state of a session in the object store.
+// Its lock is held while its batches and manifest are written, so that slow
+// uploads of one session don't block the others.
+type objectSession struct {
+	mu          sync.RWMutex             // Protects all fields below
+	id          string                   // Session ID
+	batches     map[int64]*objectBatch   // Maps batch sequence number to its state
+	frames      map[int64]objectLocation // Maps frame index to where the frame is stored
+	indexes     []int64                  // Frame indexes in ascending order
+	keyFrames   []int64                  // Key frame indexes in ascending order
+	pending     int                      // Number of buffered frames
+	pendBytes   int64                    // Size of buffered frame data
+	pendSince   time.Time                // When the oldest buffered frame was written
+	nextBatch   int64                    // Sequence number of the next batch
+	size        int64                    // Total size of frame data
+	newest      time.Time                // Newest frame timestamp seen
+	retention   *RetentionPolicy         // Session policy, nil for the default
+	checkpoints map[string]int64         // Maps consumer to its checkpoint
+	dirty       bool                     // Whether the manifest changed since it was written
+	deleted     bool                     // Whether the session was deleted, which stops all writes
+}
+
+// objectBatch is a written batch object.
+type objectBatch struct {
+	seq  int64 // Sequence number, also the object name
+	live int   // Number of frames of the batch still in the manifest
+}
+
+// objectLocation locates a frame, either in memory or in a batch object.
+type objectLocation struct {
+	frame     *Frame       // Buffered frame, nil once written
+	batch     *objectBatch // Batch holding the frame
+	offset    int64        // Offset of the frame envelope in the batch
+	length    int64        // Length of the frame envelope
+	timestamp time.Time    // Frame timestamp
+	size      int64        // Size of the frame data
+	keyFrame  bool         // Whether the frame is a key frame
+}
+
+// objectManifest is the stored form of a session manifest.
+type objectManifest struct {
+	NextBatch   int64            `json:"next_batch"`
+	Retention   *RetentionPolicy `json:"retention,omitempty"`
+	Checkpoints map[string]int64 `json:"checkpoints,omitempty"`
+	Batches     []manifestBatch  `json:"batches"`
+}
+
+// manifestBatch lists the frames of a batch in a manifest.
+type manifestBatch struct {
+	Seq    int64           `json:"seq"`
+	Frames []manifestFrame `json:"frames"`
+}
+
+// manifestFrame locates a frame in a batch object.
+type manifestFrame struct {
+	Index     int64     `json:"index"`
+	Offset    int64     `json:"offset"`
+	Length    int64     `json:"length"`
+	Timestamp time.Time `json:"timestamp"`
+	Size      int64     `json:"size"`
+	KeyFrame  bool      `json:"key_frame,omitempty"`
+}
+
+// NewObjectStorage creates an ObjectStorage, loading the manifests of all
+// sessions already in the object store.
+//
+// Returns an error if no client is configured or the manifests cannot be
+// loaded.
+func NewObjectStorage(config ObjectConfig) (*ObjectStorage, error) {
+	if config.Client == nil {
+		return nil, errors.New("object client not set")
+	}
+	if config.BatchFrames <= 0 {
+		config.BatchFrames = defaultBatchFrames
+	}
+	if config.BatchBytes <= 0 {
+		config.BatchBytes = defaultBatchBytes
+	}
+	if config.BatchAge <= 0 {
+		config.BatchAge = defaultBatchAge
+	}
+
+	s := &ObjectStorage{
+		client:   config.Client,
+		config:   config,
+		sessions: make(map[string]*objectSession),
+		defaults: config.Retention,
+		watchers: make(map[string]map[chan struct{}]struct{}),
+		done:     make(chan struct{}),
+		flushed:  make(chan struct{}),
+	}
+
+	ctx := context.Background()
+	keys, err := s.client.ListObjects(ctx, s.config.Prefix+"sessions/")
+	if err != nil {
+		return nil, errUnavailable("failed to list sessions", err)
+	}
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, s.config.Prefix+"sessions/")
+		if !strings.HasSuffix(name, "/manifest.json") || strings.Count(name, "/") != 1 {
+			continue
+		}
+		sessionID, err := url.PathUnescape(strings.TrimSuffix(name, "/manifest.json"))
+		if err != nil {
+			continue
+		}
+
+		session, err := s.loadSession(ctx, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		s.sessions[sessionID] = session
+	}
+
+	go s.flushLoop()
+	return s, nil
+}
+
+// PutFrame buffers a frame and enforces the session's retention policy.
+// If the session's buffer is full, the buffered frames are written as a batch
+// before PutFrame returns.
+//
+// Returns an error if the frame cannot be encoded or the batch cannot be
+// written. The frame stays buffered in that case and is retried with the next
+// batch.
+func (s *ObjectStorage) PutFrame(ctx context.Context, frame Frame) error {
+	if isClosed(s.done) {
+		return errClosed("put frame")
+	}
+
+	if frame.SessionID == "" {
+		return errors.New("session ID must not be empty")
+	}
+	if err := checkEncodable(frame); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	session, exists := s.sessions[frame.SessionID]
+	if !exists {
+		session = newObjectSession(frame.SessionID)
+		s.sessions[frame.SessionID] = session
+	}
+	defaults := s.defaults
+	s.mu.Unlock()
+
+	session.mu.Lock()
+	session.buffer(frame)
+	session.enforceRetention(defaults)
+	full := session.pending >= s.config.BatchFrames || session.pendBytes >= s.config.BatchBytes
+	var err error
+	if full {
+		err = s.flushLocked(ctx, session)
+	}
+	session.mu.Unlock()
+
+	// Buffered frames are readable, so wake up subscribers either way
+	s.notify(frame.SessionID)
+	return err
+}
+
+// GetFrame returns a buffered frame or fetches it from its batch object.
+// Returns an error if the session or frame doesn't exist.
+func (s *ObjectStorage) GetFrame(ctx context.Context, sessionID string, frameIndex int64) (Frame, error) {
+	session, err := s.session(sessionID)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	session.mu.RLock()
+	location, exists := session.frames[frameIndex]
+	session.mu.RUnlock()
+	if !exists {
+		return Frame{}, errFrameNotFound(sessionID, frameIndex)
+	}
+
+	return s.readFrame(ctx, session.id, location)
+}
+
+// ListFrames returns all frames of a session, sorted by frame index.
+// Returns an error if the session doesn't exist.
+func (s *ObjectStorage) ListFrames(ctx context.Context, sessionID string) ([]Frame, error) {
+	return s.ListFramesRange(ctx, sessionID, math.MinInt64, math.MaxInt64)
+}
+
+// ListFramesRange returns the frames of a session with an index between
+// fromIndex and toIndex (both inclusive), sorted by frame index.
+// Returns an error if the session doesn't exist.
+func (s *ObjectStorage) ListFramesRange(ctx context.Context, sessionID string, fromIndex, toIndex int64) ([]Frame, error) {
+	session, err := s.session(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mu.RLock()
+	indexes := session.indexes
+	start := sort.Search(len(indexes), func(i int) bool { return indexes[i] >= fromIndex })
+	end := sort.Search(len(indexes), func(i int) bool { return indexes[i] > toIndex })
+	if end < start {
+		end = start
+	}
+	locations := session.locate(indexes[start:end])
+	session.mu.RUnlock()
+
+	return s.readFrames(ctx, sessionID, locations)
+}
+
+// ListFrameMetadata returns the metadata of the frames of a session with an
+// index between fromIndex and toIndex (both inclusive), sorted by frame index.
+// Buffered frames are read from memory; for frames in batch objects only the
+// start of each frame envelope is fetched with a ranged GET.
+//
+// Returns an error if the session doesn't exist or an object cannot be read.
+func (s *ObjectStorage) ListFrameMetadata(ctx context.Context, sessionID string, fromIndex, toIndex int64) ([]FrameMetadata, error) {
+	session, err := s.session(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mu.RLock()
+	indexes := session.indexes
+	start := sort.Search(len(indexes), func(i int) bool { return indexes[i] >= fromIndex })
+	end := sort.Search(len(indexes), func(i int) bool { return indexes[i] > toIndex })
+	if end < start {
+		end = start
+	}
+	locations := session.locate(indexes[start:end])
+	session.mu.RUnlock()
+
+	metadata := make([]FrameMetadata, 0, len(locations))
+	for _, location := range locations {
+		m, err := s.readMetadata(ctx, sessionID, location)
+		if err != nil {
+			return nil, err
+		}
+		metadata = append(metadata, m)
+	}
+	return metadata, nil
+}
+
+// LatestKeyFrame returns the key frame of a session with the highest index.
+// Key frames are flagged in the manifest, so only the frame itself is
+// fetched, or read from memory if it is still buffered.
+//
+// Returns an error if the session doesn't exist or holds no key frame.
+func (s *ObjectStorage) LatestKeyFrame(ctx context.Context, sessionID string) (Frame, error) {
+	session, err := s.session(sessionID)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	session.mu.RLock()
+	n := len(session.keyFrames)
+	var location objectLocation
+	if n > 0 {
+		location = session.frames[session.keyFrames[n-1]]
+	}
+	session.mu.RUnlock()
+	if n == 0 {
+		return Frame{}, errNoKeyFrame(sessionID)
+	}
+
+	return s.readFrame(ctx, sessionID, location)
+}
+
+// ListFramesPage returns up to limit frames of a session starting at the
+// frame index given by cursor.
+//
+// Returns an error if the session doesn't exist or limit is not positive.
+func (s *ObjectStorage) ListFramesPage(ctx context.Context, sessionID string, cursor int64, limit int) (FramePage, error) {
+	if limit <= 0 {
+		return FramePage{}, fmt.Errorf("invalid page limit: %d", limit)
+	}
+
+	session, err := s.session(sessionID)
+	if err != nil {
+		return FramePage{}, err
+	}
+
+	session.mu.RLock()
+	indexes := session.indexes
+	start := sort.Search(len(indexes), func(i int) bool { return indexes[i] >= cursor })
+	end := start + limit
+	if end > len(indexes) {
+		end = len(indexes)
+	}
+	locations := session.locate(indexes[start:end])
+	hasMore := end < len(indexes)
+	session.mu.RUnlock()
+
+	frames, err := s.readFrames(ctx, sessionID, locations)
+	if err != nil {
+		return FramePage{}, err
+	}
+
+	page := FramePage{Frames: frames, NextCursor: cursor, HasMore: hasMore}
+	if len(frames) > 0 {
+		page.NextCursor = frames[len(frames)-1].Index + 1
+	}
+	return page, nil
+}
+
+// ListFramesByTime returns the frames of a session whose Timestamp lies
+// between start and end (both inclusive), sorted by frame index. Timestamps
+// are kept in the manifest, so only matching frames are fetched.
+//
+// Returns an error if the session doesn't exist.
+func (s *ObjectStorage) ListFramesByTime(ctx context.Context, sessionID string, start, end time.Time) ([]Frame, error) {
+	session, err := s.session(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mu.RLock()
+	matches := make([]int64, 0)
+	for _, index := range session.indexes {
+		timestamp := session.frames[index].timestamp
+		if timestamp.Before(start) || timestamp.After(end) {
+			continue
+		}
+		matches = append(matches, index)
+	}
+	locations := session.locate(matches)
+	session.mu.RUnlock()
+
+	return s.readFrames(ctx, sessionID, locations)
+}
+
+// Subscribe delivers frames of a session as they are stored, reading the
+// backlog page by page. Buffered frames are delivered without waiting for
+// their batch to be written.
+//
+// The returned channel is closed when ctx is cancelled or Close is called.
+func (s *ObjectStorage) Subscribe(ctx context.Context, sessionID string, fromIndex int64) (<-chan Frame, error) {
+	if isClosed(s.done) {
+		return nil, errClosed("subscribe")
+	}
+
+	notify := make(chan struct{}, 1)
+
+	s.mu.Lock()
+	if s.watchers[sessionID] == nil {
+		s.watchers[sessionID] = make(map[chan struct{}]struct{})
+	}
+	s.watchers[sessionID][notify] = struct{}{}
+	s.mu.Unlock()
+
+	page := func(ctx context.Context, cursor int64) (FramePage, error) {
+		return s.ListFramesPage(ctx, sessionID, cursor, filePageSize)
+	}
+	unwatch := func() {
+		s.mu.Lock()
+		delete(s.watchers[sessionID], notify)
+		if len(s.watchers[sessionID]) == 0 {
+			delete(s.watchers, sessionID)
+		}
+		s.mu.Unlock()
+	}
+
+	return follow(ctx, s.done, notify, fromIndex, page, unwatch), nil
+}
+
+// ListSessions returns the IDs of all stored sessions, sorted alphabetically.
+func (s *ObjectStorage) ListSessions(_ context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := make([]string, 0, len(s.sessions))
+	for sessionID := range s.sessions {
+		sessions = append(sessions, sessionID)
+	}
+	sort.Strings(sessions)
+
+	return sessions, nil
+}
+
+// DeleteSession removes the manifest and all batch objects of a session,
+// including unreferenced batches left behind by a crash.
+//
+// Returns an error if the session doesn't exist or an object cannot be
+// deleted.
+func (s *ObjectStorage) DeleteSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		s.mu.Unlock()
+		return errSessionNotFound(sessionID)
+	}
+	delete(s.sessions, sessionID)
+	s.mu.Unlock()
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.deleted = true
+
+	// Delete the manifest first, so that a partial deletion leaves only
+	// unreferenced batches behind
+	if err := s.client.DeleteObject(ctx, s.manifestKey(sessionID)); err != nil {
+		return errUnavailable(fmt.Sprintf("failed to delete manifest of session %s", sessionID), err)
+	}
+
+	keys, err := s.client.ListObjects(ctx, s.sessionPrefix(sessionID)+"batches/")
+	if err != nil {
+		return errUnavailable(fmt.Sprintf("failed to list batches of session %s", sessionID), err)
+	}
+	for _, key := range keys {
+		if err := s.client.DeleteObject(ctx, key); err != nil {
+			return errUnavailable(fmt.Sprintf("failed to delete batch of session %s", sessionID), err)
+		}
+	}
+	return nil
+}
+
+// SetRetention sets the retention policy of a session, or the default policy
+// if sessionID is empty. Session policies are stored in the session manifest
+// with the next batch.
+//
+// This method always returns nil.
+func (s *ObjectStorage) SetRetention(_ context.Context, sessionID string, policy RetentionPolicy) error {
+	s.mu.Lock()
+	if sessionID == "" {
+		s.defaults = policy
+		s.mu.Unlock()
+		return nil
+	}
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		session = newObjectSession(sessionID)
+		s.sessions[sessionID] = session
+	}
+	s.mu.Unlock()
+
+	session.mu.Lock()
+	session.retention = &policy
+	session.dirty = true
+	session.mu.Unlock()
+	return nil
+}
+
+// SetCheckpoint records the index of the last frame of a session that a
+// consumer has processed. Checkpoints are stored in the session manifest
+// with the next batch, so a consumer may redo the frames it processed since
+// then after a crash.
+//
+// Returns an error if the session doesn't exist.
+func (s *ObjectStorage) SetCheckpoint(_ context.Context, consumer, sessionID string, index int64) error {
+	session, err := s.session(sessionID)
+	if err != nil {
+		return err
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	session.checkpoints[consumer] = index
+	session.dirty = true
+	return nil
+}
+
+// GetCheckpoint returns the checkpoint a consumer recorded for a session.
+// Returns an error if the session doesn't exist.
+func (s *ObjectStorage) GetCheckpoint(_ context.Context, consumer, sessionID string) (int64, bool, error) {
+	session, err := s.session(sessionID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	index, exists := session.checkpoints[consumer]
+	return index, exists, nil
+}
+
+// Flush writes the buffered frames of every session as batches and updates
+// the manifests.
+//
+// Returns the first error encountered; sessions that failed keep their
+// frames buffered.
+func (s *ObjectStorage) Flush(ctx context.Context) error {
+	s.mu.RLock()
+	sessions := make([]*objectSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mu.RUnlock()
+
+	var firstErr error
+	for _, session := range sessions {
+		session.mu.Lock()
+		err := s.flushLocked(ctx, session)
+		session.mu.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close stops the background flusher, ends all subscriptions and writes the
+// frames that are still buffered.
+//
+// Returns an error if buffered frames cannot be written.
+func (s *ObjectStorage) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		<-s.flushed
+		err = s.Flush(context.Background())
+	})
+	return err
+}
+
+// flushLoop periodically writes the batches of sessions whose oldest buffered
+// frame has waited for BatchAge. Failed writes are retried on the next tick.
+func (s *ObjectStorage) flushLoop() {
+	defer close(s.flushed)
+
+	ticker := time.NewTicker(s.config.BatchAge / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		s.mu.RLock()
+		sessions := make([]*objectSession, 0, len(s.sessions))
+		for _, session := range s.sessions {
+			sessions = append(sessions, session)
+		}
+		s.mu.RUnlock()
+
+		for _, session := range sessions {
+			session.mu.Lock()
+			if session.pending > 0 && time.Since(session.pendSince) >= s.config.BatchAge {
+				s.flushLocked(context.Background(), session)
+			}
+			session.mu.Unlock()
+		}
+	}
+}
+
+// flushLocked writes the buffered frames of a session as a new batch, then
+// the manifest, then deletes batches without frames left. The caller must
+// hold session.mu for writing.
+func (s *ObjectStorage) flushLocked(ctx context.Context, session *objectSession) error {
+	if session.deleted || (session.pending == 0 && !session.dirty) {
+		return nil
+	}
+
+	if session.pending > 0 {
+		batch := &objectBatch{seq: session.nextBatch}
+		var data []byte
+		offsets := make(map[int64]int64, session.pending)
+		for _, index := range session.indexes {
+			location := session.frames[index]
+			if location.frame == nil {
+				continue
+			}
+			envelope, err := EncodeFrame(*location.frame)
+			if err != nil {
+				return err
+			}
+			data = binary.BigEndian.AppendUint32(data, uint32(len(envelope)))
+			offsets[index] = int64(len(data))
+			data = append(data, envelope...)
+		}
+
+		if err := s.client.PutObject(ctx, s.batchKey(session.id, batch.seq), data); err != nil {
+			return errUnavailable(fmt.Sprintf("failed to write batch of session %s", session.id), err)
+		}
+
+		for index, offset := range offsets {
+			location := session.frames[index]
+			location.length = int64(binary.BigEndian.Uint32(data[offset-4 : offset]))
+			location.offset = offset
+			location.frame = nil
+			location.batch = batch
+			session.frames[index] = location
+			batch.live++
+		}
+		session.batches[batch.seq] = batch
+		session.nextBatch++
+		session.pending = 0
+		session.pendBytes = 0
+		session.dirty = true
+	}
+
+	manifest, err := json.Marshal(session.manifest())
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+	if err := s.client.PutObject(ctx, s.manifestKey(session.id), manifest); err != nil {
+		return errUnavailable(fmt.Sprintf("failed to write manifest of session %s", session.id), err)
+	}
+	session.dirty = false
+
+	// Batches no longer in the manifest can go
+	for seq, batch := range session.batches {
+		if batch.live > 0 {
+			continue
+		}
+		if err := s.client.DeleteObject(ctx, s.batchKey(session.id, seq)); err != nil {
+			return errUnavailable(fmt.Sprintf("failed to delete batch of session %s", session.id), err)
+		}
+		delete(session.batches, seq)
+	}
+	return nil
+}
+
+// loadSession reads the manifest of a session.
+func (s *ObjectStorage) loadSession(ctx context.Context, sessionID string) (*objectSession, error) {
+	data, err := s.client.GetObject(ctx, s.manifestKey(sessionID))
+	if err != nil {
+		return nil, errUnavailable(fmt.Sprintf("failed to read manifest of session %s", sessionID), err)
+	}
+
+	var manifest objectManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manifest of session %s: %v", sessionID, err)
+	}
+
+	session := newObjectSession(sessionID)
+	session.nextBatch = manifest.NextBatch
+	session.retention = manifest.Retention
+	if manifest.Checkpoints != nil {
+		session.checkpoints = manifest.Checkpoints
+	}
+	for _, mb := range manifest.Batches {
+		batch := &objectBatch{seq: mb.Seq, live: len(mb.Frames)}
+		session.batches[batch.seq] = batch
+		for _, mf := range mb.Frames {
+			session.frames[mf.Index] = objectLocation{
+				batch:     batch,
+				offset:    mf.Offset,
+				length:    mf.Length,
+				timestamp: mf.Timestamp,
+				size:      mf.Size,
+				keyFrame:  mf.KeyFrame,
+			}
+			session.indexes = append(session.indexes, mf.Index)
+			if mf.KeyFrame {
+				session.keyFrames = append(session.keyFrames, mf.Index)
+			}
+			session.size += mf.Size
+			if mf.Timestamp.After(session.newest) {
+				session.newest = mf.Timestamp
+			}
+		}
+	}
+	sort.Slice(session.indexes, func(i, j int) bool { return session.indexes[i] < session.indexes[j] })
+	sort.Slice(session.keyFrames, func(i, j int) bool { return session.keyFrames[i] < session.keyFrames[j] })
+
+	return session, nil
+}
+
+// session returns the state of a session.
+func (s *ObjectStorage) session(sessionID string) (*objectSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return nil, errSessionNotFound(sessionID)
+	}
+	return session, nil
+}
+
+// notify wakes up the subscribers of a session.
+func (s *ObjectStorage) notify(sessionID string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for notify := range s.watchers[sessionID] {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// readFrames reads the frames at the given locations in order.
+func (s *ObjectStorage) readFrames(ctx context.Context, sessionID string, locations []objectLocation) ([]Frame, error) {
+	frames := make([]Frame, 0, len(locations))
+	for _, location := range locations {
+		frame, err := s.readFrame(ctx, sessionID, location)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// readFrame returns a buffered frame or fetches it from its batch object.
+func (s *ObjectStorage) readFrame(ctx context.Context, sessionID string, location objectLocation) (Frame, error) {
+	if location.frame != nil {
+		return *location.frame, nil
+	}
+
+	data, err := s.client.GetObjectRange(ctx, s.batchKey(sessionID, location.batch.seq), location.offset, location.length)
+	if err != nil {
+		return Frame{}, errUnavailable(fmt.Sprintf("failed to read frame of session %s", sessionID), err)
+	}
+	return DecodeFrame(data)
+}
+
+// readMetadata returns the metadata of a buffered frame, or fetches the start
+// of its envelope from its batch object. Envelopes whose fields before the
+// frame data don't fit in metadataPrefixSize are fetched in full.
+func (s *ObjectStorage) readMetadata(ctx context.Context, sessionID string, location objectLocation) (FrameMetadata, error) {
+	if location.frame == nil && location.length > metadataPrefixSize {
+		prefix, err := s.client.GetObjectRange(ctx, s.batchKey(sessionID, location.batch.seq), location.offset, metadataPrefixSize)
+		if err != nil {
+			return FrameMetadata{}, errUnavailable(fmt.Sprintf("failed to read frame of session %s", sessionID), err)
+		}
+		if metadata, err := decodeMetadataPrefix(prefix, location.size); err == nil {
+			return metadata, nil
+		}
+	}
+
+	frame, err := s.readFrame(ctx, sessionID, location)
+	if err != nil {
+		return FrameMetadata{}, err
+	}
+	return frame.Metadata(), nil
+}
+
+// sessionPrefix returns the key prefix of all objects of a session.
+func (s *ObjectStorage) sessionPrefix(sessionID string) string {
+	return s.config.Prefix + "sessions/" + url.PathEscape(sessionID) + "/"
+}
+
+// manifestKey returns the key of a session's manifest.
+func (s *ObjectStorage) manifestKey(sessionID string) string {
+	return s.sessionPrefix(sessionID) + "manifest.json"
+}
+
+// batchKey returns the key of a batch object.
+func (s *ObjectStorage) batchKey(sessionID string, seq int64) string {
+	return fmt.Sprintf("%sbatches/%016d.bin", s.sessionPrefix(sessionID), seq)
+}
+
+// newObjectSession creates the state of an empty session.
+func newObjectSession(sessionID string) *objectSession {
+	return &objectSession{
+		id:          sessionID,
+		batches:     make(map[int64]*objectBatch),
+		frames:      make(map[int64]objectLocation),
+		checkpoints: make(map[string]int64),
+	}
+}
+
+// buffer adds a frame to the session's buffer, replacing any stored frame
+// with the same index.
+func (session *objectSession) buffer(frame Frame) {
+	if session.pending == 0 {
+		session.pendSince = time.Now()
+	}
+
+	if !session.remove(frame.Index) {
+		session.indexes = insertIndex(session.indexes, frame.Index)
+	}
+	session.frames[frame.Index] = objectLocation{
+		frame:     &frame,
+		timestamp: frame.Timestamp,
+		size:      int64(len(frame.Data)),
+		keyFrame:  frame.KeyFrame,
+	}
+	if frame.KeyFrame {
+		session.keyFrames = insertIndex(session.keyFrames, frame.Index)
+	}
+	session.pending++
+	session.pendBytes += int64(len(frame.Data))
+	session.size += int64(len(frame.Data))
+	if frame.Timestamp.After(session.newest) {
+		session.newest = frame.Timestamp
+	}
+}
+
+// remove drops a frame from the session's accounting but keeps its index.
+// It reports whether the frame existed.
+func (session *objectSession) remove(index int64) bool {
+	location, exists := session.frames[index]
+	if !exists {
+		return false
+	}
+
+	if location.frame != nil {
+		session.pending--
+		session.pendBytes -= location.size
+	} else {
+		location.batch.live--
+		session.dirty = true
+	}
+	session.size -= location.size
+	delete(session.frames, index)
+	session.keyFrames = removeIndex(session.keyFrames, index)
+	return true
+}
+
+// enforceRetention evicts the frames of the session that its retention
+// policy, or the given default policy if it has none, doesn't keep.
+func (session *objectSession) enforceRetention(defaults RetentionPolicy) {
+	policy := defaults
+	if session.retention != nil {
+		policy = *session.retention
+	}
+	if policy.IsZero() {
+		return
+	}
+
+	evicted := policy.evictions(session.indexes, session.newest, session.size, func(index int64) (time.Time, int64) {
+		location := session.frames[index]
+		return location.timestamp, location.size
+	})
+	for _, index := range evicted {
+		session.remove(index)
+		session.indexes = removeIndex(session.indexes, index)
+	}
+}
+
+// locate returns the locations of the given frame indexes.
+func (session *objectSession) locate(indexes []int64) []objectLocation {
+	locations := make([]objectLocation, 0, len(indexes))
+	for _, index := range indexes {
+		locations = append(locations, session.frames[index])
+	}
+	return locations
+}
+
+// manifest builds the stored form of the session's manifest from its
+// written frames.
+func (session *objectSession) manifest() objectManifest {
+	manifest := objectManifest{
+		NextBatch:   session.nextBatch,
+		Retention:   session.retention,
+		Checkpoints: session.checkpoints,
+		Batches:     make([]manifestBatch, 0, len(session.batches)),
+	}
+
+	byBatch := make(map[int64][]manifestFrame)
+	for _, index := range session.indexes {
+		location := session.frames[index]
+		if location.frame != nil {
+			continue
+		}
+		byBatch[location.batch.seq] = append(byBatch[location.batch.seq], manifestFrame{
+			Index:     index,
+			Offset:    location.offset,
+			Length:    location.length,
+			Timestamp: location.timestamp,
+			Size:      location.size,
+			KeyFrame:  location.keyFrame,
+		})
+	}
+
+	for seq, frames := range byBatch {
+		manifest.Batches = append(manifest.Batches, manifestBatch{Seq: seq, Frames: frames})
+	}
+	sort.Slice(manifest.Batches, func(i, j int) bool { return manifest.Batches[i].Seq < manifest.Batches[j].Seq })
+	return manifest
+}
@@ -3,23 +3,46 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/relais/pkg/util"
 )
 
 // RedisStorage implements the Storage interface using Redis as the backend.
 // This implementation provides persistent storage and is suitable for production
-// use cases where data needs to survive process restarts. It uses Redis Lists
-// to store frames for each session and Redis Sets to track active sessions.
+// use cases where data needs to survive process restarts. Frames are stored in
+// the binary envelope of EncodeFrame; JSON frames written by earlier versions
+// remain readable. It uses Redis Hashes to store frames for each session,
+// Sorted Sets to index them by frame index and timestamp, and Redis Sets to
+// track active sessions.
 //
 // Key Schema:
-// - Session frames: "frames:{sessionID}" (List)
+// - Session frames: "frames:{sessionID}:data" (Hash, frame index -> frame)
+// - Frame index: "frames:{sessionID}:index" (Sorted Set, scored by frame index)
+// - Timestamp index: "frames:{sessionID}:time" (Sorted Set, scored by Unix microseconds)
+// - Key frame index: "frames:{sessionID}:keyframes" (Sorted Set, key frames scored by frame index)
+// - Frame sizes: "frames:{sessionID}:sizes" (Hash, frame index -> data size)
+// - Frame metadata: "frames:{sessionID}:meta" (Hash, frame index -> metadata)
+// - Session size: "frames:{sessionID}:bytes" (String counter)
 // - Active sessions: "active_sessions" (Set)
+// - Retention policies: "retention" (Hash, session ID -> policy)
+// - New frame notifications: "frames:{sessionID}:events" (Pub/Sub channel, frame index)
+//
+// Earlier versions stored the frames of a session as JSON in a List at
+// "frames:{sessionID}". Such sessions are migrated to the schema above when
+// the storage is created; see migrateLegacy.
 //
 // Performance Considerations:
 // - Uses pipelining for batch operations where possible
+// - Writes and retention enforcement run in a single Lua script round trip
+// - Single frame lookups are O(1) and range lookups are O(log n)
 // - Implements efficient session tracking using Redis Sets
 // - Handles Redis connection errors and retries
 //
@@ -27,8 +50,12 @@ import (
 // All operations are thread-safe as Redis handles concurrent access.
 // The client connection is safe for concurrent use by multiple goroutines.
 type RedisStorage struct {
-	client *redis.Client // Redis client connection
-	prefix string        // Key prefix for namespacing (e.g., "myapp:")
+	client    *redis.Client   // Redis client connection
+	prefix    string          // Key prefix for namespacing (e.g., "myapp:")
+	mu        sync.RWMutex    // Protects defaults
+	defaults  RetentionPolicy // Retention policy for sessions without their own
+	done      chan struct{}   // Closed by Close to end all subscriptions
+	closeOnce sync.Once       // Guards closing of done and the client
 }
 
 // RedisConfig holds configuration options for RedisStorage.
@@ -37,6 +64,8 @@ type RedisConfig struct {
 	Password string // Redis password (optional)
 	DB       int    // Redis database number
 	Prefix   string // Key prefix for namespacing (optional)
+
+	Retention RetentionPolicy // Default retention policy for all sessions (optional)
 }
 
 // NewRedisStorage creates a new RedisStorage instance.
@@ -47,83 +76,314 @@ type RedisConfig struct {
 // - Cannot connect to Redis server
 // - Invalid configuration parameters
 // - Redis ping fails
+// - Sessions stored by earlier versions cannot be migrated
 func NewRedisStorage(config interface{}) (*RedisStorage, error) {
-	var client *redis.Client
+	client, cfg, err := newRedisClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &RedisStorage{
+		client:   client,
+		prefix:   cfg.Prefix,
+		defaults: cfg.Retention,
+		done:     make(chan struct{}),
+	}
+	if err := s.migrateLegacy(context.Background()); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// newRedisClient creates and verifies a Redis client from either a Redis
+// address string or a RedisConfig. It returns the effective configuration
+// alongside the client so callers can pick up the remaining options.
+func newRedisClient(config interface{}) (*redis.Client, RedisConfig, error) {
+	var cfg RedisConfig
 
-	switch cfg := config.(type) {
+	switch c := config.(type) {
 	case string:
 		// Backward compatibility: treat string as Redis address
-		client = redis.NewClient(&redis.Options{
-			Addr: cfg,
-		})
+		cfg = RedisConfig{Addr: c}
 	case RedisConfig:
-		client = redis.NewClient(&redis.Options{
-			Addr:     cfg.Addr,
-			Password: cfg.Password,
-			DB:       cfg.DB,
-		})
+		cfg = c
 	default:
-		return nil, fmt.Errorf("invalid configuration type: expected string or RedisConfig")
+		return nil, RedisConfig{}, fmt.Errorf("invalid configuration type: expected string or RedisConfig")
 	}
 
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
 	// Verify connection
 	if err := client.Ping(context.Background()).Err(); err != nil {
-		return nil, fmt.Errorf("redis connection failed: %v", err)
+		client.Close()
+		return nil, RedisConfig{}, redisError("redis connection failed", err)
 	}
 
-	prefix := ""
-	if cfg, ok := config.(RedisConfig); ok {
-		prefix = cfg.Prefix
-	}
+	return client, cfg, nil
+}
 
-	return &RedisStorage{
-		client: client,
-		prefix: prefix,
-	}, nil
+// redisError wraps an error returned by the Redis client. Calls on a closed
+// client report ErrClosed, every other failure ErrBackendUnavailable.
+func redisError(message string, err error) error {
+	if errors.Is(err, redis.ErrClosed) {
+		return util.NewError(util.ErrorTypeStorage, message, fmt.Errorf("%w: %w", ErrClosed, err))
+	}
+	return errUnavailable(message, err)
 }
 
-// frameKey generates the Redis key for storing frames of a session.
+// frameKey generates the base Redis key of a session, from which the keys of
+// its frames and indexes are derived. Earlier versions stored the frames of
+// the session in a List at this key.
 func (s *RedisStorage) frameKey(sessionID string) string {
 	return fmt.Sprintf("%sframes:%s", s.prefix, sessionID)
 }
 
+// dataKey generates the Redis key of the Hash holding the frames of a session.
+func (s *RedisStorage) dataKey(sessionID string) string {
+	return s.frameKey(sessionID) + ":data"
+}
+
+// indexKey generates the Redis key of the sorted set indexing a session's
+// frames by frame index.
+func (s *RedisStorage) indexKey(sessionID string) string {
+	return s.frameKey(sessionID) + ":index"
+}
+
+// timeKey generates the Redis key of the sorted set indexing a session's
+// frames by timestamp.
+func (s *RedisStorage) timeKey(sessionID string) string {
+	return s.frameKey(sessionID) + ":time"
+}
+
+// keyFrameKey generates the Redis key of the sorted set indexing a session's
+// key frames by frame index.
+func (s *RedisStorage) keyFrameKey(sessionID string) string {
+	return s.frameKey(sessionID) + ":keyframes"
+}
+
+// sizesKey generates the Redis key of the Hash holding the data size of each
+// frame of a session, used to keep the session size counter accurate.
+func (s *RedisStorage) sizesKey(sessionID string) string {
+	return s.frameKey(sessionID) + ":sizes"
+}
+
+// bytesKey generates the Redis key of the counter holding the total data
+// size of a session.
+func (s *RedisStorage) bytesKey(sessionID string) string {
+	return s.frameKey(sessionID) + ":bytes"
+}
+
+// metadataKey generates the Redis key of the Hash holding the metadata of
+// each frame of a session, so that it can be listed without frame data.
+func (s *RedisStorage) metadataKey(sessionID string) string {
+	return s.frameKey(sessionID) + ":meta"
+}
+
+// checkpointKey generates the Redis key of the Hash holding the checkpoint
+// of each consumer of a session.
+func (s *RedisStorage) checkpointKey(sessionID string) string {
+	return s.frameKey(sessionID) + ":checkpoints"
+}
+
+// retentionKey generates the Redis key of the Hash holding per-session
+// retention policies.
+func (s *RedisStorage) retentionKey() string {
+	return s.prefix + "retention"
+}
+
+// eventChannel generates the Redis Pub/Sub channel on which newly stored
+// frames of a session are published.
+func (s *RedisStorage) eventChannel(sessionID string) string {
+	return s.frameKey(sessionID) + ":events"
+}
+
 // sessionKey generates the Redis key for the active sessions set.
 func (s *RedisStorage) sessionKey() string {
 	return s.prefix + "active_sessions"
 }
 
+// timeScore converts a frame timestamp to a sorted set score.
+// Microseconds are used because Unix nanoseconds exceed the integer
+// precision of the float64 scores used by Redis.
+func timeScore(t time.Time) float64 {
+	return float64(t.UnixMicro())
+}
+
+// putFrameScript stores a frame, indexes it, enforces the session's retention
+// policy and notifies subscribers in a single atomic step. Only the index of
+// the frame is published; subscribers read the frame itself from the Hash.
+//
+// KEYS: frames, index, time, sizes, bytes, active sessions, retention,
+// metadata, key frames
+// ARGV: member, frame, index score, time score, size, session ID,
+// default policy, event channel, metadata, key frame flag
+var putFrameScript = redis.NewScript(`
+local member = ARGV[1]
+
+local old = redis.call('HGET', KEYS[4], member)
+if old then
+	redis.call('DECRBY', KEYS[5], old)
+end
+redis.call('HSET', KEYS[1], member, ARGV[2])
+redis.call('HSET', KEYS[8], member, ARGV[9])
+redis.call('HSET', KEYS[4], member, ARGV[5])
+redis.call('INCRBY', KEYS[5], ARGV[5])
+redis.call('ZADD', KEYS[2], ARGV[3], member)
+redis.call('ZADD', KEYS[3], ARGV[4], member)
+if ARGV[10] == '1' then
+	redis.call('ZADD', KEYS[9], ARGV[3], member)
+else
+	redis.call('ZREM', KEYS[9], member)
+end
+redis.call('SADD', KEYS[6], ARGV[6])
+
+local policy = redis.call('HGET', KEYS[7], ARGV[6])
+if not policy then
+	policy = ARGV[7]
+end
+policy = cjson.decode(policy)
+
+local function evict(m)
+	local size = redis.call('HGET', KEYS[4], m)
+	if size then
+		redis.call('DECRBY', KEYS[5], size)
+	end
+	redis.call('HDEL', KEYS[1], m)
+	redis.call('HDEL', KEYS[8], m)
+	redis.call('HDEL', KEYS[4], m)
+	redis.call('ZREM', KEYS[2], m)
+	redis.call('ZREM', KEYS[3], m)
+	redis.call('ZREM', KEYS[9], m)
+end
+
+if policy.max_frames > 0 then
+	local excess = redis.call('ZCARD', KEYS[2]) - policy.max_frames
+	if excess > 0 then
+		for _, m in ipairs(redis.call('ZRANGE', KEYS[2], 0, excess - 1)) do
+			evict(m)
+		end
+	end
+end
+
+if policy.max_age > 0 then
+	local newest = redis.call('ZRANGE', KEYS[3], -1, -1, 'WITHSCORES')
+	if newest[2] then
+		local cutoff = tonumber(newest[2]) - policy.max_age
+		for _, m in ipairs(redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', '(' .. string.format('%.0f', cutoff))) do
+			evict(m)
+		end
+	end
+end
+
+if policy.max_bytes > 0 then
+	while tonumber(redis.call('GET', KEYS[5]) or '0') > policy.max_bytes do
+		local oldest = redis.call('ZRANGE', KEYS[2], 0, 0)
+		if #oldest == 0 then
+			break
+		end
+		evict(oldest[1])
+	end
+end
+
+redis.call('PUBLISH', ARGV[8], member)
+return 0
+`)
+
+// redisPolicy is the representation of a RetentionPolicy stored in Redis and
+// read by putFrameScript. MaxAge is stored in microseconds to match the
+// timestamp index scores.
+type redisPolicy struct {
+	MaxFrames int   `json:"max_frames"`
+	MaxAge    int64 `json:"max_age"`
+	MaxBytes  int64 `json:"max_bytes"`
+}
+
+// encodePolicy serializes a retention policy for putFrameScript.
+func encodePolicy(policy RetentionPolicy) (string, error) {
+	data, err := json.Marshal(redisPolicy{
+		MaxFrames: policy.MaxFrames,
+		MaxAge:    policy.MaxAge.Microseconds(),
+		MaxBytes:  policy.MaxBytes,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal retention policy: %v", err)
+	}
+	return string(data), nil
+}
+
 // PutFrame stores a frame in Redis.
-// The frame is serialized to JSON and stored in a Redis List.
-// The session ID is also added to the active sessions set.
+// The frame is serialized with EncodeFrame and stored in the session's Hash
+// under its index, replacing any frame previously stored with the same index.
+// The index and timestamp sorted sets are updated, the session ID is added to
+// the active sessions set, the session's retention policy is enforced, and the
+// frame index is published on the session's event channel for subscribers.
 //
-// The operation is atomic: either both the frame is stored and the session
-// is tracked, or neither operation occurs.
+// The operation is atomic: all of the above runs in a single Lua script, so
+// either the frame is stored and the session is tracked, or neither occurs.
 func (s *RedisStorage) PutFrame(ctx context.Context, frame Frame) error {
-	// Serialize frame to JSON
-	frameJSON, err := json.Marshal(frame)
+	if isClosed(s.done) {
+		return errClosed("put frame")
+	}
+
+	// Serialize frame to the binary envelope
+	encoded, err := EncodeFrame(frame)
 	if err != nil {
 		return fmt.Errorf("failed to marshal frame: %v", err)
 	}
+	metadata, err := encodeMetadata(frame)
+	if err != nil {
+		return fmt.Errorf("failed to marshal frame metadata: %v", err)
+	}
 
-	// Create a pipeline for atomic operations
-	pipe := s.client.Pipeline()
-
-	// Add frame to the session's list
-	pipe.RPush(ctx, s.frameKey(frame.SessionID), frameJSON)
+	s.mu.RLock()
+	defaults, err := encodePolicy(s.defaults)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
 
-	// Track the session
-	pipe.SAdd(ctx, s.sessionKey(), frame.SessionID)
+	keys := []string{
+		s.dataKey(frame.SessionID),
+		s.indexKey(frame.SessionID),
+		s.timeKey(frame.SessionID),
+		s.sizesKey(frame.SessionID),
+		s.bytesKey(frame.SessionID),
+		s.sessionKey(),
+		s.retentionKey(),
+		s.metadataKey(frame.SessionID),
+		s.keyFrameKey(frame.SessionID),
+	}
+	keyFrame := "0"
+	if frame.KeyFrame {
+		keyFrame = "1"
+	}
+	args := []interface{}{
+		strconv.FormatInt(frame.Index, 10),
+		encoded,
+		frame.Index,
+		timeScore(frame.Timestamp),
+		len(frame.Data),
+		frame.SessionID,
+		defaults,
+		s.eventChannel(frame.SessionID),
+		metadata,
+		keyFrame,
+	}
 
-	// Execute pipeline
-	if _, err := pipe.Exec(ctx); err != nil {
-		return fmt.Errorf("failed to store frame: %v", err)
+	if err := putFrameScript.Run(ctx, s.client, keys, args...).Err(); err != nil {
+		return redisError("failed to store frame", err)
 	}
 
 	return nil
 }
 
 // GetFrame retrieves a specific frame from Redis by session ID and frame index.
-// It scans the session's frame list to find the frame with the matching index.
+// The frame is read directly from the session's Hash.
 //
 // Returns an error if:
 // - Session doesn't exist
@@ -132,75 +392,364 @@ func (s *RedisStorage) PutFrame(ctx context.Context, frame Frame) error {
 // - Frame data is corrupted
 func (s *RedisStorage) GetFrame(ctx context.Context, sessionID string, frameIndex int64) (Frame, error) {
 	// Check if session exists
-	exists, err := s.client.SIsMember(ctx, s.sessionKey(), sessionID).Result()
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return Frame{}, err
+	}
+
+	encoded, err := s.client.HGet(ctx, s.dataKey(sessionID), strconv.FormatInt(frameIndex, 10)).Bytes()
+	if err == redis.Nil {
+		return Frame{}, errFrameNotFound(sessionID, frameIndex)
+	}
 	if err != nil {
-		return Frame{}, fmt.Errorf("failed to check session: %v", err)
+		return Frame{}, redisError("failed to get frame", err)
 	}
-	if !exists {
-		return Frame{}, fmt.Errorf("session not found: %s", sessionID)
+
+	return DecodeFrame(encoded)
+}
+
+// ListFrames returns all frames for a given session, sorted by frame index.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Redis operation fails
+// - Frame data is corrupted
+func (s *RedisStorage) ListFrames(ctx context.Context, sessionID string) ([]Frame, error) {
+	return s.ListFramesRange(ctx, sessionID, math.MinInt64, math.MaxInt64)
+}
+
+// ListFramesRange returns the frames of a session with an index between
+// fromIndex and toIndex (both inclusive), sorted by frame index.
+// The range is resolved through the index sorted set and only the matching
+// frames are fetched from the Hash.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Redis operation fails
+// - Frame data is corrupted
+func (s *RedisStorage) ListFramesRange(ctx context.Context, sessionID string, fromIndex, toIndex int64) ([]Frame, error) {
+	// Check if session exists
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return nil, err
+	}
+
+	members, err := s.client.ZRangeByScore(ctx, s.indexKey(sessionID), &redis.ZRangeBy{
+		Min: scoreBound(fromIndex),
+		Max: scoreBound(toIndex),
+	}).Result()
+	if err != nil {
+		return nil, redisError("failed to get frame index", err)
+	}
+
+	return s.fetchFrames(ctx, sessionID, members)
+}
+
+// ListFrameMetadata returns the metadata of the frames of a session with an
+// index between fromIndex and toIndex (both inclusive), sorted by frame index.
+// Metadata is read from a Hash kept next to the frames, so no frame data is
+// transferred. Frames stored before metadata was kept are read in full.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Redis operation fails
+// - Metadata is corrupted
+func (s *RedisStorage) ListFrameMetadata(ctx context.Context, sessionID string, fromIndex, toIndex int64) ([]FrameMetadata, error) {
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return nil, err
+	}
+
+	members, err := s.client.ZRangeByScore(ctx, s.indexKey(sessionID), &redis.ZRangeBy{
+		Min: scoreBound(fromIndex),
+		Max: scoreBound(toIndex),
+	}).Result()
+	if err != nil {
+		return nil, redisError("failed to get frame index", err)
+	}
+
+	metadata := make([]FrameMetadata, 0, len(members))
+	if len(members) == 0 {
+		return metadata, nil
 	}
 
-	// Get all frames for the session
-	frameList, err := s.client.LRange(ctx, s.frameKey(sessionID), 0, -1).Result()
+	values, err := s.client.HMGet(ctx, s.metadataKey(sessionID), members...).Result()
 	if err != nil {
-		return Frame{}, fmt.Errorf("failed to get frames: %v", err)
+		return nil, redisError("failed to get frame metadata", err)
 	}
 
-	// Find frame with matching index
-	for _, frameJSON := range frameList {
-		var frame Frame
-		if err := json.Unmarshal([]byte(frameJSON), &frame); err != nil {
-			return Frame{}, fmt.Errorf("failed to unmarshal frame: %v", err)
+	for i, value := range values {
+		encoded, ok := value.(string)
+		if !ok {
+			// Frame was deleted meanwhile, or stored without metadata
+			frames, err := s.fetchFrames(ctx, sessionID, members[i:i+1])
+			if err != nil {
+				return nil, err
+			}
+			for _, frame := range frames {
+				metadata = append(metadata, frame.Metadata())
+			}
+			continue
 		}
-		if frame.Index == frameIndex {
-			return frame, nil
+
+		m, err := decodeMetadata([]byte(encoded))
+		if err != nil {
+			return nil, err
 		}
+		metadata = append(metadata, m)
 	}
 
-	return Frame{}, fmt.Errorf("frame not found: session %s, index %d", sessionID, frameIndex)
+	return metadata, nil
 }
 
-// ListFrames returns all frames for a given session, sorted by frame index.
-// The frames are retrieved from the Redis List and deserialized from JSON.
+// LatestKeyFrame returns the key frame of a session with the highest index.
+// It is looked up in the key frame sorted set and read from the session's
+// Hash.
 //
 // Returns an error if:
 // - Session doesn't exist
+// - Session holds no key frame
 // - Redis operation fails
 // - Frame data is corrupted
-func (s *RedisStorage) ListFrames(ctx context.Context, sessionID string) ([]Frame, error) {
+func (s *RedisStorage) LatestKeyFrame(ctx context.Context, sessionID string) (Frame, error) {
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return Frame{}, err
+	}
+
+	members, err := s.client.ZRevRange(ctx, s.keyFrameKey(sessionID), 0, 0).Result()
+	if err != nil {
+		return Frame{}, redisError("failed to get key frame index", err)
+	}
+
+	frames, err := s.fetchFrames(ctx, sessionID, members)
+	if err != nil {
+		return Frame{}, err
+	}
+	if len(frames) == 0 {
+		return Frame{}, errNoKeyFrame(sessionID)
+	}
+	return frames[0], nil
+}
+
+// ListFramesPage returns up to limit frames of a session starting at the
+// frame index given by cursor. One extra index entry is read to tell whether
+// more frames follow the page.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Limit is not positive
+// - Redis operation fails
+// - Frame data is corrupted
+func (s *RedisStorage) ListFramesPage(ctx context.Context, sessionID string, cursor int64, limit int) (FramePage, error) {
+	if limit <= 0 {
+		return FramePage{}, fmt.Errorf("invalid page limit: %d", limit)
+	}
+
 	// Check if session exists
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return FramePage{}, err
+	}
+
+	members, err := s.client.ZRangeByScore(ctx, s.indexKey(sessionID), &redis.ZRangeBy{
+		Min:   scoreBound(cursor),
+		Max:   "+inf",
+		Count: int64(limit) + 1,
+	}).Result()
+	if err != nil {
+		return FramePage{}, redisError("failed to get frame index", err)
+	}
+
+	page := FramePage{NextCursor: cursor}
+	if len(members) > limit {
+		page.HasMore = true
+		members = members[:limit]
+	}
+
+	page.Frames, err = s.fetchFrames(ctx, sessionID, members)
+	if err != nil {
+		return FramePage{}, err
+	}
+	if len(page.Frames) > 0 {
+		page.NextCursor = page.Frames[len(page.Frames)-1].Index + 1
+	}
+
+	return page, nil
+}
+
+// ListFramesByTime returns the frames of a session whose Timestamp lies
+// between start and end (both inclusive), sorted by frame index.
+// The range is resolved through the timestamp sorted set, at microsecond
+// precision.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Redis operation fails
+// - Frame data is corrupted
+func (s *RedisStorage) ListFramesByTime(ctx context.Context, sessionID string, start, end time.Time) ([]Frame, error) {
+	// Check if session exists
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return nil, err
+	}
+
+	members, err := s.client.ZRangeByScore(ctx, s.timeKey(sessionID), &redis.ZRangeBy{
+		Min: strconv.FormatFloat(timeScore(start), 'f', -1, 64),
+		Max: strconv.FormatFloat(timeScore(end), 'f', -1, 64),
+	}).Result()
+	if err != nil {
+		return nil, redisError("failed to get timestamp index", err)
+	}
+
+	frames, err := s.fetchFrames(ctx, sessionID, members)
+	if err != nil {
+		return nil, err
+	}
+
+	// Timestamps don't necessarily follow frame indexes
+	sort.Slice(frames, func(i, j int) bool {
+		return frames[i].Index < frames[j].Index
+	})
+
+	return frames, nil
+}
+
+// checkSession returns an error if the session is not in the active sessions set.
+func (s *RedisStorage) checkSession(ctx context.Context, sessionID string) error {
 	exists, err := s.client.SIsMember(ctx, s.sessionKey(), sessionID).Result()
 	if err != nil {
-		return nil, fmt.Errorf("failed to check session: %v", err)
+		return redisError("failed to check session", err)
 	}
 	if !exists {
-		return nil, fmt.Errorf("session not found: %s", sessionID)
+		return errSessionNotFound(sessionID)
+	}
+	return nil
+}
+
+// fetchFrames reads the frames with the given index members from the
+// session's Hash, preserving the order of members.
+func (s *RedisStorage) fetchFrames(ctx context.Context, sessionID string, members []string) ([]Frame, error) {
+	frames := make([]Frame, 0, len(members))
+	if len(members) == 0 {
+		return frames, nil
 	}
 
-	// Get all frames for the session
-	frameList, err := s.client.LRange(ctx, s.frameKey(sessionID), 0, -1).Result()
+	values, err := s.client.HMGet(ctx, s.dataKey(sessionID), members...).Result()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get frames: %v", err)
+		return nil, redisError("failed to get frames", err)
 	}
 
 	// Deserialize frames
-	frames := make([]Frame, 0, len(frameList))
-	for _, frameJSON := range frameList {
-		var frame Frame
-		if err := json.Unmarshal([]byte(frameJSON), &frame); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal frame: %v", err)
+	for _, value := range values {
+		encoded, ok := value.(string)
+		if !ok {
+			// Frame was deleted between the index and data reads
+			continue
+		}
+
+		frame, err := DecodeFrame([]byte(encoded))
+		if err != nil {
+			return nil, err
 		}
 		frames = append(frames, frame)
 	}
 
-	// Sort frames by index
-	sort.Slice(frames, func(i, j int) bool {
-		return frames[i].Index < frames[j].Index
-	})
-
 	return frames, nil
 }
 
+// scoreBound formats a frame index as a sorted set score bound, mapping
+// the extremes of int64 to infinite bounds.
+func scoreBound(index int64) string {
+	switch index {
+	case math.MinInt64:
+		return "-inf"
+	case math.MaxInt64:
+		return "+inf"
+	default:
+		return strconv.FormatInt(index, 10)
+	}
+}
+
+// Subscribe delivers frames of a session as they are stored.
+// It subscribes to the session's Pub/Sub channel before reading the backlog
+// of stored frames, so no frame written in between is lost. Frames that show
+// up both in the backlog and on the channel are delivered only once. The
+// channel carries frame indexes, and each announced frame is read from the
+// session's Hash; frames evicted before they are read are skipped.
+//
+// The returned channel is closed when ctx is cancelled, the Redis connection
+// is lost, Close is called, or the subscriber falls more than
+// MaxPendingFrames frames behind.
+//
+// Returns an error if the Pub/Sub subscription or the backlog read fails.
+func (s *RedisStorage) Subscribe(ctx context.Context, sessionID string, fromIndex int64) (<-chan Frame, error) {
+	if isClosed(s.done) {
+		return nil, errClosed("subscribe")
+	}
+
+	pubsub := s.client.Subscribe(ctx, s.eventChannel(sessionID))
+
+	// Wait for the subscription to be confirmed before reading the backlog
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, redisError("failed to subscribe", err)
+	}
+
+	members, err := s.client.ZRangeByScore(ctx, s.indexKey(sessionID), &redis.ZRangeBy{
+		Min: scoreBound(fromIndex),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		pubsub.Close()
+		return nil, redisError("failed to get frame index", err)
+	}
+	backlog, err := s.fetchFrames(ctx, sessionID, members)
+	if err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	// Next index to deliver, live frames below it were already delivered
+	next := fromIndex
+	if len(backlog) > 0 {
+		next = backlog[len(backlog)-1].Index + 1
+	}
+
+	sub := newSubscription(backlog)
+	go sub.run(ctx, s.done)
+
+	go func() {
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.done:
+				return
+			case <-sub.dropped:
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				index, err := strconv.ParseInt(msg.Payload, 10, 64)
+				// Skip frames already delivered, including overwrites
+				if err != nil || index < next {
+					continue
+				}
+				frames, err := s.fetchFrames(ctx, sessionID, []string{msg.Payload})
+				if err != nil {
+					return
+				}
+				for _, frame := range frames {
+					sub.push(frame)
+					next = frame.Index + 1
+				}
+			}
+		}
+	}()
+
+	return sub.out, nil
+}
+
 // ListSessions returns all active session IDs.
 // The session IDs are retrieved from the Redis Set and sorted alphabetically.
 //
@@ -209,7 +758,7 @@ func (s *RedisStorage) ListSessions(ctx context.Context) ([]string, error) {
 	// Get all session IDs from the set
 	sessions, err := s.client.SMembers(ctx, s.sessionKey()).Result()
 	if err != nil {
-		return nil, fmt.Errorf("failed to list sessions: %v", err)
+		return nil, redisError("failed to list sessions", err)
 	}
 
 	// Sort sessions for consistent ordering
@@ -217,44 +766,225 @@ func (s *RedisStorage) ListSessions(ctx context.Context) ([]string, error) {
 	return sessions, nil
 }
 
-// DeleteSession removes all frames for a given session and removes it from
-// the active sessions set. The operation is atomic: either both the frames
-// are deleted and the session is removed from tracking, or neither operation occurs.
+// DeleteSession removes all frames and indexes for a given session and removes
+// it from the active sessions set. The operation is atomic: either both the
+// frames are deleted and the session is removed from tracking, or neither
+// operation occurs.
 //
 // Returns an error if:
 // - Session doesn't exist
 // - Redis operation fails
 func (s *RedisStorage) DeleteSession(ctx context.Context, sessionID string) error {
 	// Check if session exists
-	exists, err := s.client.SIsMember(ctx, s.sessionKey(), sessionID).Result()
-	if err != nil {
-		return fmt.Errorf("failed to check session: %v", err)
-	}
-	if !exists {
-		return fmt.Errorf("session not found: %s", sessionID)
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return err
 	}
 
 	// Create pipeline for atomic operations
 	pipe := s.client.Pipeline()
 
-	// Delete session's frame list
-	pipe.Del(ctx, s.frameKey(sessionID))
-
-	// Remove from active sessions set
+	// Delete session's frames and indexes
+	pipe.Del(ctx,
+		s.frameKey(sessionID),
+		s.dataKey(sessionID),
+		s.indexKey(sessionID),
+		s.timeKey(sessionID),
+		s.sizesKey(sessionID),
+		s.bytesKey(sessionID),
+		s.metadataKey(sessionID),
+		s.keyFrameKey(sessionID),
+		s.checkpointKey(sessionID),
+	)
+
+	// Remove from active sessions set and drop its retention policy
 	pipe.SRem(ctx, s.sessionKey(), sessionID)
+	pipe.HDel(ctx, s.retentionKey(), sessionID)
 
 	// Execute pipeline
 	if _, err := pipe.Exec(ctx); err != nil {
-		return fmt.Errorf("failed to delete session: %v", err)
+		return redisError("failed to delete session", err)
+	}
+
+	return nil
+}
+
+// SetRetention sets the retention policy of a session, or the default policy
+// if sessionID is empty. Per-session policies are stored in Redis so that every
+// process writing to the session enforces them; the default policy is local to
+// this RedisStorage instance.
+//
+// Returns an error if the Redis operation fails.
+func (s *RedisStorage) SetRetention(ctx context.Context, sessionID string, policy RetentionPolicy) error {
+	if sessionID == "" {
+		s.mu.Lock()
+		s.defaults = policy
+		s.mu.Unlock()
+		return nil
+	}
+
+	encoded, err := encodePolicy(policy)
+	if err != nil {
+		return err
+	}
+	if err := s.client.HSet(ctx, s.retentionKey(), sessionID, encoded).Err(); err != nil {
+		return redisError("failed to set retention policy", err)
+	}
+	return nil
+}
+
+// SetCheckpoint records the index of the last frame of a session that a
+// consumer has processed. Checkpoints are stored in a Hash next to the
+// session's frames, so consumers resume where they left off after a restart.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Redis operation fails
+func (s *RedisStorage) SetCheckpoint(ctx context.Context, consumer, sessionID string, index int64) error {
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return err
+	}
+	return setRedisCheckpoint(ctx, s.client, s.checkpointKey(sessionID), consumer, index)
+}
+
+// GetCheckpoint returns the checkpoint a consumer recorded for a session.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Redis operation fails
+func (s *RedisStorage) GetCheckpoint(ctx context.Context, consumer, sessionID string) (int64, bool, error) {
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return 0, false, err
+	}
+	return getRedisCheckpoint(ctx, s.client, s.checkpointKey(sessionID), consumer)
+}
+
+// setRedisCheckpoint stores the checkpoint of a consumer in a Hash.
+func setRedisCheckpoint(ctx context.Context, client *redis.Client, key, consumer string, index int64) error {
+	if err := client.HSet(ctx, key, consumer, index).Err(); err != nil {
+		return redisError("failed to set checkpoint", err)
+	}
+	return nil
+}
+
+// getRedisCheckpoint reads the checkpoint of a consumer from a Hash.
+func getRedisCheckpoint(ctx context.Context, client *redis.Client, key, consumer string) (int64, bool, error) {
+	index, err := client.HGet(ctx, key, consumer).Int64()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, redisError("failed to get checkpoint", err)
+	}
+	return index, true, nil
+}
+
+// legacyMigrationAttempts bounds how often the migration of a session is
+// retried when its legacy List changes while being migrated.
+const legacyMigrationAttempts = 3
+
+// migrateLegacy moves the frames of sessions stored in the List layout of
+// earlier versions, a JSON frame per entry at frameKey, to the current key
+// schema. Frames are written in index order through PutFrame, so indexes,
+// metadata and retention are applied as for new frames; frames whose index is
+// already stored in the current schema are kept. The List is deleted once its
+// frames are migrated.
+//
+// The List is watched while it is migrated, so that frames appended meanwhile
+// by a process still running an earlier version cause the session to be
+// migrated again rather than be lost.
+//
+// Returns an error if a Redis operation fails or a legacy frame is corrupted.
+func (s *RedisStorage) migrateLegacy(ctx context.Context) error {
+	sessions, err := s.client.SMembers(ctx, s.sessionKey()).Result()
+	if err != nil {
+		return redisError("failed to list sessions", err)
+	}
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	pipe := s.client.Pipeline()
+	types := make([]*redis.StatusCmd, len(sessions))
+	for i, sessionID := range sessions {
+		types[i] = pipe.Type(ctx, s.frameKey(sessionID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return redisError("failed to check session layout", err)
 	}
 
+	for i, sessionID := range sessions {
+		if types[i].Val() != "list" {
+			continue
+		}
+		if err := s.migrateLegacySession(ctx, sessionID); err != nil {
+			return fmt.Errorf("failed to migrate session %s: %w", sessionID, err)
+		}
+	}
 	return nil
 }
 
-// Close closes the Redis client connection and cleans up resources.
-// After Close is called, no other methods should be called on this instance.
+// migrateLegacySession migrates the legacy List of a single session. See
+// migrateLegacy.
+func (s *RedisStorage) migrateLegacySession(ctx context.Context, sessionID string) error {
+	key := s.frameKey(sessionID)
+
+	migrate := func(tx *redis.Tx) error {
+		entries, err := tx.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return redisError("failed to read legacy frames", err)
+		}
+
+		frames := make([]Frame, 0, len(entries))
+		for _, entry := range entries {
+			frame, err := DecodeFrame([]byte(entry))
+			if err != nil {
+				return err
+			}
+			frames = append(frames, frame)
+		}
+		sort.SliceStable(frames, func(i, j int) bool {
+			return frames[i].Index < frames[j].Index
+		})
+
+		for _, frame := range frames {
+			err := tx.ZScore(ctx, s.indexKey(sessionID), strconv.FormatInt(frame.Index, 10)).Err()
+			if err == nil {
+				continue
+			}
+			if err != redis.Nil {
+				return redisError("failed to check frame index", err)
+			}
+			frame.SessionID = sessionID
+			if err := s.PutFrame(ctx, frame); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < legacyMigrationAttempts; attempt++ {
+		if err := s.client.Watch(ctx, migrate, key); err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return errUnavailable("legacy frames kept changing", redis.TxFailedErr)
+}
+
+// Close ends all active subscriptions, closes the Redis client connection and
+// cleans up resources. After Close is called, no other methods should be
+// called on this instance. Calling Close again has no effect.
 //
 // Returns an error if the Redis connection cannot be closed cleanly.
 func (s *RedisStorage) Close() error {
-	return s.client.Close()
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.client.Close()
+	})
+	return err
 }
@@ -0,0 +1,1036 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// subscribeBlock is how long a subscription blocks on XREAD before checking
+// whether it was cancelled.
+const subscribeBlock = 500 * time.Millisecond
+
+// RedisStreamsStorage implements the Storage interface on top of Redis Streams.
+// Every session is an append-only stream of frames, indexed by frame index and
+// timestamp with Sorted Sets. Unlike RedisStorage, the stream can be consumed
+// through Redis consumer groups: several processes reading the same session
+// with ReadGroup share its frames, and each frame is delivered to exactly one
+// of them until it is acknowledged with Ack. This is what allows running
+// multiple transform plugin instances against the same sessions; see
+// GroupReader.
+//
+// Key Schema:
+// - Session frames: "streams:{sessionID}" (Stream, fields index/size/frame)
+// - Frame index: "streams:{sessionID}:index" (Sorted Set, entry ID scored by frame index)
+// - Timestamp index: "streams:{sessionID}:time" (Sorted Set, entry ID scored by Unix microseconds)
+// - Key frame index: "streams:{sessionID}:keyframes" (Sorted Set, key frame entry IDs scored by frame index)
+// - Frame metadata: "streams:{sessionID}:meta" (Hash, entry ID -> metadata)
+// - Session size: "streams:{sessionID}:bytes" (String counter)
+// - Active sessions: "stream_sessions" (Set)
+// - Retention policies: "stream_retention" (Hash, session ID -> policy)
+//
+// Overwrite Semantics:
+// Streams are append-only, so writing a frame with an index that is already
+// stored deletes the previous entry and appends a new one. Consumer groups
+// therefore see the new version of the frame as a new message.
+//
+// Thread Safety:
+// All operations are thread-safe as Redis handles concurrent access.
+// The client connection is safe for concurrent use by multiple goroutines.
+type RedisStreamsStorage struct {
+	client    *redis.Client   // Redis client connection
+	prefix    string          // Key prefix for namespacing (e.g., "myapp:")
+	mu        sync.RWMutex    // Protects defaults
+	defaults  RetentionPolicy // Retention policy for sessions without their own
+	groups    sync.Map        // Consumer groups known to exist, keyed by "group/sessionID"
+	done      chan struct{}   // Closed by Close to end all subscriptions
+	closeOnce sync.Once       // Guards closing of done and the client
+}
+
+// StreamMessage is a frame delivered to a consumer group member by ReadGroup.
+type StreamMessage struct {
+	ID    string // Stream entry ID, to be passed to Ack once processed
+	Frame Frame  // The delivered frame
+}
+
+// GroupReader is implemented by storages whose sessions can be shared by the
+// consumers of a consumer group, such as RedisStreamsStorage.
+//
+// A consumer reads messages with ReadGroup, processes them and acknowledges
+// them with Ack. A message that isn't acknowledged stays pending, and is
+// taken over with ClaimStale by another consumer once idle for long enough,
+// so frames of a consumer that crashed are processed by the others. Messages
+// that can't be delivered, because their frame was evicted or is corrupt,
+// are acknowledged by the reads that find them, so they don't stay pending.
+// RenditionTransform consumes its input this way when given a group.
+type GroupReader interface {
+	ReadGroup(ctx context.Context, group, consumer string, sessionIDs []string, count int64, block time.Duration) ([]StreamMessage, error)
+	ClaimStale(ctx context.Context, group, consumer, sessionID string, minIdle time.Duration, count int64) ([]StreamMessage, error)
+	Ack(ctx context.Context, group, sessionID string, ids ...string) error
+}
+
+// NewRedisStreamsStorage creates a new RedisStreamsStorage instance.
+// Like NewRedisStorage, it accepts either a Redis address string or a RedisConfig.
+//
+// Returns an error if:
+// - Cannot connect to Redis server
+// - Invalid configuration parameters
+// - Redis ping fails
+func NewRedisStreamsStorage(config interface{}) (*RedisStreamsStorage, error) {
+	client, cfg, err := newRedisClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisStreamsStorage{
+		client:   client,
+		prefix:   cfg.Prefix,
+		defaults: cfg.Retention,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// streamKey generates the Redis key of the stream holding a session's frames.
+func (s *RedisStreamsStorage) streamKey(sessionID string) string {
+	return fmt.Sprintf("%sstreams:%s", s.prefix, sessionID)
+}
+
+// indexKey generates the Redis key of the sorted set mapping frame indexes
+// to stream entry IDs.
+func (s *RedisStreamsStorage) indexKey(sessionID string) string {
+	return s.streamKey(sessionID) + ":index"
+}
+
+// timeKey generates the Redis key of the sorted set mapping frame timestamps
+// to stream entry IDs.
+func (s *RedisStreamsStorage) timeKey(sessionID string) string {
+	return s.streamKey(sessionID) + ":time"
+}
+
+// keyFrameKey generates the Redis key of the sorted set mapping the frame
+// indexes of key frames to stream entry IDs.
+func (s *RedisStreamsStorage) keyFrameKey(sessionID string) string {
+	return s.streamKey(sessionID) + ":keyframes"
+}
+
+// bytesKey generates the Redis key of the counter holding the total data
+// size of a session.
+func (s *RedisStreamsStorage) bytesKey(sessionID string) string {
+	return s.streamKey(sessionID) + ":bytes"
+}
+
+// metadataKey generates the Redis key of the Hash mapping stream entry IDs
+// to the metadata of their frames.
+func (s *RedisStreamsStorage) metadataKey(sessionID string) string {
+	return s.streamKey(sessionID) + ":meta"
+}
+
+// checkpointKey generates the Redis key of the Hash holding the checkpoint
+// of each consumer of a session.
+func (s *RedisStreamsStorage) checkpointKey(sessionID string) string {
+	return s.streamKey(sessionID) + ":checkpoints"
+}
+
+// sessionKey generates the Redis key for the active sessions set.
+func (s *RedisStreamsStorage) sessionKey() string {
+	return s.prefix + "stream_sessions"
+}
+
+// retentionKey generates the Redis key of the Hash holding per-session
+// retention policies.
+func (s *RedisStreamsStorage) retentionKey() string {
+	return s.prefix + "stream_retention"
+}
+
+// putStreamFrameScript appends a frame to a session stream, replacing any
+// entry with the same frame index, indexes it and enforces the session's
+// retention policy in a single atomic step. It returns the new entry ID.
+//
+// KEYS: stream, index, time, bytes, active sessions, retention, metadata,
+// key frames
+// ARGV: frame index, frame, time score, size, session ID, default policy,
+// metadata, key frame flag
+var putStreamFrameScript = redis.NewScript(`
+local function evict(id)
+	local entry = redis.call('XRANGE', KEYS[1], id, id)
+	if entry[1] then
+		local fields = entry[1][2]
+		for i = 1, #fields, 2 do
+			if fields[i] == 'size' then
+				redis.call('DECRBY', KEYS[4], fields[i + 1])
+			end
+		end
+	end
+	redis.call('XDEL', KEYS[1], id)
+	redis.call('HDEL', KEYS[7], id)
+	redis.call('ZREM', KEYS[2], id)
+	redis.call('ZREM', KEYS[3], id)
+	redis.call('ZREM', KEYS[8], id)
+end
+
+for _, id in ipairs(redis.call('ZRANGEBYSCORE', KEYS[2], ARGV[1], ARGV[1])) do
+	evict(id)
+end
+
+local id = redis.call('XADD', KEYS[1], '*', 'index', ARGV[1], 'size', ARGV[4], 'frame', ARGV[2])
+redis.call('HSET', KEYS[7], id, ARGV[7])
+redis.call('ZADD', KEYS[2], ARGV[1], id)
+redis.call('ZADD', KEYS[3], ARGV[3], id)
+if ARGV[8] == '1' then
+	redis.call('ZADD', KEYS[8], ARGV[1], id)
+end
+redis.call('INCRBY', KEYS[4], ARGV[4])
+redis.call('SADD', KEYS[5], ARGV[5])
+
+local policy = redis.call('HGET', KEYS[6], ARGV[5])
+if not policy then
+	policy = ARGV[6]
+end
+policy = cjson.decode(policy)
+
+if policy.max_frames > 0 then
+	local excess = redis.call('ZCARD', KEYS[2]) - policy.max_frames
+	if excess > 0 then
+		for _, old in ipairs(redis.call('ZRANGE', KEYS[2], 0, excess - 1)) do
+			evict(old)
+		end
+	end
+end
+
+if policy.max_age > 0 then
+	local newest = redis.call('ZRANGE', KEYS[3], -1, -1, 'WITHSCORES')
+	if newest[2] then
+		local cutoff = tonumber(newest[2]) - policy.max_age
+		for _, old in ipairs(redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', '(' .. string.format('%.0f', cutoff))) do
+			evict(old)
+		end
+	end
+end
+
+if policy.max_bytes > 0 then
+	while tonumber(redis.call('GET', KEYS[4]) or '0') > policy.max_bytes do
+		local oldest = redis.call('ZRANGE', KEYS[2], 0, 0)
+		if #oldest == 0 then
+			break
+		end
+		evict(oldest[1])
+	end
+end
+
+return id
+`)
+
+// PutFrame appends a frame to the session's stream.
+// A frame previously stored with the same index is removed from the stream
+// first, the index and timestamp sorted sets are updated, the session is
+// tracked and its retention policy is enforced, all in one Lua script.
+//
+// Returns an error if the frame cannot be serialized or the Redis operation fails.
+func (s *RedisStreamsStorage) PutFrame(ctx context.Context, frame Frame) error {
+	if isClosed(s.done) {
+		return errClosed("put frame")
+	}
+
+	encoded, err := EncodeFrame(frame)
+	if err != nil {
+		return fmt.Errorf("failed to marshal frame: %v", err)
+	}
+	metadata, err := encodeMetadata(frame)
+	if err != nil {
+		return fmt.Errorf("failed to marshal frame metadata: %v", err)
+	}
+
+	s.mu.RLock()
+	defaults, err := encodePolicy(s.defaults)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{
+		s.streamKey(frame.SessionID),
+		s.indexKey(frame.SessionID),
+		s.timeKey(frame.SessionID),
+		s.bytesKey(frame.SessionID),
+		s.sessionKey(),
+		s.retentionKey(),
+		s.metadataKey(frame.SessionID),
+		s.keyFrameKey(frame.SessionID),
+	}
+	keyFrame := "0"
+	if frame.KeyFrame {
+		keyFrame = "1"
+	}
+	args := []interface{}{
+		frame.Index,
+		encoded,
+		timeScore(frame.Timestamp),
+		len(frame.Data),
+		frame.SessionID,
+		defaults,
+		metadata,
+		keyFrame,
+	}
+
+	if err := putStreamFrameScript.Run(ctx, s.client, keys, args...).Err(); err != nil {
+		return redisError("failed to store frame", err)
+	}
+
+	return nil
+}
+
+// GetFrame retrieves a specific frame by session ID and frame index.
+// The entry ID is looked up in the index sorted set and the entry is read
+// with XRANGE.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Frame index not found
+// - Redis operation fails
+// - Frame data is corrupted
+func (s *RedisStreamsStorage) GetFrame(ctx context.Context, sessionID string, frameIndex int64) (Frame, error) {
+	frames, err := s.ListFramesRange(ctx, sessionID, frameIndex, frameIndex)
+	if err != nil {
+		return Frame{}, err
+	}
+	if len(frames) == 0 {
+		return Frame{}, errFrameNotFound(sessionID, frameIndex)
+	}
+
+	return frames[0], nil
+}
+
+// ListFrames returns all frames for a given session, sorted by frame index.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Redis operation fails
+// - Frame data is corrupted
+func (s *RedisStreamsStorage) ListFrames(ctx context.Context, sessionID string) ([]Frame, error) {
+	return s.ListFramesRange(ctx, sessionID, math.MinInt64, math.MaxInt64)
+}
+
+// ListFramesRange returns the frames of a session with an index between
+// fromIndex and toIndex (both inclusive), sorted by frame index.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Redis operation fails
+// - Frame data is corrupted
+func (s *RedisStreamsStorage) ListFramesRange(ctx context.Context, sessionID string, fromIndex, toIndex int64) ([]Frame, error) {
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return nil, err
+	}
+
+	ids, err := s.client.ZRangeByScore(ctx, s.indexKey(sessionID), &redis.ZRangeBy{
+		Min: scoreBound(fromIndex),
+		Max: scoreBound(toIndex),
+	}).Result()
+	if err != nil {
+		return nil, redisError("failed to get frame index", err)
+	}
+
+	messages, err := s.fetchEntries(ctx, sessionID, ids)
+	if err != nil {
+		return nil, err
+	}
+	return messageFrames(messages), nil
+}
+
+// ListFrameMetadata returns the metadata of the frames of a session with an
+// index between fromIndex and toIndex (both inclusive), sorted by frame index.
+// Metadata is read from a Hash kept next to the stream, so no frame data is
+// transferred. Entries stored before metadata was kept are read in full.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Redis operation fails
+// - Metadata is corrupted
+func (s *RedisStreamsStorage) ListFrameMetadata(ctx context.Context, sessionID string, fromIndex, toIndex int64) ([]FrameMetadata, error) {
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return nil, err
+	}
+
+	ids, err := s.client.ZRangeByScore(ctx, s.indexKey(sessionID), &redis.ZRangeBy{
+		Min: scoreBound(fromIndex),
+		Max: scoreBound(toIndex),
+	}).Result()
+	if err != nil {
+		return nil, redisError("failed to get frame index", err)
+	}
+
+	metadata := make([]FrameMetadata, 0, len(ids))
+	if len(ids) == 0 {
+		return metadata, nil
+	}
+
+	values, err := s.client.HMGet(ctx, s.metadataKey(sessionID), ids...).Result()
+	if err != nil {
+		return nil, redisError("failed to get frame metadata", err)
+	}
+
+	for i, value := range values {
+		encoded, ok := value.(string)
+		if !ok {
+			// Entry was evicted meanwhile, or stored without metadata
+			messages, err := s.fetchEntries(ctx, sessionID, ids[i:i+1])
+			if err != nil {
+				return nil, err
+			}
+			for _, frame := range messageFrames(messages) {
+				metadata = append(metadata, frame.Metadata())
+			}
+			continue
+		}
+
+		m, err := decodeMetadata([]byte(encoded))
+		if err != nil {
+			return nil, err
+		}
+		metadata = append(metadata, m)
+	}
+
+	return metadata, nil
+}
+
+// LatestKeyFrame returns the key frame of a session with the highest index.
+// Its entry ID is looked up in the key frame sorted set and the entry is read
+// with XRANGE.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Session holds no key frame
+// - Redis operation fails
+// - Frame data is corrupted
+func (s *RedisStreamsStorage) LatestKeyFrame(ctx context.Context, sessionID string) (Frame, error) {
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return Frame{}, err
+	}
+
+	ids, err := s.client.ZRevRange(ctx, s.keyFrameKey(sessionID), 0, 0).Result()
+	if err != nil {
+		return Frame{}, redisError("failed to get key frame index", err)
+	}
+
+	messages, err := s.fetchEntries(ctx, sessionID, ids)
+	if err != nil {
+		return Frame{}, err
+	}
+	frames := messageFrames(messages)
+	if len(frames) == 0 {
+		return Frame{}, errNoKeyFrame(sessionID)
+	}
+	return frames[0], nil
+}
+
+// ListFramesPage returns up to limit frames of a session starting at the
+// frame index given by cursor.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Limit is not positive
+// - Redis operation fails
+// - Frame data is corrupted
+func (s *RedisStreamsStorage) ListFramesPage(ctx context.Context, sessionID string, cursor int64, limit int) (FramePage, error) {
+	if limit <= 0 {
+		return FramePage{}, fmt.Errorf("invalid page limit: %d", limit)
+	}
+
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return FramePage{}, err
+	}
+
+	ids, err := s.client.ZRangeByScore(ctx, s.indexKey(sessionID), &redis.ZRangeBy{
+		Min:   scoreBound(cursor),
+		Max:   "+inf",
+		Count: int64(limit) + 1,
+	}).Result()
+	if err != nil {
+		return FramePage{}, redisError("failed to get frame index", err)
+	}
+
+	page := FramePage{NextCursor: cursor}
+	if len(ids) > limit {
+		page.HasMore = true
+		ids = ids[:limit]
+	}
+
+	messages, err := s.fetchEntries(ctx, sessionID, ids)
+	if err != nil {
+		return FramePage{}, err
+	}
+	page.Frames = messageFrames(messages)
+	if len(page.Frames) > 0 {
+		page.NextCursor = page.Frames[len(page.Frames)-1].Index + 1
+	}
+
+	return page, nil
+}
+
+// ListFramesByTime returns the frames of a session whose Timestamp lies
+// between start and end (both inclusive), sorted by frame index.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Redis operation fails
+// - Frame data is corrupted
+func (s *RedisStreamsStorage) ListFramesByTime(ctx context.Context, sessionID string, start, end time.Time) ([]Frame, error) {
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return nil, err
+	}
+
+	ids, err := s.client.ZRangeByScore(ctx, s.timeKey(sessionID), &redis.ZRangeBy{
+		Min: strconv.FormatFloat(timeScore(start), 'f', -1, 64),
+		Max: strconv.FormatFloat(timeScore(end), 'f', -1, 64),
+	}).Result()
+	if err != nil {
+		return nil, redisError("failed to get timestamp index", err)
+	}
+
+	messages, err := s.fetchEntries(ctx, sessionID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := messageFrames(messages)
+	sort.Slice(frames, func(i, j int) bool {
+		return frames[i].Index < frames[j].Index
+	})
+	return frames, nil
+}
+
+// Subscribe delivers frames of a session as they are stored.
+// The ID of the last stream entry is read before the backlog, so the backlog
+// covers entries up to that ID and XREAD picks up every entry after it.
+//
+// The returned channel is closed when ctx is cancelled, a Redis error occurs,
+// Close is called, or the subscriber falls more than MaxPendingFrames frames
+// behind.
+//
+// Returns an error if the backlog cannot be read.
+func (s *RedisStreamsStorage) Subscribe(ctx context.Context, sessionID string, fromIndex int64) (<-chan Frame, error) {
+	if isClosed(s.done) {
+		return nil, errClosed("subscribe")
+	}
+
+	lastID := "0-0"
+	last, err := s.client.XRevRangeN(ctx, s.streamKey(sessionID), "+", "-", 1).Result()
+	if err != nil {
+		return nil, redisError("failed to read stream", err)
+	}
+	if len(last) > 0 {
+		lastID = last[0].ID
+	}
+
+	ids, err := s.client.ZRangeByScore(ctx, s.indexKey(sessionID), &redis.ZRangeBy{
+		Min: scoreBound(fromIndex),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, redisError("failed to get frame index", err)
+	}
+
+	// Entries newer than lastID are delivered by XREAD below
+	backlogIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if compareStreamIDs(id, lastID) <= 0 {
+			backlogIDs = append(backlogIDs, id)
+		}
+	}
+	messages, err := s.fetchEntries(ctx, sessionID, backlogIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	backlog := messageFrames(messages)
+	next := fromIndex
+	if len(backlog) > 0 {
+		next = backlog[len(backlog)-1].Index + 1
+	}
+
+	sub := newSubscription(backlog)
+	go sub.run(ctx, s.done)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.done:
+				return
+			case <-sub.dropped:
+				return
+			default:
+			}
+
+			streams, err := s.client.XRead(ctx, &redis.XReadArgs{
+				Streams: []string{s.streamKey(sessionID), lastID},
+				Block:   subscribeBlock,
+			}).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				return
+			}
+
+			for _, stream := range streams {
+				for _, msg := range stream.Messages {
+					lastID = msg.ID
+					// Skip frames already delivered, including overwrites
+					frame, err := decodeStreamMessage(msg)
+					if err != nil || frame.Index < next {
+						continue
+					}
+					sub.push(frame)
+					next = frame.Index + 1
+				}
+			}
+		}
+	}()
+
+	return sub.out, nil
+}
+
+// ReadGroup reads new frames from the given sessions on behalf of a consumer
+// in a consumer group. Each frame is delivered to only one consumer of the
+// group; it stays pending until acknowledged with Ack. The group is created
+// on first use and starts at the beginning of each session's stream, so a new
+// group processes the whole retained history.
+//
+// Parameters:
+//   - ctx: Context for cancellation and timeouts
+//   - group: Name of the consumer group, shared by cooperating processes
+//   - consumer: Unique name of this consumer within the group
+//   - sessionIDs: Sessions to read from
+//   - count: Maximum number of messages to return per session
+//   - block: How long to wait for new frames; zero returns immediately
+//
+// Returns the delivered messages, or an error if the Redis operation fails.
+func (s *RedisStreamsStorage) ReadGroup(ctx context.Context, group, consumer string, sessionIDs []string, count int64, block time.Duration) ([]StreamMessage, error) {
+	if len(sessionIDs) == 0 {
+		return nil, nil
+	}
+
+	streams := make([]string, 0, 2*len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		if err := s.ensureGroup(ctx, group, sessionID); err != nil {
+			return nil, err
+		}
+		streams = append(streams, s.streamKey(sessionID))
+	}
+	for range sessionIDs {
+		streams = append(streams, ">")
+	}
+
+	args := &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  streams,
+		Count:    count,
+		Block:    block,
+	}
+	if block <= 0 {
+		// go-redis only sends BLOCK for non-negative durations
+		args.Block = -1
+	}
+
+	result, err := s.client.XReadGroup(ctx, args).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, redisError("failed to read from group", err)
+	}
+
+	messages, dead := decodeStreams(result)
+	if err := s.ackDead(ctx, group, dead); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ClaimStale takes over messages of a session that were delivered to another
+// consumer of the group but not acknowledged within minIdle, for example
+// because that consumer crashed. The pending list is paged through until
+// count messages are claimed, and pending messages whose entry was evicted
+// meanwhile are acknowledged instead of being claimed.
+//
+// Returns the claimed messages, or an error if the Redis operation fails.
+func (s *RedisStreamsStorage) ClaimStale(ctx context.Context, group, consumer, sessionID string, minIdle time.Duration, count int64) ([]StreamMessage, error) {
+	if err := s.ensureGroup(ctx, group, sessionID); err != nil {
+		return nil, err
+	}
+
+	stream := s.streamKey(sessionID)
+	messages := make([]StreamMessage, 0)
+	for start := "-"; int64(len(messages)) < count; {
+		pending, err := s.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  group,
+			Start:  start,
+			End:    "+",
+			Count:  count,
+		}).Result()
+		if err != nil {
+			return nil, redisError("failed to list pending messages", err)
+		}
+
+		var ids []string
+		for _, p := range pending {
+			if p.Idle >= minIdle && int64(len(messages)+len(ids)) < count {
+				ids = append(ids, p.ID)
+			}
+		}
+		claimed, err := s.claim(ctx, group, consumer, stream, minIdle, ids)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, claimed...)
+
+		if int64(len(pending)) < count {
+			break
+		}
+		start = nextStreamID(pending[len(pending)-1].ID)
+	}
+	return messages, nil
+}
+
+// claim claims pending messages of a stream for a consumer, and
+// acknowledges those whose entry was deleted.
+func (s *RedisStreamsStorage) claim(ctx context.Context, group, consumer, stream string, minIdle time.Duration, ids []string) ([]StreamMessage, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	// XCLAIM checks the idle time again, so concurrent claimers can't both win
+	claimed, err := s.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil && err != redis.Nil {
+		return nil, redisError("failed to claim messages", err)
+	}
+	messages, dead := decodeStreams([]redis.XStream{{Stream: stream, Messages: claimed}})
+
+	// Deleted entries aren't returned by XCLAIM on Redis 7 and later, and
+	// neither are those another consumer claimed first; only the deleted
+	// ones are dead
+	returned := make(map[string]bool, len(claimed))
+	for _, msg := range claimed {
+		returned[msg.ID] = true
+	}
+	var missing []string
+	for _, id := range ids {
+		if !returned[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		pipe := s.client.Pipeline()
+		cmds := make([]*redis.XMessageSliceCmd, len(missing))
+		for i, id := range missing {
+			cmds[i] = pipe.XRange(ctx, stream, id, id)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, redisError("failed to check pending messages", err)
+		}
+		for i, cmd := range cmds {
+			if len(cmd.Val()) == 0 {
+				dead[stream] = append(dead[stream], missing[i])
+			}
+		}
+	}
+
+	if err := s.ackDead(ctx, group, dead); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ackDead acknowledges the messages of a group that can't be delivered, by
+// stream key, so they don't stay pending forever.
+func (s *RedisStreamsStorage) ackDead(ctx context.Context, group string, dead map[string][]string) error {
+	if len(dead) == 0 {
+		return nil
+	}
+
+	pipe := s.client.Pipeline()
+	for stream, ids := range dead {
+		pipe.XAck(ctx, stream, group, ids...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return redisError("failed to acknowledge dead messages", err)
+	}
+	return nil
+}
+
+// Ack acknowledges messages of a session as processed by the consumer group,
+// removing them from the group's pending list.
+//
+// Returns an error if the Redis operation fails.
+func (s *RedisStreamsStorage) Ack(ctx context.Context, group, sessionID string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := s.client.XAck(ctx, s.streamKey(sessionID), group, ids...).Err(); err != nil {
+		return redisError("failed to acknowledge messages", err)
+	}
+	return nil
+}
+
+// ensureGroup creates a consumer group on a session's stream if it doesn't
+// exist yet. Groups known to exist are cached to avoid a round trip per read.
+func (s *RedisStreamsStorage) ensureGroup(ctx context.Context, group, sessionID string) error {
+	cacheKey := group + "/" + sessionID
+	if _, exists := s.groups.Load(cacheKey); exists {
+		return nil
+	}
+
+	err := s.client.XGroupCreateMkStream(ctx, s.streamKey(sessionID), group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return redisError("failed to create consumer group", err)
+	}
+
+	s.groups.Store(cacheKey, struct{}{})
+	return nil
+}
+
+// ListSessions returns all active session IDs, sorted alphabetically.
+//
+// Returns an error if the Redis operation fails.
+func (s *RedisStreamsStorage) ListSessions(ctx context.Context) ([]string, error) {
+	sessions, err := s.client.SMembers(ctx, s.sessionKey()).Result()
+	if err != nil {
+		return nil, redisError("failed to list sessions", err)
+	}
+
+	sort.Strings(sessions)
+	return sessions, nil
+}
+
+// DeleteSession removes a session's stream, including its consumer groups,
+// together with its indexes, and removes it from the active sessions set.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Redis operation fails
+func (s *RedisStreamsStorage) DeleteSession(ctx context.Context, sessionID string) error {
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return err
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx,
+		s.streamKey(sessionID),
+		s.indexKey(sessionID),
+		s.timeKey(sessionID),
+		s.bytesKey(sessionID),
+		s.metadataKey(sessionID),
+		s.keyFrameKey(sessionID),
+		s.checkpointKey(sessionID),
+	)
+	pipe.SRem(ctx, s.sessionKey(), sessionID)
+	pipe.HDel(ctx, s.retentionKey(), sessionID)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return redisError("failed to delete session", err)
+	}
+
+	// Consumer groups were deleted along with the stream
+	s.groups.Range(func(key, _ interface{}) bool {
+		if strings.HasSuffix(key.(string), "/"+sessionID) {
+			s.groups.Delete(key)
+		}
+		return true
+	})
+
+	return nil
+}
+
+// SetRetention sets the retention policy of a session, or the default policy
+// if sessionID is empty. Per-session policies are stored in Redis; the default
+// policy is local to this instance.
+//
+// Returns an error if the Redis operation fails.
+func (s *RedisStreamsStorage) SetRetention(ctx context.Context, sessionID string, policy RetentionPolicy) error {
+	if sessionID == "" {
+		s.mu.Lock()
+		s.defaults = policy
+		s.mu.Unlock()
+		return nil
+	}
+
+	encoded, err := encodePolicy(policy)
+	if err != nil {
+		return err
+	}
+	if err := s.client.HSet(ctx, s.retentionKey(), sessionID, encoded).Err(); err != nil {
+		return redisError("failed to set retention policy", err)
+	}
+	return nil
+}
+
+// SetCheckpoint records the index of the last frame of a session that a
+// consumer has processed. Checkpoints are stored in a Hash next to the
+// session's stream.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Redis operation fails
+func (s *RedisStreamsStorage) SetCheckpoint(ctx context.Context, consumer, sessionID string, index int64) error {
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return err
+	}
+	return setRedisCheckpoint(ctx, s.client, s.checkpointKey(sessionID), consumer, index)
+}
+
+// GetCheckpoint returns the checkpoint a consumer recorded for a session.
+//
+// Returns an error if:
+// - Session doesn't exist
+// - Redis operation fails
+func (s *RedisStreamsStorage) GetCheckpoint(ctx context.Context, consumer, sessionID string) (int64, bool, error) {
+	if err := s.checkSession(ctx, sessionID); err != nil {
+		return 0, false, err
+	}
+	return getRedisCheckpoint(ctx, s.client, s.checkpointKey(sessionID), consumer)
+}
+
+// Close ends all active subscriptions and closes the Redis client connection.
+// After Close is called, no other methods should be called on this instance.
+// Calling Close again has no effect.
+//
+// Returns an error if the Redis connection cannot be closed cleanly.
+func (s *RedisStreamsStorage) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.client.Close()
+	})
+	return err
+}
+
+// checkSession returns an error if the session is not in the active sessions set.
+func (s *RedisStreamsStorage) checkSession(ctx context.Context, sessionID string) error {
+	exists, err := s.client.SIsMember(ctx, s.sessionKey(), sessionID).Result()
+	if err != nil {
+		return redisError("failed to check session", err)
+	}
+	if !exists {
+		return errSessionNotFound(sessionID)
+	}
+	return nil
+}
+
+// fetchEntries reads the stream entries with the given IDs, preserving their
+// order. Entries evicted between the index and stream reads are skipped.
+func (s *RedisStreamsStorage) fetchEntries(ctx context.Context, sessionID string, ids []string) ([]StreamMessage, error) {
+	messages := make([]StreamMessage, 0, len(ids))
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.XMessageSliceCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.XRange(ctx, s.streamKey(sessionID), id, id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, redisError("failed to get frames", err)
+	}
+
+	for _, cmd := range cmds {
+		for _, msg := range cmd.Val() {
+			frame, err := decodeStreamMessage(msg)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, StreamMessage{ID: msg.ID, Frame: frame})
+		}
+	}
+
+	return messages, nil
+}
+
+// decodeStreamMessage deserializes the frame stored in a stream entry.
+func decodeStreamMessage(msg redis.XMessage) (Frame, error) {
+	encoded, ok := msg.Values["frame"].(string)
+	if !ok {
+		return Frame{}, fmt.Errorf("failed to unmarshal frame: entry %s has no frame", msg.ID)
+	}
+
+	return DecodeFrame([]byte(encoded))
+}
+
+// decodeStreams converts XREADGROUP and XCLAIM results to messages. Entries
+// that were deleted while pending come back without values, and are
+// returned by stream key with the entries that fail to decode, as dead.
+func decodeStreams(streams []redis.XStream) ([]StreamMessage, map[string][]string) {
+	messages := make([]StreamMessage, 0)
+	dead := make(map[string][]string)
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			frame, err := decodeStreamMessage(msg)
+			if err != nil {
+				dead[stream.Stream] = append(dead[stream.Stream], msg.ID)
+				continue
+			}
+			messages = append(messages, StreamMessage{ID: msg.ID, Frame: frame})
+		}
+	}
+	return messages, dead
+}
+
+// messageFrames extracts the frames of stream messages.
+func messageFrames(messages []StreamMessage) []Frame {
+	frames := make([]Frame, len(messages))
+	for i, msg := range messages {
+		frames[i] = msg.Frame
+	}
+	return frames
+}
+
+// compareStreamIDs compares two stream entry IDs of the form "ms-seq",
+// returning -1, 0 or 1.
+func compareStreamIDs(a, b string) int {
+	aMs, aSeq := parseStreamID(a)
+	bMs, bSeq := parseStreamID(b)
+
+	switch {
+	case aMs < bMs || (aMs == bMs && aSeq < bSeq):
+		return -1
+	case aMs == bMs && aSeq == bSeq:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// nextStreamID returns the smallest stream entry ID following id.
+func nextStreamID(id string) string {
+	ms, seq := parseStreamID(id)
+	if seq == math.MaxUint64 {
+		return strconv.FormatUint(ms+1, 10) + "-0"
+	}
+	return strconv.FormatUint(ms, 10) + "-" + strconv.FormatUint(seq+1, 10)
+}
+
+// parseStreamID splits a stream entry ID into its millisecond and sequence parts.
+func parseStreamID(id string) (uint64, uint64) {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseUint(msPart, 10, 64)
+	seq, _ := strconv.ParseUint(seqPart, 10, 64)
+	return ms, seq
+}
@@ -0,0 +1,37 @@
+package storage
+
+import "strings"
+
+// Renditions written by the built-in plugins. Ingress plugins write the
+// frames they capture to the source rendition of a stream; transforms read
+// a rendition and write their output to another one, so the frames they
+// read are never overwritten.
+const (
+	RenditionSource      = "source"      // Frames as captured by an ingress plugin
+	RenditionWatermarked = "watermarked" // Frames with a watermark applied
+)
+
+// RenditionSessionID returns the ID of the session that holds a rendition of
+// a stream, e.g. "cam1/source" or "cam1/watermarked".
+//
+// Parameters:
+//   - stream: Identifier of the stream, e.g. a camera device ID
+//   - rendition: Name of the rendition
+//
+// Returns the session ID "<stream>/<rendition>".
+func RenditionSessionID(stream, rendition string) string {
+	return stream + "/" + rendition
+}
+
+// SplitRenditionSessionID splits a session ID created by RenditionSessionID
+// into its stream and rendition. The rendition is the part after the last
+// "/", so stream identifiers may contain slashes themselves.
+//
+// Returns an empty rendition if the session ID has no rendition.
+func SplitRenditionSessionID(sessionID string) (stream, rendition string) {
+	i := strings.LastIndex(sessionID, "/")
+	if i < 0 {
+		return sessionID, ""
+	}
+	return sessionID[:i], sessionID[i+1:]
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"time"
+)
+
+// RetentionPolicy limits how much of a session is kept in storage.
+// Limits are enforced every time a frame is written; when a limit is
+// exceeded, the oldest frames of the session are evicted until the session
+// fits again: the lowest indexes for MaxFrames and MaxBytes, and every frame
+// whose Timestamp is too old for MaxAge, whatever its index. This turns a session into a ring buffer, which is what live
+// streaming with a short DVR window needs.
+//
+// A zero value for any field disables that limit, so the zero RetentionPolicy
+// keeps every frame.
+type RetentionPolicy struct {
+	MaxFrames int           // Maximum number of frames kept per session
+	MaxAge    time.Duration // Maximum age of a frame, relative to the newest frame's Timestamp
+	MaxBytes  int64         // Maximum total size of frame Data kept per session
+}
+
+// IsZero reports whether the policy has no limits.
+func (p RetentionPolicy) IsZero() bool {
+	return p.MaxFrames <= 0 && p.MaxAge <= 0 && p.MaxBytes <= 0
+}
+
+// evictions returns the indexes of the frames a session must evict to
+// satisfy the policy, given its frame indexes in ascending order, the newest
+// frame timestamp, the total size of its frame data and a function returning
+// the timestamp and size of a frame. Limits are applied in the order of the
+// Redis backend: MaxFrames, then MaxAge, then MaxBytes.
+func (p RetentionPolicy) evictions(indexes []int64, newest time.Time, size int64, frame func(index int64) (time.Time, int64)) []int64 {
+	var evicted []int64
+	if p.MaxFrames > 0 && len(indexes) > p.MaxFrames {
+		excess := len(indexes) - p.MaxFrames
+		for _, index := range indexes[:excess] {
+			_, frameSize := frame(index)
+			size -= frameSize
+		}
+		evicted = append(evicted, indexes[:excess]...)
+		indexes = indexes[excess:]
+	}
+
+	if p.MaxAge > 0 {
+		cutoff := newest.Add(-p.MaxAge)
+		var kept []int64
+		for i, index := range indexes {
+			timestamp, frameSize := frame(index)
+			if !timestamp.Before(cutoff) {
+				if kept != nil {
+					kept = append(kept, index)
+				}
+				continue
+			}
+			if kept == nil {
+				kept = append(make([]int64, 0, len(indexes)), indexes[:i]...)
+			}
+			evicted = append(evicted, index)
+			size -= frameSize
+		}
+		if kept != nil {
+			indexes = kept
+		}
+	}
+
+	if p.MaxBytes > 0 {
+		for len(indexes) > 0 && size > p.MaxBytes {
+			_, frameSize := frame(indexes[0])
+			size -= frameSize
+			evicted = append(evicted, indexes[0])
+			indexes = indexes[1:]
+		}
+	}
+	return evicted
+}
@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config holds the connection settings of an S3Client.
+type S3Config struct {
+	Endpoint  string       // Service URL, e.g. "https://s3.us-east-1.amazonaws.com" or "http://localhost:9000"
+	Region    string       // Region used for request signing, default "us-east-1"
+	Bucket    string       // Bucket holding the objects
+	AccessKey string       // Access key ID
+	SecretKey string       // Secret access key
+	Client    *http.Client // HTTP client, default http.DefaultClient
+}
+
+// S3Client implements ObjectClient for Amazon S3 and compatible services
+// such as MinIO. Requests use path-style addressing and are signed with
+// AWS Signature Version 4, which keeps the client free of SDK dependencies.
+type S3Client struct {
+	config   S3Config // Connection settings
+	endpoint *url.URL // Parsed service URL
+}
+
+// NewS3Client creates an S3Client for the configured bucket.
+//
+// Returns an error if the endpoint is not a valid URL or no bucket is set.
+func NewS3Client(config S3Config) (*S3Client, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint: %q", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, errors.New("S3 bucket not set")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+
+	return &S3Client{config: config, endpoint: endpoint}, nil
+}
+
+// PutObject uploads an object.
+func (c *S3Client) PutObject(ctx context.Context, key string, data []byte) error {
+	resp, err := c.do(ctx, http.MethodPut, key, nil, nil, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, key)
+	}
+	return nil
+}
+
+// GetObject downloads an object.
+func (c *S3Client) GetObject(ctx context.Context, key string) ([]byte, error) {
+	return c.get(ctx, key, nil)
+}
+
+// GetObjectRange downloads a byte range of an object.
+func (c *S3Client) GetObjectRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	if length <= 0 {
+		return []byte{}, nil
+	}
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)}}
+	return c.get(ctx, key, header)
+}
+
+// get downloads an object with the given extra headers.
+func (c *S3Client) get(ctx context.Context, key string, header http.Header) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+	case http.StatusNotFound:
+		return nil, ErrObjectNotFound
+	default:
+		return nil, responseError(resp, key)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object %s: %v", key, err)
+	}
+	return data, nil
+}
+
+// listBucketResult is the response of ListObjectsV2.
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// ListObjects lists the keys with a prefix using ListObjectsV2, following
+// continuation tokens until all keys are read.
+func (c *S3Client) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := c.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := responseError(resp, prefix)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode object list: %v", err)
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// DeleteObject deletes an object.
+func (c *S3Client) DeleteObject(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return responseError(resp, key)
+	}
+	return nil
+}
+
+// do sends a signed request for an object, or for the bucket if key is empty.
+func (c *S3Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.config.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.ContentLength = int64(len(body))
+
+	c.sign(req, body, time.Now().UTC())
+
+	resp, err := c.config.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 request failed: %v", err)
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers to a request.
+func (c *S3Client) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Sign every header that is set at this point
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.config.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.config.SecretKey), date)
+	key = hmacSHA256(key, c.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.config.AccessKey, scope, signedHeaders, signature))
+	req.Header.Del("Host")
+}
+
+// responseError turns an unexpected S3 response into an error, including the
+// error code from the response body if there is one.
+func responseError(resp *http.Response, key string) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &body) == nil && body.Code != "" {
+		return fmt.Errorf("S3 request for %s failed: %s: %s", key, body.Code, body.Message)
+	}
+	return fmt.Errorf("S3 request for %s failed: %s", key, resp.Status)
+}
+
+// escapePath URI-encodes every segment of a path as required by SigV4.
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery encodes query parameters sorted by name, as required by SigV4.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, uriEncode(name)+"="+uriEncode(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// sha256Hex returns the hex-encoded SHA-256 hash of data.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -6,20 +6,24 @@ package storage
 import (
 	"context"
 	"time"
+
+	"github.com/relais/pkg/frames"
 )
 
-// Frame represents a media frame with metadata.
-// It contains both the raw frame data and associated information needed for
-// proper playback and processing. Frames are the fundamental unit of media
-// in the Relais system.
-type Frame struct {
-	SessionID  string    // Unique identifier for the media session this frame belongs to
-	Index      int64     // Sequential frame number within the session, used for ordering
-	Data       []byte    // Raw frame data (encoded video/audio) in the specified codec format
-	Timestamp  time.Time // When the frame was captured/created, used for synchronization
-	MediaType  string    // Type of media ("video" or "audio")
-	Codec      string    // Codec used for encoding (e.g., "h264", "opus", "jpeg")
-	KeyFrame   bool      // Whether this is a key frame (for video), important for seeking
+// Frame is the media frame stored by every backend. It is the canonical
+// frames.Frame, aliased here so that storage users don't need to import
+// both packages.
+type Frame = frames.Frame
+
+// FrameMetadata is the information about a frame without its data, as
+// returned by ListFrameMetadata.
+type FrameMetadata = frames.FrameMetadata
+
+// FramePage is a page of frames returned by ListFramesPage.
+type FramePage struct {
+	Frames     []Frame // Frames in this page, ordered by Index
+	NextCursor int64   // Cursor for the next page: last returned index + 1, or the requested cursor if empty
+	HasMore    bool    // Whether frames beyond this page were stored at read time
 }
 
 // Storage defines the interface for frame storage backends.
@@ -38,7 +42,8 @@ type Storage interface {
 	// Returns an error if:
 	//   - The storage operation fails
 	//   - The context is cancelled
-	//   - The backend is unavailable
+	//   - The backend is unavailable (ErrBackendUnavailable)
+	//   - The storage is closed (ErrClosed)
 	PutFrame(ctx context.Context, frame Frame) error
 
 	// GetFrame retrieves a specific frame by session and index.
@@ -53,8 +58,8 @@ type Storage interface {
 	// Returns:
 	//   - The requested frame and nil error if successful
 	//   - Empty frame and error if:
-	//     * Frame not found
-	//     * Session not found
+	//     * Frame not found (ErrFrameNotFound)
+	//     * Session not found (ErrSessionNotFound)
 	//     * Storage error occurs
 	GetFrame(ctx context.Context, sessionID string, frameIndex int64) (Frame, error)
 
@@ -68,9 +73,115 @@ type Storage interface {
 	//
 	// Returns:
 	//   - Slice of frames ordered by Index
-	//   - Error if session not found or storage error occurs
+	//   - Error if session not found (ErrSessionNotFound) or storage error occurs
 	ListFrames(ctx context.Context, sessionID string) ([]Frame, error)
 
+	// ListFramesRange returns the frames of a session with an index between
+	// fromIndex and toIndex, both inclusive. Backends should locate the
+	// range through an index rather than reading the whole session, so that
+	// seeking in long sessions stays cheap.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeouts
+	//   - sessionID: Unique identifier for the media session
+	//   - fromIndex: Lowest frame index to return
+	//   - toIndex: Highest frame index to return
+	//
+	// Returns:
+	//   - Slice of frames ordered by Index, empty if no frame is in range
+	//   - Error if session not found or storage error occurs
+	ListFramesRange(ctx context.Context, sessionID string, fromIndex, toIndex int64) ([]Frame, error)
+
+	// ListFrameMetadata returns the metadata of the frames of a session with
+	// an index between fromIndex and toIndex, both inclusive, without their
+	// data. It lets consumers find key frames or compute bitrates without
+	// transferring frame payloads; backends keep metadata apart from frame
+	// data or read only the start of each stored frame.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeouts
+	//   - sessionID: Unique identifier for the media session
+	//   - fromIndex: Lowest frame index to return
+	//   - toIndex: Highest frame index to return
+	//
+	// Returns:
+	//   - Slice of frame metadata ordered by Index, empty if no frame is in range
+	//   - Error if session not found or storage error occurs
+	ListFrameMetadata(ctx context.Context, sessionID string, fromIndex, toIndex int64) ([]FrameMetadata, error)
+
+	// LatestKeyFrame returns the key frame of a session with the highest
+	// index. Backends keep an index of key frames, so this doesn't scan the
+	// session. Consumers such as egress plugins use it to start new viewers
+	// on a decodable frame instead of in the middle of a group of pictures;
+	// SubscribeFromKeyFrame builds on it.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeouts
+	//   - sessionID: Unique identifier for the media session
+	//
+	// Returns:
+	//   - The latest key frame and nil error if successful
+	//   - Empty frame and error if:
+	//     * The session holds no key frame (ErrFrameNotFound)
+	//     * Session not found (ErrSessionNotFound)
+	//     * Storage error occurs
+	LatestKeyFrame(ctx context.Context, sessionID string) (Frame, error)
+
+	// ListFramesPage returns up to limit frames of a session, starting at
+	// the first frame with an Index >= cursor. The returned page carries
+	// the cursor to pass to the next call.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeouts
+	//   - sessionID: Unique identifier for the media session
+	//   - cursor: Frame index to start the page at
+	//   - limit: Maximum number of frames to return, must be positive
+	//
+	// Returns:
+	//   - Page of frames ordered by Index
+	//   - Error if session not found, limit is invalid or storage error occurs
+	ListFramesPage(ctx context.Context, sessionID string, cursor int64, limit int) (FramePage, error)
+
+	// ListFramesByTime returns the frames of a session whose Timestamp lies
+	// between start and end, both inclusive.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeouts
+	//   - sessionID: Unique identifier for the media session
+	//   - start: Earliest frame timestamp to return
+	//   - end: Latest frame timestamp to return
+	//
+	// Returns:
+	//   - Slice of frames ordered by Index, empty if no frame is in range
+	//   - Error if session not found or storage error occurs
+	ListFramesByTime(ctx context.Context, sessionID string, start, end time.Time) ([]Frame, error)
+
+	// Subscribe delivers frames of a session as they are stored.
+	// Frames already stored with an Index >= fromIndex are delivered first,
+	// in index order, followed by every frame written with PutFrame after
+	// the subscription was established. This lets consumers react to new
+	// frames without polling ListFrames.
+	//
+	// Frames are delivered in increasing index order: a frame written with
+	// an index below the last one delivered, such as an overwrite of a
+	// delivered frame, is stored but not delivered.
+	//
+	// Subscribing to a session that doesn't exist yet is allowed; frames
+	// are delivered once an ingress plugin starts writing to it.
+	//
+	// Parameters:
+	//   - ctx: Context controlling the lifetime of the subscription
+	//   - sessionID: Unique identifier for the media session
+	//   - fromIndex: Lowest frame index to deliver
+	//
+	// Returns:
+	//   - Channel of frames, closed when ctx is cancelled or the storage is closed.
+	//     Backends buffering live frames per subscriber also close it when the
+	//     subscriber falls more than MaxPendingFrames frames behind
+	//   - Error if the subscription cannot be established, or ErrClosed if the
+	//     storage is closed
+	Subscribe(ctx context.Context, sessionID string, fromIndex int64) (<-chan Frame, error)
+
 	// ListSessions returns all active session IDs.
 	// This method is used to discover available media sessions,
 	// typically by transform plugins that need to process all sessions.
@@ -92,14 +203,58 @@ type Storage interface {
 	//   - sessionID: Unique identifier for the media session to delete
 	//
 	// Returns an error if:
-	//   - Session not found
+	//   - Session not found (ErrSessionNotFound)
 	//   - Deletion fails
 	//   - Context is cancelled
 	DeleteSession(ctx context.Context, sessionID string) error
 
+	// SetRetention sets the retention policy of a session.
+	// An empty session ID sets the default policy, which applies to every
+	// session without a policy of its own. Policies take effect with the
+	// next frame written to a session.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeouts
+	//   - sessionID: Session to configure, or "" for the default policy
+	//   - policy: Limits to enforce; the zero policy keeps every frame
+	//
+	// Returns an error if the policy cannot be stored.
+	SetRetention(ctx context.Context, sessionID string, policy RetentionPolicy) error
+
+	// SetCheckpoint records the index of the last frame of a session that a
+	// consumer has processed, so that it can resume from the next frame
+	// after a restart instead of processing the session again. Checkpoints
+	// are kept by durable backends and deleted together with their session.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeouts
+	//   - consumer: Identifier of the consumer, e.g. a plugin instance
+	//   - sessionID: Unique identifier for the media session
+	//   - index: Index of the last processed frame
+	//
+	// Returns an error if:
+	//   - Session not found (ErrSessionNotFound)
+	//   - The checkpoint cannot be stored
+	SetCheckpoint(ctx context.Context, consumer, sessionID string, index int64) error
+
+	// GetCheckpoint returns the checkpoint a consumer recorded for a session
+	// with SetCheckpoint.
+	//
+	// Parameters:
+	//   - ctx: Context for cancellation and timeouts
+	//   - consumer: Identifier of the consumer, e.g. a plugin instance
+	//   - sessionID: Unique identifier for the media session
+	//
+	// Returns:
+	//   - Index of the last processed frame
+	//   - Whether the consumer has a checkpoint for the session
+	//   - Error if session not found or storage error occurs
+	GetCheckpoint(ctx context.Context, consumer, sessionID string) (int64, bool, error)
+
 	// Close cleans up any resources used by the storage backend.
 	// This should be called when the storage is no longer needed.
-	// After Close is called, no other methods should be called.
+	// After Close is called, no other methods should be called; PutFrame and
+	// Subscribe return ErrClosed. Calling Close again has no effect.
 	//
 	// Returns an error if cleanup fails.
 	Close() error
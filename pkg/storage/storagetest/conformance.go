@@ -0,0 +1,589 @@
+// Package storagetest provides a conformance test suite for implementations
+// of storage.Storage. Every backend is expected to pass it, so that plugins
+// can rely on the same semantics regardless of where frames are stored.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/frames"
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory creates an empty storage backend for a single test.
+// The suite closes the returned storage when the test ends.
+type Factory func(t *testing.T) storage.Storage
+
+// RunConformance runs the conformance suite against the storage backends
+// created by factory. Each case runs as a subtest with its own storage.
+func RunConformance(t *testing.T, factory Factory) {
+	cases := []struct {
+		name string
+		test func(t *testing.T, ctx context.Context, store storage.Storage)
+	}{
+		{"RoundTrip", testRoundTrip},
+		{"Ordering", testOrdering},
+		{"Overwrite", testOverwrite},
+		{"NotFound", testNotFound},
+		{"Ranges", testRanges},
+		{"Metadata", testMetadata},
+		{"KeyFrames", testKeyFrames},
+		{"ConcurrentWriters", testConcurrentWriters},
+		{"DeleteSession", testDeleteSession},
+		{"Retention", testRetention},
+		{"RetentionOutOfOrder", testRetentionOutOfOrder},
+		{"Checkpoints", testCheckpoints},
+		{"Subscribe", testSubscribe},
+		{"SubscribeOverwrite", testSubscribeOverwrite},
+		{"SubscribeFromKeyFrame", testSubscribeFromKeyFrame},
+		{"Close", testClose},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			store := factory(t)
+			t.Cleanup(func() { store.Close() })
+
+			c.test(t, ctx, store)
+		})
+	}
+}
+
+// base is the timestamp of the first test frame. Timestamps are kept at
+// microsecond precision, the coarsest precision a backend may store.
+var base = time.Unix(1700000000, 0)
+
+// frame creates a test frame whose data and timestamp derive from its index.
+func frame(sessionID string, index int64) storage.Frame {
+	return storage.Frame{
+		SessionID: sessionID,
+		Index:     index,
+		Data:      []byte(fmt.Sprintf("frame %d", index)),
+		Timestamp: base.Add(time.Duration(index) * time.Second),
+		MediaType: frames.MediaTypeVideo,
+		Codec:     frames.CodecH264,
+		KeyFrame:  index%10 == 0,
+		TrackID:   1,
+		Sequence:  uint64(index),
+		PTS:       index * 3000,
+		DTS:       index * 3000,
+		Duration:  3000,
+		Timebase:  frames.Timebase90kHz,
+		SideData:  []frames.SideData{{Type: frames.SideDataSPS, Data: []byte{0x67, 0x42}}},
+	}
+}
+
+// indexes returns the indexes of frames in order.
+func indexes(frames []storage.Frame) []int64 {
+	result := make([]int64, 0, len(frames))
+	for _, f := range frames {
+		result = append(result, f.Index)
+	}
+	return result
+}
+
+// testRoundTrip checks that every field of a frame is stored.
+func testRoundTrip(t *testing.T, ctx context.Context, store storage.Storage) {
+	want := frame("cam1", 10)
+	require.NoError(t, store.PutFrame(ctx, want))
+
+	got, err := store.GetFrame(ctx, "cam1", 10)
+	require.NoError(t, err)
+	assert.Equal(t, want.SessionID, got.SessionID)
+	assert.Equal(t, want.Index, got.Index)
+	assert.Equal(t, want.Data, got.Data)
+	assert.True(t, want.Timestamp.Equal(got.Timestamp), "timestamp %v, want %v", got.Timestamp, want.Timestamp)
+	assert.Equal(t, want.MediaType, got.MediaType)
+	assert.Equal(t, want.Codec, got.Codec)
+	assert.Equal(t, want.KeyFrame, got.KeyFrame)
+	assert.Equal(t, want.TrackID, got.TrackID)
+	assert.Equal(t, want.Sequence, got.Sequence)
+	assert.Equal(t, want.PTS, got.PTS)
+	assert.Equal(t, want.DTS, got.DTS)
+	assert.Equal(t, want.Duration, got.Duration)
+	assert.Equal(t, want.Timebase, got.Timebase)
+	assert.Equal(t, want.SideData, got.SideData)
+}
+
+// testOrdering checks that frames are listed by index, whatever the order
+// they were written in.
+func testOrdering(t *testing.T, ctx context.Context, store storage.Storage) {
+	for _, i := range []int64{5, 1, 9, 3, 7, 0, 2, 8, 4, 6} {
+		require.NoError(t, store.PutFrame(ctx, frame("cam1", i)))
+	}
+
+	frames, err := store.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, indexes(frames))
+}
+
+// testOverwrite checks that writing an existing index replaces the frame
+// instead of adding a duplicate.
+func testOverwrite(t *testing.T, ctx context.Context, store storage.Storage) {
+	for i := int64(0); i < 3; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam1", i)))
+	}
+
+	replacement := frame("cam1", 1)
+	replacement.Data = []byte("replaced")
+	require.NoError(t, store.PutFrame(ctx, replacement))
+
+	frames, err := store.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{0, 1, 2}, indexes(frames))
+
+	got, err := store.GetFrame(ctx, "cam1", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("replaced"), got.Data)
+}
+
+// testNotFound checks that reading missing sessions and frames fails with
+// ErrSessionNotFound and ErrFrameNotFound.
+func testNotFound(t *testing.T, ctx context.Context, store storage.Storage) {
+	_, err := store.GetFrame(ctx, "missing", 0)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound, "GetFrame of a missing session")
+	_, err = store.ListFrames(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound, "ListFrames of a missing session")
+	_, err = store.ListFramesRange(ctx, "missing", 0, 10)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound, "ListFramesRange of a missing session")
+	_, err = store.ListFramesPage(ctx, "missing", 0, 10)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound, "ListFramesPage of a missing session")
+	_, err = store.ListFramesByTime(ctx, "missing", base, base.Add(time.Hour))
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound, "ListFramesByTime of a missing session")
+	assert.ErrorIs(t, store.DeleteSession(ctx, "missing"), storage.ErrSessionNotFound, "DeleteSession of a missing session")
+
+	require.NoError(t, store.PutFrame(ctx, frame("cam1", 0)))
+	_, err = store.GetFrame(ctx, "cam1", 1)
+	assert.ErrorIs(t, err, storage.ErrFrameNotFound, "GetFrame of a missing frame")
+
+	sessions, err := store.ListSessions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cam1"}, sessions)
+}
+
+// testRanges checks index ranges, pages and time ranges.
+func testRanges(t *testing.T, ctx context.Context, store storage.Storage) {
+	for i := int64(0); i < 20; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam1", i)))
+	}
+
+	frames, err := store.ListFramesRange(ctx, "cam1", 5, 8)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{5, 6, 7, 8}, indexes(frames))
+
+	frames, err = store.ListFramesRange(ctx, "cam1", 30, 40)
+	require.NoError(t, err)
+	assert.Empty(t, frames)
+
+	frames, err = store.ListFramesByTime(ctx, "cam1", base.Add(3*time.Second), base.Add(5*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3, 4, 5}, indexes(frames))
+
+	var paged []int64
+	cursor := int64(0)
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 10, "too many pages")
+
+		page, err := store.ListFramesPage(ctx, "cam1", cursor, 6)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(page.Frames), 6)
+		paged = append(paged, indexes(page.Frames)...)
+		cursor = page.NextCursor
+		if !page.HasMore {
+			break
+		}
+	}
+	assert.Len(t, paged, 20)
+
+	_, err = store.ListFramesPage(ctx, "cam1", 0, 0)
+	assert.Error(t, err, "ListFramesPage with a zero limit")
+}
+
+// testMetadata checks that frame metadata is listed without frame data and
+// follows overwrites and evictions.
+func testMetadata(t *testing.T, ctx context.Context, store storage.Storage) {
+	_, err := store.ListFrameMetadata(ctx, "missing", 0, 10)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound, "ListFrameMetadata of a missing session")
+
+	require.NoError(t, store.SetRetention(ctx, "cam1", storage.RetentionPolicy{MaxFrames: 15}))
+	for i := int64(0); i < 20; i++ {
+		f := frame("cam1", i)
+		if i%5 == 0 {
+			// Large enough for backends to read only the start of the frame
+			f.Data = make([]byte, 4096)
+		}
+		require.NoError(t, store.PutFrame(ctx, f))
+	}
+	overwritten := frame("cam1", 12)
+	overwritten.Data = []byte("overwritten")
+	overwritten.KeyFrame = true
+	require.NoError(t, store.PutFrame(ctx, overwritten))
+
+	// Evicted frames are left out just like in ListFramesRange
+	frames, err := store.ListFramesRange(ctx, "cam1", 0, 12)
+	require.NoError(t, err)
+	metadata, err := store.ListFrameMetadata(ctx, "cam1", 0, 12)
+	require.NoError(t, err)
+	require.Len(t, metadata, len(frames))
+	require.Less(t, len(frames), 13, "retention evicted no frames")
+
+	for i, got := range metadata {
+		assert.Equal(t, frames[i].Index, got.Index)
+		want := frame("cam1", got.Index)
+		if got.Index%5 == 0 {
+			want.Data = make([]byte, 4096)
+		}
+		if got.Index == 12 {
+			want = overwritten
+		}
+		expected := want.Metadata()
+		assert.True(t, expected.Timestamp.Equal(got.Timestamp), "timestamp of frame %d", got.Index)
+		expected.Timestamp = got.Timestamp
+		assert.Equal(t, expected, got, "metadata of frame %d", got.Index)
+	}
+	assert.Equal(t, 4096, metadata[len(metadata)-3].Size, "size of frame 10")
+
+	metadata, err = store.ListFrameMetadata(ctx, "cam1", 30, 40)
+	require.NoError(t, err)
+	assert.Empty(t, metadata)
+}
+
+// testKeyFrames checks that the latest key frame follows writes, overwrites
+// and evictions.
+func testKeyFrames(t *testing.T, ctx context.Context, store storage.Storage) {
+	_, err := store.LatestKeyFrame(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound, "LatestKeyFrame of a missing session")
+
+	// Every tenth frame is a key frame
+	for i := int64(1); i < 10; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam1", i)))
+	}
+	_, err = store.LatestKeyFrame(ctx, "cam1")
+	assert.ErrorIs(t, err, storage.ErrFrameNotFound, "LatestKeyFrame without key frames")
+
+	for i := int64(10); i < 25; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam1", i)))
+	}
+	latest, err := store.LatestKeyFrame(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), latest.Index)
+	assert.Equal(t, []byte("frame 20"), latest.Data)
+
+	// Overwrites update the index both ways
+	replaced := frame("cam1", 20)
+	replaced.KeyFrame = false
+	require.NoError(t, store.PutFrame(ctx, replaced))
+	latest, err = store.LatestKeyFrame(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), latest.Index)
+
+	replaced = frame("cam1", 15)
+	replaced.KeyFrame = true
+	require.NoError(t, store.PutFrame(ctx, replaced))
+	latest, err = store.LatestKeyFrame(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(15), latest.Index)
+
+	// Evicted key frames leave the index
+	require.NoError(t, store.SetRetention(ctx, "cam2", storage.RetentionPolicy{MaxFrames: 5}))
+	for i := int64(0); i < 10; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam2", i)))
+	}
+	_, err = store.LatestKeyFrame(ctx, "cam2")
+	assert.ErrorIs(t, err, storage.ErrFrameNotFound, "LatestKeyFrame after eviction")
+}
+
+// testConcurrentWriters checks that concurrent writes to the same and to
+// different sessions are all stored.
+func testConcurrentWriters(t *testing.T, ctx context.Context, store storage.Storage) {
+	const writers, perWriter = 8, 25
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				index := int64(w*perWriter + i)
+				assert.NoError(t, store.PutFrame(ctx, frame("shared", index)))
+				assert.NoError(t, store.PutFrame(ctx, frame(fmt.Sprintf("own%d", w), int64(i))))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	frames, err := store.ListFrames(ctx, "shared")
+	require.NoError(t, err)
+	require.Len(t, frames, writers*perWriter)
+	for i, f := range frames {
+		assert.Equal(t, int64(i), f.Index)
+	}
+
+	sessions, err := store.ListSessions(ctx)
+	require.NoError(t, err)
+	assert.Len(t, sessions, writers+1)
+}
+
+// testDeleteSession checks that a deleted session is gone entirely and
+// starts empty when written again.
+func testDeleteSession(t *testing.T, ctx context.Context, store storage.Storage) {
+	for i := int64(0); i < 10; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam1", i)))
+		require.NoError(t, store.PutFrame(ctx, frame("cam2", i)))
+	}
+
+	require.NoError(t, store.DeleteSession(ctx, "cam1"))
+
+	_, err := store.ListFrames(ctx, "cam1")
+	assert.Error(t, err)
+	_, err = store.GetFrame(ctx, "cam1", 0)
+	assert.Error(t, err)
+
+	sessions, err := store.ListSessions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cam2"}, sessions)
+
+	// Other sessions are untouched
+	frames, err := store.ListFrames(ctx, "cam2")
+	require.NoError(t, err)
+	assert.Len(t, frames, 10)
+
+	// A new session with the same ID doesn't see the old frames
+	require.NoError(t, store.PutFrame(ctx, frame("cam1", 42)))
+	frames, err = store.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{42}, indexes(frames))
+}
+
+// testRetention checks that per-session and default policies evict the
+// oldest frames.
+func testRetention(t *testing.T, ctx context.Context, store storage.Storage) {
+	require.NoError(t, store.SetRetention(ctx, "", storage.RetentionPolicy{MaxFrames: 5}))
+	require.NoError(t, store.SetRetention(ctx, "cam2", storage.RetentionPolicy{MaxAge: 2 * time.Second}))
+
+	for i := int64(0); i < 10; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam1", i)))
+		require.NoError(t, store.PutFrame(ctx, frame("cam2", i)))
+	}
+
+	frames, err := store.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{5, 6, 7, 8, 9}, indexes(frames))
+
+	frames, err = store.ListFrames(ctx, "cam2")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{7, 8, 9}, indexes(frames))
+}
+
+// testRetentionOutOfOrder checks that MaxAge evicts frames by timestamp
+// rather than index when frames arrive out of timestamp order.
+func testRetentionOutOfOrder(t *testing.T, ctx context.Context, store storage.Storage) {
+	require.NoError(t, store.SetRetention(ctx, "cam1", storage.RetentionPolicy{MaxAge: 2 * time.Second}))
+
+	// Frame 1 is older than the cutoff, frame 0 isn't
+	for i, offset := range []time.Duration{10, 0, 11, 12} {
+		f := frame("cam1", int64(i))
+		f.Timestamp = base.Add(offset * time.Second)
+		require.NoError(t, store.PutFrame(ctx, f))
+	}
+
+	frames, err := store.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{0, 2, 3}, indexes(frames))
+}
+
+// testSubscribe checks that a subscription delivers the backlog from the
+// requested index followed by new frames.
+func testSubscribe(t *testing.T, ctx context.Context, store storage.Storage) {
+	for i := int64(0); i < 5; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam1", i)))
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	frames, err := store.Subscribe(subCtx, "cam1", 2)
+	require.NoError(t, err)
+
+	for i := int64(5); i < 10; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam1", i)))
+	}
+
+	for want := int64(2); want < 10; want++ {
+		select {
+		case f := <-frames:
+			require.Equal(t, want, f.Index)
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for frame %d", want)
+		}
+	}
+
+	// Cancelling the context closes the channel
+	cancel()
+	assertClosed(t, ctx, frames)
+}
+
+// testSubscribeOverwrite checks that a subscription delivers frames in
+// increasing index order, so overwrites of frames it already delivered are
+// not delivered again.
+func testSubscribeOverwrite(t *testing.T, ctx context.Context, store storage.Storage) {
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	frames, err := store.Subscribe(subCtx, "cam1", 0)
+	require.NoError(t, err)
+
+	receive := func(want int64) storage.Frame {
+		select {
+		case f := <-frames:
+			require.Equal(t, want, f.Index)
+			return f
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for frame %d", want)
+			return storage.Frame{}
+		}
+	}
+
+	for i := int64(0); i < 3; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam1", i)))
+		receive(i)
+	}
+
+	overwrite := frame("cam1", 1)
+	overwrite.Data = []byte("overwritten")
+	require.NoError(t, store.PutFrame(ctx, overwrite))
+	require.NoError(t, store.PutFrame(ctx, frame("cam1", 3)))
+	assert.Equal(t, frame("cam1", 3).Data, receive(3).Data)
+
+	// The overwrite is stored even though it wasn't delivered
+	stored, err := store.GetFrame(ctx, "cam1", 1)
+	require.NoError(t, err)
+	assert.Equal(t, overwrite.Data, stored.Data)
+}
+
+// testSubscribeFromKeyFrame checks that SubscribeFromKeyFrame starts at the
+// latest key frame, or waits for the next one if there is none.
+func testSubscribeFromKeyFrame(t *testing.T, ctx context.Context, store storage.Storage) {
+	for i := int64(0); i < 15; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam1", i)))
+	}
+	for i := int64(1); i < 5; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam2", i)))
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	live, err := storage.SubscribeFromKeyFrame(subCtx, store, "cam1")
+	require.NoError(t, err)
+	waiting, err := storage.SubscribeFromKeyFrame(subCtx, store, "cam2")
+	require.NoError(t, err)
+
+	for i := int64(15); i < 20; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam1", i)))
+	}
+	for i := int64(5); i < 12; i++ {
+		require.NoError(t, store.PutFrame(ctx, frame("cam2", i)))
+	}
+
+	for _, c := range []struct {
+		frames   <-chan storage.Frame
+		from, to int64
+	}{
+		{live, 10, 19},
+		{waiting, 10, 11},
+	} {
+		for want := c.from; want <= c.to; want++ {
+			select {
+			case f := <-c.frames:
+				require.Equal(t, want, f.Index)
+			case <-ctx.Done():
+				t.Fatalf("timed out waiting for frame %d", want)
+			}
+		}
+	}
+
+	cancel()
+	assertClosed(t, ctx, live)
+	assertClosed(t, ctx, waiting)
+}
+
+// testCheckpoints checks that checkpoints are kept per consumer and session
+// and deleted together with their session.
+func testCheckpoints(t *testing.T, ctx context.Context, store storage.Storage) {
+	err := store.SetCheckpoint(ctx, "watermark", "missing", 0)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound, "SetCheckpoint of a missing session")
+	_, _, err = store.GetCheckpoint(ctx, "watermark", "missing")
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound, "GetCheckpoint of a missing session")
+
+	require.NoError(t, store.PutFrame(ctx, frame("cam1", 0)))
+	require.NoError(t, store.PutFrame(ctx, frame("cam2", 0)))
+
+	_, ok, err := store.GetCheckpoint(ctx, "watermark", "cam1")
+	require.NoError(t, err)
+	assert.False(t, ok, "checkpoint before SetCheckpoint")
+
+	require.NoError(t, store.SetCheckpoint(ctx, "watermark", "cam1", 5))
+	require.NoError(t, store.SetCheckpoint(ctx, "watermark", "cam1", 7))
+	require.NoError(t, store.SetCheckpoint(ctx, "thumbnail", "cam1", 3))
+	require.NoError(t, store.SetCheckpoint(ctx, "watermark", "cam2", 1))
+
+	for _, c := range []struct {
+		consumer  string
+		sessionID string
+		index     int64
+	}{
+		{"watermark", "cam1", 7},
+		{"thumbnail", "cam1", 3},
+		{"watermark", "cam2", 1},
+	} {
+		index, ok, err := store.GetCheckpoint(ctx, c.consumer, c.sessionID)
+		require.NoError(t, err)
+		assert.True(t, ok, "checkpoint of %s in %s", c.consumer, c.sessionID)
+		assert.Equal(t, c.index, index, "checkpoint of %s in %s", c.consumer, c.sessionID)
+	}
+
+	require.NoError(t, store.DeleteSession(ctx, "cam1"))
+	require.NoError(t, store.PutFrame(ctx, frame("cam1", 0)))
+	_, ok, err = store.GetCheckpoint(ctx, "watermark", "cam1")
+	require.NoError(t, err)
+	assert.False(t, ok, "checkpoint of a deleted session")
+}
+
+// testClose checks that Close ends subscriptions, can be called again and
+// makes PutFrame and Subscribe fail with ErrClosed.
+func testClose(t *testing.T, ctx context.Context, store storage.Storage) {
+	require.NoError(t, store.PutFrame(ctx, frame("cam1", 0)))
+
+	frames, err := store.Subscribe(ctx, "cam1", 1)
+	require.NoError(t, err)
+
+	assert.NoError(t, store.Close())
+	assertClosed(t, ctx, frames)
+	assert.NoError(t, store.Close(), "second Close")
+
+	assert.ErrorIs(t, store.PutFrame(ctx, frame("cam1", 1)), storage.ErrClosed, "PutFrame after Close")
+	_, err = store.Subscribe(ctx, "cam1", 0)
+	assert.ErrorIs(t, err, storage.ErrClosed, "Subscribe after Close")
+}
+
+// assertClosed waits for a subscription channel to be closed, discarding
+// frames still in flight.
+func assertClosed(t *testing.T, ctx context.Context, frames <-chan storage.Frame) {
+	t.Helper()
+	for {
+		select {
+		case _, ok := <-frames:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			t.Fatal("subscription channel not closed")
+		}
+	}
+}
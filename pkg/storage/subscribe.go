@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+)
+
+// MaxPendingFrames is the number of frames written after a subscription was
+// established that may wait for delivery to its subscriber. A subscriber
+// falling further behind is dropped: its channel is closed without the
+// waiting frames, and it can subscribe again from the index after the last
+// frame it received to read them from storage.
+const MaxPendingFrames = 1024
+
+// subscription delivers frames to a single subscriber.
+// Producers push frames into a bounded queue without blocking, and a
+// dedicated goroutine drains the queue into the subscriber's channel. This
+// keeps PutFrame fast regardless of how quickly subscribers consume frames,
+// while a stalled subscriber holds at most MaxPendingFrames frames.
+type subscription struct {
+	mu      sync.Mutex
+	backlog []Frame       // Already stored frames, delivered first
+	queue   []Frame       // Live frames waiting to be delivered, in arrival order
+	notify  chan struct{} // Signals the delivery goroutine that the queue changed
+	dropped chan struct{} // Closed when the queue overflows, ending delivery
+	out     chan Frame    // Channel handed to the subscriber
+}
+
+// newSubscription creates a subscription that first delivers the given
+// backlog of already stored frames. The backlog doesn't count towards
+// MaxPendingFrames.
+func newSubscription(backlog []Frame) *subscription {
+	return &subscription{
+		backlog: backlog,
+		notify:  make(chan struct{}, 1),
+		dropped: make(chan struct{}),
+		out:     make(chan Frame),
+	}
+}
+
+// push appends a frame to the delivery queue. It never blocks. If the queue
+// already holds MaxPendingFrames frames, the subscription is dropped instead.
+// Producers feeding the subscription from a goroutine stop once dropped is
+// closed.
+func (s *subscription) push(frame Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if isClosed(s.dropped) {
+		return
+	}
+	if len(s.queue) >= MaxPendingFrames {
+		s.queue = nil
+		close(s.dropped)
+		return
+	}
+	s.queue = append(s.queue, frame)
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers the backlog and then queued frames to the subscriber until the
+// context is cancelled, done is closed or the subscription is dropped. The
+// output channel is closed when run returns.
+func (s *subscription) run(ctx context.Context, done <-chan struct{}) {
+	defer close(s.out)
+
+	for {
+		frame, ok := s.next()
+		if !ok {
+			select {
+			case <-s.notify:
+				continue
+			case <-s.dropped:
+				return
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			}
+		}
+
+		select {
+		case s.out <- frame:
+		case <-s.dropped:
+			return
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// next takes the next frame to deliver, from the backlog first and then from
+// the queue. Queued frames are only removed once taken, so the queue holds
+// every live frame not delivered yet. It reports false if there is no frame
+// to deliver.
+func (s *subscription) next() (Frame, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var frame Frame
+	switch {
+	case len(s.backlog) > 0:
+		frame, s.backlog = s.backlog[0], s.backlog[1:]
+	case len(s.queue) > 0:
+		frame, s.queue = s.queue[0], s.queue[1:]
+	default:
+		return Frame{}, false
+	}
+	return frame, true
+}
+
+// pageFunc reads a page of frames of a session starting at cursor.
+type pageFunc func(ctx context.Context, cursor int64) (FramePage, error)
+
+// follow delivers frames of a session by paging through storage from a
+// cursor, waking up whenever notify fires. Backends that keep frame data
+// outside of memory use it for Subscribe, so that a subscription reads its
+// backlog page by page instead of loading it at once. Frames are delivered
+// in index order; frames later written below the cursor are not delivered.
+//
+// The notify channel must be registered with the backend before follow is
+// called so that no write is missed. onExit is called once delivery stops,
+// after which the returned channel is closed.
+func follow(ctx context.Context, done, notify <-chan struct{}, fromIndex int64, page pageFunc, onExit func()) <-chan Frame {
+	out := make(chan Frame)
+
+	go func() {
+		defer close(out)
+		defer onExit()
+
+		cursor := fromIndex
+		for {
+			// Deliver everything stored from the cursor on
+			for {
+				p, err := page(ctx, cursor)
+				if err != nil {
+					// The session may not exist yet; wait for a write
+					break
+				}
+
+				for _, frame := range p.Frames {
+					select {
+					case out <- frame:
+					case <-ctx.Done():
+						return
+					case <-done:
+						return
+					}
+				}
+				cursor = p.NextCursor
+
+				if !p.HasMore {
+					break
+				}
+			}
+
+			select {
+			case <-notify:
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// SubscribeFromKeyFrame subscribes to a session starting at its latest key
+// frame, so that the first delivered frame can be decoded without the frames
+// before it. It is meant for egress plugins starting new viewers on a live
+// video session: the viewer receives the current group of pictures and then
+// every new frame.
+//
+// Frames before the first key frame are dropped, which covers sessions
+// without a stored key frame yet and a key frame evicted by retention before
+// the subscription was established. Subscribing to a session that doesn't
+// exist yet is allowed, as with Subscribe.
+//
+// The returned channel is closed when ctx is cancelled or the storage is
+// closed.
+func SubscribeFromKeyFrame(ctx context.Context, store Storage, sessionID string) (<-chan Frame, error) {
+	fromIndex := int64(math.MinInt64)
+	keyFrame, err := store.LatestKeyFrame(ctx, sessionID)
+	switch {
+	case err == nil:
+		fromIndex = keyFrame.Index
+	case !errors.Is(err, ErrFrameNotFound) && !errors.Is(err, ErrSessionNotFound):
+		return nil, err
+	}
+
+	frames, err := store.Subscribe(ctx, sessionID, fromIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan Frame)
+	go func() {
+		defer close(out)
+
+		started := false
+		for frame := range frames {
+			if !started && !frame.KeyFrame {
+				continue
+			}
+			started = true
+
+			select {
+			case out <- frame:
+			case <-ctx.Done():
+				// Drain until Subscribe closes its channel
+				for range frames {
+				}
+				return
+			}
+		}
+	}()
+	return out, nil
+}
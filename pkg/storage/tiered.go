@@ -0,0 +1,535 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TieredConfig holds the configuration of a TieredStorage.
+type TieredConfig struct {
+	HotFrames  int // Frames kept in memory per session, default 300
+	SpillBatch int // Frames moved to the cold tier at once, default 30
+}
+
+// Default tier sizes, about 10 seconds of 30 fps video in memory.
+const (
+	defaultHotFrames  = 300
+	defaultSpillBatch = 30
+)
+
+// TieredStorage implements the Storage interface on top of two backends.
+// The most recent frames of each session are kept in a MemoryStorage, and
+// older frames are spilled to a cold backend such as FileStorage. Reads are
+// served from both tiers, so live viewers hit RAM while rewinding reads from
+// cheap storage.
+//
+// Spilling happens during PutFrame: once a session holds HotFrames plus
+// SpillBatch frames in memory, the oldest SpillBatch frames are written to
+// the cold tier and then dropped from memory. A frame is therefore always
+// readable from at least one tier. Frames still in memory are lost on a crash
+// if the cold tier is the only durable one; Close spills them.
+//
+// Writes are serialized; reads run concurrently with writes. A frame stored
+// in both tiers while it is being spilled is read from the hot tier.
+type TieredStorage struct {
+	mu           sync.Mutex                            // Serializes writes and spills
+	hot          *MemoryStorage                        // Recent frames of each session
+	cold         Storage                               // Frames spilled from the hot tier
+	config       TieredConfig                          // Tier sizes
+	coldSessions map[string]struct{}                   // Sessions with frames in the cold tier
+	watchers     map[string]map[chan struct{}]struct{} // Maps session ID to subscriptions waiting for frames
+	stateMu      sync.Mutex                            // Protects coldSessions and watchers
+	done         chan struct{}                         // Closed by Close to end all subscriptions
+	closeOnce    sync.Once                             // Guards closing of done
+}
+
+// NewTieredStorage creates a TieredStorage that spills frames to cold.
+// Sessions already stored in the cold tier are readable right away.
+// The TieredStorage takes ownership of cold and closes it in Close.
+//
+// Returns an error if the sessions of the cold tier cannot be listed.
+func NewTieredStorage(cold Storage, config TieredConfig) (*TieredStorage, error) {
+	if config.HotFrames <= 0 {
+		config.HotFrames = defaultHotFrames
+	}
+	if config.SpillBatch <= 0 {
+		config.SpillBatch = defaultSpillBatch
+	}
+
+	sessions, err := cold.ListSessions(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cold sessions: %v", err)
+	}
+
+	t := &TieredStorage{
+		hot:          NewMemoryStorage(),
+		cold:         cold,
+		config:       config,
+		coldSessions: make(map[string]struct{}, len(sessions)),
+		watchers:     make(map[string]map[chan struct{}]struct{}),
+		done:         make(chan struct{}),
+	}
+	for _, sessionID := range sessions {
+		t.coldSessions[sessionID] = struct{}{}
+	}
+	return t, nil
+}
+
+// PutFrame stores a frame in the hot tier and spills the oldest frames of the
+// session to the cold tier if the hot tier is full.
+//
+// Returns an error if spilling to the cold tier fails. The frame itself is
+// stored in the hot tier even then, and spilling is retried on the next write.
+func (t *TieredStorage) PutFrame(ctx context.Context, frame Frame) error {
+	if isClosed(t.done) {
+		return errClosed("put frame")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.hot.PutFrame(ctx, frame); err != nil {
+		return err
+	}
+
+	if err := t.spillLocked(ctx, frame.SessionID); err != nil {
+		return err
+	}
+
+	// Wake up subscribers
+	t.stateMu.Lock()
+	for notify := range t.watchers[frame.SessionID] {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+	t.stateMu.Unlock()
+	return nil
+}
+
+// spillLocked moves the oldest frames of a session to the cold tier once the
+// hot tier holds more than HotFrames plus SpillBatch of them.
+// The caller must hold t.mu.
+func (t *TieredStorage) spillLocked(ctx context.Context, sessionID string) error {
+	frames := t.hot.oldestFrames(sessionID, t.config.HotFrames)
+	if len(frames) < t.config.SpillBatch {
+		return nil
+	}
+
+	t.stateMu.Lock()
+	t.coldSessions[sessionID] = struct{}{}
+	t.stateMu.Unlock()
+
+	spilled := make([]int64, 0, len(frames))
+	for _, frame := range frames {
+		if err := t.cold.PutFrame(ctx, frame); err != nil {
+			t.hot.removeFrames(sessionID, spilled)
+			return fmt.Errorf("failed to spill frame %d: %v", frame.Index, err)
+		}
+		spilled = append(spilled, frame.Index)
+	}
+
+	t.hot.removeFrames(sessionID, spilled)
+	return t.spillCheckpoints(ctx, sessionID)
+}
+
+// spillCheckpoints copies the checkpoints a session has in the hot tier to
+// the cold tier, which only holds checkpoints of sessions it has frames of.
+func (t *TieredStorage) spillCheckpoints(ctx context.Context, sessionID string) error {
+	t.hot.mu.RLock()
+	checkpoints := make(map[string]int64, len(t.hot.checkpoints[sessionID]))
+	for consumer, index := range t.hot.checkpoints[sessionID] {
+		checkpoints[consumer] = index
+	}
+	t.hot.mu.RUnlock()
+
+	for consumer, index := range checkpoints {
+		if err := t.cold.SetCheckpoint(ctx, consumer, sessionID, index); err != nil {
+			return fmt.Errorf("failed to spill checkpoint of %s: %v", consumer, err)
+		}
+	}
+	return nil
+}
+
+// GetFrame retrieves a frame from the hot tier, falling back to the cold tier.
+// Returns an error if the frame is in neither tier.
+func (t *TieredStorage) GetFrame(ctx context.Context, sessionID string, frameIndex int64) (Frame, error) {
+	if frame, err := t.hot.GetFrame(ctx, sessionID, frameIndex); err == nil {
+		return frame, nil
+	}
+
+	if !t.inCold(sessionID) {
+		if !t.inHot(sessionID) {
+			return Frame{}, errSessionNotFound(sessionID)
+		}
+		return Frame{}, errFrameNotFound(sessionID, frameIndex)
+	}
+	return t.cold.GetFrame(ctx, sessionID, frameIndex)
+}
+
+// ListFrames returns all frames of a session from both tiers, sorted by
+// frame index.
+// Returns an error if the session doesn't exist.
+func (t *TieredStorage) ListFrames(ctx context.Context, sessionID string) ([]Frame, error) {
+	return t.ListFramesRange(ctx, sessionID, math.MinInt64, math.MaxInt64)
+}
+
+// ListFramesRange returns the frames of a session with an index between
+// fromIndex and toIndex (both inclusive) from both tiers, sorted by frame
+// index.
+// Returns an error if the session doesn't exist.
+func (t *TieredStorage) ListFramesRange(ctx context.Context, sessionID string, fromIndex, toIndex int64) ([]Frame, error) {
+	return t.listBoth(sessionID, func(store Storage) ([]Frame, error) {
+		return store.ListFramesRange(ctx, sessionID, fromIndex, toIndex)
+	})
+}
+
+// ListFrameMetadata returns the metadata of the frames of a session with an
+// index between fromIndex and toIndex (both inclusive) from both tiers,
+// sorted by frame index.
+// Returns an error if the session doesn't exist.
+func (t *TieredStorage) ListFrameMetadata(ctx context.Context, sessionID string, fromIndex, toIndex int64) ([]FrameMetadata, error) {
+	hot, hotErr := t.hot.ListFrameMetadata(ctx, sessionID, fromIndex, toIndex)
+	if !t.inCold(sessionID) {
+		return hot, hotErr
+	}
+
+	cold, err := t.cold.ListFrameMetadata(ctx, sessionID, fromIndex, toIndex)
+	if err != nil {
+		return nil, err
+	}
+	return mergeMetadata(cold, hot), nil
+}
+
+// LatestKeyFrame returns the key frame of a session with the highest index
+// in either tier. The hot tier usually holds it, but the cold tier is
+// consulted too for sessions that have spilled frames.
+// Returns an error if the session doesn't exist or holds no key frame.
+func (t *TieredStorage) LatestKeyFrame(ctx context.Context, sessionID string) (Frame, error) {
+	hot, hotErr := t.hot.LatestKeyFrame(ctx, sessionID)
+	if !t.inCold(sessionID) {
+		return hot, hotErr
+	}
+
+	cold, err := t.coldKeyFrame(ctx, sessionID)
+	if err != nil && !errors.Is(err, ErrFrameNotFound) {
+		return Frame{}, err
+	}
+	switch {
+	case hotErr == nil && (err != nil || hot.Index >= cold.Index):
+		return hot, nil
+	case err == nil:
+		return cold, nil
+	default:
+		return Frame{}, errNoKeyFrame(sessionID)
+	}
+}
+
+// coldKeyFrame returns the latest key frame of a session in the cold tier
+// that isn't shadowed by a frame overwritten in the hot tier.
+func (t *TieredStorage) coldKeyFrame(ctx context.Context, sessionID string) (Frame, error) {
+	frame, err := t.cold.LatestKeyFrame(ctx, sessionID)
+	if err != nil {
+		return Frame{}, err
+	}
+	if _, err := t.hot.GetFrame(ctx, sessionID, frame.Index); err != nil {
+		return frame, nil
+	}
+
+	// Rare: walk back through the older cold key frames
+	metadata, err := t.cold.ListFrameMetadata(ctx, sessionID, math.MinInt64, frame.Index-1)
+	if err != nil {
+		return Frame{}, err
+	}
+	for i := len(metadata) - 1; i >= 0; i-- {
+		if !metadata[i].KeyFrame {
+			continue
+		}
+		if _, err := t.hot.GetFrame(ctx, sessionID, metadata[i].Index); err != nil {
+			return t.cold.GetFrame(ctx, sessionID, metadata[i].Index)
+		}
+	}
+	return Frame{}, errNoKeyFrame(sessionID)
+}
+
+// ListFramesPage returns up to limit frames of a session starting at the
+// frame index given by cursor, reading a page from each tier and merging them.
+//
+// Returns an error if the session doesn't exist or limit is not positive.
+func (t *TieredStorage) ListFramesPage(ctx context.Context, sessionID string, cursor int64, limit int) (FramePage, error) {
+	if limit <= 0 {
+		return FramePage{}, fmt.Errorf("invalid page limit: %d", limit)
+	}
+
+	var hasMore bool
+	frames, err := t.listBoth(sessionID, func(store Storage) ([]Frame, error) {
+		page, err := store.ListFramesPage(ctx, sessionID, cursor, limit)
+		hasMore = hasMore || page.HasMore
+		return page.Frames, err
+	})
+	if err != nil {
+		return FramePage{}, err
+	}
+
+	if len(frames) > limit {
+		frames = frames[:limit]
+		hasMore = true
+	}
+
+	page := FramePage{Frames: frames, NextCursor: cursor, HasMore: hasMore}
+	if len(frames) > 0 {
+		page.NextCursor = frames[len(frames)-1].Index + 1
+	}
+	return page, nil
+}
+
+// ListFramesByTime returns the frames of a session whose Timestamp lies
+// between start and end (both inclusive) from both tiers, sorted by frame
+// index.
+// Returns an error if the session doesn't exist.
+func (t *TieredStorage) ListFramesByTime(ctx context.Context, sessionID string, start, end time.Time) ([]Frame, error) {
+	return t.listBoth(sessionID, func(store Storage) ([]Frame, error) {
+		return store.ListFramesByTime(ctx, sessionID, start, end)
+	})
+}
+
+// listBoth runs a list operation against both tiers and merges the results.
+// The cold tier is only queried for sessions that have spilled frames.
+func (t *TieredStorage) listBoth(sessionID string, list func(store Storage) ([]Frame, error)) ([]Frame, error) {
+	hot, hotErr := list(t.hot)
+	if !t.inCold(sessionID) {
+		return hot, hotErr
+	}
+
+	cold, err := list(t.cold)
+	if err != nil {
+		return nil, err
+	}
+	return mergeFrames(cold, hot), nil
+}
+
+// mergeFrames merges two index-ordered frame lists, preferring frames from
+// hot when both contain the same index.
+func mergeFrames(cold, hot []Frame) []Frame {
+	merged := make([]Frame, 0, len(cold)+len(hot))
+	i, j := 0, 0
+	for i < len(cold) || j < len(hot) {
+		switch {
+		case j == len(hot) || (i < len(cold) && cold[i].Index < hot[j].Index):
+			merged = append(merged, cold[i])
+			i++
+		case i < len(cold) && cold[i].Index == hot[j].Index:
+			i++
+		default:
+			merged = append(merged, hot[j])
+			j++
+		}
+	}
+	return merged
+}
+
+// mergeMetadata merges two index-ordered metadata lists like mergeFrames.
+func mergeMetadata(cold, hot []FrameMetadata) []FrameMetadata {
+	merged := make([]FrameMetadata, 0, len(cold)+len(hot))
+	i, j := 0, 0
+	for i < len(cold) || j < len(hot) {
+		switch {
+		case j == len(hot) || (i < len(cold) && cold[i].Index < hot[j].Index):
+			merged = append(merged, cold[i])
+			i++
+		case i < len(cold) && cold[i].Index == hot[j].Index:
+			i++
+		default:
+			merged = append(merged, hot[j])
+			j++
+		}
+	}
+	return merged
+}
+
+// Subscribe delivers frames of a session as they are stored. The backlog is
+// read page by page from both tiers; new frames are picked up as they are
+// written.
+//
+// The returned channel is closed when ctx is cancelled or Close is called.
+func (t *TieredStorage) Subscribe(ctx context.Context, sessionID string, fromIndex int64) (<-chan Frame, error) {
+	if isClosed(t.done) {
+		return nil, errClosed("subscribe")
+	}
+
+	notify := make(chan struct{}, 1)
+
+	t.stateMu.Lock()
+	if t.watchers[sessionID] == nil {
+		t.watchers[sessionID] = make(map[chan struct{}]struct{})
+	}
+	t.watchers[sessionID][notify] = struct{}{}
+	t.stateMu.Unlock()
+
+	page := func(ctx context.Context, cursor int64) (FramePage, error) {
+		return t.ListFramesPage(ctx, sessionID, cursor, filePageSize)
+	}
+	unwatch := func() {
+		t.stateMu.Lock()
+		delete(t.watchers[sessionID], notify)
+		if len(t.watchers[sessionID]) == 0 {
+			delete(t.watchers, sessionID)
+		}
+		t.stateMu.Unlock()
+	}
+
+	return follow(ctx, t.done, notify, fromIndex, page, unwatch), nil
+}
+
+// ListSessions returns the sessions of both tiers, sorted alphabetically.
+func (t *TieredStorage) ListSessions(ctx context.Context) ([]string, error) {
+	sessions, err := t.hot.ListSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t.stateMu.Lock()
+	for sessionID := range t.coldSessions {
+		sessions = append(sessions, sessionID)
+	}
+	t.stateMu.Unlock()
+
+	sort.Strings(sessions)
+	unique := sessions[:0]
+	for i, sessionID := range sessions {
+		if i == 0 || sessionID != sessions[i-1] {
+			unique = append(unique, sessionID)
+		}
+	}
+	return unique, nil
+}
+
+// DeleteSession removes a session from both tiers.
+// Returns an error if the session doesn't exist in either tier or the cold
+// tier fails to delete it.
+func (t *TieredStorage) DeleteSession(ctx context.Context, sessionID string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hotErr := t.hot.DeleteSession(ctx, sessionID)
+	if !t.inCold(sessionID) {
+		return hotErr
+	}
+
+	if err := t.cold.DeleteSession(ctx, sessionID); err != nil {
+		return err
+	}
+
+	t.stateMu.Lock()
+	delete(t.coldSessions, sessionID)
+	t.stateMu.Unlock()
+	return nil
+}
+
+// SetRetention sets the retention policy of a session, or the default policy
+// if sessionID is empty, on both tiers.
+//
+// Returns an error if the cold tier cannot store the policy.
+func (t *TieredStorage) SetRetention(ctx context.Context, sessionID string, policy RetentionPolicy) error {
+	if err := t.hot.SetRetention(ctx, sessionID, policy); err != nil {
+		return err
+	}
+	return t.cold.SetRetention(ctx, sessionID, policy)
+}
+
+// SetCheckpoint records the index of the last frame of a session that a
+// consumer has processed. The checkpoint is kept in the hot tier and, once
+// the session has frames in the cold tier, in the cold tier as well, so that
+// a durable cold tier keeps it across restarts.
+//
+// Returns an error if the session doesn't exist in either tier or the cold
+// tier cannot store the checkpoint.
+func (t *TieredStorage) SetCheckpoint(ctx context.Context, consumer, sessionID string, index int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	inHot, inCold := t.inHot(sessionID), t.inCold(sessionID)
+	if !inHot && !inCold {
+		return errSessionNotFound(sessionID)
+	}
+
+	if inHot {
+		if err := t.hot.SetCheckpoint(ctx, consumer, sessionID, index); err != nil {
+			return err
+		}
+	}
+	if inCold {
+		return t.cold.SetCheckpoint(ctx, consumer, sessionID, index)
+	}
+	return nil
+}
+
+// GetCheckpoint returns the checkpoint a consumer recorded for a session,
+// from the cold tier if the session has frames there.
+// Returns an error if the session doesn't exist in either tier.
+func (t *TieredStorage) GetCheckpoint(ctx context.Context, consumer, sessionID string) (int64, bool, error) {
+	if t.inCold(sessionID) {
+		return t.cold.GetCheckpoint(ctx, consumer, sessionID)
+	}
+	return t.hot.GetCheckpoint(ctx, consumer, sessionID)
+}
+
+// Close ends all active subscriptions, spills the frames still held in
+// memory to the cold tier along with their checkpoints and closes both tiers.
+//
+// Returns an error if spilling fails or the cold tier cannot be closed.
+func (t *TieredStorage) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.done)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		ctx := context.Background()
+		sessions, _ := t.hot.ListSessions(ctx)
+		for _, sessionID := range sessions {
+			frames := t.hot.oldestFrames(sessionID, 0)
+			for _, frame := range frames {
+				if putErr := t.cold.PutFrame(ctx, frame); putErr != nil && err == nil {
+					err = fmt.Errorf("failed to spill frame %d: %v", frame.Index, putErr)
+				}
+			}
+			if len(frames) > 0 || t.inCold(sessionID) {
+				if spillErr := t.spillCheckpoints(ctx, sessionID); spillErr != nil && err == nil {
+					err = spillErr
+				}
+			}
+		}
+
+		t.hot.Close()
+		if closeErr := t.cold.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	})
+	return err
+}
+
+// inHot reports whether a session has been written to the hot tier.
+func (t *TieredStorage) inHot(sessionID string) bool {
+	t.hot.mu.RLock()
+	defer t.hot.mu.RUnlock()
+
+	_, exists := t.hot.sessions[sessionID]
+	return exists
+}
+
+// inCold reports whether a session has frames in the cold tier.
+func (t *TieredStorage) inCold(sessionID string) bool {
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+
+	_, exists := t.coldSessions[sessionID]
+	return exists
+}
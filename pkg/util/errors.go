@@ -1,6 +1,7 @@
 package util
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -28,6 +29,26 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("%s: %s", e.Type, e.Message)
 }
 
+// Unwrap returns the cause of the error, so that errors.Is and errors.As
+// can match sentinel errors wrapped in an Error.
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is reports whether target is an *Error of the same type. A target with a
+// message only matches errors with the same message, so
+//
+//	errors.Is(err, &Error{Type: ErrorTypeStorage})
+//
+// matches any storage error.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return e.Type == t.Type && (t.Message == "" || e.Message == t.Message)
+}
+
 // NewError creates a new application error
 func NewError(errType ErrorType, message string, cause error) error {
 	return &Error{
@@ -37,9 +58,10 @@ func NewError(errType ErrorType, message string, cause error) error {
 	}
 }
 
-// IsErrorType checks if an error is of a specific type
+// IsErrorType checks if an error, or any error it wraps, is of a specific type
 func IsErrorType(err error, errType ErrorType) bool {
-	if appErr, ok := err.(*Error); ok {
+	var appErr *Error
+	if errors.As(err, &appErr) {
 		return appErr.Type == errType
 	}
 	return false
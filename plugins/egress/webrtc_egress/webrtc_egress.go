@@ -2,31 +2,114 @@ package webrtc_egress
 
 import (
 	"context"
-	"time"
+	"errors"
+	"fmt"
+	"sort"
 
 	"github.com/pion/webrtc/v3"
-	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/relais/pkg/frames"
 	"github.com/relais/pkg/plugins"
+	"github.com/relais/pkg/rtp"
 	"github.com/relais/pkg/storage"
 )
 
 // WebRTCEgressPlugin implements EgressPlugin for WebRTC output
 type WebRTCEgressPlugin struct {
 	peerConnection *webrtc.PeerConnection
-	videoTrack     *webrtc.TrackLocalStaticSample
+	track          *webrtc.TrackLocalStaticRTP
+	packetizer     *rtp.Packetizer
+	stream         string // Stream to send, names the default session
+	rendition      string // Rendition of the stream to send
+	sessionID      string // Session to send frames from, default the stream's rendition
+}
+
+// hevcPayloadType is the dynamic payload type H.265 is registered with, as
+// pion doesn't register it by default.
+const hevcPayloadType = 116
+
+// mimeTypes maps the codecs WebRTC can carry to their MIME type.
+var mimeTypes = map[frames.CodecType]string{
+	frames.CodecH264: webrtc.MimeTypeH264,
+	frames.CodecHEVC: webrtc.MimeTypeH265,
+	frames.CodecVP8:  webrtc.MimeTypeVP8,
+	frames.CodecVP9:  webrtc.MimeTypeVP9,
+	frames.CodecAV1:  webrtc.MimeTypeAV1,
+	frames.CodecOpus: webrtc.MimeTypeOpus,
 }
 
 // NewWebRTCEgressPlugin creates a new WebRTC egress plugin
 func NewWebRTCEgressPlugin() plugins.EgressPlugin {
-	return &WebRTCEgressPlugin{}
+	return &WebRTCEgressPlugin{
+		rendition: storage.RenditionSource, // Default to the frames as captured
+	}
+}
+
+// ConfigSchema returns the config options of the WebRTC egress plugin.
+func (p *WebRTCEgressPlugin) ConfigSchema() plugins.Schema {
+	codecs := make([]string, 0, len(mimeTypes))
+	for codec := range mimeTypes {
+		codecs = append(codecs, string(codec))
+	}
+	sort.Strings(codecs)
+	return plugins.Schema{Fields: []plugins.Field{
+		{Name: "codec", Type: plugins.FieldString, Description: "Codec of the frames to send", Default: string(frames.CodecH264), Values: codecs},
+		{Name: "stream", Type: plugins.FieldString, Description: "Stream to send, e.g. a camera device ID"},
+		{Name: "rendition", Type: plugins.FieldString, Description: "Rendition of the stream to send", Default: storage.RenditionSource},
+		{Name: "session_id", Type: plugins.FieldString, Description: "Session to send frames from, \"<stream>/<rendition>\" by default"},
+	}}
 }
 
+// Initialize sets up the peer connection and its track.
+// The host validates the config against ConfigSchema beforehand, so
+// options have the types of the schema.
+// Supported config options:
+// - codec: string - Codec of the frames to send, "h264" by default
+// - stream: string - Stream to send, e.g. a camera device ID
+// - rendition: string - Rendition of the stream to send, "source" by default
+// - session_id: string - Session to send frames from, "<stream>/<rendition>" by default
+//
+// Either stream or session_id must be set.
 func (p *WebRTCEgressPlugin) Initialize(ctx context.Context, config map[string]interface{}) error {
+	if stream, ok := config["stream"].(string); ok {
+		p.stream = stream
+	}
+	if rendition, ok := config["rendition"].(string); ok {
+		p.rendition = rendition
+	}
+	if sessionID, ok := config["session_id"].(string); ok {
+		p.sessionID = sessionID
+	}
+	if p.stream == "" && p.sessionID == "" {
+		return errors.New("missing stream or session_id")
+	}
+	codec := frames.CodecH264
+	if name, ok := config["codec"].(string); ok {
+		codec = frames.CodecType(name)
+	}
+	mimeType, ok := mimeTypes[codec]
+	if !ok {
+		return fmt.Errorf("unsupported WebRTC codec: %s", codec)
+	}
+
+	// Payload type and SSRC are set per peer connection by the track
+	packetizer, err := rtp.NewPacketizer(codec, 0, 0)
+	if err != nil {
+		return err
+	}
+
 	// Initialize WebRTC peer connection
 	mediaEngine := webrtc.MediaEngine{}
 	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
 		return err
 	}
+	if codec == frames.CodecHEVC {
+		if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeType, ClockRate: packetizer.ClockRate()},
+			PayloadType:        hevcPayloadType,
+		}, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
+	}
 
 	api := webrtc.NewAPI(webrtc.WithMediaEngine(&mediaEngine))
 	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{})
@@ -34,51 +117,61 @@ func (p *WebRTCEgressPlugin) Initialize(ctx context.Context, config map[string]i
 		return err
 	}
 
-	// Create video track
-	videoTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: "video/h264"},
-		"video",
+	// Create the track, fed with packets from the packetizer
+	trackID := "video"
+	if codec.IsAudio() {
+		trackID = "audio"
+	}
+	track, err := webrtc.NewTrackLocalStaticRTP(
+		webrtc.RTPCodecCapability{MimeType: mimeType, ClockRate: packetizer.ClockRate()},
+		trackID,
 		"relais-stream",
 	)
 	if err != nil {
 		return err
 	}
 
-	if _, err = peerConnection.AddTrack(videoTrack); err != nil {
+	if _, err = peerConnection.AddTrack(track); err != nil {
 		return err
 	}
 
 	p.peerConnection = peerConnection
-	p.videoTrack = videoTrack
+	p.track = track
+	p.packetizer = packetizer
 	return nil
 }
 
 func (p *WebRTCEgressPlugin) Run(ctx context.Context, store storage.Storage) error {
-	ticker := time.NewTicker(time.Second / 30) // 30 FPS
-	defer ticker.Stop()
+	sessionID := p.sessionID
+	if sessionID == "" {
+		sessionID = storage.RenditionSessionID(p.stream, p.rendition)
+	}
 
-	var lastFrameIndex int64 = -1
+	// Start on the latest key frame so the viewer can decode the first sample
+	stream, err := storage.SubscribeFromKeyFrame(ctx, store, sessionID)
+	if err != nil {
+		return err
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-ticker.C:
-			frames, err := store.ListFrames(ctx, "current_session")
+		case frame, ok := <-stream:
+			if !ok {
+				// Storage was closed or the subscription ended
+				return ctx.Err()
+			}
+
+			packets, err := p.packetizer.Packetize(frame)
 			if err != nil {
+				// Skip frames that can't be packetized
 				continue
 			}
 
-			// Find new frames
-			for _, frame := range frames {
-				if frame.Index > lastFrameIndex {
-					if err := p.videoTrack.WriteSample(media.Sample{
-						Data:     frame.Data,
-						Duration: time.Second / 30,
-					}); err != nil {
-						return err
-					}
-					lastFrameIndex = frame.Index
+			for _, packet := range packets {
+				if err := p.track.WriteRTP(packet); err != nil {
+					return err
 				}
 			}
 		}
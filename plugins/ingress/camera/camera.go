@@ -3,8 +3,11 @@ package camera
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/relais/pkg/frames"
 	"github.com/relais/pkg/plugins"
 	"github.com/relais/pkg/storage"
 )
@@ -12,8 +15,9 @@ import (
 // CameraPlugin implements IngressPlugin for camera input.
 // It generates simulated video frames at a specified frame rate.
 type CameraPlugin struct {
-	deviceID string // Unique identifier for the camera device
-	fps      int    // Frames per second to generate
+	deviceID  string // Unique identifier for the camera device
+	fps       int    // Frames per second to generate
+	sessionID string // Session to write frames to, default the device's source rendition
 }
 
 // NewCameraPlugin creates a new camera ingress plugin with default settings.
@@ -23,10 +27,22 @@ func NewCameraPlugin() plugins.IngressPlugin {
 	}
 }
 
+// ConfigSchema returns the config options of the camera plugin.
+func (p *CameraPlugin) ConfigSchema() plugins.Schema {
+	return plugins.Schema{Fields: []plugins.Field{
+		{Name: "device_id", Type: plugins.FieldString, Description: "Unique identifier for the camera", Required: true},
+		{Name: "fps", Type: plugins.FieldInt, Description: "Frames per second to generate", Default: 30, Range: &plugins.Range{Min: 1, Max: 240}},
+		{Name: "session_id", Type: plugins.FieldString, Description: "Session to write frames to, \"<device_id>/source\" by default"},
+	}}
+}
+
 // Initialize sets up the camera plugin with configuration parameters.
+// The host validates the config against ConfigSchema beforehand, so
+// options have the types of the schema; others are ignored.
 // Supported config options:
-// - device_id: string - Unique identifier for the camera
-// - fps: int - Frames per second to generate
+// - device_id: string - Unique identifier for the camera, required
+// - fps: int - Frames per second to generate, 1 to 240, 30 by default
+// - session_id: string - Session to write frames to, "<device_id>/source" by default
 func (p *CameraPlugin) Initialize(ctx context.Context, config map[string]interface{}) error {
 	if deviceID, ok := config["device_id"].(string); ok {
 		p.deviceID = deviceID
@@ -34,16 +50,32 @@ func (p *CameraPlugin) Initialize(ctx context.Context, config map[string]interfa
 	if fps, ok := config["fps"].(int); ok {
 		p.fps = fps
 	}
+	if sessionID, ok := config["session_id"].(string); ok {
+		p.sessionID = sessionID
+	}
 	return nil
 }
 
-// Run starts generating simulated video frames and storing them.
+// Run starts generating simulated video frames and storing them in the
+// source rendition of the device's stream, or the configured session.
 // Frames are generated at the configured FPS rate until context is cancelled.
+// A restarted run continues after the last frame stored in the session
+// rather than overwriting it.
 func (p *CameraPlugin) Run(ctx context.Context, store storage.Storage) error {
+	sessionID := p.sessionID
+	if sessionID == "" {
+		sessionID = storage.RenditionSessionID(p.deviceID, storage.RenditionSource)
+	}
+	frameIndex, err := nextIndex(ctx, store, sessionID)
+	if err != nil {
+		return err
+	}
+
 	ticker := time.NewTicker(time.Second / time.Duration(p.fps))
 	defer ticker.Stop()
 
-	frameIndex := int64(0)
+	// Media timestamps advance by one frame duration on the RTP video clock
+	duration := frames.Timebase90kHz.Timestamp(time.Second / time.Duration(p.fps))
 
 	for {
 		select {
@@ -52,11 +84,17 @@ func (p *CameraPlugin) Run(ctx context.Context, store storage.Storage) error {
 		case <-ticker.C:
 			// Create a simulated video frame
 			frame := storage.Frame{
-				SessionID: p.deviceID,
+				SessionID: sessionID,
 				Index:     frameIndex,
 				Timestamp: time.Now(),
-				MediaType: "video",
+				MediaType: frames.MediaTypeVideo,
 				Data:      []byte("mock frame data"), // In real implementation, this would be actual frame data
+				KeyFrame:  true,
+				Sequence:  uint64(frameIndex),
+				PTS:       frameIndex * duration,
+				DTS:       frameIndex * duration,
+				Duration:  duration,
+				Timebase:  frames.Timebase90kHz,
 			}
 
 			if err := store.PutFrame(ctx, frame); err != nil {
@@ -68,6 +106,20 @@ func (p *CameraPlugin) Run(ctx context.Context, store storage.Storage) error {
 	}
 }
 
+// nextIndex returns the index following the last frame of a session, or 0
+// if the session holds no frame. Every generated frame is a key frame, so
+// the latest key frame is the last frame written.
+func nextIndex(ctx context.Context, store storage.Storage, sessionID string) (int64, error) {
+	frame, err := store.LatestKeyFrame(ctx, sessionID)
+	if errors.Is(err, storage.ErrSessionNotFound) || errors.Is(err, storage.ErrFrameNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find last frame: %w", err)
+	}
+	return frame.Index + 1, nil
+}
+
 // Stop cleans up any resources used by the camera plugin.
 func (p *CameraPlugin) Stop() error {
 	// Cleanup resources if needed
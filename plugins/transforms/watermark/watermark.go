@@ -3,112 +3,129 @@ package watermark
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"image"
 	"image/draw"
 	"image/png"
-	"time"
 
+	"github.com/relais/pkg/frames"
 	"github.com/relais/pkg/plugins"
 	"github.com/relais/pkg/storage"
 )
 
-// WatermarkPlugin implements TransformPlugin for adding watermarks
+// WatermarkPlugin implements TransformPlugin for adding watermarks.
+// It reads the frames of the input rendition of every stream and writes
+// the watermarked frames to the output rendition, leaving the input intact.
+// Progress is checkpointed per input session by its RenditionTransform, so
+// a restarted plugin resumes after the last frame it processed.
 type WatermarkPlugin struct {
 	watermark image.Image
 	position  image.Point
+	transform plugins.RenditionTransform // Renditions read and written
 }
 
 // NewWatermarkPlugin creates a new watermark transform plugin
 func NewWatermarkPlugin() plugins.TransformPlugin {
-	return &WatermarkPlugin{}
+	return &WatermarkPlugin{
+		transform: plugins.RenditionTransform{
+			Input:      storage.RenditionSource,
+			Output:     storage.RenditionWatermarked,
+			InstanceID: "watermark",
+		},
+	}
 }
 
+// ConfigSchema returns the config options of the watermark plugin.
+func (p *WatermarkPlugin) ConfigSchema() plugins.Schema {
+	fields := []plugins.Field{
+		{Name: "watermark_image", Type: plugins.FieldBytes, Description: "PNG encoded watermark", Required: true},
+		{Name: "position_x", Type: plugins.FieldInt, Description: "Watermark position, negative values are relative to the right edge", Default: 0},
+		{Name: "position_y", Type: plugins.FieldInt, Description: "Watermark position, negative values are relative to the bottom edge", Default: 0},
+	}
+	return plugins.Schema{Fields: append(fields, plugins.RenditionTransformFields()...)}
+}
+
+// Initialize sets up the watermark plugin with configuration parameters.
+// The host validates the config against ConfigSchema beforehand, so
+// options have the types of the schema.
+// Supported config options:
+// - watermark_image: []byte - PNG encoded watermark, required
+// - position_x, position_y: int - Watermark position, negative values are relative to the right and bottom edges
+// - input_rendition: string - Rendition to read frames from
+// - output_rendition: string - Rendition to write watermarked frames to
+// - instance_id: string - Name under which progress is checkpointed, unique per plugin instance
+// - group: string - Consumer group sharing the input between instances, which need distinct instance IDs
+// - stream: string - Only stream to process, all streams by default
 func (p *WatermarkPlugin) Initialize(ctx context.Context, config map[string]interface{}) error {
 	// Load watermark image from config
-	if watermarkData, ok := config["watermark_image"].([]byte); ok {
-		watermark, err := png.Decode(bytes.NewReader(watermarkData))
-		if err != nil {
-			return err
-		}
-		p.watermark = watermark
+	data, _ := config["watermark_image"].([]byte)
+	watermark, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode watermark image: %v", err)
 	}
+	p.watermark = watermark
 
 	// Set watermark position
-	if x, ok := config["position_x"].(int); ok {
-		if y, ok := config["position_y"].(int); ok {
-			p.position = image.Point{X: x, Y: y}
-		}
-	}
+	x, _ := config["position_x"].(int)
+	y, _ := config["position_y"].(int)
+	p.position = image.Point{X: x, Y: y}
 
-	return nil
+	// Set input and output renditions, stream and instance ID
+	return p.transform.Configure(config)
 }
 
 func (p *WatermarkPlugin) Run(ctx context.Context, store storage.Storage) error {
-	// Process frames in a loop
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			// Get list of sessions
-			sessions, err := store.ListSessions(ctx)
-			if err != nil {
-				continue
-			}
-
-			// Process each session
-			for _, sessionID := range sessions {
-				frames, err := store.ListFrames(ctx, sessionID)
-				if err != nil {
-					continue
-				}
-
-				// Process each frame
-				for _, frame := range frames {
-					// Skip non-video frames
-					if frame.MediaType != "video" {
-						continue
-					}
-
-					// Decode image
-					img, _, err := image.Decode(bytes.NewReader(frame.Data))
-					if err != nil {
-						continue
-					}
-
-					// Create output image
-					bounds := img.Bounds()
-					out := image.NewRGBA(bounds)
-					draw.Draw(out, bounds, img, image.Point{}, draw.Src)
-
-					// Apply watermark
-					watermarkPos := p.position
-					if watermarkPos.X < 0 {
-						watermarkPos.X = bounds.Max.X - p.watermark.Bounds().Max.X + watermarkPos.X
-					}
-					if watermarkPos.Y < 0 {
-						watermarkPos.Y = bounds.Max.Y - p.watermark.Bounds().Max.Y + watermarkPos.Y
-					}
-					draw.Draw(out, p.watermark.Bounds().Add(watermarkPos), p.watermark, image.Point{}, draw.Over)
-
-					// Encode back to bytes
-					var buf bytes.Buffer
-					if err := png.Encode(&buf, out); err != nil {
-						continue
-					}
-
-					// Update frame with watermarked data
-					frame.Data = buf.Bytes()
-					if err := store.PutFrame(ctx, frame); err != nil {
-						continue
-					}
-				}
-			}
-
-			// Sleep briefly to avoid tight loop
-			time.Sleep(100 * time.Millisecond)
-		}
+	return p.transform.Run(ctx, store, p.processFrame)
+}
+
+// processFrame watermarks a single video frame. Frames that are not video
+// or cannot be decoded are skipped.
+func (p *WatermarkPlugin) processFrame(ctx context.Context, frame storage.Frame) (storage.Frame, bool, error) {
+	// Skip non-video frames
+	if frame.MediaType != frames.MediaTypeVideo {
+		return frame, false, nil
+	}
+
+	data, err := p.apply(frame.Data)
+	if err != nil {
+		return frame, false, nil
+	}
+
+	frame.Data = data
+	frame.Codec = frames.CodecPNG
+	frame.SideData = nil
+	return frame, true, nil
+}
+
+// apply decodes an image, draws the watermark on it and encodes the result as PNG.
+func (p *WatermarkPlugin) apply(data []byte) ([]byte, error) {
+	// Decode image
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// Create output image
+	bounds := img.Bounds()
+	out := image.NewRGBA(bounds)
+	draw.Draw(out, bounds, img, image.Point{}, draw.Src)
+
+	// Apply watermark
+	watermarkPos := p.position
+	if watermarkPos.X < 0 {
+		watermarkPos.X = bounds.Max.X - p.watermark.Bounds().Max.X + watermarkPos.X
+	}
+	if watermarkPos.Y < 0 {
+		watermarkPos.Y = bounds.Max.Y - p.watermark.Bounds().Max.Y + watermarkPos.Y
+	}
+	draw.Draw(out, p.watermark.Bounds().Add(watermarkPos), p.watermark, image.Point{}, draw.Over)
+
+	// Encode back to bytes
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, out); err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), nil
 }
 
 func (p *WatermarkPlugin) Stop() error {
@@ -78,18 +78,14 @@ func BenchmarkConcurrentClients(b *testing.B) {
 				go func(clientID int) {
 					defer wg.Done()
 
-					for {
-						select {
-						case <-ctx.Done():
-							return
-						default:
-							_, err := store.ListFrames(ctx, "test_camera")
-							if err != nil {
-								b.Error(err)
-								return
-							}
-							time.Sleep(time.Second / 30) // Simulate 30fps playback
-						}
+					frames, err := store.Subscribe(ctx, "test_camera", 0)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+
+					// Consume frames as they arrive until the subscription ends
+					for range frames {
 					}
 				}(i)
 			}
@@ -2,6 +2,7 @@ package benchmark
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -74,3 +75,96 @@ func BenchmarkStorageRead(b *testing.B) {
 		})
 	}
 }
+
+// BenchmarkStorageReadRange tests ranged read performance of different storage backends.
+// It measures how quickly each backend can retrieve a one second window of frames.
+func BenchmarkStorageReadRange(b *testing.B) {
+	ctx := context.Background()
+	stores := map[string]storage.Storage{
+		"memory": storage.NewMemoryStorage(),
+	}
+
+	// Try to connect to Redis if available
+	if redisStore, err := storage.NewRedisStorage("localhost:6379"); err == nil {
+		stores["redis"] = redisStore
+		defer redisStore.Close()
+	}
+
+	// Prepare test data
+	generator := NewVideoGenerator(1280, 720, 30, 5*time.Second)
+	frames := generator.GenerateFrames()
+
+	for name, store := range stores {
+		// Pre-populate store with test data
+		for _, frame := range frames {
+			err := store.PutFrame(ctx, frame)
+			require.NoError(b, err)
+		}
+
+		// Benchmark ranged read operations
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := store.ListFramesRange(ctx, "test_session", 60, 89)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+// BenchmarkFrameEncoding compares the JSON frame serialization used by earlier
+// versions of RedisStorage with the binary envelope it uses now.
+// It measures encoding and decoding of a frame sized like a 1080p keyframe.
+func BenchmarkFrameEncoding(b *testing.B) {
+	frame := storage.Frame{
+		SessionID: "test_session",
+		Index:     42,
+		Data:      make([]byte, 256*1024),
+		Timestamp: time.Now(),
+		MediaType: "video",
+		Codec:     "h264",
+		KeyFrame:  true,
+	}
+	for i := range frame.Data {
+		frame.Data[i] = byte(i * 31)
+	}
+
+	jsonData, err := json.Marshal(frame)
+	require.NoError(b, err)
+	binaryData, err := storage.EncodeFrame(frame)
+	require.NoError(b, err)
+
+	b.Run("json/encode", func(b *testing.B) {
+		b.ReportMetric(float64(len(jsonData)), "bytes/frame")
+		for i := 0; i < b.N; i++ {
+			if _, err := json.Marshal(frame); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("json/decode", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var decoded storage.Frame
+			if err := json.Unmarshal(jsonData, &decoded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("binary/encode", func(b *testing.B) {
+		b.ReportMetric(float64(len(binaryData)), "bytes/frame")
+		for i := 0; i < b.N; i++ {
+			if _, err := storage.EncodeFrame(frame); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("binary/decode", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := storage.DecodeFrame(binaryData); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/relais/pkg/config"
+	"github.com/relais/pkg/plugins"
+	"github.com/relais/plugins/ingress/camera"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPluginConfig verifies that the plugin config of the runners is read
+// from its JSON setting and validates against the plugin's schema.
+func TestPluginConfig(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("plugin_config", `{"device_id": "cam1", "fps": 25}`)
+	cfg, err := config.LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"device_id": "cam1", "fps": float64(25)}, cfg.Plugin)
+
+	validated, err := plugins.ValidateConfig("camera", camera.NewCameraPlugin(), cfg.Plugin)
+	require.NoError(t, err)
+	assert.Equal(t, 25, validated["fps"])
+
+	viper.Set("plugin_config", `{"device_id": }`)
+	_, err = config.LoadConfig()
+	assert.Error(t, err)
+}
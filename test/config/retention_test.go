@@ -0,0 +1,55 @@
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/config"
+	"github.com/relais/pkg/storage"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRetentionSessions verifies that per-session retention policies are
+// loaded from their JSON setting and applied next to the default policy.
+func TestRetentionSessions(t *testing.T) {
+	ctx := context.Background()
+	defer viper.Reset()
+
+	viper.Set("storage.retention.max_frames", 2)
+	viper.Set("storage.retention.sessions", `{"cam1/source": {"max_frames": 4, "max_age": "5m"}}`)
+	cfg, err := config.LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 2, cfg.Storage.Retention.MaxFrames)
+	assert.Equal(t, map[string]config.RetentionLimits{
+		"cam1/source": {MaxFrames: 4, MaxAge: 5 * time.Minute},
+	}, cfg.Storage.Retention.Sessions)
+
+	store := storage.NewMemoryStorage()
+	require.NoError(t, config.ApplyRetention(ctx, store, cfg.Storage.Retention))
+	for _, sessionID := range []string{"cam1/source", "cam2/source"} {
+		for i := int64(0); i < 6; i++ {
+			require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: sessionID, Index: i, Timestamp: time.Now()}))
+		}
+	}
+
+	for sessionID, want := range map[string]int{"cam1/source": 4, "cam2/source": 2} {
+		frames, err := store.ListFrames(ctx, sessionID)
+		require.NoError(t, err)
+		assert.Len(t, frames, want, "frames kept in %s", sessionID)
+	}
+}
+
+// TestRetentionSessionsInvalid verifies that malformed per-session retention
+// policies are rejected.
+func TestRetentionSessionsInvalid(t *testing.T) {
+	defer viper.Reset()
+
+	for _, sessions := range []string{`{"cam1/source": 4}`, `{"cam1/source": {"max_age": "soon"}}`} {
+		viper.Set("storage.retention.sessions", sessions)
+		_, err := config.LoadConfig()
+		assert.Error(t, err, sessions)
+	}
+}
@@ -0,0 +1,127 @@
+package frames
+
+import (
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/frames"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Frame headers of VP8 and VP9 640x480 and 1280x720 key and inter frames.
+var (
+	vp8Key   = []byte{0x50, 0x2a, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0xe0, 0x01, 0x39}
+	vp8Inter = []byte{0x31, 0x0e, 0x00, 0x11, 0x00}
+	vp9Key   = []byte{0x82, 0x49, 0x83, 0x42, 0x00, 0x4f, 0xf0, 0x2c, 0xf0, 0x00}
+	vp9Inter = []byte{0x86, 0x00, 0x40, 0x92}
+)
+
+// TestVP8 verifies VP8 key frame detection and dimensions.
+func TestVP8(t *testing.T) {
+	assert.True(t, frames.VP8IsKeyFrame(vp8Key))
+	assert.False(t, frames.VP8IsKeyFrame(vp8Inter))
+	assert.False(t, frames.VP8IsKeyFrame(nil))
+
+	params, err := frames.ParseVP8KeyFrame(vp8Key)
+	require.NoError(t, err)
+	assert.Equal(t, frames.CodecParams{Type: frames.CodecVP8, Profile: "0", Width: 640, Height: 480}, params)
+
+	_, err = frames.ParseVP8KeyFrame(vp8Inter)
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+	_, err = frames.ParseVP8KeyFrame(vp8Key[:8])
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+}
+
+// TestVP9 verifies VP9 key frame detection and dimensions.
+func TestVP9(t *testing.T) {
+	assert.True(t, frames.VP9IsKeyFrame(vp9Key))
+	assert.False(t, frames.VP9IsKeyFrame(vp9Inter))
+	assert.False(t, frames.VP9IsKeyFrame([]byte{0x00}))
+
+	params, err := frames.ParseVP9KeyFrame(vp9Key)
+	require.NoError(t, err)
+	assert.Equal(t, frames.CodecParams{Type: frames.CodecVP9, Profile: "0", Width: 1280, Height: 720}, params)
+
+	_, err = frames.ParseVP9KeyFrame(vp9Inter)
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+	_, err = frames.ParseVP9KeyFrame(vp9Key[:6])
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+}
+
+// TestOpus verifies TOC and identification header parsing.
+func TestOpus(t *testing.T) {
+	// CELT fullband 20ms stereo, one frame
+	toc, err := frames.ParseOpusTOC([]byte{0xfc, 0xff, 0xfe})
+	require.NoError(t, err)
+	assert.Equal(t, frames.OpusTOC{Config: 31, Mode: "celt", Bandwidth: "fullband", FrameDuration: 20 * time.Millisecond, Stereo: true, Frames: 1}, toc)
+	assert.Equal(t, 2, toc.Channels())
+
+	// SILK narrowband 20ms mono, three frames
+	toc, err = frames.ParseOpusTOC([]byte{0x0b, 0x03, 0x00})
+	require.NoError(t, err)
+	assert.Equal(t, "silk", toc.Mode)
+	assert.Equal(t, 1, toc.Channels())
+	assert.Equal(t, 60*time.Millisecond, toc.Duration())
+
+	// Hybrid superwideband 10ms, two frames
+	toc, err = frames.ParseOpusTOC([]byte{0x61})
+	require.NoError(t, err)
+	assert.Equal(t, "hybrid", toc.Mode)
+	assert.Equal(t, "superwideband", toc.Bandwidth)
+	assert.Equal(t, 20*time.Millisecond, toc.Duration())
+
+	_, err = frames.ParseOpusTOC(nil)
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+	_, err = frames.ParseOpusTOC([]byte{0x0b})
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+
+	head := append([]byte("OpusHead"), 1, 2, 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0, 0)
+	params, err := frames.ParseOpusHead(head)
+	require.NoError(t, err)
+	assert.Equal(t, frames.CodecParams{Type: frames.CodecOpus, SampleRate: 48000, Channels: 2}, params)
+	_, err = frames.ParseOpusHead(head[:10])
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+}
+
+// TestAAC verifies ADTS parsing and stripping and AudioSpecificConfig
+// round trips.
+func TestAAC(t *testing.T) {
+	// AAC-LC 44.1kHz stereo, 3 bytes of payload per frame
+	adts := []byte{0xff, 0xf1, 0x50, 0x80, 0x01, 0x5f, 0xfc}
+	data := append(append([]byte{}, adts...), 1, 2, 3)
+	data = append(append(data, adts...), 4, 5, 6)
+
+	header, err := frames.ParseADTSHeader(data)
+	require.NoError(t, err)
+	assert.Equal(t, frames.ADTSHeader{ObjectType: 2, SampleRateIndex: 4, ChannelConfig: 2, HeaderSize: 7, FrameLength: 10}, header)
+	assert.Equal(t, 44100, header.SampleRate())
+	assert.True(t, frames.IsADTS(data))
+	assert.False(t, frames.IsADTS([]byte{1, 2, 3, 4, 5, 6, 7}))
+
+	raw, header, err := frames.StripADTS(data)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6}, raw)
+	assert.Equal(t, []byte{0x12, 0x10}, header.AudioSpecificConfig())
+
+	params, err := frames.ParseAudioSpecificConfig(header.AudioSpecificConfig())
+	require.NoError(t, err)
+	assert.Equal(t, frames.CodecParams{Type: frames.CodecAAC, Profile: "lc", SampleRate: 44100, Channels: 2}, params)
+
+	_, _, err = frames.StripADTS(data[:15])
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+	_, err = frames.ParseAudioSpecificConfig([]byte{0x12})
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+}
+
+// TestCodecIsKeyFrame verifies key frame detection across codecs.
+func TestCodecIsKeyFrame(t *testing.T) {
+	assert.True(t, frames.CodecH264.IsKeyFrame(frames.JoinAnnexB([][]byte{sps720p, pps, idr})))
+	assert.False(t, frames.CodecH264.IsKeyFrame(frames.JoinAnnexB([][]byte{slice})))
+	assert.True(t, frames.CodecVP8.IsKeyFrame(vp8Key))
+	assert.False(t, frames.CodecVP8.IsKeyFrame(vp8Inter))
+	assert.True(t, frames.CodecVP9.IsKeyFrame(vp9Key))
+	assert.False(t, frames.CodecVP9.IsKeyFrame(vp9Inter))
+	assert.True(t, frames.CodecOpus.IsKeyFrame([]byte{0xfc}))
+	assert.True(t, frames.CodecJPEG.IsKeyFrame([]byte{0xff, 0xd8}))
+}
@@ -0,0 +1,60 @@
+package frames
+
+import (
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/frames"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTimebase verifies conversions between media timestamps and durations.
+func TestTimebase(t *testing.T) {
+	assert.Equal(t, time.Second, frames.Timebase90kHz.Duration(90000))
+	assert.Equal(t, 33333*time.Microsecond+333, frames.Timebase90kHz.Duration(3000))
+	assert.Equal(t, 20*time.Millisecond, frames.Timebase48kHz.Duration(960))
+	assert.Equal(t, int64(3000), frames.Timebase90kHz.Timestamp(time.Second/30))
+	assert.Equal(t, int64(960), frames.Timebase48kHz.Timestamp(20*time.Millisecond))
+
+	// 30 days at 90 kHz doesn't overflow
+	month := 30 * 24 * time.Hour
+	assert.Equal(t, month, frames.Timebase90kHz.Duration(frames.Timebase90kHz.Timestamp(month)))
+
+	// Timebases other than 1/n
+	ntsc := frames.Timebase{Num: 1001, Den: 30000}
+	assert.Equal(t, 1001*time.Millisecond, ntsc.Duration(30))
+	assert.Equal(t, int64(30), ntsc.Timestamp(1001*time.Millisecond))
+
+	assert.True(t, frames.Timebase{}.IsZero())
+	assert.Zero(t, frames.Timebase{}.Duration(1000))
+	assert.Zero(t, frames.Timebase{}.Timestamp(time.Second))
+}
+
+// TestFrameMetadata verifies the accessors of the frame model.
+func TestFrameMetadata(t *testing.T) {
+	frame := frames.Frame{
+		SessionID: "cam1/source",
+		Index:     3,
+		Data:      []byte{1, 2, 3, 4},
+		MediaType: frames.MediaTypeVideo,
+		Codec:     frames.CodecH264,
+		KeyFrame:  true,
+		TrackID:   1,
+		PTS:       9000,
+		DTS:       6000,
+		Duration:  3000,
+		Timebase:  frames.Timebase90kHz,
+		SideData:  []frames.SideData{{Type: frames.SideDataSPS, Data: []byte{0x67}}},
+	}
+
+	assert.Equal(t, 100*time.Millisecond, frame.PresentationTime())
+	assert.Equal(t, 66666*time.Microsecond+666, frame.DecodeTime())
+	assert.Equal(t, []byte{0x67}, frame.FindSideData(frames.SideDataSPS))
+	assert.Nil(t, frame.FindSideData(frames.SideDataPPS))
+
+	metadata := frame.Metadata()
+	assert.Equal(t, 4, metadata.Size)
+	assert.Equal(t, frame.PTS, metadata.PTS)
+	assert.Equal(t, frame.Codec, metadata.Codec)
+	assert.True(t, frame.Codec.IsVideo())
+}
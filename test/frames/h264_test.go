@@ -0,0 +1,125 @@
+package frames
+
+import (
+	"testing"
+
+	"github.com/relais/pkg/frames"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Parameter sets written by x264 for 1280x720 and 1920x1080 High profile
+// streams. The 1080p SPS is cropped from 1088 lines and contains emulation
+// prevention bytes.
+var (
+	sps720p  = []byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60}
+	sps1080p = []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0xc0, 0x44, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc6, 0x58}
+	pps      = []byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0}
+	idr      = []byte{0x65, 0x88, 0x84, 0x00, 0x33}
+	slice    = []byte{0x41, 0x9a, 0x02, 0x03}
+)
+
+// TestAnnexB verifies splitting Annex-B payloads and converting them to and
+// from AVCC.
+func TestAnnexB(t *testing.T) {
+	// Mixed start code lengths and trailing zero bytes
+	data := []byte{0, 0, 0, 1}
+	data = append(data, sps720p...)
+	data = append(data, 0, 0, 1)
+	data = append(data, pps...)
+	data = append(data, 0, 0, 0, 0, 1)
+	data = append(data, idr...)
+
+	assert.True(t, frames.IsAnnexB(data))
+	assert.Equal(t, [][]byte{sps720p, pps, idr}, frames.SplitAnnexB(data))
+
+	avcc := frames.AnnexBToAVCC(data)
+	assert.False(t, frames.IsAnnexB(avcc))
+	nals, err := frames.SplitAVCC(avcc, 4)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{sps720p, pps, idr}, nals)
+
+	annexB, err := frames.AVCCToAnnexB(avcc, 4)
+	require.NoError(t, err)
+	assert.Equal(t, frames.JoinAnnexB([][]byte{sps720p, pps, idr}), annexB)
+
+	// Both forms are detected
+	nals, err = frames.SplitNALUnits(avcc)
+	require.NoError(t, err)
+	assert.Len(t, nals, 3)
+	nals, err = frames.SplitNALUnits(annexB)
+	require.NoError(t, err)
+	assert.Len(t, nals, 3)
+}
+
+// TestAVCC verifies length-prefixed payloads with other length sizes and
+// truncated payloads.
+func TestAVCC(t *testing.T) {
+	nals, err := frames.SplitAVCC([]byte{0, 2, 0x41, 0x9a, 0, 1, 0x06}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{0x41, 0x9a}, {0x06}}, nals)
+
+	_, err = frames.SplitAVCC([]byte{0, 0, 0, 9, 0x41}, 4)
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+	_, err = frames.SplitAVCC([]byte{0, 0, 0}, 4)
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+	_, err = frames.SplitAVCC(nil, 3)
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+}
+
+// TestH264KeyFrames verifies key frame detection and parameter set
+// extraction.
+func TestH264KeyFrames(t *testing.T) {
+	keyFrame := frames.JoinAnnexB([][]byte{sps720p, pps, idr})
+	assert.True(t, frames.H264IsKeyFrame(keyFrame))
+	assert.True(t, frames.H264IsKeyFrame(frames.AnnexBToAVCC(keyFrame)))
+	assert.False(t, frames.H264IsKeyFrame(frames.JoinAnnexB([][]byte{slice})))
+	assert.False(t, frames.H264IsKeyFrame(nil))
+
+	assert.Equal(t, frames.H264NALSPS, frames.H264NALUnitType(sps720p))
+	assert.Equal(t, frames.H264NALIDR, frames.H264NALUnitType(idr))
+
+	assert.Equal(t, []frames.SideData{
+		{Type: frames.SideDataSPS, Data: sps720p},
+		{Type: frames.SideDataPPS, Data: pps},
+	}, frames.H264ParameterSets(keyFrame))
+	assert.Nil(t, frames.H264ParameterSets(frames.JoinAVCC([][]byte{slice})))
+}
+
+// TestParseH264SPS verifies decoding of profile, level and resolution.
+func TestParseH264SPS(t *testing.T) {
+	params, err := frames.ParseH264SPS(sps720p)
+	require.NoError(t, err)
+	assert.Equal(t, frames.CodecParams{Type: frames.CodecH264, Profile: "high", Level: "3.1", Width: 1280, Height: 720}, params)
+
+	params, err = frames.ParseH264SPS(sps1080p)
+	require.NoError(t, err)
+	assert.Equal(t, frames.CodecParams{Type: frames.CodecH264, Profile: "high", Level: "4.0", Width: 1920, Height: 1080}, params)
+
+	// Baseline SPS without chroma format fields
+	params, err = frames.ParseH264SPS([]byte{0x67, 0x42, 0x00, 0x0a, 0xf8, 0x41, 0xa2})
+	require.NoError(t, err)
+	assert.Equal(t, frames.CodecParams{Type: frames.CodecH264, Profile: "baseline", Level: "1.0", Width: 128, Height: 96}, params)
+
+	_, err = frames.ParseH264SPS(pps)
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+	_, err = frames.ParseH264SPS(sps720p[:6])
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+}
+
+// TestH264DecoderConfig verifies the AVCDecoderConfigurationRecord round
+// trip.
+func TestH264DecoderConfig(t *testing.T) {
+	record, err := frames.H264DecoderConfig{SPS: [][]byte{sps720p}, PPS: [][]byte{pps}}.Marshal()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 0x64, 0x00, 0x1f, 0xff, 0xe1}, record[:6])
+
+	config, err := frames.ParseH264DecoderConfig(record)
+	require.NoError(t, err)
+	assert.Equal(t, frames.H264DecoderConfig{LengthSize: 4, SPS: [][]byte{sps720p}, PPS: [][]byte{pps}}, config)
+
+	_, err = frames.ParseH264DecoderConfig(record[:10])
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+	_, err = frames.H264DecoderConfig{PPS: [][]byte{pps}}.Marshal()
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+}
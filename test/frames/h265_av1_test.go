@@ -0,0 +1,118 @@
+package frames
+
+import (
+	"testing"
+
+	"github.com/relais/pkg/frames"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Parameter sets and slice headers written by x265 for a 1920x1080 Main
+// profile stream.
+var (
+	hevcVPS   = []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x78, 0x99, 0x98, 0x09}
+	hevcSPS   = []byte{0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x10, 0xe5, 0x96, 0x56, 0x69, 0x24, 0xca, 0xf0, 0x10, 0x10, 0x00, 0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x01, 0xe0, 0x80}
+	hevcPPS   = []byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40}
+	hevcIDR   = []byte{0x26, 0x01, 0xaf, 0x06, 0xb8}
+	hevcTrail = []byte{0x02, 0x01, 0xd0, 0x09, 0x7e}
+)
+
+// bits packs fields of {value, width} MSB first, padding the last byte
+// with a stop bit and zeros.
+func bits(fields ...[2]int) []byte {
+	var data []byte
+	n := 0
+	put := func(bit int) {
+		if n%8 == 0 {
+			data = append(data, 0)
+		}
+		data[len(data)-1] |= byte(bit << (7 - n%8))
+		n++
+	}
+	for _, field := range fields {
+		for i := field[1] - 1; i >= 0; i-- {
+			put(field[0] >> i & 1)
+		}
+	}
+	put(1)
+	return data
+}
+
+// obu builds an AV1 OBU with a size field.
+func obu(obuType frames.AV1OBUType, payload []byte) []byte {
+	return append([]byte{byte(obuType)<<3 | 0x02, byte(len(payload))}, payload...)
+}
+
+// TestH265 verifies key frame detection, parameter set extraction and SPS
+// decoding.
+func TestH265(t *testing.T) {
+	keyFrame := frames.JoinAnnexB([][]byte{hevcVPS, hevcSPS, hevcPPS, hevcIDR})
+	assert.True(t, frames.H265IsKeyFrame(keyFrame))
+	assert.True(t, frames.H265IsKeyFrame(frames.AnnexBToAVCC(keyFrame)))
+	assert.False(t, frames.H265IsKeyFrame(frames.JoinAnnexB([][]byte{hevcTrail})))
+	assert.True(t, frames.CodecHEVC.IsKeyFrame(keyFrame))
+
+	assert.Equal(t, frames.H265NALSPS, frames.H265NALUnitType(hevcSPS))
+	assert.Equal(t, frames.H265NALIDRWRADL, frames.H265NALUnitType(hevcIDR))
+
+	assert.Equal(t, []frames.SideData{
+		{Type: frames.SideDataVPS, Data: hevcVPS},
+		{Type: frames.SideDataSPS, Data: hevcSPS},
+		{Type: frames.SideDataPPS, Data: hevcPPS},
+	}, frames.H265ParameterSets(keyFrame))
+	assert.Nil(t, frames.H265ParameterSets(frames.JoinAnnexB([][]byte{hevcTrail})))
+
+	params, err := frames.ParseH265SPS(hevcSPS)
+	require.NoError(t, err)
+	assert.Equal(t, frames.CodecParams{Type: frames.CodecHEVC, Profile: "main", Level: "4.0", Width: 1920, Height: 1080}, params)
+
+	_, err = frames.ParseH265SPS(hevcPPS)
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+	_, err = frames.ParseH265SPS(hevcSPS[:12])
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+}
+
+// TestAV1 verifies OBU splitting, key frame detection and sequence header
+// decoding.
+func TestAV1(t *testing.T) {
+	// Main profile, level 4.0, 1920x1080 maximum frame size
+	sequenceHeader := obu(frames.AV1OBUSequenceHeader, bits(
+		[2]int{0, 3}, [2]int{0, 1}, [2]int{0, 1}, // profile, still and reduced still picture
+		[2]int{0, 1}, [2]int{0, 1}, [2]int{0, 5}, // timing info, display delay, operating points
+		[2]int{0, 12}, [2]int{8, 5}, [2]int{0, 1}, // operating point, level and tier
+		[2]int{10, 4}, [2]int{10, 4}, [2]int{1919, 11}, [2]int{1079, 11},
+	))
+	delimiter := obu(frames.AV1OBUTemporalDelimiter, nil)
+	keyFrame := append(append(append([]byte{}, delimiter...), sequenceHeader...), obu(frames.AV1OBUFrame, []byte{0x10, 0xaa})...)
+	interFrame := append(append([]byte{}, delimiter...), obu(frames.AV1OBUFrame, []byte{0x30, 0xbb})...)
+
+	obus, err := frames.SplitAV1OBUs(keyFrame)
+	require.NoError(t, err)
+	require.Len(t, obus, 3)
+	assert.Equal(t, frames.AV1OBUTemporalDelimiter, frames.AV1OBUTypeOf(obus[0]))
+	assert.Equal(t, sequenceHeader, obus[1])
+	assert.Equal(t, frames.AV1OBUFrame, frames.AV1OBUTypeOf(obus[2]))
+
+	// The last OBU may omit its size
+	obus, err = frames.SplitAV1OBUs(append(append([]byte{}, delimiter...), 0x30, 0x10, 0xaa))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{delimiter, {0x30, 0x10, 0xaa}}, obus)
+
+	_, err = frames.SplitAV1OBUs(keyFrame[:len(keyFrame)-1])
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+
+	assert.True(t, frames.AV1IsKeyFrame(keyFrame))
+	assert.False(t, frames.AV1IsKeyFrame(interFrame))
+	assert.True(t, frames.CodecAV1.IsKeyFrame(keyFrame))
+
+	assert.Equal(t, []frames.SideData{{Type: frames.SideDataAV1SequenceHeader, Data: sequenceHeader}}, frames.AV1SequenceHeader(keyFrame))
+	assert.Nil(t, frames.AV1SequenceHeader(interFrame))
+
+	params, err := frames.ParseAV1SequenceHeader(sequenceHeader)
+	require.NoError(t, err)
+	assert.Equal(t, frames.CodecParams{Type: frames.CodecAV1, Profile: "main", Level: "4.0", Width: 1920, Height: 1080}, params)
+
+	_, err = frames.ParseAV1SequenceHeader(delimiter)
+	assert.ErrorIs(t, err, frames.ErrInvalidBitstream)
+}
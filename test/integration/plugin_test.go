@@ -1,7 +1,11 @@
 package integration
 
 import (
+	"bytes"
 	"context"
+	"image"
+	"image/color"
+	"image/png"
 	"testing"
 	"time"
 
@@ -24,44 +28,97 @@ func TestPluginChain(t *testing.T) {
 	// Initialize plugins
 	cameraPlugin := camera.NewCameraPlugin()
 	err := cameraPlugin.Initialize(ctx, map[string]interface{}{
-		"fps": 30,
+		"device_id": "test_camera",
+		"fps":       30,
 	})
 	require.NoError(t, err)
 
 	watermarkPlugin := watermark.NewWatermarkPlugin()
+	watermarkImage := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			watermarkImage.Set(x, y, color.White)
+		}
+	}
+	var watermarkData bytes.Buffer
+	require.NoError(t, png.Encode(&watermarkData, watermarkImage))
 	err = watermarkPlugin.Initialize(ctx, map[string]interface{}{
-		"position_x": 10,
-		"position_y": 10,
+		"watermark_image": watermarkData.Bytes(),
+		"position_x":      10,
+		"position_y":      10,
 	})
 	require.NoError(t, err)
 
 	// Run camera plugin
 	go func() {
 		err := cameraPlugin.Run(ctx, store)
-		assert.NoError(t, err)
+		assert.ErrorIs(t, err, context.Canceled)
 	}()
 
 	// Wait for some frames
 	time.Sleep(2 * time.Second)
 
 	// Verify frames were captured
-	frames, err := store.ListFrames(ctx, "test_camera")
+	sourceID := storage.RenditionSessionID("test_camera", storage.RenditionSource)
+	frames, err := store.ListFrames(ctx, sourceID)
 	require.NoError(t, err)
 	assert.Greater(t, len(frames), 0)
 
+	// The simulated camera doesn't produce images, so add a stream of
+	// blank images for the watermark to be drawn on
+	const imageFrames = 3
+	imageSourceID := storage.RenditionSessionID("test_images", storage.RenditionSource)
+	var imageData bytes.Buffer
+	require.NoError(t, png.Encode(&imageData, image.NewRGBA(image.Rect(0, 0, 32, 32))))
+	for i := int64(0); i < imageFrames; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{
+			SessionID: imageSourceID,
+			Index:     i,
+			Timestamp: time.Now(),
+			MediaType: frames[0].MediaType,
+			Data:      imageData.Bytes(),
+			KeyFrame:  true,
+		}))
+	}
+
 	// Run watermark plugin
 	go func() {
 		err := watermarkPlugin.Run(ctx, store)
-		assert.NoError(t, err)
+		assert.ErrorIs(t, err, context.Canceled)
 	}()
 
-	// Wait for processing
-	time.Sleep(2 * time.Second)
+	// Verify the images were watermarked into their output rendition
+	outputID := storage.RenditionSessionID("test_images", storage.RenditionWatermarked)
+	var output []storage.Frame
+	require.Eventually(t, func() bool {
+		output, err = store.ListFrames(ctx, outputID)
+		return err == nil && len(output) == imageFrames
+	}, 5*time.Second, 10*time.Millisecond)
+	for i, frame := range output {
+		assert.Equal(t, int64(i), frame.Index)
+		img, err := png.Decode(bytes.NewReader(frame.Data))
+		require.NoError(t, err)
+		assert.Equal(t, color.RGBAModel.Convert(color.White), color.RGBAModel.Convert(img.At(10, 10)))
+		assert.Equal(t, color.RGBAModel.Convert(color.Transparent), color.RGBAModel.Convert(img.At(0, 0)))
+	}
+
+	// Camera frames aren't images and are skipped
+	_, err = store.ListFrames(ctx, storage.RenditionSessionID("test_camera", storage.RenditionWatermarked))
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound)
 
-	// Verify frames were processed
-	processedFrames, err := store.ListFrames(ctx, "test_camera")
+	// Verify the source frames were left intact
+	sourceFrames, err := store.ListFrames(ctx, sourceID)
 	require.NoError(t, err)
-	assert.Equal(t, len(frames), len(processedFrames))
+	assert.GreaterOrEqual(t, len(sourceFrames), len(frames))
+	for i, frame := range frames {
+		assert.Equal(t, frame.Data, sourceFrames[i].Data)
+	}
+	imageSource, err := store.ListFrames(ctx, imageSourceID)
+	require.NoError(t, err)
+	require.Len(t, imageSource, imageFrames)
+	for _, frame := range imageSource {
+		assert.Equal(t, imageData.Bytes(), frame.Data)
+	}
 }
 
 // TestPluginFailureRecovery verifies that plugins can recover from failures.
@@ -90,7 +147,7 @@ func TestPluginFailureRecovery(t *testing.T) {
 		time.Sleep(time.Second)
 
 		// Verify plugin stopped cleanly
-		frames, err := store.ListFrames(ctx, "test_camera")
+		frames, err := store.ListFrames(ctx, storage.RenditionSessionID("test_camera", storage.RenditionSource))
 		require.NoError(t, err)
 		assert.Greater(t, len(frames), 0)
 	}
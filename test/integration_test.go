@@ -77,7 +77,8 @@ func TestFullPipeline(t *testing.T) {
 	// Initialize WebRTC egress plugin
 	webrtcPlugin := webrtc_egress.NewWebRTCEgressPlugin()
 	err = webrtcPlugin.Initialize(ctx, map[string]interface{}{
-		"ice_servers": []string{"stun:stun.l.google.com:19302"},
+		"stream":    "test_camera",
+		"rendition": storage.RenditionWatermarked,
 	})
 	assert.NoError(t, err)
 
@@ -0,0 +1,304 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/pipeline"
+	"github.com/relais/pkg/plugins"
+	"github.com/relais/pkg/storage"
+	"github.com/relais/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sourcePlugin writes three frames to its session_id.
+type sourcePlugin struct {
+	sessionID string
+}
+
+func (p *sourcePlugin) Initialize(ctx context.Context, config map[string]interface{}) error {
+	p.sessionID, _ = config["session_id"].(string)
+	return nil
+}
+
+func (p *sourcePlugin) Run(ctx context.Context, store storage.Storage) error {
+	for i := int64(0); i < 3; i++ {
+		if err := store.PutFrame(ctx, storage.Frame{SessionID: p.sessionID, Index: i, Data: []byte{byte(i)}, Timestamp: time.Now()}); err != nil {
+			return err
+		}
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (p *sourcePlugin) Stop() error { return nil }
+
+// copyPlugin copies the frames of its input rendition to its output
+// rendition, appending a byte.
+type copyPlugin struct {
+	input, output string
+}
+
+func (p *copyPlugin) Initialize(ctx context.Context, config map[string]interface{}) error {
+	stream, _ := config["stream"].(string)
+	input, _ := config["input_rendition"].(string)
+	output, _ := config["output_rendition"].(string)
+	p.input = storage.RenditionSessionID(stream, input)
+	p.output = storage.RenditionSessionID(stream, output)
+	return nil
+}
+
+func (p *copyPlugin) Run(ctx context.Context, store storage.Storage) error {
+	frames, err := store.Subscribe(ctx, p.input, 0)
+	if err != nil {
+		return err
+	}
+	for frame := range frames {
+		frame.SessionID = p.output
+		frame.Data = append(frame.Data, 0xff)
+		if err := store.PutFrame(ctx, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *copyPlugin) Stop() error { return nil }
+
+// sinkPlugin records the frames of its session_id.
+type sinkPlugin struct {
+	sessionID string
+	received  chan storage.Frame
+}
+
+func (p *sinkPlugin) ConfigSchema() plugins.Schema {
+	return plugins.Schema{Fields: []plugins.Field{
+		{Name: "buffer", Type: plugins.FieldInt, Default: 1, Range: &plugins.Range{Min: 1, Max: 64}},
+	}}
+}
+
+func (p *sinkPlugin) Initialize(ctx context.Context, config map[string]interface{}) error {
+	p.sessionID, _ = config["session_id"].(string)
+	return nil
+}
+
+func (p *sinkPlugin) Run(ctx context.Context, store storage.Storage) error {
+	frames, err := store.Subscribe(ctx, p.sessionID, 0)
+	if err != nil {
+		return err
+	}
+	for frame := range frames {
+		p.received <- frame
+	}
+	return nil
+}
+
+func (p *sinkPlugin) Stop() error { return nil }
+
+// failingPlugin fails to initialize.
+type failingPlugin struct{ sinkPlugin }
+
+func (p *failingPlugin) Initialize(ctx context.Context, config map[string]interface{}) error {
+	return errors.New("no device")
+}
+
+// newRegistry returns a registry of the test plugins. Sinks send the frames
+// they receive to received.
+func newRegistry(t *testing.T, received chan storage.Frame) *plugins.Registry {
+	registry := plugins.NewRegistry()
+	require.NoError(t, registry.Register(plugins.PluginTypeIngress, "source", func() plugins.Plugin { return &sourcePlugin{} }))
+	require.NoError(t, registry.Register(plugins.PluginTypeTransform, "copy", func() plugins.Plugin { return &copyPlugin{} }))
+	require.NoError(t, registry.Register(plugins.PluginTypeEgress, "sink", func() plugins.Plugin { return &sinkPlugin{received: received} }))
+	require.NoError(t, registry.Register(plugins.PluginTypeEgress, "failing", func() plugins.Plugin { return &failingPlugin{} }))
+	return registry
+}
+
+const spec = `
+name: lobby
+sources:
+  - id: cam
+    plugin: source
+    stream: lobby-cam
+transforms:
+  - id: first
+    plugin: copy
+  - id: second
+    plugin: copy
+sinks:
+  - id: viewer
+    plugin: sink
+  - id: raw
+    plugin: sink
+    input: cam
+    config: {buffer: 4}
+`
+
+func TestCompile(t *testing.T) {
+	registry := newRegistry(t, nil)
+
+	s, err := pipeline.Parse([]byte(spec))
+	require.NoError(t, err)
+	p, err := pipeline.Compile(s, registry)
+	require.NoError(t, err)
+	assert.Equal(t, "lobby", p.Name())
+
+	nodes := p.Nodes()
+	require.Len(t, nodes, 5)
+	type mapping struct{ id, instance, input, inputSession, outputSession string }
+	expected := []mapping{
+		{"cam", "lobby/cam", "", "", "lobby-cam/source"},
+		{"first", "lobby/first", "cam", "lobby-cam/source", "lobby-cam/first"},
+		{"second", "lobby/second", "first", "lobby-cam/first", "lobby-cam/second"},
+		{"viewer", "lobby/viewer", "second", "lobby-cam/second", ""},
+		{"raw", "lobby/raw", "cam", "lobby-cam/source", ""},
+	}
+	for i, node := range nodes {
+		assert.Equal(t, expected[i], mapping{node.ID, node.InstanceID, node.Input, node.InputSession, node.OutputSession})
+		assert.Equal(t, "lobby-cam", node.Stream)
+	}
+
+	assert.Equal(t, plugins.PluginTypeIngress, nodes[0].Type)
+	assert.Equal(t, "lobby-cam/source", nodes[0].Config["session_id"])
+	assert.Equal(t, map[string]interface{}{
+		"stream":           "lobby-cam",
+		"input_rendition":  "first",
+		"output_rendition": "second",
+		"instance_id":      "lobby/second",
+	}, nodes[2].Config)
+	assert.Equal(t, map[string]interface{}{"session_id": "lobby-cam/source", "buffer": 4}, nodes[4].Config)
+
+	// JSON specs are accepted too
+	s, err = pipeline.Parse([]byte(`{"name": "p", "sources": [{"id": "a", "plugin": "source"}], "sinks": [{"id": "b", "plugin": "sink", "config": {"session_id": "x", "buffer": 2}}]}`))
+	require.NoError(t, err)
+	p, err = pipeline.Compile(s, registry)
+	require.NoError(t, err)
+	assert.Equal(t, "x", p.Nodes()[1].Config["session_id"])
+	assert.Equal(t, 2, p.Nodes()[1].Config["buffer"], "numbers are converted to the schema type")
+	assert.Equal(t, "a/source", p.Nodes()[1].InputSession)
+
+	_, err = pipeline.Parse([]byte("name: p\nsink: []\n"))
+	assert.Error(t, err)
+}
+
+func TestCompileErrors(t *testing.T) {
+	registry := newRegistry(t, nil)
+
+	tests := []struct {
+		name string
+		spec string
+		err  string
+	}{
+		{"Empty", `name: ""`, "missing pipeline name; no sources; no sinks"},
+		{"UnknownPlugin", `
+name: p
+sources: [{id: a, plugin: camera}]
+sinks: [{id: b, plugin: source}]`, "ingress a: unknown ingress plugin camera; egress b: unknown egress plugin source"},
+		{"DuplicateID", `
+name: p
+sources: [{id: a, plugin: source}]
+sinks: [{id: a, plugin: sink}]`, "egress a: duplicate id"},
+		{"AmbiguousInput", `
+name: p
+sources: [{id: a, plugin: source}, {id: b, plugin: source}]
+sinks: [{id: c, plugin: sink}]`, "egress c: missing input, the pipeline has several sources"},
+		{"ForwardInput", `
+name: p
+sources: [{id: a, plugin: source}]
+transforms: [{id: t1, plugin: copy, input: t2}, {id: t2, plugin: copy}]
+sinks: [{id: c, plugin: sink, input: c}]`, "transform t1: input t2 is not a source or an earlier transform; egress c: input c is not a source or an earlier transform"},
+		{"SessionConflict", `
+name: p
+sources: [{id: a, plugin: source, stream: s}, {id: b, plugin: source, stream: s}]
+sinks: [{id: c, plugin: sink, input: a}]`, "ingress b: session s/source is also written by a"},
+		{"SameRendition", `
+name: p
+sources: [{id: a, plugin: source}]
+transforms: [{id: t, plugin: copy, rendition: source}]
+sinks: [{id: c, plugin: sink}]`, "transform t: rendition source is the rendition of its input"},
+		{"InvalidConfig", `
+name: p
+sources: [{id: a, plugin: source}]
+sinks: [{id: b, plugin: sink, config: {buffer: 100, size: 3}}]`, "egress b: invalid plugin config: option buffer: 100 is out of range [1, 64]; unknown option size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := pipeline.Parse([]byte(tt.spec))
+			require.NoError(t, err)
+			_, err = pipeline.Compile(s, registry)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, pipeline.ErrInvalidSpec)
+			assert.True(t, util.IsErrorType(err, util.ErrorTypeValidation))
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	received := make(chan storage.Frame, 16)
+	registry := newRegistry(t, received)
+	manager := plugins.NewPluginManager(registry, storage.NewMemoryStorage())
+
+	s, err := pipeline.Parse([]byte(spec))
+	require.NoError(t, err)
+	p, err := pipeline.Compile(s, registry)
+	require.NoError(t, err)
+
+	require.NoError(t, p.Start(ctx, manager))
+	assert.ErrorIs(t, p.Start(ctx, manager), pipeline.ErrAlreadyStarted)
+
+	// Both sinks get the frames, through the transform chain or directly
+	bySession := make(map[string][][]byte)
+	for i := 0; i < 6; i++ {
+		select {
+		case frame := <-received:
+			bySession[frame.SessionID] = append(bySession[frame.SessionID], frame.Data)
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for frames")
+		}
+	}
+	assert.Equal(t, [][]byte{{0}, {1}, {2}}, bySession["lobby-cam/source"])
+	assert.Equal(t, [][]byte{{0, 0xff, 0xff}, {1, 0xff, 0xff}, {2, 0xff, 0xff}}, bySession["lobby-cam/second"])
+
+	statuses := p.Status()
+	require.Len(t, statuses, 5)
+	for _, status := range statuses {
+		assert.True(t, status.Running, status.ID)
+	}
+
+	require.NoError(t, p.Stop())
+	assert.Nil(t, p.Status())
+	for _, status := range manager.ListPlugins() {
+		assert.False(t, status.Running, status.ID)
+	}
+	assert.ErrorIs(t, p.Stop(), pipeline.ErrNotStarted)
+}
+
+func TestStartFailure(t *testing.T) {
+	ctx := context.Background()
+	registry := newRegistry(t, make(chan storage.Frame, 16))
+	manager := plugins.NewPluginManager(registry, storage.NewMemoryStorage())
+
+	s, err := pipeline.Parse([]byte(`
+name: p
+sources: [{id: a, plugin: source}]
+sinks: [{id: bad, plugin: failing}, {id: ok, plugin: sink}]
+`))
+	require.NoError(t, err)
+	p, err := pipeline.Compile(s, registry)
+	require.NoError(t, err)
+
+	err = p.Start(ctx, manager)
+	assert.ErrorContains(t, err, "failed to start stage bad")
+
+	// The stages started before the failure are stopped again
+	statuses := manager.ListPlugins()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "p/ok", statuses[0].ID)
+	assert.False(t, statuses[0].Running)
+}
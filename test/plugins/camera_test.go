@@ -0,0 +1,49 @@
+package plugins
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/storage"
+	"github.com/relais/plugins/ingress/camera"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCameraResume verifies that a restarted camera continues after the
+// frames already stored instead of overwriting them.
+func TestCameraResume(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store := storage.NewMemoryStorage()
+	sessionID := storage.RenditionSessionID("cam1", storage.RenditionSource)
+	for i := int64(0); i < 3; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: sessionID, Index: i, Data: []byte("earlier"), KeyFrame: true}))
+	}
+
+	plugin := camera.NewCameraPlugin()
+	require.NoError(t, plugin.Initialize(ctx, map[string]interface{}{"device_id": "cam1", "fps": 240}))
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- plugin.Run(runCtx, store) }()
+
+	require.Eventually(t, func() bool {
+		frames, err := store.ListFrames(ctx, sessionID)
+		return err == nil && len(frames) >= 6
+	}, 5*time.Second, 10*time.Millisecond)
+	stop()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	frames, err := store.ListFrames(ctx, sessionID)
+	require.NoError(t, err)
+	for i, frame := range frames {
+		assert.Equal(t, int64(i), frame.Index)
+		if i < 3 {
+			assert.Equal(t, []byte("earlier"), frame.Data)
+		} else {
+			assert.NotEqual(t, []byte("earlier"), frame.Data)
+		}
+	}
+}
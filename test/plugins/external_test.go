@@ -0,0 +1,353 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/plugins"
+	"github.com/relais/pkg/plugins/external"
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pluginArg is the first argument that makes the test binary serve one of
+// the external test plugins instead of running tests.
+const pluginArg = "external-plugin"
+
+func TestMain(m *testing.M) {
+	if len(os.Args) > 2 && os.Args[1] == pluginArg {
+		if err := servePlugin(os.Args[2], os.Args[3:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// servePlugin serves the named test plugin.
+func servePlugin(name string, args []string) error {
+	switch name {
+	case "counter":
+		return external.Serve(name, &counterSource{})
+	case "invert":
+		return external.Serve(name, &invertTransformer{marker: args[0]})
+	case "recorder":
+		return external.Serve(name, &recorderSink{})
+	default:
+		return fmt.Errorf("unknown test plugin %s", name)
+	}
+}
+
+// counterSource emits three frames to session_id.
+type counterSource struct {
+	sessionID string
+}
+
+func (s *counterSource) Initialize(config map[string]interface{}) error {
+	s.sessionID, _ = config["session_id"].(string)
+	if s.sessionID == "" {
+		return errors.New("missing session_id")
+	}
+	return nil
+}
+
+func (s *counterSource) Ingest(ctx context.Context, emit func(storage.Frame) error) error {
+	for i := int64(0); i < 3; i++ {
+		if err := emit(storage.Frame{SessionID: s.sessionID, Index: i, Data: []byte{byte(i)}, Timestamp: time.Now()}); err != nil {
+			return err
+		}
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (s *counterSource) Close() error { return nil }
+
+// invertTransformer inverts the bits of frames and skips empty ones. The
+// first time it sees a frame reading "crash" it exits, leaving marker
+// behind so that it doesn't crash again. It always exits on frames reading
+// "abort", and takes half a second to answer frames reading "slow".
+type invertTransformer struct {
+	marker string
+}
+
+func (t *invertTransformer) Initialize(config map[string]interface{}) error { return nil }
+
+func (t *invertTransformer) Transform(frame storage.Frame) (storage.Frame, bool, error) {
+	switch string(frame.Data) {
+	case "crash":
+		if _, err := os.Stat(t.marker); err != nil {
+			os.WriteFile(t.marker, nil, 0o644)
+			os.Exit(3)
+		}
+	case "abort":
+		os.Exit(4)
+	case "slow":
+		time.Sleep(500 * time.Millisecond)
+	}
+	if len(frame.Data) == 0 {
+		return frame, false, nil
+	}
+	data := make([]byte, len(frame.Data))
+	for i, b := range frame.Data {
+		data[i] = ^b
+	}
+	frame.Data = data
+	return frame, true, nil
+}
+
+func (t *invertTransformer) Close() error { return nil }
+
+// recorderSink appends the data of the frames it gets to the output file,
+// one line per frame. It stops reading frames once it gets one reading
+// "hang".
+type recorderSink struct {
+	file *os.File
+}
+
+func (s *recorderSink) Initialize(config map[string]interface{}) error {
+	path, _ := config["output"].(string)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	return nil
+}
+
+func (s *recorderSink) Send(frame storage.Frame) error {
+	if string(frame.Data) == "hang" {
+		select {}
+	}
+	_, err := fmt.Fprintf(s.file, "%s\n", frame.Data)
+	return err
+}
+
+func (s *recorderSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// registerExternal registers the named test plugin in registry through its
+// handshake.
+func registerExternal(t *testing.T, registry *plugins.Registry, name string, args ...string) {
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	args = append([]string{pluginArg, name}, args...)
+
+	pType, announced, err := external.Discover(context.Background(), executable, args...)
+	require.NoError(t, err)
+	assert.Equal(t, name, announced)
+	require.NoError(t, registry.Register(pType, name, external.Factory(pType, executable, args...)))
+}
+
+func TestExternalSource(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	registry := plugins.NewRegistry()
+	registerExternal(t, registry, "counter")
+	manager := plugins.NewPluginManager(registry, store)
+
+	err := manager.StartPlugin(ctx, plugins.PluginTypeIngress, "counter", nil)
+	assert.ErrorContains(t, err, "missing session_id")
+
+	_, err = manager.StartInstance(ctx, "cam", plugins.PluginTypeIngress, "counter", map[string]interface{}{"session_id": "cam/source"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		frames, err := store.ListFrames(ctx, "cam/source")
+		return err == nil && len(frames) == 3
+	}, 10*time.Second, 10*time.Millisecond)
+	require.NoError(t, manager.StopPlugin("cam"))
+}
+
+func TestExternalTransform(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	registry := plugins.NewRegistry()
+	registerExternal(t, registry, "invert", filepath.Join(t.TempDir(), "crashed"))
+	manager := plugins.NewPluginManager(registry, store)
+	manager.SetRestartPolicy(plugins.RestartPolicy{MaxRestarts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	for i, data := range []string{"\x00\x01", "", "crash", "\xf0"} {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam/source", Index: int64(i), Data: []byte(data), Timestamp: time.Now()}))
+	}
+
+	_, err := manager.StartInstance(ctx, "inv", plugins.PluginTypeTransform, "invert", map[string]interface{}{"instance_id": "inv"})
+	require.NoError(t, err)
+
+	// The process crashes on the third frame, and the restarted one goes on
+	// from there
+	var frames []storage.Frame
+	require.Eventually(t, func() bool {
+		frames, err = store.ListFrames(ctx, "cam/invert")
+		return err == nil && len(frames) == 3
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []byte{0xff, 0xfe}, frames[0].Data)
+	assert.Equal(t, int64(2), frames[1].Index)
+	assert.Equal(t, []byte{^byte('c'), ^byte('r'), ^byte('a'), ^byte('s'), ^byte('h')}, frames[1].Data)
+	assert.Equal(t, []byte{0x0f}, frames[2].Data)
+
+	status, err := manager.GetPluginStatus("inv")
+	require.NoError(t, err)
+	assert.True(t, status.Running)
+	assert.Equal(t, 1, status.Restarts)
+	assert.ErrorContains(t, status.Error, "plugin process exited")
+	require.NoError(t, manager.StopPlugin("inv"))
+}
+
+// invert returns data with its bits inverted.
+func invert(data string) []byte {
+	out := []byte(data)
+	for i := range out {
+		out[i] = ^out[i]
+	}
+	return out
+}
+
+// TestExternalTransformAbandoned verifies that an answer arriving after its
+// call was abandoned isn't taken as the answer to the next frame.
+func TestExternalTransformAbandoned(t *testing.T) {
+	ctx := context.Background()
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	store := storage.NewMemoryStorage()
+
+	plugin := external.NewPlugin(executable, pluginArg, "invert", filepath.Join(t.TempDir(), "crashed"))
+	require.NoError(t, plugin.Initialize(ctx, map[string]interface{}{"instance_id": "inv"}))
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam/source", Index: 0, Data: []byte("slow")}))
+
+	// Give up on the first frame while the plugin is still working on it
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- plugin.Run(runCtx, store) }()
+	time.Sleep(200 * time.Millisecond)
+	stop()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam/source", Index: 1, Data: []byte("\x01")}))
+	runCtx, stop = context.WithCancel(ctx)
+	go func() { done <- plugin.Run(runCtx, store) }()
+
+	var frames []storage.Frame
+	require.Eventually(t, func() bool {
+		frames, err = store.ListFrames(ctx, "cam/invert")
+		return err == nil && len(frames) == 2
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, invert("slow"), frames[0].Data)
+	assert.Equal(t, []byte{0xfe}, frames[1].Data)
+
+	stop()
+	<-done
+	require.NoError(t, plugin.Stop())
+}
+
+// TestExternalTransformFailures verifies that frames that can't be sent to
+// a transformer and processes exiting abnormally are reported.
+func TestExternalTransformFailures(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	executable, err := os.Executable()
+	require.NoError(t, err)
+
+	// A frame that doesn't fit the envelope stops the transform
+	store := storage.NewMemoryStorage()
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam/source", Index: 0, MediaType: strings.Repeat("v", 300)}))
+	plugin := external.NewPlugin(executable, pluginArg, "invert", filepath.Join(t.TempDir(), "crashed"))
+	require.NoError(t, plugin.Initialize(ctx, map[string]interface{}{"instance_id": "inv"}))
+	assert.ErrorContains(t, plugin.Run(ctx, store), "failed to encode frame 0")
+	require.NoError(t, plugin.Stop())
+
+	// A process that crashed is reported by Stop
+	store = storage.NewMemoryStorage()
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam/source", Index: 0, Data: []byte("abort")}))
+	plugin = external.NewPlugin(executable, pluginArg, "invert", filepath.Join(t.TempDir(), "crashed"))
+	require.NoError(t, plugin.Initialize(ctx, map[string]interface{}{"instance_id": "inv"}))
+	assert.ErrorContains(t, plugin.Run(ctx, store), "plugin process exited")
+	assert.ErrorContains(t, plugin.Stop(), "exit status 4")
+}
+
+func TestExternalSink(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	registry := plugins.NewRegistry()
+	registerExternal(t, registry, "recorder")
+	manager := plugins.NewPluginManager(registry, store)
+
+	output := filepath.Join(t.TempDir(), "frames")
+	_, err := manager.StartInstance(ctx, "rec", plugins.PluginTypeEgress, "recorder", map[string]interface{}{
+		"session_id": "cam/source",
+		"output":     output,
+	})
+	require.NoError(t, err)
+
+	for i, data := range []string{"a", "b", "c"} {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam/source", Index: int64(i), Data: []byte(data), KeyFrame: true, Timestamp: time.Now()}))
+	}
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(output)
+		return strings.HasSuffix(string(data), "c\n")
+	}, 10*time.Second, 10*time.Millisecond)
+	require.NoError(t, manager.StopPlugin("rec"))
+}
+
+// TestExternalSinkStuck verifies that cancelling Run isn't held up by a sink
+// that stopped reading frames.
+func TestExternalSinkStuck(t *testing.T) {
+	ctx := context.Background()
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	store := storage.NewMemoryStorage()
+
+	plugin := external.NewPlugin(executable, pluginArg, "recorder")
+	require.NoError(t, plugin.Initialize(ctx, map[string]interface{}{
+		"session_id": "cam/source",
+		"output":     filepath.Join(t.TempDir(), "frames"),
+	}))
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam/source", Index: 0, Data: []byte("hang"), KeyFrame: true}))
+
+	// Fill the pipe so that writing the next frame blocks
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- plugin.Run(runCtx, store) }()
+	data := []byte(strings.Repeat("x", 64*1024))
+	for i := int64(1); i <= 8; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam/source", Index: i, Data: data}))
+	}
+	time.Sleep(200 * time.Millisecond)
+	stop()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after being cancelled")
+	}
+	require.NoError(t, plugin.Stop())
+}
+
+func TestExternalErrors(t *testing.T) {
+	ctx := context.Background()
+	executable, err := os.Executable()
+	require.NoError(t, err)
+
+	// The executable must have the type it was registered with
+	plugin := external.Factory(plugins.PluginTypeEgress, executable, pluginArg, "counter")()
+	err = plugin.Initialize(ctx, map[string]interface{}{"session_id": "s"})
+	assert.ErrorContains(t, err, "is a ingress plugin, want egress")
+
+	// Processes that don't speak the protocol are rejected
+	_, _, err = external.Discover(ctx, executable, pluginArg, "unknown")
+	assert.ErrorContains(t, err, "failed to read plugin handshake")
+	_, _, err = external.Discover(ctx, filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorContains(t, err, "failed to start plugin")
+}
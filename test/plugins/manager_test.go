@@ -0,0 +1,332 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/plugins"
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePlugin is an ingress plugin whose Run fails a number of times before
+// running until cancelled.
+type fakePlugin struct {
+	failures int32         // Runs left to fail
+	runs     atomic.Int32  // Calls to Run
+	stops    atomic.Int32  // Calls to Stop
+	panics   bool          // Whether failing runs panic rather than return an error
+	finish   bool          // Whether Run returns nil instead of blocking
+	failLate time.Duration // How long failing runs last before failing
+}
+
+func (p *fakePlugin) Initialize(ctx context.Context, config map[string]interface{}) error {
+	return nil
+}
+
+func (p *fakePlugin) Run(ctx context.Context, store storage.Storage) error {
+	if p.runs.Add(1) <= p.failures {
+		select {
+		case <-time.After(p.failLate):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if p.panics {
+			panic("boom")
+		}
+		return errors.New("run failed")
+	}
+	if p.finish {
+		return nil
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (p *fakePlugin) Stop() error {
+	p.stops.Add(1)
+	return nil
+}
+
+// stoppedPlugin is a plugin without a Run method.
+type stoppedPlugin struct{}
+
+func (stoppedPlugin) Initialize(ctx context.Context, config map[string]interface{}) error {
+	return nil
+}
+
+func (stoppedPlugin) Stop() error { return nil }
+
+// newManager returns a manager of a registry holding plugin as "fake" and
+// a fast restart policy.
+func newManager(t *testing.T, plugin plugins.Plugin, maxRestarts int) *plugins.PluginManager {
+	registry := plugins.NewRegistry()
+	require.NoError(t, registry.Register(plugins.PluginTypeIngress, "fake", func() plugins.Plugin { return plugin }))
+	manager := plugins.NewPluginManager(registry, storage.NewMemoryStorage())
+	manager.SetRestartPolicy(plugins.RestartPolicy{
+		MaxRestarts:    maxRestarts,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     4 * time.Millisecond,
+	})
+	return manager
+}
+
+// waitStatus waits until the status of a plugin satisfies cond.
+func waitStatus(t *testing.T, manager *plugins.PluginManager, name string, cond func(*plugins.PluginStatus) bool) *plugins.PluginStatus {
+	var status *plugins.PluginStatus
+	require.Eventually(t, func() bool {
+		var err error
+		status, err = manager.GetPluginStatus(name)
+		require.NoError(t, err)
+		return cond(status)
+	}, 5*time.Second, time.Millisecond)
+	return status
+}
+
+func TestPluginManager(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("RunAndStop", func(t *testing.T) {
+		plugin := &fakePlugin{}
+		manager := newManager(t, plugin, 3)
+		require.NoError(t, manager.StartPlugin(ctx, plugins.PluginTypeIngress, "fake", nil))
+
+		err := manager.StartPlugin(ctx, plugins.PluginTypeIngress, "fake", nil)
+		assert.ErrorIs(t, err, plugins.ErrPluginAlreadyRunning)
+
+		waitStatus(t, manager, "fake", func(s *plugins.PluginStatus) bool { return plugin.runs.Load() == 1 })
+		require.NoError(t, manager.StopPlugin("fake"))
+		assert.Equal(t, int32(1), plugin.stops.Load())
+
+		status, err := manager.GetPluginStatus("fake")
+		require.NoError(t, err)
+		assert.False(t, status.Running)
+		assert.NoError(t, status.Error)
+
+		assert.ErrorIs(t, manager.StopPlugin("fake"), plugins.ErrPluginNotRunning)
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		plugin := &fakePlugin{failures: 2}
+		manager := newManager(t, plugin, 3)
+		require.NoError(t, manager.StartPlugin(ctx, plugins.PluginTypeIngress, "fake", nil))
+
+		status := waitStatus(t, manager, "fake", func(s *plugins.PluginStatus) bool { return s.Restarts == 2 })
+		assert.True(t, status.Running)
+		assert.EqualError(t, status.Error, "run failed")
+		require.NoError(t, manager.StopPlugin("fake"))
+		assert.Equal(t, int32(3), plugin.runs.Load())
+	})
+
+	t.Run("MaxRestarts", func(t *testing.T) {
+		plugin := &fakePlugin{failures: 10, panics: true}
+		manager := newManager(t, plugin, 2)
+		require.NoError(t, manager.StartPlugin(ctx, plugins.PluginTypeIngress, "fake", nil))
+
+		status := waitStatus(t, manager, "fake", func(s *plugins.PluginStatus) bool { return !s.Running })
+		assert.Equal(t, 2, status.Restarts)
+		assert.ErrorContains(t, status.Error, "boom")
+		assert.Equal(t, int32(3), plugin.runs.Load())
+		// Every failed run is stopped, the factory returning the same plugin
+		assert.Equal(t, int32(3), plugin.stops.Load())
+		assert.ErrorIs(t, manager.StopPlugin("fake"), plugins.ErrPluginNotRunning)
+	})
+
+	t.Run("StableAfter", func(t *testing.T) {
+		// Every run lasts long enough to count as stable, so the restart
+		// limit is never reached
+		plugin := &fakePlugin{failures: 5, failLate: 20 * time.Millisecond}
+		manager := newManager(t, plugin, 2)
+		manager.SetRestartPolicy(plugins.RestartPolicy{
+			MaxRestarts:    2,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     4 * time.Millisecond,
+			StableAfter:    10 * time.Millisecond,
+		})
+		require.NoError(t, manager.StartPlugin(ctx, plugins.PluginTypeIngress, "fake", nil))
+
+		status := waitStatus(t, manager, "fake", func(s *plugins.PluginStatus) bool { return s.Restarts == 5 })
+		assert.True(t, status.Running)
+		require.NoError(t, manager.StopPlugin("fake"))
+		assert.Equal(t, int32(6), plugin.runs.Load())
+	})
+
+	t.Run("Finish", func(t *testing.T) {
+		plugin := &fakePlugin{finish: true}
+		manager := newManager(t, plugin, 3)
+		require.NoError(t, manager.StartPlugin(ctx, plugins.PluginTypeIngress, "fake", nil))
+
+		status := waitStatus(t, manager, "fake", func(s *plugins.PluginStatus) bool { return !s.Running })
+		assert.Zero(t, status.Restarts)
+		assert.NoError(t, status.Error)
+		assert.Equal(t, int32(1), plugin.runs.Load())
+	})
+
+	t.Run("ContextCancel", func(t *testing.T) {
+		plugin := &fakePlugin{}
+		manager := newManager(t, plugin, 3)
+		ctx, cancel := context.WithCancel(ctx)
+		require.NoError(t, manager.StartPlugin(ctx, plugins.PluginTypeIngress, "fake", nil))
+
+		cancel()
+		waitStatus(t, manager, "fake", func(s *plugins.PluginStatus) bool { return !s.Running })
+		assert.Equal(t, int32(1), plugin.stops.Load())
+	})
+
+	t.Run("ConcurrentStop", func(t *testing.T) {
+		plugin := &fakePlugin{}
+		manager := newManager(t, plugin, 3)
+		require.NoError(t, manager.StartPlugin(ctx, plugins.PluginTypeIngress, "fake", nil))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				manager.StopPlugin("fake")
+				manager.GetPluginStatus("fake")
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), plugin.stops.Load())
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		registry := plugins.NewRegistry()
+		require.NoError(t, registry.Register(plugins.PluginTypeIngress, "stopped", func() plugins.Plugin { return stoppedPlugin{} }))
+		manager := plugins.NewPluginManager(registry, storage.NewMemoryStorage())
+
+		err := manager.StartPlugin(ctx, plugins.PluginTypeIngress, "stopped", nil)
+		assert.ErrorIs(t, err, plugins.ErrPluginInvalidType)
+		err = manager.StartPlugin(ctx, plugins.PluginTypeEgress, "missing", nil)
+		assert.ErrorIs(t, err, plugins.ErrPluginNotFound)
+		_, err = manager.GetPluginStatus("missing")
+		assert.ErrorIs(t, err, plugins.ErrPluginNotFound)
+	})
+}
+
+// configPlugin is an ingress plugin recording its config, running until
+// cancelled.
+type configPlugin struct {
+	fakePlugin
+	config map[string]interface{}
+}
+
+func (p *configPlugin) Initialize(ctx context.Context, config map[string]interface{}) error {
+	p.config = config
+	return nil
+}
+
+func TestPluginManagerInstances(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var created []*configPlugin
+	registry := plugins.NewRegistry()
+	require.NoError(t, registry.Register(plugins.PluginTypeIngress, "camera", func() plugins.Plugin {
+		mu.Lock()
+		defer mu.Unlock()
+		p := &configPlugin{}
+		created = append(created, p)
+		return p
+	}))
+	manager := plugins.NewPluginManager(registry, storage.NewMemoryStorage())
+
+	config := map[string]interface{}{"device": "/dev/video0"}
+	id, err := manager.StartInstance(ctx, "front", plugins.PluginTypeIngress, "camera", config)
+	require.NoError(t, err)
+	assert.Equal(t, "front", id)
+	config["device"] = "/dev/video1"
+	id, err = manager.StartInstance(ctx, "", plugins.PluginTypeIngress, "camera", config)
+	require.NoError(t, err)
+	assert.Equal(t, "camera-1", id)
+	id, err = manager.StartInstance(ctx, "", plugins.PluginTypeIngress, "camera", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "camera-2", id)
+
+	require.Len(t, created, 3)
+	assert.Equal(t, "/dev/video0", created[0].config["device"])
+	assert.Equal(t, "/dev/video1", created[1].config["device"])
+
+	_, err = manager.StartInstance(ctx, "front", plugins.PluginTypeIngress, "camera", nil)
+	assert.ErrorIs(t, err, plugins.ErrPluginAlreadyRunning)
+
+	statuses := manager.ListPlugins()
+	require.Len(t, statuses, 3)
+	for i, id := range []string{"camera-1", "camera-2", "front"} {
+		assert.Equal(t, id, statuses[i].ID)
+		assert.Equal(t, plugins.PluginTypeIngress, statuses[i].Type)
+		assert.Equal(t, "camera", statuses[i].Name)
+		assert.True(t, statuses[i].Running)
+	}
+
+	// Stopping one instance leaves the others running
+	require.NoError(t, manager.StopPlugin("camera-1"))
+	assert.Equal(t, int32(1), created[1].stops.Load())
+	status, err := manager.GetPluginStatus("front")
+	require.NoError(t, err)
+	assert.True(t, status.Running)
+
+	// Restarting creates a new plugin with the instance config
+	require.NoError(t, manager.RestartPlugin(ctx, "camera-1"))
+	require.NoError(t, manager.RestartPlugin(ctx, "front"))
+	require.Len(t, created, 5)
+	assert.Equal(t, "/dev/video1", created[3].config["device"])
+	assert.Equal(t, "/dev/video0", created[4].config["device"])
+	assert.Equal(t, int32(1), created[0].stops.Load())
+	status, err = manager.GetPluginStatus("camera-1")
+	require.NoError(t, err)
+	assert.True(t, status.Running)
+
+	assert.ErrorIs(t, manager.RestartPlugin(ctx, "missing"), plugins.ErrPluginNotFound)
+
+	for _, status := range manager.ListPlugins() {
+		require.NoError(t, manager.StopPlugin(status.ID))
+	}
+	for _, status := range manager.ListPlugins() {
+		assert.False(t, status.Running)
+	}
+}
+
+// TestPluginManagerRestartReinitializes verifies that a failed plugin is
+// stopped and replaced by a new plugin initialized with the instance config.
+func TestPluginManagerRestartReinitializes(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var created []*configPlugin
+	registry := plugins.NewRegistry()
+	require.NoError(t, registry.Register(plugins.PluginTypeIngress, "camera", func() plugins.Plugin {
+		mu.Lock()
+		defer mu.Unlock()
+		// Only the first plugin fails, so a restart reusing it fails again
+		p := &configPlugin{}
+		if len(created) == 0 {
+			p.failures = 1
+		}
+		created = append(created, p)
+		return p
+	}))
+	manager := plugins.NewPluginManager(registry, storage.NewMemoryStorage())
+	manager.SetRestartPolicy(plugins.RestartPolicy{MaxRestarts: 1, InitialBackoff: time.Millisecond})
+
+	_, err := manager.StartInstance(ctx, "front", plugins.PluginTypeIngress, "camera", map[string]interface{}{"device": "/dev/video0"})
+	require.NoError(t, err)
+	status := waitStatus(t, manager, "front", func(s *plugins.PluginStatus) bool { return s.Restarts == 1 })
+	assert.True(t, status.Running)
+	require.NoError(t, manager.StopPlugin("front"))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, created, 2)
+	for _, p := range created {
+		assert.Equal(t, "/dev/video0", p.config["device"])
+		assert.Equal(t, int32(1), p.runs.Load())
+		assert.Equal(t, int32(1), p.stops.Load())
+	}
+}
@@ -0,0 +1,280 @@
+package plugins
+
+import (
+	"context"
+	"encoding/base64"
+	"image/color"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/plugins"
+	"github.com/relais/pkg/storage"
+	"github.com/relais/pkg/util"
+	"github.com/relais/plugins/egress/webrtc_egress"
+	"github.com/relais/plugins/ingress/camera"
+	"github.com/relais/plugins/transforms/watermark"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSchema = plugins.Schema{Fields: []plugins.Field{
+	{Name: "name", Type: plugins.FieldString, Required: true},
+	{Name: "mode", Type: plugins.FieldString, Default: "fast", Values: []string{"fast", "slow"}},
+	{Name: "count", Type: plugins.FieldInt, Default: 1, Range: &plugins.Range{Min: 1, Max: 10}},
+	{Name: "ratio", Type: plugins.FieldFloat},
+	{Name: "enabled", Type: plugins.FieldBool},
+	{Name: "timeout", Type: plugins.FieldDuration},
+	{Name: "key", Type: plugins.FieldBytes},
+}}
+
+// TestSchemaValidate verifies that config values are converted from the
+// types JSON, YAML and environment variables produce, and that defaults
+// are set.
+func TestSchemaValidate(t *testing.T) {
+	// As decoded from JSON
+	config, err := testSchema.Validate(map[string]interface{}{
+		"name":    "a",
+		"count":   float64(3),
+		"ratio":   float64(1),
+		"enabled": true,
+		"timeout": "1.5s",
+		"key":     base64.StdEncoding.EncodeToString([]byte{1, 2}),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":    "a",
+		"mode":    "fast",
+		"count":   3,
+		"ratio":   1.0,
+		"enabled": true,
+		"timeout": 1500 * time.Millisecond,
+		"key":     []byte{1, 2},
+	}, config)
+
+	// As read from environment variables
+	config, err = testSchema.Validate(map[string]interface{}{
+		"name":    "a",
+		"mode":    "slow",
+		"count":   " 10",
+		"ratio":   "0.5",
+		"enabled": "false",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 10, config["count"])
+	assert.Equal(t, 0.5, config["ratio"])
+	assert.Equal(t, false, config["enabled"])
+
+	// As decoded from YAML, with host keys passed through and nil values
+	// taken as missing
+	config, err = testSchema.Validate(map[string]interface{}{
+		"name":       "a",
+		"count":      uint64(2),
+		"ratio":      4,
+		"timeout":    nil,
+		"session_id": "cam1/source",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, config["count"])
+	assert.Equal(t, 4.0, config["ratio"])
+	assert.NotContains(t, config, "timeout")
+	assert.Equal(t, "cam1/source", config["session_id"])
+}
+
+// TestSchemaErrors verifies that every problem of a config is reported.
+func TestSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		err    string
+	}{
+		{"Missing", map[string]interface{}{}, "missing required option name"},
+		{"Type", map[string]interface{}{"name": 1}, "option name: expected string, got int"},
+		{"Values", map[string]interface{}{"name": "a", "mode": "medium"}, `option mode: "medium" is not one of fast, slow`},
+		{"Range", map[string]interface{}{"name": "a", "count": 0}, "option count: 0 is out of range [1, 10]"},
+		{"Fraction", map[string]interface{}{"name": "a", "count": 2.5}, "option count: expected integer, got 2.5"},
+		{"Number", map[string]interface{}{"name": "a", "count": "two"}, `option count: invalid integer "two"`},
+		{"Duration", map[string]interface{}{"name": "a", "timeout": 5}, "option timeout: expected duration"},
+		{"Base64", map[string]interface{}{"name": "a", "key": "!"}, "option key: invalid base64"},
+		{"NaN", map[string]interface{}{"name": "a", "ratio": "NaN"}, "option ratio: NaN is not a finite number"},
+		{"Inf", map[string]interface{}{"name": "a", "ratio": math.Inf(-1)}, "option ratio: -Inf is not a finite number"},
+		{"Unknown", map[string]interface{}{"name": "a", "cuont": 2, "bar": 1}, "unknown option bar; unknown option cuont"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testSchema.Validate(tt.config)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, plugins.ErrInvalidConfig)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+// TestPluginSchemas verifies that the schemas of the built-in plugins are
+// discoverable through the registry, and that their config is validated
+// before Initialize.
+func TestPluginSchemas(t *testing.T) {
+	registry := plugins.NewRegistry()
+	require.NoError(t, registry.Register(plugins.PluginTypeIngress, "camera", func() plugins.Plugin { return camera.NewCameraPlugin() }))
+	require.NoError(t, registry.Register(plugins.PluginTypeTransform, "watermark", func() plugins.Plugin { return watermark.NewWatermarkPlugin() }))
+	require.NoError(t, registry.Register(plugins.PluginTypeEgress, "webrtc", func() plugins.Plugin { return webrtc_egress.NewWebRTCEgressPlugin() }))
+	require.NoError(t, registry.Register(plugins.PluginTypeIngress, "fake", func() plugins.Plugin { return &fakePlugin{} }))
+	assert.Equal(t, []string{"camera", "fake"}, registry.Names(plugins.PluginTypeIngress))
+
+	schema, err := registry.Schema(plugins.PluginTypeIngress, "camera")
+	require.NoError(t, err)
+	require.NotNil(t, schema)
+	fps := schema.Field("fps")
+	require.NotNil(t, fps)
+	assert.Equal(t, plugins.FieldInt, fps.Type)
+	assert.Equal(t, 30, fps.Default)
+
+	schema, err = registry.Schema(plugins.PluginTypeTransform, "watermark")
+	require.NoError(t, err)
+	require.NotNil(t, schema)
+	assert.True(t, schema.Field("watermark_image").Required)
+	assert.NotNil(t, schema.Field("input_rendition"))
+
+	schema, err = registry.Schema(plugins.PluginTypeIngress, "fake")
+	require.NoError(t, err)
+	assert.Nil(t, schema)
+	_, err = registry.Schema(plugins.PluginTypeIngress, "missing")
+	assert.ErrorIs(t, err, plugins.ErrPluginNotFound)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	store := storage.NewMemoryStorage()
+	manager := plugins.NewPluginManager(registry, store)
+
+	// The watermark plugin needs its image
+	_, err = manager.StartInstance(ctx, "", plugins.PluginTypeTransform, "watermark", map[string]interface{}{})
+	assert.ErrorIs(t, err, plugins.ErrInvalidConfig)
+	assert.True(t, util.IsErrorType(err, util.ErrorTypeValidation))
+	assert.Contains(t, err.Error(), "transform/watermark")
+	assert.Contains(t, err.Error(), "missing required option watermark_image")
+	assert.Empty(t, manager.ListPlugins())
+
+	_, err = manager.StartInstance(ctx, "", plugins.PluginTypeEgress, "webrtc", map[string]interface{}{"codec": "mp3"})
+	assert.ErrorIs(t, err, plugins.ErrInvalidConfig)
+
+	// JSON numbers are accepted as the camera frame rate, which sets the
+	// duration of frames on the 90 kHz clock
+	id, err := manager.StartInstance(ctx, "", plugins.PluginTypeIngress, "camera", map[string]interface{}{
+		"device_id": "cam1",
+		"fps":       float64(50),
+	})
+	require.NoError(t, err)
+	var frames []storage.Frame
+	require.Eventually(t, func() bool {
+		frames, err = store.ListFrames(ctx, "cam1/source")
+		return err == nil && len(frames) > 0
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(90000/50), frames[0].Duration)
+	require.NoError(t, manager.StopPlugin(id))
+}
+
+// TestInitializeConfig verifies that plugins take the config they are
+// initialized with as the types of their schema, as validated by the host.
+func TestInitializeConfig(t *testing.T) {
+	config, err := plugins.ValidateConfig("watermark", watermark.NewWatermarkPlugin(), map[string]interface{}{
+		"watermark_image": base64.StdEncoding.EncodeToString(encodePNG(t, 2, color.White)),
+		"position_x":      float64(-1),
+	})
+	require.NoError(t, err)
+	assert.NoError(t, watermark.NewWatermarkPlugin().Initialize(context.Background(), config))
+
+	_, err = plugins.ValidateConfig("camera", camera.NewCameraPlugin(), map[string]interface{}{"device_id": "cam1", "fps": 0})
+	assert.ErrorIs(t, err, plugins.ErrInvalidConfig)
+	_, err = plugins.ValidateConfig("camera", camera.NewCameraPlugin(), map[string]interface{}{"fps": 25})
+	assert.ErrorContains(t, err, "missing required option device_id")
+	assert.NoError(t, camera.NewCameraPlugin().Initialize(context.Background(), map[string]interface{}{"fps": 25}))
+}
+
+// schemaPlugin is an ingress plugin with a given schema, recording its
+// config and running until cancelled.
+type schemaPlugin struct {
+	configPlugin
+	schema plugins.Schema
+}
+
+func (p *schemaPlugin) ConfigSchema() plugins.Schema {
+	return p.schema
+}
+
+// TestValidateOnce verifies that the config of an instance is validated
+// when it is started, but not again when it is restarted or when it is
+// started validated already.
+func TestValidateOnce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var created []*schemaPlugin
+	registry := plugins.NewRegistry()
+	require.NoError(t, registry.Register(plugins.PluginTypeIngress, "schema", func() plugins.Plugin {
+		mu.Lock()
+		defer mu.Unlock()
+		p := &schemaPlugin{schema: testSchema}
+		created = append(created, p)
+		return p
+	}))
+	manager := plugins.NewPluginManager(registry, storage.NewMemoryStorage())
+	last := func() map[string]interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return created[len(created)-1].config
+	}
+
+	_, err := manager.StartInstance(ctx, "a", plugins.PluginTypeIngress, "schema", map[string]interface{}{"name": "a", "count": "2"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, last()["count"])
+	require.NoError(t, manager.RestartPlugin(ctx, "a"))
+	assert.Equal(t, 2, last()["count"])
+	assert.Equal(t, "fast", last()["mode"])
+
+	// A validated config is passed as it is, even holding what validation
+	// would reject
+	_, err = manager.StartValidatedInstance(ctx, "b", plugins.PluginTypeIngress, "schema", map[string]interface{}{"count": "2"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"count": "2"}, last())
+
+	for _, status := range manager.ListPlugins() {
+		require.NoError(t, manager.StopPlugin(status.ID))
+	}
+}
+
+// TestSchemaDefaults verifies that schemas whose defaults don't match their
+// fields are rejected before any config is validated against them.
+func TestSchemaDefaults(t *testing.T) {
+	tests := []struct {
+		name  string
+		field plugins.Field
+		err   string
+	}{
+		{"Type", plugins.Field{Name: "fps", Type: plugins.FieldInt, Default: "30"}, "default of fps: expected int, got string"},
+		{"Float", plugins.Field{Name: "ratio", Type: plugins.FieldFloat, Default: 1}, "default of ratio: expected float, got int"},
+		{"Range", plugins.Field{Name: "fps", Type: plugins.FieldInt, Default: 0, Range: &plugins.Range{Min: 1, Max: 240}}, "default of fps: 0 is out of range [1, 240]"},
+		{"Values", plugins.Field{Name: "mode", Type: plugins.FieldString, Default: "medium", Values: []string{"fast"}}, `default of mode: "medium" is not one of fast`},
+		{"NaN", plugins.Field{Name: "ratio", Type: plugins.FieldFloat, Default: math.NaN()}, "default of ratio: NaN is not a finite number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := plugins.Schema{Fields: []plugins.Field{tt.field}}
+			err := schema.Check()
+			assert.ErrorIs(t, err, plugins.ErrInvalidSchema)
+			assert.ErrorContains(t, err, tt.err)
+
+			registry := plugins.NewRegistry()
+			require.NoError(t, registry.Register(plugins.PluginTypeIngress, "bad", func() plugins.Plugin {
+				return &schemaPlugin{schema: schema}
+			}))
+			_, err = registry.Schema(plugins.PluginTypeIngress, "bad")
+			assert.ErrorIs(t, err, plugins.ErrInvalidSchema)
+			_, err = plugins.NewPluginManager(registry, storage.NewMemoryStorage()).StartInstance(context.Background(), "", plugins.PluginTypeIngress, "bad", nil)
+			assert.ErrorIs(t, err, plugins.ErrInvalidSchema)
+		})
+	}
+
+	assert.NoError(t, testSchema.Check())
+}
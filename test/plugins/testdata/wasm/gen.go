@@ -0,0 +1,224 @@
+//go:build ignore
+
+// gen writes the WebAssembly test modules of TestWasm*. They are encoded
+// by hand to stay a few hundred bytes, without a WebAssembly toolchain.
+//
+// Run from this directory with:
+//
+//	go run gen.go
+//
+// Both modules implement the relais ABI with a bump allocator that is reset
+// after every frame, and dispatch on the first payload byte:
+//
+//	's'    skip the frame
+//	't'    trap
+//	'm'    set_metadata({"key_frame":true,"codec":"png"}), keep the payload
+//	'e'    error("bad frame"), return status 2
+//	'c'    output the number of frames seen by the instance, as one byte
+//	other  xor every payload byte with the module's key and output it
+//
+// invert.wasm xors with 0xff, passthrough.wasm with 0x00.
+package main
+
+import (
+	"log"
+	"os"
+)
+
+const (
+	heapBase       = 1024 // Start of the bump allocator
+	metadataOffset = 16   // Data segment holding the set_metadata JSON
+	errorOffset    = 128  // Data segment holding the error message
+)
+
+var (
+	metadataJSON = []byte(`{"key_frame":true,"codec":"png"}`)
+	errorMessage = []byte("bad frame")
+)
+
+// Opcodes and types used.
+const (
+	opUnreachable = 0x00
+	opBlock       = 0x02
+	opLoop        = 0x03
+	opIf          = 0x04
+	opElse        = 0x05
+	opEnd         = 0x0b
+	opBr          = 0x0c
+	opBrIf        = 0x0d
+	opReturn      = 0x0f
+	opCall        = 0x10
+	opLocalGet    = 0x20
+	opLocalSet    = 0x21
+	opGlobalGet   = 0x23
+	opGlobalSet   = 0x24
+	opLoad8U      = 0x2d
+	opStore8      = 0x3a
+	opI32Const    = 0x41
+	opI32Eq       = 0x46
+	opI32GeU      = 0x4f
+	opI32Add      = 0x6a
+	opI32Xor      = 0x73
+
+	blockEmpty = 0x40
+	typeI32    = 0x7f
+	typeFunc   = 0x60
+)
+
+// Function indexes: the imports come first.
+const (
+	fnOutput = iota
+	fnSetMetadata
+	fnError
+	fnAlloc
+	fnTransform
+)
+
+func main() {
+	for name, key := range map[string]byte{"invert.wasm": 0xff, "passthrough.wasm": 0x00} {
+		if err := os.WriteFile(name, module(key), 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// uleb returns the unsigned LEB128 encoding of v.
+func uleb(v uint32) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b = append(b, c|0x80)
+			continue
+		}
+		return append(b, c)
+	}
+}
+
+// sleb returns the signed LEB128 encoding of v.
+func sleb(v int32) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// vec returns items prefixed with their count.
+func vec(items ...[]byte) []byte {
+	b := uleb(uint32(len(items)))
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
+
+// bytes returns b prefixed with its length.
+func bytes(b []byte) []byte {
+	return append(uleb(uint32(len(b))), b...)
+}
+
+// section returns a section with its ID and size.
+func section(id byte, content []byte) []byte {
+	return append([]byte{id}, bytes(content)...)
+}
+
+// cat concatenates byte slices.
+func cat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// i32 returns an i32.const instruction.
+func i32(v int32) []byte {
+	return cat([]byte{opI32Const}, sleb(v))
+}
+
+// resetHeap frees every allocation.
+func resetHeap() []byte {
+	return cat(i32(heapBase), []byte{opGlobalSet, 0})
+}
+
+// onCommand returns the instructions run if the command byte, in local 5,
+// is cmd.
+func onCommand(cmd byte, body []byte) []byte {
+	return cat([]byte{opLocalGet, 5}, i32(int32(cmd)), []byte{opI32Eq, opIf, blockEmpty}, body, []byte{opEnd})
+}
+
+// module returns a module xoring payloads with key.
+func module(key byte) []byte {
+	types := section(1, vec(
+		[]byte{typeFunc, 1, typeI32, 1, typeI32},                            // 0: alloc
+		[]byte{typeFunc, 4, typeI32, typeI32, typeI32, typeI32, 1, typeI32}, // 1: transform
+		[]byte{typeFunc, 2, typeI32, typeI32, 0},                            // 2: host functions
+	))
+	imports := section(2, vec(
+		cat(bytes([]byte("relais")), bytes([]byte("output")), []byte{0x00, 2}),
+		cat(bytes([]byte("relais")), bytes([]byte("set_metadata")), []byte{0x00, 2}),
+		cat(bytes([]byte("relais")), bytes([]byte("error")), []byte{0x00, 2}),
+	))
+	functions := section(3, vec([]byte{0}, []byte{1}))
+	memory := section(5, vec([]byte{0x00, 1}))
+	globals := section(6, vec(
+		cat([]byte{typeI32, 1}, i32(heapBase), []byte{opEnd}), // 0: heap pointer
+		cat([]byte{typeI32, 1}, i32(0), []byte{opEnd}),        // 1: frames seen
+	))
+	exports := section(7, vec(
+		cat(bytes([]byte("memory")), []byte{0x02, 0}),
+		cat(bytes([]byte("relais_alloc")), []byte{0x00, fnAlloc}),
+		cat(bytes([]byte("relais_transform")), []byte{0x00, fnTransform}),
+	))
+
+	// relais_alloc(size) returns the heap pointer and moves it past size
+	alloc := cat(
+		[]byte{0}, // no locals
+		[]byte{opGlobalGet, 0},
+		[]byte{opGlobalGet, 0, opLocalGet, 0, opI32Add, opGlobalSet, 0},
+		[]byte{opEnd},
+	)
+
+	// relais_transform(meta_ptr 0, meta_len 1, data_ptr 2, data_len 3),
+	// with locals i 4 and cmd 5
+	transform := cat(
+		vec([]byte{2, typeI32}),
+		// Count the frame
+		[]byte{opGlobalGet, 1}, i32(1), []byte{opI32Add, opGlobalSet, 1},
+		// cmd = data_len > 0 ? data[0] : 0
+		[]byte{opLocalGet, 3, opIf, typeI32, opLocalGet, 2, opLoad8U, 0, 0, opElse}, i32(0), []byte{opEnd, opLocalSet, 5},
+		onCommand('s', cat(resetHeap(), i32(1), []byte{opReturn})),
+		onCommand('t', []byte{opUnreachable}),
+		onCommand('m', cat(i32(metadataOffset), i32(int32(len(metadataJSON))), []byte{opCall, fnSetMetadata}, resetHeap(), i32(0), []byte{opReturn})),
+		onCommand('e', cat(i32(errorOffset), i32(int32(len(errorMessage))), []byte{opCall, fnError}, resetHeap(), i32(2), []byte{opReturn})),
+		onCommand('c', cat(
+			[]byte{opLocalGet, 2, opGlobalGet, 1, opStore8, 0, 0},
+			[]byte{opLocalGet, 2}, i32(1), []byte{opCall, fnOutput},
+			resetHeap(), i32(0), []byte{opReturn},
+		)),
+		// for i := 0; i < data_len; i++ { data[i] ^= key }
+		[]byte{opBlock, blockEmpty, opLoop, blockEmpty},
+		[]byte{opLocalGet, 4, opLocalGet, 3, opI32GeU, opBrIf, 1},
+		[]byte{opLocalGet, 2, opLocalGet, 4, opI32Add},
+		[]byte{opLocalGet, 2, opLocalGet, 4, opI32Add, opLoad8U, 0, 0}, i32(int32(key)), []byte{opI32Xor, opStore8, 0, 0},
+		[]byte{opLocalGet, 4}, i32(1), []byte{opI32Add, opLocalSet, 4, opBr, 0},
+		[]byte{opEnd, opEnd},
+		[]byte{opLocalGet, 2, opLocalGet, 3, opCall, fnOutput},
+		resetHeap(), i32(0),
+		[]byte{opEnd},
+	)
+	code := section(10, vec(bytes(alloc), bytes(transform)))
+
+	data := section(11, vec(
+		cat([]byte{0x00}, i32(metadataOffset), []byte{opEnd}, bytes(metadataJSON)),
+		cat([]byte{0x00}, i32(errorOffset), []byte{opEnd}, bytes(errorMessage)),
+	))
+
+	return cat([]byte("\x00asm\x01\x00\x00\x00"), types, imports, functions, memory, globals, exports, code, data)
+}
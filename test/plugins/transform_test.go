@@ -0,0 +1,163 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/relais/pkg/plugins"
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// errWrite is returned by failingStore for the frames it fails to write.
+var errWrite = errors.New("write failed")
+
+// failingStore is a storage that fails to write one output frame, or to
+// record checkpoints.
+type failingStore struct {
+	storage.Storage
+	failSession    string // Session of the frame failing to be written
+	failIndex      int64  // Index of the frame failing to be written
+	failCheckpoint bool   // Fail every SetCheckpoint call
+}
+
+func (s *failingStore) PutFrame(ctx context.Context, frame storage.Frame) error {
+	if frame.SessionID == s.failSession && frame.Index == s.failIndex {
+		return errWrite
+	}
+	return s.Storage.PutFrame(ctx, frame)
+}
+
+func (s *failingStore) SetCheckpoint(ctx context.Context, consumer, sessionID string, index int64) error {
+	if s.failCheckpoint {
+		return errWrite
+	}
+	return s.Storage.SetCheckpoint(ctx, consumer, sessionID, index)
+}
+
+// TestRenditionTransformWriteFailure verifies that a transform stops at the
+// first frame it fails to write, without checkpointing past it, and that the
+// error is returned.
+func TestRenditionTransformWriteFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sourceID := storage.RenditionSessionID("cam1", storage.RenditionSource)
+	outputID := storage.RenditionSessionID("cam1", "copy")
+	store := &failingStore{Storage: storage.NewMemoryStorage(), failSession: outputID, failIndex: 2}
+	for i := int64(0); i < 5; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: sourceID, Index: i}))
+	}
+
+	transform := plugins.RenditionTransform{Input: storage.RenditionSource, Output: "copy", InstanceID: "copy"}
+	err := transform.Run(ctx, store, func(ctx context.Context, frame storage.Frame) (storage.Frame, bool, error) {
+		return frame, true, nil
+	})
+	assert.ErrorIs(t, err, errWrite)
+
+	frames, err := store.ListFrames(ctx, outputID)
+	require.NoError(t, err)
+	require.Len(t, frames, 2)
+	assert.Equal(t, int64(1), frames[1].Index)
+
+	checkpoint, ok, err := store.GetCheckpoint(ctx, "copy", sourceID)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), checkpoint)
+
+	// A restarted transform resumes at the failed frame
+	store.failIndex = -1
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- transform.Run(runCtx, store, func(ctx context.Context, frame storage.Frame) (storage.Frame, bool, error) {
+			return frame, true, nil
+		})
+	}()
+	require.Eventually(t, func() bool {
+		frames, err := store.ListFrames(ctx, outputID)
+		return err == nil && len(frames) == 5
+	}, 5*time.Second, 10*time.Millisecond)
+	stop()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+// TestRenditionTransformCheckpointFailure verifies that a transform stops
+// with the error when its checkpoint can't be recorded.
+func TestRenditionTransformCheckpointFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sourceID := storage.RenditionSessionID("cam1", storage.RenditionSource)
+	store := &failingStore{Storage: storage.NewMemoryStorage(), failIndex: -1, failCheckpoint: true}
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: sourceID, Index: 0}))
+
+	transform := plugins.RenditionTransform{Input: storage.RenditionSource, Output: "copy", InstanceID: "copy"}
+	err := transform.Run(ctx, store, func(ctx context.Context, frame storage.Frame) (storage.Frame, bool, error) {
+		return frame, false, nil
+	})
+	assert.ErrorIs(t, err, errWrite)
+}
+
+// TestRenditionTransformGroup verifies that transforms sharing a consumer
+// group process every input frame exactly once between them.
+func TestRenditionTransformGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store, err := storage.NewRedisStreamsStorage(miniredis.RunT(t).Addr())
+	require.NoError(t, err)
+	defer store.Close()
+
+	sourceID := storage.RenditionSessionID("cam1", storage.RenditionSource)
+	outputID := storage.RenditionSessionID("cam1", "copy")
+	for i := int64(0); i < 40; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: sourceID, Index: i}))
+	}
+
+	var mu sync.Mutex
+	processed := make(map[int64]int)
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 2)
+	for _, instance := range []string{"a", "b"} {
+		transform := plugins.RenditionTransform{Input: storage.RenditionSource, Output: "copy", InstanceID: instance, Group: "copy"}
+		go func() {
+			done <- transform.Run(runCtx, store, func(ctx context.Context, frame storage.Frame) (storage.Frame, bool, error) {
+				mu.Lock()
+				processed[frame.Index]++
+				mu.Unlock()
+				return frame, true, nil
+			})
+		}()
+	}
+
+	require.Eventually(t, func() bool {
+		frames, err := store.ListFrames(ctx, outputID)
+		return err == nil && len(frames) == 40
+	}, 5*time.Second, 10*time.Millisecond)
+	stop()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, processed, 40)
+	for index, count := range processed {
+		assert.Equal(t, 1, count, "frame %d processed %d times", index, count)
+	}
+}
+
+// TestRenditionTransformGroupUnsupported verifies that a consumer group is
+// rejected on a storage without consumer groups.
+func TestRenditionTransformGroupUnsupported(t *testing.T) {
+	transform := plugins.RenditionTransform{Input: storage.RenditionSource, Output: "copy", InstanceID: "a", Group: "copy"}
+	err := transform.Run(context.Background(), storage.NewMemoryStorage(), func(ctx context.Context, frame storage.Frame) (storage.Frame, bool, error) {
+		return frame, true, nil
+	})
+	assert.ErrorContains(t, err, "consumer groups")
+}
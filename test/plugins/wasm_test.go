@@ -0,0 +1,226 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/frames"
+	"github.com/relais/pkg/plugins"
+	"github.com/relais/pkg/plugins/wasm"
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWasmMetadata verifies that frame metadata survives the JSON form
+// passed to modules.
+func TestWasmMetadata(t *testing.T) {
+	frame := storage.Frame{
+		SessionID: "cam1/source",
+		Index:     7,
+		Timestamp: time.Unix(1700000000, 42),
+		MediaType: "video",
+		Codec:     frames.CodecH264,
+		KeyFrame:  true,
+		TrackID:   1,
+		Sequence:  9,
+		PTS:       3000,
+		DTS:       3000,
+		Duration:  3000,
+		Timebase:  frames.Timebase{Num: 1, Den: 90000},
+		SideData:  []frames.SideData{{Type: frames.SideDataSPS, Data: []byte{1, 2, 3}}},
+		Data:      []byte("payload"),
+	}
+
+	data, err := json.Marshal(wasm.MetadataOf(frame))
+	require.NoError(t, err)
+	var m wasm.Metadata
+	require.NoError(t, json.Unmarshal(data, &m))
+
+	out := m.Apply(storage.Frame{Codec: frames.CodecH264, SideData: frame.SideData, Data: frame.Data})
+	assert.Equal(t, frame.SessionID, out.SessionID)
+	assert.Equal(t, frame.Index, out.Index)
+	assert.True(t, frame.Timestamp.Equal(out.Timestamp))
+	assert.Equal(t, frame.Codec, out.Codec)
+	assert.True(t, out.KeyFrame)
+	assert.Equal(t, frame.Timebase, out.Timebase)
+	assert.Equal(t, frame.SideData, out.SideData)
+	assert.Equal(t, frame.Data, out.Data)
+
+	// Side data describes the codec, so it doesn't survive a codec change
+	m.Codec = string(frames.CodecHEVC)
+	out = m.Apply(frame)
+	assert.Equal(t, frames.CodecHEVC, out.Codec)
+	assert.Nil(t, out.SideData)
+
+	// A zero timestamp means unknown
+	m = wasm.MetadataOf(storage.Frame{})
+	assert.Zero(t, m.Timestamp)
+	assert.True(t, m.Apply(frame).Timestamp.IsZero())
+}
+
+// copyModule copies a test module of testdata/wasm, built by gen.go, to a
+// temporary directory and returns its path.
+func copyModule(t *testing.T, dir, name, as string) string {
+	data, err := os.ReadFile(filepath.Join("testdata", "wasm", name))
+	require.NoError(t, err)
+	path := filepath.Join(dir, as)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	return path
+}
+
+// startWasm initializes a WebAssembly plugin and runs it in the background.
+// The returned function stops it and returns the error of Run.
+func startWasm(t *testing.T, ctx context.Context, plugin plugins.TransformPlugin, store storage.Storage) func() error {
+	require.NoError(t, plugin.Initialize(ctx, map[string]interface{}{}))
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- plugin.Run(runCtx, store) }()
+	return func() error {
+		stop()
+		err := <-done
+		plugin.Stop()
+		return err
+	}
+}
+
+// TestWasmTransform verifies that frames are transformed by the module:
+// output payloads, metadata set by the module, and skipped frames.
+func TestWasmTransform(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	registry := plugins.NewRegistry()
+	require.NoError(t, wasm.Register(registry, "", copyModule(t, t.TempDir(), "invert.wasm", "invert.wasm")))
+	plugin, err := registry.Create(plugins.PluginTypeTransform, "invert")
+	require.NoError(t, err)
+
+	store := storage.NewMemoryStorage()
+	sourceID := storage.RenditionSessionID("cam1", storage.RenditionSource)
+	outputID := storage.RenditionSessionID("cam1", "invert")
+	for i, data := range []string{"\x01\x02", "skip", "meta", "\xf0"} {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{
+			SessionID: sourceID,
+			Index:     int64(i),
+			MediaType: frames.MediaTypeVideo,
+			Codec:     frames.CodecH264,
+			Data:      []byte(data),
+		}))
+	}
+
+	stop := startWasm(t, ctx, plugin.(plugins.TransformPlugin), store)
+	require.Eventually(t, func() bool {
+		out, err := store.ListFrames(ctx, outputID)
+		return err == nil && len(out) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, stop(), context.Canceled)
+
+	out, err := store.ListFrames(ctx, outputID)
+	require.NoError(t, err)
+	require.Len(t, out, 3)
+	assert.Equal(t, int64(0), out[0].Index)
+	assert.Equal(t, []byte{0xfe, 0xfd}, out[0].Data)
+	assert.Equal(t, frames.CodecH264, out[0].Codec)
+
+	// set_metadata overrides fields, and the payload is kept without output
+	assert.Equal(t, int64(2), out[1].Index)
+	assert.Equal(t, []byte("meta"), out[1].Data)
+	assert.True(t, out[1].KeyFrame)
+	assert.Equal(t, frames.CodecPNG, out[1].Codec)
+	assert.Equal(t, outputID, out[1].SessionID)
+
+	assert.Equal(t, int64(3), out[2].Index)
+	assert.Equal(t, []byte{0x0f}, out[2].Data)
+
+	// The skipped frame is checkpointed like the others
+	checkpoint, ok, err := store.GetCheckpoint(ctx, "invert", sourceID)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), checkpoint)
+}
+
+// TestWasmErrors verifies that an error status stops the plugin, and that a
+// trapped module is instantiated again for the next frame.
+func TestWasmErrors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store := storage.NewMemoryStorage()
+	sourceID := storage.RenditionSessionID("cam1", storage.RenditionSource)
+	outputID := storage.RenditionSessionID("cam1", "invert")
+	plugin := wasm.NewPlugin(copyModule(t, t.TempDir(), "invert.wasm", "invert.wasm"))
+	require.NoError(t, plugin.Initialize(ctx, map[string]interface{}{}))
+	defer plugin.Stop()
+
+	// The module counts the frames its instance saw before trapping
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: sourceID, Index: 0, Data: []byte("c")}))
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: sourceID, Index: 1, Data: []byte("t")}))
+	err := plugin.Run(ctx, store)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "module call failed")
+
+	// The frame that trapped is processed again after the restart, by a new
+	// instance that saw no frames yet
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: sourceID, Index: 1, Data: []byte("c")}))
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: sourceID, Index: 2, Data: []byte("e")}))
+	err = plugin.Run(ctx, store)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "module failed with status 2: bad frame")
+
+	out, err := store.ListFrames(ctx, outputID)
+	require.NoError(t, err)
+	require.Len(t, out, 2)
+	assert.Equal(t, []byte{1}, out[0].Data)
+	assert.Equal(t, []byte{1}, out[1].Data)
+
+	// Invalid modules fail to initialize
+	bad := filepath.Join(t.TempDir(), "bad.wasm")
+	require.NoError(t, os.WriteFile(bad, []byte("\x00asm\x01\x00\x00\x00"), 0o644))
+	err = wasm.NewPlugin(bad).Initialize(ctx, map[string]interface{}{})
+	assert.ErrorContains(t, err, "module must export memory")
+}
+
+// TestWasmReload verifies that a module is loaded again when its file
+// changes, without restarting the plugin.
+func TestWasmReload(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	path := copyModule(t, dir, "invert.wasm", "transform.wasm")
+	store := storage.NewMemoryStorage()
+	sourceID := storage.RenditionSessionID("cam1", storage.RenditionSource)
+	outputID := storage.RenditionSessionID("cam1", "transform")
+
+	stop := startWasm(t, ctx, wasm.NewPlugin(path), store)
+	defer stop()
+
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: sourceID, Index: 0, Data: []byte{0x01}}))
+	require.Eventually(t, func() bool {
+		out, err := store.ListFrames(ctx, outputID)
+		return err == nil && len(out) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Replace the module, with a later modification time than the first
+	copyModule(t, dir, "passthrough.wasm", "transform.wasm")
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+
+	// The file is checked for changes at most once a second
+	time.Sleep(1100 * time.Millisecond)
+
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: sourceID, Index: 1, Data: []byte{0x01}}))
+	require.Eventually(t, func() bool {
+		out, err := store.ListFrames(ctx, outputID)
+		return err == nil && len(out) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	out, err := store.ListFrames(ctx, outputID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xfe}, out[0].Data, "transformed by invert.wasm")
+	assert.Equal(t, []byte{0x01}, out[1].Data, "transformed by passthrough.wasm")
+}
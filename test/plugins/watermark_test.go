@@ -0,0 +1,154 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/storage"
+	"github.com/relais/plugins/transforms/watermark"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodePNG returns a PNG encoded image of the given size filled with c.
+func encodePNG(t *testing.T, size int, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// TestWatermarkRenditions verifies that the watermark plugin writes to the
+// output rendition and leaves the source frames intact.
+func TestWatermarkRenditions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store := storage.NewMemoryStorage()
+	sourceID := storage.RenditionSessionID("cam1", storage.RenditionSource)
+	outputID := storage.RenditionSessionID("cam1", storage.RenditionWatermarked)
+
+	source := encodePNG(t, 8, color.Black)
+	for i := int64(0); i < 3; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{
+			SessionID: sourceID,
+			Index:     i,
+			MediaType: "video",
+			Data:      source,
+		}))
+	}
+
+	plugin := watermark.NewWatermarkPlugin()
+	require.NoError(t, plugin.Initialize(ctx, map[string]interface{}{
+		"watermark_image": encodePNG(t, 2, color.White),
+	}))
+
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- plugin.Run(runCtx, store) }()
+
+	require.Eventually(t, func() bool {
+		frames, err := store.ListFrames(ctx, outputID)
+		return err == nil && len(frames) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Let the plugin poll the sessions a few more times
+	time.Sleep(300 * time.Millisecond)
+	stop()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	frames, err := store.ListFrames(ctx, sourceID)
+	require.NoError(t, err)
+	require.Len(t, frames, 3)
+	for _, frame := range frames {
+		assert.Equal(t, source, frame.Data)
+	}
+
+	frames, err = store.ListFrames(ctx, outputID)
+	require.NoError(t, err)
+	require.Len(t, frames, 3)
+	for _, frame := range frames {
+		img, err := png.Decode(bytes.NewReader(frame.Data))
+		require.NoError(t, err)
+		r, _, _, _ := img.At(0, 0).RGBA()
+		assert.Equal(t, uint32(0xffff), r, "watermark pixel")
+		r, _, _, _ = img.At(7, 7).RGBA()
+		assert.Zero(t, r, "source pixel")
+	}
+
+	// Watermarked frames are not watermarked again
+	sessions, err := store.ListSessions(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{sourceID, outputID}, sessions)
+
+	// Writing to the rendition that is read is rejected
+	err = watermark.NewWatermarkPlugin().Initialize(ctx, map[string]interface{}{
+		"watermark_image":  encodePNG(t, 2, color.White),
+		"output_rendition": storage.RenditionSource,
+	})
+	assert.ErrorContains(t, err, "must differ")
+}
+
+// TestWatermarkCheckpoints verifies that a restarted watermark plugin resumes
+// after the last frame it processed instead of processing the session again.
+func TestWatermarkCheckpoints(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store := storage.NewMemoryStorage()
+	sourceID := storage.RenditionSessionID("cam1", storage.RenditionSource)
+	outputID := storage.RenditionSessionID("cam1", storage.RenditionWatermarked)
+	source := encodePNG(t, 8, color.Black)
+	config := map[string]interface{}{"watermark_image": encodePNG(t, 2, color.White)}
+
+	// run starts a watermark plugin and waits until the output holds count frames
+	run := func(count int) {
+		plugin := watermark.NewWatermarkPlugin()
+		require.NoError(t, plugin.Initialize(ctx, config))
+
+		runCtx, stop := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() { done <- plugin.Run(runCtx, store) }()
+
+		require.Eventually(t, func() bool {
+			frames, err := store.ListFrames(ctx, outputID)
+			return err == nil && len(frames) == count
+		}, 5*time.Second, 10*time.Millisecond)
+
+		stop()
+		<-done
+	}
+
+	for i := int64(0); i < 3; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: sourceID, Index: i, MediaType: "video", Data: source}))
+	}
+	run(3)
+
+	checkpoint, ok, err := store.GetCheckpoint(ctx, "watermark", sourceID)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), checkpoint)
+
+	// Mark an output frame to detect it being processed again
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: outputID, Index: 0, Data: []byte("marker")}))
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: sourceID, Index: 3, MediaType: "video", Data: source}))
+	run(4)
+
+	frame, err := store.GetFrame(ctx, outputID, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("marker"), frame.Data)
+
+	checkpoint, _, err = store.GetCheckpoint(ctx, "watermark", sourceID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), checkpoint)
+}
@@ -0,0 +1,31 @@
+package plugins
+
+import (
+	"context"
+	"testing"
+
+	"github.com/relais/plugins/egress/webrtc_egress"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWebRTCEgressCodecs verifies that the WebRTC egress plugin sets up a
+// track for every codec WebRTC can carry and rejects the others.
+func TestWebRTCEgressCodecs(t *testing.T) {
+	for _, codec := range []string{"h264", "hevc", "vp8", "vp9", "av1", "opus"} {
+		t.Run(codec, func(t *testing.T) {
+			plugin := webrtc_egress.NewWebRTCEgressPlugin()
+			require.NoError(t, plugin.Initialize(context.Background(), map[string]interface{}{"codec": codec, "stream": "cam1"}))
+			assert.NoError(t, plugin.Stop())
+		})
+	}
+
+	for _, codec := range []string{"aac", "png", "unknown"} {
+		plugin := webrtc_egress.NewWebRTCEgressPlugin()
+		assert.Error(t, plugin.Initialize(context.Background(), map[string]interface{}{"codec": codec, "stream": "cam1"}), codec)
+	}
+
+	// The plugin needs to know what to send
+	plugin := webrtc_egress.NewWebRTCEgressPlugin()
+	assert.ErrorContains(t, plugin.Initialize(context.Background(), map[string]interface{}{"codec": "h264"}), "missing stream or session_id")
+}
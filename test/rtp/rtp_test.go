@@ -0,0 +1,365 @@
+package rtp
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	pionrtp "github.com/pion/rtp"
+	"github.com/relais/pkg/frames"
+	"github.com/relais/pkg/rtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	h264SPS = []byte{0x67, 0x42, 0x00, 0x0a, 0xf8, 0x41, 0xa2}
+	h264PPS = []byte{0x68, 0xce, 0x38, 0x80}
+	hevcVPS = []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff}
+	hevcSPS = []byte{0x42, 0x01, 0x01, 0x01, 0x60}
+	hevcPPS = []byte{0x44, 0x01, 0xc1, 0x72}
+)
+
+// payload returns a payload of n bytes starting with header, free of start
+// code emulation.
+func payload(header []byte, n int) []byte {
+	data := append([]byte{}, header...)
+	return append(data, bytes.Repeat([]byte{0xab}, n-len(header))...)
+}
+
+// obu builds an AV1 OBU with a size field.
+func obu(obuType byte, payload []byte) []byte {
+	data := []byte{obuType<<3 | 0x02}
+	for size := len(payload); ; {
+		b := byte(size & 0x7f)
+		size >>= 7
+		if size == 0 {
+			data = append(data, b)
+			break
+		}
+		data = append(data, b|0x80)
+	}
+	return append(data, payload...)
+}
+
+// adts builds an AAC-LC 44.1kHz stereo ADTS frame around an access unit.
+func adts(unit []byte) []byte {
+	length := 7 + len(unit)
+	header := []byte{0xff, 0xf1, 0x50, 0x80 | byte(length>>11), byte(length >> 3), byte(length<<5) | 0x1f, 0xfc}
+	return append(header, unit...)
+}
+
+// packetize converts frames to packets.
+func packetize(t *testing.T, codec frames.CodecType, clockRate uint32, in []frames.Frame) []*pionrtp.Packet {
+	packetizer, err := rtp.NewPacketizer(codec, 96, clockRate)
+	require.NoError(t, err)
+
+	var packets []*pionrtp.Packet
+	for _, frame := range in {
+		p, err := packetizer.Packetize(frame)
+		require.NoError(t, err)
+		for _, packet := range p {
+			assert.LessOrEqual(t, packet.MarshalSize(), rtp.DefaultMTU)
+			assert.Equal(t, packetizer.SSRC(), packet.SSRC)
+		}
+		packets = append(packets, p...)
+	}
+	return packets
+}
+
+// depacketize reassembles frames from packets.
+func depacketize(t *testing.T, codec frames.CodecType, clockRate uint32, packets []*pionrtp.Packet) []frames.Frame {
+	depacketizer, err := rtp.NewDepacketizer(codec, clockRate, 0)
+	require.NoError(t, err)
+
+	var out []frames.Frame
+	for _, packet := range packets {
+		out = append(out, depacketizer.Push(packet)...)
+	}
+	return append(out, depacketizer.Flush()...)
+}
+
+// TestRoundTrip verifies that frames of every codec survive packetization
+// and depacketization, including fragmentation across packets.
+func TestRoundTrip(t *testing.T) {
+	h264IDR := payload([]byte{0x65, 0x88}, 3000)
+	h264Slice := payload([]byte{0x41, 0x9a}, 500)
+	hevcIDR := payload([]byte{0x26, 0x01}, 3000)
+	sequenceHeader := obu(1, []byte{0x00, 0x00, 0x00, 0x0a, 0x0f, 0x0a, 0x80})
+	av1Frame := obu(6, payload([]byte{0x10}, 2500))
+	aacUnit := payload([]byte{0x21}, 2000)
+
+	tests := []struct {
+		name      string
+		codec     frames.CodecType
+		clockRate uint32
+		in        frames.Frame
+		want      []byte
+		keyFrame  bool
+		sideData  []frames.SideData
+	}{
+		{
+			name:     "h264 key frame with side data",
+			codec:    frames.CodecH264,
+			in:       frames.Frame{Data: frames.JoinAVCC([][]byte{h264IDR}), KeyFrame: true, SideData: []frames.SideData{{Type: frames.SideDataPPS, Data: h264PPS}, {Type: frames.SideDataSPS, Data: h264SPS}}},
+			want:     frames.JoinAnnexB([][]byte{h264SPS, h264PPS, h264IDR}),
+			keyFrame: true,
+			sideData: []frames.SideData{{Type: frames.SideDataSPS, Data: h264SPS}, {Type: frames.SideDataPPS, Data: h264PPS}},
+		},
+		{
+			name:  "h264 slice",
+			codec: frames.CodecH264,
+			in:    frames.Frame{Data: frames.JoinAnnexB([][]byte{h264Slice})},
+			want:  frames.JoinAnnexB([][]byte{h264Slice}),
+		},
+		{
+			name:     "hevc key frame",
+			codec:    frames.CodecHEVC,
+			in:       frames.Frame{Data: frames.JoinAnnexB([][]byte{hevcVPS, hevcSPS, hevcPPS, hevcIDR}), KeyFrame: true},
+			want:     frames.JoinAnnexB([][]byte{hevcVPS, hevcSPS, hevcPPS, hevcIDR}),
+			keyFrame: true,
+			sideData: []frames.SideData{{Type: frames.SideDataVPS, Data: hevcVPS}, {Type: frames.SideDataSPS, Data: hevcSPS}, {Type: frames.SideDataPPS, Data: hevcPPS}},
+		},
+		{
+			name:     "vp8 key frame",
+			codec:    frames.CodecVP8,
+			in:       frames.Frame{Data: payload([]byte{0x50, 0x2a, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0xe0, 0x01}, 3000)},
+			want:     payload([]byte{0x50, 0x2a, 0x00, 0x9d, 0x01, 0x2a, 0x80, 0x02, 0xe0, 0x01}, 3000),
+			keyFrame: true,
+		},
+		{
+			name:     "vp9 key frame",
+			codec:    frames.CodecVP9,
+			in:       frames.Frame{Data: payload([]byte{0x82, 0x49, 0x83, 0x42, 0x00, 0x4f, 0xf0, 0x2c, 0xf0}, 3000)},
+			want:     payload([]byte{0x82, 0x49, 0x83, 0x42, 0x00, 0x4f, 0xf0, 0x2c, 0xf0}, 3000),
+			keyFrame: true,
+		},
+		{
+			name:     "av1 key frame",
+			codec:    frames.CodecAV1,
+			in:       frames.Frame{Data: append(append(obu(2, nil), sequenceHeader...), av1Frame...), KeyFrame: true},
+			want:     append(append([]byte{}, sequenceHeader...), av1Frame...),
+			keyFrame: true,
+			sideData: []frames.SideData{{Type: frames.SideDataAV1SequenceHeader, Data: sequenceHeader}},
+		},
+		{
+			name:     "opus",
+			codec:    frames.CodecOpus,
+			in:       frames.Frame{Data: payload([]byte{0xfc}, 160)},
+			want:     payload([]byte{0xfc}, 160),
+			keyFrame: true,
+		},
+		{
+			name:      "aac fragmented",
+			codec:     frames.CodecAAC,
+			clockRate: 44100,
+			in:        frames.Frame{Data: adts(aacUnit)},
+			want:      aacUnit,
+			keyFrame:  true,
+		},
+		{
+			name:      "aac aggregated",
+			codec:     frames.CodecAAC,
+			clockRate: 44100,
+			in:        frames.Frame{Data: append(adts([]byte{1, 2, 3}), adts([]byte{4, 5})...)},
+			want:      []byte{1, 2, 3, 4, 5},
+			keyFrame:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.in.Codec = tt.codec
+			packets := packetize(t, tt.codec, tt.clockRate, []frames.Frame{tt.in})
+			require.NotEmpty(t, packets)
+			assert.True(t, packets[len(packets)-1].Marker)
+
+			out := depacketize(t, tt.codec, tt.clockRate, packets)
+			require.Len(t, out, 1)
+			assert.Equal(t, tt.want, out[0].Data)
+			assert.Equal(t, tt.codec, out[0].Codec)
+			assert.Equal(t, tt.keyFrame, out[0].KeyFrame)
+			assert.Equal(t, tt.sideData, out[0].SideData)
+		})
+	}
+}
+
+// videoFrames returns H.264 frames of a 30 FPS stream, starting with a key
+// frame, each spanning several packets.
+func videoFrames(n int) []frames.Frame {
+	out := make([]frames.Frame, n)
+	for i := range out {
+		nal := payload([]byte{0x41, byte(i)}, 2000)
+		keyFrame := i == 0
+		if keyFrame {
+			nal = payload([]byte{0x65, 0x88}, 2000)
+		}
+		out[i] = frames.Frame{
+			Data:     frames.JoinAnnexB([][]byte{nal}),
+			Codec:    frames.CodecH264,
+			KeyFrame: keyFrame,
+			PTS:      int64(i) * 3000,
+			Timebase: frames.Timebase90kHz,
+		}
+	}
+	return out
+}
+
+// TestDepacketizerReordering verifies that reordered packets are put back
+// in order and timestamps are kept.
+func TestDepacketizerReordering(t *testing.T) {
+	in := videoFrames(10)
+	packets := packetize(t, frames.CodecH264, 0, in)
+
+	// Swap packets within a window smaller than the jitter buffer
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i+1 < len(packets); i += 2 {
+		if r.Intn(2) == 0 {
+			packets[i], packets[i+1] = packets[i+1], packets[i]
+		}
+	}
+	packets[3], packets[9] = packets[9], packets[3]
+
+	out := depacketize(t, frames.CodecH264, 0, packets)
+	require.Len(t, out, len(in))
+	for i, frame := range out {
+		assert.Equal(t, in[i].Data, frame.Data)
+		assert.Equal(t, in[i].PTS, frame.PTS)
+		assert.Equal(t, uint64(i), frame.Sequence)
+		assert.Equal(t, frames.Timebase90kHz, frame.Timebase)
+		assert.Equal(t, frames.MediaTypeVideo, frame.MediaType)
+	}
+	assert.True(t, out[0].KeyFrame)
+}
+
+// TestDepacketizerLoss verifies that frames missing a packet are dropped
+// and the following ones are recovered.
+func TestDepacketizerLoss(t *testing.T) {
+	in := videoFrames(5)
+	packets := packetize(t, frames.CodecH264, 0, in)
+	perFrame := len(packets) / len(in)
+
+	// Drop a packet in the middle of the third frame
+	lost := 2*perFrame + 1
+	packets = append(packets[:lost], packets[lost+1:]...)
+
+	out := depacketize(t, frames.CodecH264, 0, packets)
+	require.Len(t, out, 4)
+	for i, want := range []int{0, 1, 3, 4} {
+		assert.Equal(t, in[want].Data, out[i].Data)
+		assert.Equal(t, in[want].PTS, out[i].PTS)
+	}
+}
+
+// TestDepacketizerMalformed verifies that truncated payloads mark their
+// frame broken instead of crashing, and that the following frames are
+// recovered.
+func TestDepacketizerMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   frames.CodecType
+		payload []byte
+	}{
+		{"STAP-A", frames.CodecH264, []byte{0x78, 0x00}},
+		{"FU-A", frames.CodecH264, []byte{0x7c}},
+		{"FU-AStart", frames.CodecH264, []byte{0x7c, 0x85}},
+		{"VP8", frames.CodecVP8, []byte{0x90, 0x80, 0x80}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var in []frames.Frame
+			if tt.codec == frames.CodecH264 {
+				in = videoFrames(2)
+			} else {
+				in = []frames.Frame{
+					{Data: payload([]byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a}, 100), Codec: tt.codec, PTS: 0},
+					{Data: payload([]byte{0x11}, 100), Codec: tt.codec, PTS: 3000},
+				}
+			}
+			packets := packetize(t, tt.codec, 0, in)
+
+			depacketizer, err := rtp.NewDepacketizer(tt.codec, 0, 0)
+			require.NoError(t, err)
+			malformed := &pionrtp.Packet{
+				Header:  pionrtp.Header{SequenceNumber: packets[0].SequenceNumber - 1, Timestamp: packets[0].Timestamp - 3000, Marker: true},
+				Payload: tt.payload,
+			}
+			var out []frames.Frame
+			require.NotPanics(t, func() {
+				out = append(out, depacketizer.Push(malformed)...)
+				for _, packet := range packets {
+					out = append(out, depacketizer.Push(packet)...)
+				}
+				out = append(out, depacketizer.Flush()...)
+			})
+			require.Len(t, out, len(in))
+			for i := range in {
+				assert.Equal(t, in[i].Data, out[i].Data)
+			}
+		})
+	}
+}
+
+// TestJitterBuffer verifies ordering across sequence number wraparound,
+// duplicates, late packets and skipping lost packets.
+func TestJitterBuffer(t *testing.T) {
+	packet := func(seq uint16) *pionrtp.Packet {
+		return &pionrtp.Packet{Header: pionrtp.Header{SequenceNumber: seq}}
+	}
+	popAll := func(b *rtp.JitterBuffer) ([]uint16, int) {
+		var seqs []uint16
+		total := 0
+		for {
+			p, lost := b.Pop()
+			if p == nil {
+				return seqs, total
+			}
+			seqs = append(seqs, p.SequenceNumber)
+			total += lost
+		}
+	}
+
+	b := rtp.NewJitterBuffer(4)
+	for _, seq := range []uint16{65535, 65534, 1, 0, 1} {
+		b.Push(packet(seq))
+	}
+	seqs, lost := popAll(b)
+	assert.Equal(t, []uint16{65534, 65535, 0, 1}, seqs)
+	assert.Zero(t, lost)
+
+	// Late packets are dropped
+	b.Push(packet(65535))
+	assert.Zero(t, b.Len())
+
+	// A missing packet is waited for until the buffer is full
+	for _, seq := range []uint16{3, 4, 5} {
+		b.Push(packet(seq))
+	}
+	seqs, _ = popAll(b)
+	assert.Empty(t, seqs)
+	b.Push(packet(6))
+	seqs, lost = popAll(b)
+	assert.Equal(t, []uint16{3, 4, 5, 6}, seqs)
+	assert.Equal(t, 1, lost)
+
+	// Flush releases what is left
+	b.Push(packet(9))
+	b.Push(packet(8))
+	assert.Equal(t, []*pionrtp.Packet{packet(8), packet(9)}, b.Flush())
+}
+
+// TestPacketizerErrors verifies unsupported codecs and mismatched frames.
+func TestPacketizerErrors(t *testing.T) {
+	_, err := rtp.NewPacketizer(frames.CodecPNG, 96, 0)
+	assert.ErrorIs(t, err, rtp.ErrUnsupportedCodec)
+	_, err = rtp.NewDepacketizer(frames.CodecJPEG, 0, 0)
+	assert.ErrorIs(t, err, rtp.ErrUnsupportedCodec)
+	_, err = rtp.NewPacketizer(frames.CodecAAC, 96, 0)
+	assert.Error(t, err)
+
+	packetizer, err := rtp.NewPacketizer(frames.CodecVP8, 96, 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(90000), packetizer.ClockRate())
+	_, err = packetizer.Packetize(frames.Frame{Codec: frames.CodecVP9, Data: []byte{1}})
+	assert.Error(t, err)
+}
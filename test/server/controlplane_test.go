@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/relais/pkg/server"
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestControlPlaneErrors verifies that missing sessions are reported as
+// 404 Not Found.
+func TestControlPlaneErrors(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	mux := http.NewServeMux()
+	server.NewControlPlane(server.NewSessionManager(), store).RegisterRoutes(mux)
+
+	deleteSession := func(sessionID string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/sessions/"+sessionID, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	require.NoError(t, store.PutFrame(context.Background(), storage.Frame{SessionID: "cam1", Index: 0}))
+	assert.Equal(t, http.StatusNoContent, deleteSession("cam1"))
+	assert.Equal(t, http.StatusNotFound, deleteSession("cam1"))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/sessions/missing", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "session missing: session not found\n", rec.Body.String())
+}
+
+// TestControlPlaneUnavailable verifies that a failing storage backend is
+// reported as 503 Service Unavailable.
+func TestControlPlaneUnavailable(t *testing.T) {
+	redis := miniredis.RunT(t)
+	store, err := storage.NewRedisStorage(redis.Addr())
+	require.NoError(t, err)
+	defer store.Close()
+
+	sessionMgr := server.NewSessionManager()
+	session, err := sessionMgr.CreateSession(context.Background(), "webrtc", nil)
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	server.NewControlPlane(sessionMgr, store).RegisterRoutes(mux)
+
+	redis.Close()
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/sessions/"+session.ID, nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	// The session is kept so that the delete can be retried
+	_, exists := sessionMgr.GetSession(session.ID)
+	assert.True(t, exists)
+}
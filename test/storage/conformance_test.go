@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/relais/pkg/storage"
+	"github.com/relais/pkg/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConformance runs the storage conformance suite against every backend.
+// Redis backends run against an in-process fake server.
+func TestConformance(t *testing.T) {
+	backends := map[string]storagetest.Factory{
+		"memory": func(t *testing.T) storage.Storage {
+			return storage.NewMemoryStorage()
+		},
+		"redis": func(t *testing.T) storage.Storage {
+			store, err := storage.NewRedisStorage(miniredis.RunT(t).Addr())
+			require.NoError(t, err)
+			return store
+		},
+		"redis_streams": func(t *testing.T) storage.Storage {
+			store, err := storage.NewRedisStreamsStorage(miniredis.RunT(t).Addr())
+			require.NoError(t, err)
+			return store
+		},
+		"file": func(t *testing.T) storage.Storage {
+			store, err := storage.NewFileStorage(storage.FileConfig{Dir: t.TempDir(), MaxSegmentBytes: 4096})
+			require.NoError(t, err)
+			return store
+		},
+		"object": func(t *testing.T) storage.Storage {
+			store, err := storage.NewObjectStorage(storage.ObjectConfig{
+				Client:      storage.NewMemoryObjectClient(),
+				BatchFrames: 4,
+				BatchAge:    50 * time.Millisecond,
+			})
+			require.NoError(t, err)
+			return store
+		},
+		"tiered": func(t *testing.T) storage.Storage {
+			cold, err := storage.NewFileStorage(storage.FileConfig{Dir: t.TempDir()})
+			require.NoError(t, err)
+			store, err := storage.NewTieredStorage(cold, storage.TieredConfig{HotFrames: 4, SpillBatch: 2})
+			require.NoError(t, err)
+			return store
+		},
+	}
+
+	for name, factory := range backends {
+		t.Run(name, func(t *testing.T) {
+			storagetest.RunConformance(t, factory)
+		})
+	}
+}
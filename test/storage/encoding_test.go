@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/frames"
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFrameEncodingRoundTrip verifies that the binary envelope preserves
+// every frame field.
+func TestFrameEncodingRoundTrip(t *testing.T) {
+	tests := []storage.Frame{
+		{
+			SessionID: "cam1",
+			Index:     1234,
+			Data:      []byte{0, 0, 0, 1, 0x65, 0xff},
+			Timestamp: time.Unix(1700000000, 123456789),
+			MediaType: "video",
+			Codec:     frames.CodecH264,
+			KeyFrame:  true,
+			TrackID:   1,
+			Sequence:  99,
+			PTS:       180000,
+			DTS:       177000,
+			Duration:  3000,
+			Timebase:  frames.Timebase90kHz,
+			SideData: []frames.SideData{
+				{Type: frames.SideDataSPS, Data: []byte{0x67, 0x42, 0xc0, 0x1f}},
+				{Type: frames.SideDataPPS, Data: []byte{0x68, 0xce, 0x3c, 0x80}},
+			},
+		},
+		{SessionID: "empty"},
+	}
+
+	for _, frame := range tests {
+		encoded, err := storage.EncodeFrame(frame)
+		require.NoError(t, err)
+
+		decoded, err := storage.DecodeFrame(encoded)
+		require.NoError(t, err)
+		assert.True(t, frame.Timestamp.Equal(decoded.Timestamp))
+		decoded.Timestamp = frame.Timestamp
+		assert.Equal(t, frame, decoded)
+	}
+}
+
+// TestFrameEncodingVersion1 verifies that envelopes written before media
+// timestamps and side data were added can still be decoded.
+func TestFrameEncodingVersion1(t *testing.T) {
+	envelope := []byte{
+		'R', 'F', 1, 1, // magic, version, key frame flag
+		0, 0, 0, 0, 0, 0, 0, 7, // index
+		0x17, 0x97, 0x9c, 0xfe, 0x2c, 0x1a, 0x00, 0x00, // timestamp
+		0, 4, 'c', 'a', 'm', '1', // session
+		5, 'v', 'i', 'd', 'e', 'o', // media type
+		4, 'h', '2', '6', '4', // codec
+		0xde, 0xad, // data
+	}
+
+	frame, err := storage.DecodeFrame(envelope)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Frame{
+		SessionID: "cam1",
+		Index:     7,
+		Data:      []byte{0xde, 0xad},
+		Timestamp: time.Unix(0, 0x17979cfe2c1a0000),
+		MediaType: "video",
+		Codec:     frames.CodecH264,
+		KeyFrame:  true,
+	}, frame)
+}
+
+// TestFrameEncodingLegacyJSON verifies that frames stored as JSON by earlier
+// versions, as found in testdata/legacy_frames.jsonl, can still be decoded.
+func TestFrameEncodingLegacyJSON(t *testing.T) {
+	lines := readLegacyFrames(t)
+	require.Len(t, lines, len(legacyFrames))
+
+	for i, line := range lines {
+		decoded, err := storage.DecodeFrame([]byte(line))
+		require.NoError(t, err)
+		assert.True(t, legacyFrames[i].Timestamp.Equal(decoded.Timestamp))
+		decoded.Timestamp = legacyFrames[i].Timestamp
+		assert.Equal(t, legacyFrames[i], decoded)
+	}
+}
+
+// TestFrameDecodingAliasesData verifies that frames decoded from a binary
+// envelope share the envelope's memory, as documented by DecodeFrame.
+func TestFrameDecodingAliasesData(t *testing.T) {
+	encoded, err := storage.EncodeFrame(storage.Frame{SessionID: "cam1", Data: []byte{1, 2, 3}})
+	require.NoError(t, err)
+
+	decoded, err := storage.DecodeFrame(encoded)
+	require.NoError(t, err)
+	encoded[len(encoded)-1] = 9
+	assert.Equal(t, []byte{1, 2, 9}, decoded.Data)
+}
+
+// TestFrameEncodingRejectsGarbage verifies that truncated or unknown data is
+// reported as an error.
+func TestFrameEncodingRejectsGarbage(t *testing.T) {
+	encoded, err := storage.EncodeFrame(storage.Frame{SessionID: "cam1", MediaType: "video"})
+	require.NoError(t, err)
+
+	for _, data := range [][]byte{nil, []byte("xyz"), encoded[:len(encoded)-3]} {
+		_, err := storage.DecodeFrame(data)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFileStorageReopen verifies that frames, overwrites, evictions and
+// retention policies survive closing and reopening the storage.
+func TestFileStorageReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	base := time.Unix(1700000000, 0)
+
+	store, err := storage.NewFileStorage(storage.FileConfig{Dir: dir, MaxSegmentBytes: 512})
+	require.NoError(t, err)
+
+	require.NoError(t, store.SetRetention(ctx, "cam1/hd", storage.RetentionPolicy{MaxFrames: 5}))
+	for i := int64(0); i < 10; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{
+			SessionID: "cam1/hd",
+			Index:     i,
+			Data:      []byte{byte(i), 1, 2, 3},
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+			MediaType: "video",
+			Codec:     "h264",
+			KeyFrame:  i%5 == 0,
+		}))
+	}
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1/hd", Index: 7, Data: []byte("overwritten")}))
+	require.NoError(t, store.SetCheckpoint(ctx, "watermark", "cam1/hd", 8))
+	require.NoError(t, store.Close())
+
+	store, err = storage.NewFileStorage(storage.FileConfig{Dir: dir, MaxSegmentBytes: 512})
+	require.NoError(t, err)
+	defer store.Close()
+
+	sessions, err := store.ListSessions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cam1/hd"}, sessions)
+
+	frames, err := store.ListFrames(ctx, "cam1/hd")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{5, 6, 7, 8, 9}, indexes(frames))
+	assert.Equal(t, []byte("overwritten"), frames[2].Data)
+	assert.True(t, frames[0].KeyFrame)
+	assert.Equal(t, base.Add(5*time.Second), frames[0].Timestamp)
+
+	// Key frames are flagged in the segment indexes
+	keyFrame, err := store.LatestKeyFrame(ctx, "cam1/hd")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), keyFrame.Index)
+
+	// The checkpoint was persisted with the session
+	checkpoint, ok, err := store.GetCheckpoint(ctx, "watermark", "cam1/hd")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(8), checkpoint)
+
+	// The retention policy was persisted with the session
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1/hd", Index: 10}))
+	frames, err = store.ListFrames(ctx, "cam1/hd")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{6, 7, 8, 9, 10}, indexes(frames))
+
+	// Segments holding only evicted frames were removed
+	segments, err := filepath.Glob(filepath.Join(dir, "sessions", "*", "*.seg"))
+	require.NoError(t, err)
+	assert.Less(t, len(segments), 5)
+}
+
+// TestFileStorageRecovery verifies that a torn write at the tail of a segment
+// and an index lagging behind its segment are repaired on startup.
+func TestFileStorageRecovery(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := storage.NewFileStorage(storage.FileConfig{Dir: dir})
+	require.NoError(t, err)
+	for i := int64(0); i < 5; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: i, Data: []byte("frame"), KeyFrame: i == 3}))
+	}
+	require.NoError(t, store.Close())
+
+	segment := filepath.Join(dir, "sessions", "cam1", "0000000000000001.seg")
+	index := filepath.Join(dir, "sessions", "cam1", "0000000000000001.idx")
+
+	// Lose the last two index entries and half of a record appended after them
+	info, err := os.Stat(index)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(index, info.Size()*3/5))
+
+	f, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 1, 0, 0xde, 0xad})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	store, err = storage.NewFileStorage(storage.FileConfig{Dir: dir})
+	require.NoError(t, err)
+
+	frames, err := store.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{0, 1, 2, 3, 4}, indexes(frames))
+
+	// Re-indexed records keep their key frame flag
+	keyFrame, err := store.LatestKeyFrame(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), keyFrame.Index)
+
+	// New frames are appended after the repaired tail
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: 5, Data: []byte("frame")}))
+	require.NoError(t, store.Close())
+
+	store, err = storage.NewFileStorage(storage.FileConfig{Dir: dir})
+	require.NoError(t, err)
+	defer store.Close()
+
+	frame, err := store.GetFrame(ctx, "cam1", 5)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("frame"), frame.Data)
+}
+
+// TestFileStorageRotation verifies that segments are rotated by size and age.
+func TestFileStorageRotation(t *testing.T) {
+	ctx := context.Background()
+	countSegments := func(dir string) int {
+		segments, err := filepath.Glob(filepath.Join(dir, "sessions", "cam1", "*.seg"))
+		require.NoError(t, err)
+		return len(segments)
+	}
+
+	t.Run("size", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := storage.NewFileStorage(storage.FileConfig{Dir: dir, MaxSegmentBytes: 1000})
+		require.NoError(t, err)
+		defer store.Close()
+
+		for i := int64(0); i < 10; i++ {
+			require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: i, Data: make([]byte, 400)}))
+		}
+		assert.Equal(t, 4, countSegments(dir))
+	})
+
+	t.Run("age", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := storage.NewFileStorage(storage.FileConfig{Dir: dir, MaxSegmentAge: 20 * time.Millisecond})
+		require.NoError(t, err)
+		defer store.Close()
+
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: 0}))
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: 1}))
+		time.Sleep(30 * time.Millisecond)
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: 2}))
+		assert.Equal(t, 2, countSegments(dir))
+
+		frames, err := store.ListFrames(ctx, "cam1")
+		require.NoError(t, err)
+		assert.Equal(t, []int64{0, 1, 2}, indexes(frames))
+	})
+}
+
+// TestFileStorageSubscribe verifies that a subscription delivers the stored
+// backlog followed by new frames.
+func TestFileStorageSubscribe(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store, err := storage.NewFileStorage(storage.FileConfig{Dir: t.TempDir()})
+	require.NoError(t, err)
+	defer store.Close()
+
+	for i := int64(0); i < 100; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: i}))
+	}
+
+	frames, err := store.Subscribe(ctx, "cam1", 10)
+	require.NoError(t, err)
+
+	go func() {
+		for i := int64(100); i < 150; i++ {
+			store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: i})
+		}
+	}()
+
+	for want := int64(10); want < 150; want++ {
+		select {
+		case frame := <-frames:
+			require.Equal(t, want, frame.Index)
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for frame %d", want)
+		}
+	}
+}
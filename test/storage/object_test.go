@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestObjectStorageBatches verifies that frames are written in batches with a
+// manifest, readable before and after their batch is written, and recovered
+// by a new ObjectStorage on the same bucket.
+func TestObjectStorageBatches(t *testing.T) {
+	ctx := context.Background()
+	client := storage.NewMemoryObjectClient()
+	base := time.Unix(1700000000, 0)
+
+	store, err := storage.NewObjectStorage(storage.ObjectConfig{Client: client, Prefix: "archive/", BatchFrames: 4})
+	require.NoError(t, err)
+
+	for i := int64(0); i < 10; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{
+			SessionID: "cam1/hd",
+			Index:     i,
+			Data:      []byte{byte(i)},
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+			Codec:     "h264",
+		}))
+	}
+
+	keys, err := client.ListObjects(ctx, "archive/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"archive/sessions/cam1%2Fhd/batches/0000000000000000.bin",
+		"archive/sessions/cam1%2Fhd/batches/0000000000000001.bin",
+		"archive/sessions/cam1%2Fhd/manifest.json",
+	}, keys)
+
+	// Frames 8 and 9 are still buffered but readable
+	frames, err := store.ListFrames(ctx, "cam1/hd")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, indexes(frames))
+
+	frame, err := store.GetFrame(ctx, "cam1/hd", 5)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{5}, frame.Data)
+	assert.EqualValues(t, "h264", frame.Codec)
+
+	frames, err = store.ListFramesByTime(ctx, "cam1/hd", base.Add(3*time.Second), base.Add(4*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3, 4}, indexes(frames))
+
+	require.NoError(t, store.SetCheckpoint(ctx, "watermark", "cam1/hd", 9))
+	require.NoError(t, store.Close())
+
+	store, err = storage.NewObjectStorage(storage.ObjectConfig{Client: client, Prefix: "archive/", BatchFrames: 4})
+	require.NoError(t, err)
+	defer store.Close()
+
+	sessions, err := store.ListSessions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cam1/hd"}, sessions)
+
+	frames, err = store.ListFrames(ctx, "cam1/hd")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, indexes(frames))
+	assert.Equal(t, base.Add(9*time.Second), frames[9].Timestamp)
+
+	// The checkpoint was written with the manifest
+	checkpoint, ok, err := store.GetCheckpoint(ctx, "watermark", "cam1/hd")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(9), checkpoint)
+
+	require.NoError(t, store.DeleteSession(ctx, "cam1/hd"))
+	keys, err = client.ListObjects(ctx, "archive/")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+// TestObjectStorageRetention verifies that batches are deleted once retention
+// has evicted all of their frames.
+func TestObjectStorageRetention(t *testing.T) {
+	ctx := context.Background()
+	client := storage.NewMemoryObjectClient()
+
+	store, err := storage.NewObjectStorage(storage.ObjectConfig{
+		Client:      client,
+		BatchFrames: 2,
+		Retention:   storage.RetentionPolicy{MaxFrames: 3},
+	})
+	require.NoError(t, err)
+	defer store.Close()
+
+	for i := int64(0); i < 10; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: i}))
+	}
+
+	frames, err := store.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{7, 8, 9}, indexes(frames))
+
+	keys, err := client.ListObjects(ctx, "sessions/cam1/batches/")
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+}
+
+// TestS3Client verifies the S3 client against a minimal S3-compatible server.
+func TestS3Client(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeS3(t, "frames")
+	defer server.Close()
+
+	client, err := storage.NewS3Client(storage.S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "frames",
+		AccessKey: "test",
+		SecretKey: "secret",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, client.PutObject(ctx, "sessions/cam1%2Fhd/a.bin", []byte("0123456789")))
+	require.NoError(t, client.PutObject(ctx, "sessions/cam2/b.bin", []byte("b")))
+	require.NoError(t, client.PutObject(ctx, "other/c.bin", []byte("c")))
+
+	data, err := client.GetObject(ctx, "sessions/cam1%2Fhd/a.bin")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("0123456789"), data)
+
+	data, err = client.GetObjectRange(ctx, "sessions/cam1%2Fhd/a.bin", 3, 4)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("3456"), data)
+
+	_, err = client.GetObject(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+
+	keys, err := client.ListObjects(ctx, "sessions/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sessions/cam1%2Fhd/a.bin", "sessions/cam2/b.bin"}, keys)
+
+	require.NoError(t, client.DeleteObject(ctx, "sessions/cam2/b.bin"))
+	keys, err = client.ListObjects(ctx, "sessions/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sessions/cam1%2Fhd/a.bin"}, keys)
+
+	// The object storage runs on top of the S3 client
+	store, err := storage.NewObjectStorage(storage.ObjectConfig{Client: client, BatchFrames: 2})
+	require.NoError(t, err)
+	defer store.Close()
+
+	for i := int64(0); i < 3; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam3", Index: i, Data: []byte{byte(i)}}))
+	}
+	frame, err := store.GetFrame(ctx, "cam3", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1}, frame.Data)
+}
+
+// newFakeS3 starts a server implementing the parts of the S3 API used by
+// S3Client: PUT, GET with Range, DELETE and ListObjectsV2 with pagination.
+// It checks that every request carries a SigV4 authorization header.
+func newFakeS3(t *testing.T, bucket string) *httptest.Server {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test/") ||
+			r.Header.Get("X-Amz-Content-Sha256") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/"+bucket)
+		key := strings.TrimPrefix(path, "/")
+
+		switch {
+		case r.Method == http.MethodPut:
+			data, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			objects[key] = data
+
+		case r.Method == http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+
+		case r.Method == http.MethodGet && key == "":
+			query := r.URL.Query()
+			keys := make([]string, 0)
+			for k := range objects {
+				if strings.HasPrefix(k, query.Get("prefix")) {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+
+			// Return one key per page to exercise continuation
+			start, _ := strconv.Atoi(query.Get("continuation-token"))
+			type content struct {
+				Key string `xml:"Key"`
+			}
+			result := struct {
+				XMLName               xml.Name  `xml:"ListBucketResult"`
+				Contents              []content `xml:"Contents"`
+				IsTruncated           bool      `xml:"IsTruncated"`
+				NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+			}{}
+			if start < len(keys) {
+				result.Contents = []content{{Key: keys[start]}}
+			}
+			if start+1 < len(keys) {
+				result.IsTruncated = true
+				result.NextContinuationToken = strconv.Itoa(start + 1)
+			}
+			assert.NoError(t, xml.NewEncoder(w).Encode(result))
+
+		case r.Method == http.MethodGet:
+			data, exists := objects[key]
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+				return
+			}
+			if rng := r.Header.Get("Range"); rng != "" {
+				var from, to int
+				bounds := strings.SplitN(strings.TrimPrefix(rng, "bytes="), "-", 2)
+				from, _ = strconv.Atoi(bounds[0])
+				to, _ = strconv.Atoi(bounds[1])
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(data[from : to+1])
+				return
+			}
+			w.Write(data)
+		}
+	}))
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRangedReads verifies index ranges, pagination and timestamp ranges
+// on a session written out of order.
+func TestRangedReads(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	base := time.Unix(1700000000, 0)
+	for _, i := range []int64{4, 0, 2, 1, 3, 9, 5, 7, 6, 8} {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{
+			SessionID: "cam1",
+			Index:     i,
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+		}))
+	}
+
+	frames, err := store.ListFramesRange(ctx, "cam1", 3, 6)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3, 4, 5, 6}, indexes(frames))
+
+	frames, err = store.ListFramesRange(ctx, "cam1", 20, 30)
+	require.NoError(t, err)
+	assert.Empty(t, frames)
+
+	// Walk the session page by page
+	var walked []int64
+	cursor := int64(0)
+	for {
+		page, err := store.ListFramesPage(ctx, "cam1", cursor, 4)
+		require.NoError(t, err)
+		walked = append(walked, indexes(page.Frames)...)
+		cursor = page.NextCursor
+		if !page.HasMore {
+			break
+		}
+	}
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, walked)
+	assert.Equal(t, int64(10), cursor)
+
+	_, err = store.ListFramesPage(ctx, "cam1", 0, 0)
+	assert.Error(t, err)
+
+	frames, err = store.ListFramesByTime(ctx, "cam1", base.Add(2*time.Second), base.Add(4*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 3, 4}, indexes(frames))
+
+	_, err = store.ListFramesRange(ctx, "missing", 0, 10)
+	assert.Error(t, err)
+}
+
+func indexes(frames []storage.Frame) []int64 {
+	result := make([]int64, 0, len(frames))
+	for _, frame := range frames {
+		result = append(result, frame.Index)
+	}
+	return result
+}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/relais/pkg/frames"
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacyFrames are the frames of testdata/legacy_frames.jsonl, which holds
+// them as the List based Redis layout of earlier versions stored them.
+var legacyFrames = []storage.Frame{
+	{
+		SessionID: "cam1",
+		Index:     0,
+		Data:      []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10},
+		Timestamp: time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC),
+		MediaType: "video",
+		Codec:     frames.CodecJPEG,
+		KeyFrame:  true,
+	},
+	{
+		SessionID: "cam1",
+		Index:     1,
+		Data:      []byte{0xff, 0xd8, 0xff, 0xdb},
+		Timestamp: time.Date(2023, 11, 14, 22, 13, 20, 33333333, time.UTC),
+		MediaType: "video",
+		Codec:     frames.CodecJPEG,
+	},
+	{
+		SessionID: "cam1",
+		Index:     2,
+		Data:      []byte{0xff, 0xd8, 0xff, 0xc0, 0x00},
+		Timestamp: time.Date(2023, 11, 14, 22, 13, 20, 66666666, time.UTC),
+		MediaType: "video",
+		Codec:     frames.CodecJPEG,
+		KeyFrame:  true,
+	},
+}
+
+// readLegacyFrames returns the lines of testdata/legacy_frames.jsonl.
+func readLegacyFrames(t *testing.T) []string {
+	f, err := os.Open("testdata/legacy_frames.jsonl")
+	require.NoError(t, err)
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.NoError(t, scanner.Err())
+	return lines
+}
+
+// TestRedisLegacyLayout verifies that sessions stored by earlier versions, as
+// a List of JSON frames at "frames:{sessionID}", are migrated when the Redis
+// storage is created and stay readable and writable.
+func TestRedisLegacyLayout(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	// Seed the layout written by earlier versions: RPUSH of each frame and
+	// the session in the active sessions set
+	_, err := mr.Push("frames:cam1", readLegacyFrames(t)...)
+	require.NoError(t, err)
+	_, err = mr.SetAdd("active_sessions", "cam1")
+	require.NoError(t, err)
+
+	store, err := storage.NewRedisStorage(mr.Addr())
+	require.NoError(t, err)
+	defer store.Close()
+
+	assertFrames := func(want []storage.Frame, got []storage.Frame) {
+		t.Helper()
+		require.Len(t, got, len(want))
+		for i := range want {
+			assert.True(t, want[i].Timestamp.Equal(got[i].Timestamp))
+			got[i].Timestamp = want[i].Timestamp
+		}
+		assert.Equal(t, want, got)
+	}
+
+	all, err := store.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	assertFrames(legacyFrames, all)
+
+	frame, err := store.GetFrame(ctx, "cam1", 1)
+	require.NoError(t, err)
+	assertFrames(legacyFrames[1:2], []storage.Frame{frame})
+
+	keyFrame, err := store.LatestKeyFrame(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), keyFrame.Index)
+
+	metadata, err := store.ListFrameMetadata(ctx, "cam1", 0, 2)
+	require.NoError(t, err)
+	require.Len(t, metadata, 3)
+	assert.Equal(t, len(legacyFrames[0].Data), metadata[0].Size)
+
+	assert.False(t, mr.Exists("frames:cam1"), "legacy List should be removed once migrated")
+
+	// New frames are written next to the migrated ones
+	next := storage.Frame{SessionID: "cam1", Index: 3, Data: []byte{0xff, 0xd8}, MediaType: "video"}
+	require.NoError(t, store.PutFrame(ctx, next))
+	all, err = store.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Len(t, all, 4)
+
+	// Creating the storage again finds nothing left to migrate
+	again, err := storage.NewRedisStorage(mr.Addr())
+	require.NoError(t, err)
+	defer again.Close()
+	all, err = again.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Len(t, all, 4)
+
+	require.NoError(t, store.DeleteSession(ctx, "cam1"))
+	assert.Empty(t, mr.Keys())
+}
+
+// TestRedisLegacyLayoutKeepsNewFrames verifies that migrating a legacy List
+// doesn't replace frames already stored in the current layout under the
+// same index.
+func TestRedisLegacyLayoutKeepsNewFrames(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	store, err := storage.NewRedisStorage(mr.Addr())
+	require.NoError(t, err)
+	defer store.Close()
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: 1, Data: []byte("new")}))
+
+	_, err = mr.Push("frames:cam1", readLegacyFrames(t)...)
+	require.NoError(t, err)
+
+	migrated, err := storage.NewRedisStorage(mr.Addr())
+	require.NoError(t, err)
+	defer migrated.Close()
+
+	all, err := migrated.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, []byte("new"), all[1].Data)
+	assert.Equal(t, legacyFrames[2].Data, all[2].Data)
+}
+
+// TestRedisSubscribePublishesIndex verifies that Redis announces new frames
+// by index only, and that subscribers read the frame from storage.
+func TestRedisSubscribePublishesIndex(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	mr := miniredis.RunT(t)
+
+	store, err := storage.NewRedisStorage(mr.Addr())
+	require.NoError(t, err)
+	defer store.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	events := client.Subscribe(ctx, "frames:cam1:events")
+	defer events.Close()
+	_, err = events.Receive(ctx)
+	require.NoError(t, err)
+
+	delivered, err := store.Subscribe(ctx, "cam1", 0)
+	require.NoError(t, err)
+
+	frame := storage.Frame{SessionID: "cam1", Index: 7, Data: make([]byte, 64*1024), MediaType: "video"}
+	require.NoError(t, store.PutFrame(ctx, frame))
+
+	msg, err := events.ReceiveMessage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "7", msg.Payload)
+
+	select {
+	case got := <-delivered:
+		assert.Equal(t, frame.Index, got.Index)
+		assert.Len(t, got.Data, len(frame.Data))
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for frame")
+	}
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRetentionPolicies verifies that each retention limit evicts the oldest
+// frames of a session, and that per-session policies override the default.
+func TestRetentionPolicies(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	frame := func(sessionID string, i int64) storage.Frame {
+		return storage.Frame{
+			SessionID: sessionID,
+			Index:     i,
+			Data:      make([]byte, 100),
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		policy storage.RetentionPolicy
+		want   []int64
+	}{
+		{"unlimited", storage.RetentionPolicy{}, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"max frames", storage.RetentionPolicy{MaxFrames: 3}, []int64{7, 8, 9}},
+		{"max age", storage.RetentionPolicy{MaxAge: 2 * time.Second}, []int64{7, 8, 9}},
+		{"max bytes", storage.RetentionPolicy{MaxBytes: 450}, []int64{6, 7, 8, 9}},
+		{"combined", storage.RetentionPolicy{MaxFrames: 5, MaxBytes: 250}, []int64{8, 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := storage.NewMemoryStorage()
+			defer store.Close()
+
+			require.NoError(t, store.SetRetention(ctx, "cam1", tt.policy))
+			for i := int64(0); i < 10; i++ {
+				require.NoError(t, store.PutFrame(ctx, frame("cam1", i)))
+			}
+
+			frames, err := store.ListFrames(ctx, "cam1")
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, indexes(frames))
+		})
+	}
+
+	t.Run("default policy", func(t *testing.T) {
+		ctx := context.Background()
+		store := storage.NewMemoryStorage()
+		defer store.Close()
+
+		require.NoError(t, store.SetRetention(ctx, "", storage.RetentionPolicy{MaxFrames: 2}))
+		require.NoError(t, store.SetRetention(ctx, "archive", storage.RetentionPolicy{}))
+		for i := int64(0); i < 5; i++ {
+			require.NoError(t, store.PutFrame(ctx, frame("live", i)))
+			require.NoError(t, store.PutFrame(ctx, frame("archive", i)))
+		}
+
+		frames, err := store.ListFrames(ctx, "live")
+		require.NoError(t, err)
+		assert.Equal(t, []int64{3, 4}, indexes(frames))
+
+		frames, err = store.ListFrames(ctx, "archive")
+		require.NoError(t, err)
+		assert.Len(t, frames, 5)
+	})
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStreamsStorage returns a Redis Streams storage on a fake server holding
+// count frames of session cam1.
+func newStreamsStorage(t *testing.T, count int) (*storage.RedisStreamsStorage, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	store, err := storage.NewRedisStreamsStorage(mr.Addr())
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	for i := 0; i < count; i++ {
+		require.NoError(t, store.PutFrame(context.Background(), storage.Frame{SessionID: "cam1", Index: int64(i)}))
+	}
+	return store, mr
+}
+
+// messageIndexes returns the frame indexes of messages.
+func messageIndexes(messages []storage.StreamMessage) []int64 {
+	out := make([]int64, 0, len(messages))
+	for _, m := range messages {
+		out = append(out, m.Frame.Index)
+	}
+	return out
+}
+
+// TestStreamsReadGroup verifies that consumers of a group share the frames
+// of a session, each frame being delivered to exactly one of them, and that
+// every group receives all frames.
+func TestStreamsReadGroup(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newStreamsStorage(t, 10)
+
+	seen := make(map[int64]string)
+	for _, consumer := range []string{"a", "b", "a", "b", "a", "b"} {
+		messages, err := store.ReadGroup(ctx, "transcode", consumer, []string{"cam1"}, 2, 0)
+		require.NoError(t, err)
+		for _, m := range messages {
+			assert.Equal(t, "cam1", m.Frame.SessionID)
+			_, dup := seen[m.Frame.Index]
+			assert.False(t, dup, "frame %d delivered twice", m.Frame.Index)
+			seen[m.Frame.Index] = consumer
+		}
+	}
+	assert.Len(t, seen, 10)
+	assert.Equal(t, "a", seen[0])
+	assert.Equal(t, "b", seen[2])
+
+	// The group has nothing left to deliver
+	messages, err := store.ReadGroup(ctx, "transcode", "a", []string{"cam1"}, 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	// Frames written later are delivered once too
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: 10}))
+	messages, err = store.ReadGroup(ctx, "transcode", "b", []string{"cam1"}, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{10}, messageIndexes(messages))
+
+	// Another group reads the whole session independently
+	messages, err = store.ReadGroup(ctx, "thumbnails", "a", []string{"cam1"}, 100, 0)
+	require.NoError(t, err)
+	assert.Len(t, messages, 11)
+}
+
+// TestStreamsAck verifies that acknowledged frames leave the pending list,
+// while unacknowledged ones can still be claimed.
+func TestStreamsAck(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newStreamsStorage(t, 5)
+
+	messages, err := store.ReadGroup(ctx, "transcode", "a", []string{"cam1"}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 5)
+
+	require.NoError(t, store.Ack(ctx, "transcode", "cam1", messages[0].ID, messages[1].ID, messages[3].ID))
+	require.NoError(t, store.Ack(ctx, "transcode", "cam1"))
+
+	claimed, err := store.ClaimStale(ctx, "transcode", "b", "cam1", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 4}, messageIndexes(claimed))
+
+	require.NoError(t, store.Ack(ctx, "transcode", "cam1", claimed[0].ID, claimed[1].ID))
+	claimed, err = store.ClaimStale(ctx, "transcode", "b", "cam1", 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+// TestStreamsClaimStale verifies that frames delivered to a consumer are
+// only taken over by another once idle for the requested time.
+func TestStreamsClaimStale(t *testing.T) {
+	ctx := context.Background()
+	store, mr := newStreamsStorage(t, 3)
+
+	start := time.Now()
+	mr.SetTime(start)
+	messages, err := store.ReadGroup(ctx, "transcode", "a", []string{"cam1"}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 3)
+	require.NoError(t, store.Ack(ctx, "transcode", "cam1", messages[0].ID))
+
+	// Consumer a still owns its frames
+	mr.SetTime(start.Add(10 * time.Second))
+	claimed, err := store.ClaimStale(ctx, "transcode", "b", "cam1", time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+
+	// Consumer a stopped acknowledging; b takes over its pending frames
+	mr.SetTime(start.Add(2 * time.Minute))
+	claimed, err = store.ClaimStale(ctx, "transcode", "b", "cam1", time.Minute, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 2}, messageIndexes(claimed))
+
+	// Claiming resets the idle time, so a can't take them back right away
+	claimed, err = store.ClaimStale(ctx, "transcode", "a", "cam1", time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+// TestStreamsClaimStaleEvicted verifies that pending messages whose frames
+// were evicted by retention after their consumer died are acknowledged
+// rather than left pending, and don't hide the stale messages behind them.
+func TestStreamsClaimStaleEvicted(t *testing.T) {
+	ctx := context.Background()
+	store, mr := newStreamsStorage(t, 6)
+
+	start := time.Now()
+	mr.SetTime(start)
+	messages, err := store.ReadGroup(ctx, "transcode", "a", []string{"cam1"}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 6)
+
+	// Consumer a dies, and retention evicts frames 0 to 3 while they are
+	// pending
+	require.NoError(t, store.SetRetention(ctx, "cam1", storage.RetentionPolicy{MaxFrames: 3}))
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: 6}))
+
+	mr.SetTime(start.Add(2 * time.Minute))
+	claimed, err := store.ClaimStale(ctx, "transcode", "b", "cam1", time.Minute, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4, 5}, messageIndexes(claimed))
+
+	// Only the claimed messages are left pending
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	pending, err := client.XPending(ctx, "streams:cam1", "transcode").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pending.Count)
+	assert.Equal(t, map[string]int64{"b": 2}, pending.Consumers)
+}
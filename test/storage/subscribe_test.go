@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSubscribeBacklogAndLive verifies that a subscriber first receives the
+// stored frames from the requested index and then frames written afterwards.
+func TestSubscribeBacklogAndLive(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	for i := int64(0); i < 5; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: i}))
+	}
+
+	frames, err := store.Subscribe(ctx, "cam1", 3)
+	require.NoError(t, err)
+
+	for i := int64(5); i < 8; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: i}))
+	}
+
+	for want := int64(3); want < 8; want++ {
+		select {
+		case frame := <-frames:
+			assert.Equal(t, want, frame.Index)
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for frame %d", want)
+		}
+	}
+}
+
+// TestSubscribeBeforeSessionExists verifies that subscribing to a session
+// without frames succeeds and delivers frames once they are written.
+func TestSubscribeBeforeSessionExists(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store := storage.NewMemoryStorage()
+	defer store.Close()
+
+	frames, err := store.Subscribe(ctx, "cam1", 0)
+	require.NoError(t, err)
+
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: 0}))
+
+	select {
+	case frame := <-frames:
+		assert.Equal(t, int64(0), frame.Index)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for frame")
+	}
+}
+
+// TestSubscribeClosedOnCancel verifies that the subscription channel is
+// closed when its context is cancelled or the storage is closed.
+func TestSubscribeClosedOnCancel(t *testing.T) {
+	store := storage.NewMemoryStorage()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	frames, err := store.Subscribe(ctx, "cam1", 0)
+	require.NoError(t, err)
+	cancel()
+	assertClosed(t, frames)
+
+	frames, err = store.Subscribe(context.Background(), "cam1", 0)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	assertClosed(t, frames)
+}
+
+func assertClosed(t *testing.T, frames <-chan storage.Frame) {
+	t.Helper()
+
+	select {
+	case _, ok := <-frames:
+		assert.False(t, ok, "expected subscription channel to be closed")
+	case <-time.After(time.Second):
+		t.Fatal("subscription channel was not closed")
+	}
+}
+
+// TestSubscribeDropsSlowSubscriber verifies that a subscriber falling more
+// than MaxPendingFrames frames behind is dropped instead of buffering every
+// frame written meanwhile.
+func TestSubscribeDropsSlowSubscriber(t *testing.T) {
+	mr := miniredis.RunT(t)
+	backends := map[string]struct {
+		store   func(t *testing.T) storage.Storage
+		dropped func() bool // Reports whether the subscriber was dropped, before it reads
+	}{
+		"memory": {
+			store: func(t *testing.T) storage.Storage {
+				return storage.NewMemoryStorage()
+			},
+			dropped: func() bool { return true },
+		},
+		"redis": {
+			store: func(t *testing.T) storage.Storage {
+				store, err := storage.NewRedisStorage(mr.Addr())
+				require.NoError(t, err)
+				return store
+			},
+			// Frame announcements are read as the subscriber falls behind;
+			// it leaves the channel once dropped
+			dropped: func() bool { return mr.PubSubNumSub("frames:cam1:events")["frames:cam1:events"] == 0 },
+		},
+	}
+
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			store := backend.store(t)
+			defer store.Close()
+
+			frames, err := store.Subscribe(ctx, "cam1", 0)
+			require.NoError(t, err)
+
+			total := storage.MaxPendingFrames + 10
+			for i := 0; i < total; i++ {
+				require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: int64(i)}))
+			}
+			require.Eventually(t, backend.dropped, 5*time.Second, 10*time.Millisecond)
+
+			received := 0
+			for {
+				select {
+				case _, ok := <-frames:
+					if !ok {
+						assert.Less(t, received, total)
+						return
+					}
+					received++
+				case <-ctx.Done():
+					t.Fatalf("subscription was not dropped after %d frames", received)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/relais/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTieredStorage verifies that old frames are spilled to the cold tier
+// and that reads span both tiers.
+func TestTieredStorage(t *testing.T) {
+	ctx := context.Background()
+	base := time.Unix(1700000000, 0)
+
+	cold := storage.NewMemoryStorage()
+	store, err := storage.NewTieredStorage(cold, storage.TieredConfig{HotFrames: 10, SpillBatch: 5})
+	require.NoError(t, err)
+	defer store.Close()
+
+	for i := int64(0); i < 32; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{
+			SessionID: "cam1",
+			Index:     i,
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+		}))
+	}
+
+	// Frames 0-19 were spilled in batches of 5, the rest is still in memory
+	spilled, err := cold.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), spilled[0].Index)
+	assert.Equal(t, int64(19), spilled[len(spilled)-1].Index)
+
+	frames, err := store.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Len(t, frames, 32)
+	for i, frame := range frames {
+		assert.Equal(t, int64(i), frame.Index)
+	}
+
+	frame, err := store.GetFrame(ctx, "cam1", 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), frame.Index)
+	frame, err = store.GetFrame(ctx, "cam1", 30)
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), frame.Index)
+	_, err = store.GetFrame(ctx, "cam1", 99)
+	assert.Error(t, err)
+
+	frames, err = store.ListFramesRange(ctx, "cam1", 17, 23)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{17, 18, 19, 20, 21, 22, 23}, indexes(frames))
+
+	frames, err = store.ListFramesByTime(ctx, "cam1", base.Add(18*time.Second), base.Add(21*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{18, 19, 20, 21}, indexes(frames))
+
+	// Pages cross the tier boundary seamlessly
+	var paged []int64
+	cursor := int64(0)
+	for {
+		page, err := store.ListFramesPage(ctx, "cam1", cursor, 7)
+		require.NoError(t, err)
+		paged = append(paged, indexes(page.Frames)...)
+		cursor = page.NextCursor
+		if !page.HasMore {
+			break
+		}
+	}
+	assert.Len(t, paged, 32)
+
+	// Overwriting a spilled frame is visible right away
+	require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: 2, Data: []byte("new")}))
+	frame, err = store.GetFrame(ctx, "cam1", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new"), frame.Data)
+
+	require.NoError(t, store.DeleteSession(ctx, "cam1"))
+	_, err = store.ListFrames(ctx, "cam1")
+	assert.Error(t, err)
+	_, err = cold.ListFrames(ctx, "cam1")
+	assert.Error(t, err)
+}
+
+// TestTieredStorageClose verifies that Close spills the frames and checkpoints
+// held in memory so that a durable cold tier keeps the whole session.
+func TestTieredStorageClose(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	cold, err := storage.NewFileStorage(storage.FileConfig{Dir: dir})
+	require.NoError(t, err)
+	store, err := storage.NewTieredStorage(cold, storage.TieredConfig{HotFrames: 10, SpillBatch: 5})
+	require.NoError(t, err)
+
+	for i := int64(0); i < 12; i++ {
+		require.NoError(t, store.PutFrame(ctx, storage.Frame{SessionID: "cam1", Index: i}))
+	}
+	require.NoError(t, store.SetCheckpoint(ctx, "watermark", "cam1", 11))
+	require.NoError(t, store.Close())
+
+	cold, err = storage.NewFileStorage(storage.FileConfig{Dir: dir})
+	require.NoError(t, err)
+	store, err = storage.NewTieredStorage(cold, storage.TieredConfig{})
+	require.NoError(t, err)
+	defer store.Close()
+
+	sessions, err := store.ListSessions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cam1"}, sessions)
+
+	frames, err := store.ListFrames(ctx, "cam1")
+	require.NoError(t, err)
+	assert.Len(t, frames, 12)
+
+	checkpoint, ok, err := store.GetCheckpoint(ctx, "watermark", "cam1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(11), checkpoint)
+}